@@ -284,6 +284,13 @@ load test_helper
 
   vm=$(new_empty_vm)
 
+  run govc device.clock.add -vm "$vm"
+  assert_failure # requires vmx-17
+
+  vm=$(new_id)
+  run govc vm.create -on=false -version vmx-17 "$vm"
+  assert_success
+
   result=$(govc device.ls -vm "$vm" | grep clock | wc -l)
   [ "$result" -eq 0 ]
 
@@ -307,6 +314,9 @@ load test_helper
     result=$(govc device.ls -vm "$vm" | grep disk- | wc -l)
     [ "$result" -eq "$i" ]
   done
+
+  run govc vm.disk.create -vm "$vm" -name disk-16 -size 1K
+  assert_failure # scsi controller is full
 }
 
 @test "device.match" {
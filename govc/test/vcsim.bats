@@ -51,7 +51,7 @@ EOF
   url="https://$(govc env GOVC_URL)"
 
   run curl -skf "$url/about"
-  assert_matches "CurrentTime" # 1 param (without Context)
+  assert_matches "RetrieveServiceContent" # 1 param (without Context)
   assert_matches "TerminateSession" # 2 params (with Context)
   assert_matches "CnsAttachVolume" # method from namespace vsan
   assert_matches "PbmCreate" # method from namespace pbm
@@ -548,6 +548,157 @@ EOF
   assert_success # issue #2016
 }
 
+@test "vcsim clock" {
+  vcsim_env
+
+  url="https://$(govc env GOVC_URL)/vcsim/admin/clock"
+  auth="$(govc env GOVC_USERNAME):$(govc env GOVC_PASSWORD)"
+
+  run curl -sk -o /dev/null -w "%{http_code}" "https://$(govc env GOVC_URL)/vcsim/clock"
+  assert_success "404"
+
+  run curl -sk -o /dev/null -w "%{http_code}" "$url"
+  assert_success "401"
+
+  run curl -skf -u "$auth" -X POST "$url?freeze=true"
+  assert_success
+
+  run curl -skf -u "$auth" -X POST "$url?set=2024-01-01T00:00:00Z"
+  assert_success
+  [ "$(jq -r .now <<<"$output")" == "2024-01-01T00:00:00Z" ]
+  [ "$(jq -r .frozen <<<"$output")" == "true" ]
+
+  run curl -skf -u "$auth" -X POST "$url?advance=1h"
+  assert_success
+  [ "$(jq -r .now <<<"$output")" == "2024-01-01T01:00:00Z" ]
+
+  run govc vm.power -off DC0_H0_VM0
+  assert_success
+
+  run govc events -json vm/DC0_H0_VM0
+  assert_success
+  assert_matches "2024-01-01T01:00:00"
+
+  run curl -sk -o /dev/null -w "%{http_code}" -u "$auth" -X POST "$url?advance=enoent"
+  assert_success "400"
+
+  run curl -skf -u "$auth" -X POST "$url?freeze=false"
+  assert_success
+  [ "$(jq -r .frozen <<<"$output")" == "false" ]
+}
+
+@test "vcsim scenario" {
+  file="$BATS_TMPDIR/$(new_id).yaml"
+  cat > "$file" <<EOF
+name: test
+steps:
+  - at: 0s
+    target: DC0_H0_VM0
+    action: PowerOffVM_Task
+  - at: 0s
+    target: /DC0/host/DC0_H0/DC0_H0
+    action: event.post
+    args: {message: scenario step}
+EOF
+
+  vcsim_start -scenario "$file"
+
+  run govc events -n 100 host/DC0_H0
+  assert_success
+  assert_matches "scenario step"
+
+  run govc object.collect -s vm/DC0_H0_VM0 runtime.powerState
+  assert_success poweredOff
+
+  url="https://$(govc env GOVC_URL)/vcsim/admin/scenario"
+  auth="$(govc env GOVC_USERNAME):$(govc env GOVC_PASSWORD)"
+
+  run curl -sk -o /dev/null -w "%{http_code}" -X POST --data-binary "steps: []" "https://$(govc env GOVC_URL)/vcsim/scenario"
+  assert_success "404"
+
+  run curl -sk -o /dev/null -w "%{http_code}" -u "$auth" -X POST --data-binary "steps: [{target: DC0_H0, action: enoent}]" "$url"
+  assert_success "400"
+
+  run curl -sk -o /dev/null -w "%{http_code}" -u "$auth" -X POST --data-binary "steps: [{target: DC0_H0_VM0, action: PowerOnVM_Task}]" "$url"
+  assert_success "202"
+
+  vcsim_stop
+  rm -f "$file"
+}
+
+@test "vcsim admin" {
+  vcsim_env
+
+  url="https://$(govc env GOVC_URL)/vcsim/admin"
+
+  run curl -sk -o /dev/null -w "%{http_code}" "$url/objects"
+  assert_success "401"
+
+  auth="$(govc env GOVC_USERNAME):$(govc env GOVC_PASSWORD)"
+
+  run curl -skf -u "$auth" "$url/objects?type=HostSystem"
+  assert_success
+  [ "$(jq length <<<"$output")" == "$(govc find / -type h | wc -l)" ]
+
+  vm=$(govc find -i vm -name DC0_H0_VM0)
+
+  run curl -skf -u "$auth" -X PATCH -d '{"guest.hostName": "admin.local"}' "$url/objects/$vm"
+  assert_success
+
+  run govc object.collect -s vm/DC0_H0_VM0 guest.hostName
+  assert_success admin.local
+
+  run curl -skf -u "$auth" -X POST -d '{"state": "poweredOff"}' "$url/objects/$vm/vm.power"
+  assert_success
+
+  run govc object.collect -s vm/DC0_H0_VM0 runtime.powerState
+  assert_success poweredOff
+
+  run curl -sk -u "$auth" -o /dev/null -w "%{http_code}" -X POST "$url/objects/$vm/PowerOffVM_Task"
+  assert_success "409"
+
+  run curl -skf -u "$auth" -X POST -d '[{"method": "PowerOnVM_Task", "fault": {"_typeName": "InvalidPowerState"}}]' "$url/faults"
+  assert_success
+
+  run govc vm.power -on DC0_H0_VM0
+  assert_failure
+
+  run curl -skf -u "$auth" -X DELETE "$url/faults"
+  assert_success
+
+  run govc vm.power -on DC0_H0_VM0
+  assert_success
+}
+
+@test "vcsim metrics" {
+  vcsim_env
+
+  run curl -sk -o /dev/null -w "%{http_code}" "https://$(govc env GOVC_URL)/metrics"
+  assert_success "404"
+
+  vcsim_stop
+
+  vcsim_env -metrics
+
+  url="https://$(govc env GOVC_URL)/metrics"
+
+  run govc vm.power -off DC0_H0_VM0
+  assert_success
+
+  run curl -skf "$url"
+  assert_success
+  assert_matches 'vcsim_method_calls_total{method="PowerOffVM_Task"} 1'
+  assert_matches 'vcsim_objects{type="VirtualMachine"} 4'
+  assert_matches 'vcsim_wait_for_updates_waiters 0'
+
+  run govc vm.power -off DC0_H0_VM0
+  assert_failure
+
+  run curl -skf "$url"
+  assert_success
+  assert_matches 'vcsim_method_calls_total{method="PowerOffVM_Task"} 2'
+}
+
 @test "vcsim trace file" {
   file="$BATS_TMPDIR/$(new_id).trace"
 
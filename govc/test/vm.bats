@@ -133,6 +133,12 @@ load test_helper
 
   id=DC0_H0_VM0
 
+  run govc vm.change -c 2 -vm $id
+  assert_failure # cpuHotAddEnabled=false
+
+  run govc vm.power -off $id
+  assert_success
+
   run govc vm.change -g ubuntu64Guest -m 1024 -c 2 -vm $id
   assert_success
 
@@ -186,6 +186,68 @@ func (m VirtualDiskManager) ShrinkVirtualDisk(ctx context.Context, name string,
 	return NewTask(m.c, res.Returnval), nil
 }
 
+// ExtendVirtualDisk expands the capacity of a virtual disk to the new capacity.
+func (m VirtualDiskManager) ExtendVirtualDisk(ctx context.Context, name string, dc *Datacenter, capacityKb int64, eagerZero *bool) (*Task, error) {
+	req := types.ExtendVirtualDisk_Task{
+		This:          m.Reference(),
+		Name:          name,
+		NewCapacityKb: capacityKb,
+		EagerZero:     eagerZero,
+	}
+
+	if dc != nil {
+		ref := dc.Reference()
+		req.Datacenter = &ref
+	}
+
+	res, err := methods.ExtendVirtualDisk_Task(ctx, m.c, &req)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewTask(m.c, res.Returnval), nil
+}
+
+// EagerZeroVirtualDisk explicitly zeros out unaccessed parts of a zeroedthick disk.
+func (m VirtualDiskManager) EagerZeroVirtualDisk(ctx context.Context, name string, dc *Datacenter) (*Task, error) {
+	req := types.EagerZeroVirtualDisk_Task{
+		This: m.Reference(),
+		Name: name,
+	}
+
+	if dc != nil {
+		ref := dc.Reference()
+		req.Datacenter = &ref
+	}
+
+	res, err := methods.EagerZeroVirtualDisk_Task(ctx, m.c, &req)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewTask(m.c, res.Returnval), nil
+}
+
+// ZeroFillVirtualDisk overwrites all blocks of a virtual disk with zeros.
+func (m VirtualDiskManager) ZeroFillVirtualDisk(ctx context.Context, name string, dc *Datacenter) (*Task, error) {
+	req := types.ZeroFillVirtualDisk_Task{
+		This: m.Reference(),
+		Name: name,
+	}
+
+	if dc != nil {
+		ref := dc.Reference()
+		req.Datacenter = &ref
+	}
+
+	res, err := methods.ZeroFillVirtualDisk_Task(ctx, m.c, &req)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewTask(m.c, res.Returnval), nil
+}
+
 // Queries virtual disk uuid
 func (m VirtualDiskManager) QueryVirtualDiskUuid(ctx context.Context, name string, dc *Datacenter) (string, error) {
 	req := types.QueryVirtualDiskUuid{
@@ -0,0 +1,279 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/zhengkes/govmomi/vim25/mo"
+	"github.com/zhengkes/govmomi/vim25/types"
+)
+
+// adminPrefix is the path prefix of the vcsim admin API, see Service.ServeAdmin.
+const adminPrefix = "/vcsim/admin/"
+
+// AdminObject is an inventory object as listed by the vcsim admin API.
+type AdminObject struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+	Name  string `json:"name"`
+}
+
+// ServeAdmin is the vcsim admin API, used to manipulate the simulator state without the vSphere API.
+// Requests are authenticated using basic auth with the same credentials accepted by SessionManager.Login.
+// Objects are referred to in the form "Type:value", such as "VirtualMachine:vm-42". Endpoints:
+//
+//	GET    /vcsim/admin/objects?type=VirtualMachine                 list inventory objects, optionally of the given type
+//	GET    /vcsim/admin/objects/{ref}?path=runtime.powerState       get an object, or only the given properties
+//	PATCH  /vcsim/admin/objects/{ref}                               set properties, such as {"runtime.powerState": "poweredOff"}
+//	POST   /vcsim/admin/objects/{ref}/{action}                      apply a ScenarioActions entry or method, the request body is its args
+//	GET    /vcsim/admin/faults                                      list fault injection rules
+//	POST   /vcsim/admin/faults                                      add fault injection rules, see FaultConfig.Load
+//	DELETE /vcsim/admin/faults                                      remove all fault injection rules
+//	*      /vcsim/admin/clock, /vcsim/admin/checkpoint, /vcsim/admin/scenario
+//
+// The last are the same as ServeClock, ServeCheckpoint and ServeScenario.
+// Values are encoded using the vim25 JSON encoding. When an action fails, the response status is 409 (Conflict).
+func (s *Service) ServeAdmin(w http.ResponseWriter, r *http.Request) {
+	user, pass, ok := r.BasicAuth()
+	if !ok || !Map.SessionManager().Authenticate(*s.Listen, &types.Login{UserName: user, Password: pass}) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="vcsim"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	ctx := SpoofContext()
+	ctx.Context = r.Context()
+	ctx.Session.UserName = user
+	ctx.svc = s
+
+	path := strings.Split(strings.TrimPrefix(r.URL.Path, adminPrefix), "/")
+
+	switch path[0] {
+	case "objects":
+		switch len(path) {
+		case 1:
+			s.adminObjects(ctx, w, r)
+			return
+		case 2, 3:
+			var ref types.ManagedObjectReference
+			if !ref.FromString(path[1]) {
+				http.Error(w, fmt.Sprintf("invalid reference %q", path[1]), http.StatusBadRequest)
+				return
+			}
+			obj := ctx.Map.Get(ref)
+			if obj == nil {
+				http.Error(w, fmt.Sprintf("%s not found", path[1]), http.StatusNotFound)
+				return
+			}
+			if len(path) == 2 {
+				s.adminObject(ctx, obj, w, r)
+			} else {
+				s.adminAction(ctx, obj, path[2], w, r)
+			}
+			return
+		}
+	case "faults":
+		s.adminFaults(w, r)
+		return
+	case "clock":
+		s.ServeClock(w, r)
+		return
+	case "checkpoint":
+		s.ServeCheckpoint(w, r)
+		return
+	case "scenario":
+		s.ServeScenario(w, r)
+		return
+	}
+
+	http.NotFound(w, r)
+}
+
+// adminEncode writes the given value as the response, using the vim25 JSON encoding.
+func adminEncode(w http.ResponseWriter, val interface{}) {
+	var buf bytes.Buffer
+
+	if err := types.NewJSONEncoder(&buf).Encode(val); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(buf.Bytes())
+}
+
+func (s *Service) adminObjects(ctx *Context, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	objs := []AdminObject{}
+	for _, e := range ctx.Map.All(r.URL.Query().Get("type")) {
+		ref := e.Reference()
+		objs = append(objs, AdminObject{Type: ref.Type, Value: ref.Value, Name: entityName(e)})
+	}
+
+	sort.Slice(objs, func(i, j int) bool {
+		if objs[i].Type == objs[j].Type {
+			return objs[i].Value < objs[j].Value
+		}
+		return objs[i].Type < objs[j].Type
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(objs)
+}
+
+func (s *Service) adminObject(ctx *Context, obj mo.Reference, w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		paths := r.URL.Query()["path"]
+
+		ctx.WithLock(obj, func() {
+			rval := getManagedObject(obj)
+			if len(paths) == 0 {
+				adminEncode(w, rval.Addr().Interface())
+				return
+			}
+
+			props := make(map[string]interface{}, len(paths))
+			for _, p := range paths {
+				val, err := fieldValue(rval, p)
+				if err != nil && err != errEmptyField {
+					http.Error(w, fmt.Sprintf("%s: %s", p, err), http.StatusBadRequest)
+					return
+				}
+				props[p] = val
+			}
+			adminEncode(w, props)
+		})
+	case http.MethodPatch:
+		var props map[string]json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&props); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		rtype := getManagedObject(obj).Type()
+		var changes []types.PropertyChange
+		for name, raw := range props {
+			ftype, err := propertyType(rtype, name)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			val := reflect.New(ftype)
+			if err = types.NewJSONDecoder(bytes.NewReader(raw)).Decode(val.Interface()); err != nil {
+				http.Error(w, fmt.Sprintf("%s: %s", name, err), http.StatusBadRequest)
+				return
+			}
+			changes = append(changes, types.PropertyChange{Name: name, Val: val.Elem().Interface()})
+		}
+
+		ctx.WithLock(obj, func() {
+			if vm, ok := obj.(*VirtualMachine); ok && isPowerStateChange(changes) {
+				vm.updatePowerState(ctx, changes)
+				return
+			}
+			ctx.Map.Update(obj, changes)
+		})
+
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// propertyType returns the type of the property at the given path of the given managed object type.
+func propertyType(rtype reflect.Type, path string) (reflect.Type, error) {
+	for _, name := range strings.Split(path, ".") {
+		if rtype.Kind() == reflect.Ptr {
+			rtype = rtype.Elem()
+		}
+		if rtype.Kind() != reflect.Struct {
+			return nil, fmt.Errorf("%s: %s", path, errInvalidField)
+		}
+		f, ok := rtype.FieldByName(ucFirst(name))
+		if !ok {
+			return nil, fmt.Errorf("%s: %s", path, errMissingField)
+		}
+		rtype = f.Type
+	}
+
+	return rtype, nil
+}
+
+func (s *Service) adminAction(ctx *Context, obj mo.Reference, action string, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	step := ScenarioStep{Target: obj.Reference().String(), Action: action}
+
+	if ScenarioActions[action] == nil {
+		if _, ok := types.TypeFunc()(action); !ok {
+			http.Error(w, fmt.Sprintf("unknown action %q", action), http.StatusNotFound)
+			return
+		}
+	}
+
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&step.Args); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := step.apply(ctx); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Service) adminFaults(w http.ResponseWriter, r *http.Request) {
+	if s.faults == nil {
+		http.Error(w, "fault injection is not enabled", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		adminEncode(w, s.faults.Rules())
+	case http.MethodPost:
+		if err := s.faults.Load(r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		s.faults.Reset()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
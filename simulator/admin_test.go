@@ -0,0 +1,201 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/zhengkes/govmomi/find"
+	"github.com/zhengkes/govmomi/vim25"
+	"github.com/zhengkes/govmomi/vim25/mo"
+	"github.com/zhengkes/govmomi/vim25/types"
+)
+
+func TestServeAdmin(t *testing.T) {
+	Test(func(ctx context.Context, c *vim25.Client) {
+		client := &http.Client{Transport: c.DefaultTransport()}
+
+		call := func(method, path, body string, status int) string {
+			t.Helper()
+			u := c.URL()
+			u.User = nil
+			u.Path, u.RawQuery, _ = strings.Cut(path, "?")
+			req, err := http.NewRequest(method, u.String(), strings.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.SetBasicAuth("admin", "pass")
+			res, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			b, _ := io.ReadAll(res.Body)
+			if res.StatusCode != status {
+				t.Fatalf("%s %s: status=%d body=%s", method, path, res.StatusCode, b)
+			}
+			return string(b)
+		}
+
+		// authentication is required, the clock, checkpoint and scenario endpoints are only served by the admin API
+		paths := map[string]int{
+			"/vcsim/admin/objects":    http.StatusUnauthorized,
+			"/vcsim/admin/clock":      http.StatusUnauthorized,
+			"/vcsim/admin/checkpoint": http.StatusUnauthorized,
+			"/vcsim/admin/scenario":   http.StatusUnauthorized,
+			"/vcsim/clock":            http.StatusNotFound,
+			"/vcsim/checkpoint":       http.StatusNotFound,
+			"/vcsim/scenario":         http.StatusNotFound,
+		}
+		for path, status := range paths {
+			for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPut} {
+				u := c.URL()
+				u.User = nil
+				u.Path = path
+				req, err := http.NewRequest(method, u.String(), nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				res, err := client.Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				_ = res.Body.Close()
+				if res.StatusCode != status {
+					t.Errorf("%s %s: status=%d", method, path, res.StatusCode)
+				}
+			}
+		}
+
+		var objs []AdminObject
+		out := call(http.MethodGet, "/vcsim/admin/objects?type=VirtualMachine", "", http.StatusOK)
+		if err := json.Unmarshal([]byte(out), &objs); err != nil {
+			t.Fatal(err)
+		}
+		if len(objs) != len(Map.All("VirtualMachine")) {
+			t.Errorf("objects=%v", objs)
+		}
+
+		finder := find.NewFinder(c)
+		vm, err := finder.VirtualMachine(ctx, "DC0_H0_VM0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := "/vcsim/admin/objects/" + vm.Reference().String()
+
+		call(http.MethodPatch, path, `{"summary.quickStats.overallCpuUsage": 42, "guest.hostName": "admin.local"}`, http.StatusNoContent)
+		call(http.MethodPatch, path, `{"summary.enoent": 1}`, http.StatusBadRequest)
+
+		var mvm mo.VirtualMachine
+		err = vm.Properties(ctx, vm.Reference(), []string{"summary.quickStats", "guest.hostName"}, &mvm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mvm.Summary.QuickStats.OverallCpuUsage != 42 || mvm.Guest.HostName != "admin.local" {
+			t.Errorf("cpu=%d hostName=%s", mvm.Summary.QuickStats.OverallCpuUsage, mvm.Guest.HostName)
+		}
+
+		out = call(http.MethodGet, path+"?path=guest.hostName", "", http.StatusOK)
+		if !strings.Contains(out, "admin.local") {
+			t.Errorf("body=%s", out)
+		}
+		out = call(http.MethodGet, path, "", http.StatusOK)
+		if !strings.Contains(out, `"name":"DC0_H0_VM0"`) {
+			t.Errorf("body=%s", out)
+		}
+
+		// power state
+		call(http.MethodPost, path+"/vm.power", `{"state": "poweredOff"}`, http.StatusNoContent)
+		call(http.MethodPost, path+"/PowerOffVM_Task", "", http.StatusConflict)
+		call(http.MethodPost, path+"/vm.power", `{"state": "enoent"}`, http.StatusConflict)
+		call(http.MethodPost, path+"/enoent", "", http.StatusNotFound)
+		state, err := vm.PowerState(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state != types.VirtualMachinePowerStatePoweredOff {
+			t.Errorf("state=%s", state)
+		}
+
+		// connection state
+		host, err := finder.HostSystem(ctx, "DC0_H0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		hpath := "/vcsim/admin/objects/" + host.Reference().String()
+		call(http.MethodPost, hpath+"/host.connection", `{"state": "disconnected"}`, http.StatusNoContent)
+		if h := Map.Get(host.Reference()).(*HostSystem); h.Runtime.ConnectionState != types.HostSystemConnectionStateDisconnected {
+			t.Errorf("state=%s", h.Runtime.ConnectionState)
+		}
+		call(http.MethodPost, hpath+"/host.connection", `{"state": "connected"}`, http.StatusNoContent)
+
+		// add and remove hosts and VMs
+		folder, err := finder.Folder(ctx, "/DC0/host")
+		if err != nil {
+			t.Fatal(err)
+		}
+		call(http.MethodPost, "/vcsim/admin/objects/"+folder.Reference().String()+"/host.add", `{"name": "admin-host"}`, http.StatusNoContent)
+		newHost, err := finder.HostSystem(ctx, "admin-host")
+		if err != nil {
+			t.Fatal(err)
+		}
+		call(http.MethodPost, hpath+"/vm.create", `{"name": "admin-vm"}`, http.StatusNoContent)
+		newVM, err := finder.VirtualMachine(ctx, "admin-vm")
+		if err != nil {
+			t.Fatal(err)
+		}
+		call(http.MethodPost, "/vcsim/admin/objects/"+newVM.Reference().String()+"/Destroy_Task", "", http.StatusNoContent)
+		call(http.MethodPost, "/vcsim/admin/objects/"+newHost.Reference().String()+"/Destroy_Task", "", http.StatusNoContent)
+		if Map.Get(newVM.Reference()) != nil || Map.Get(newHost.Reference()) != nil {
+			t.Error("not destroyed")
+		}
+		call(http.MethodGet, "/vcsim/admin/objects/"+newVM.Reference().String(), "", http.StatusNotFound)
+
+		// fault injection
+		call(http.MethodPost, "/vcsim/admin/faults", `[{"method": "PowerOnVM_Task", "fault": {"_typeName": "InvalidPowerState"}}]`, http.StatusNoContent)
+		out = call(http.MethodGet, "/vcsim/admin/faults", "", http.StatusOK)
+		if !strings.Contains(out, "InvalidPowerState") {
+			t.Errorf("faults=%s", out)
+		}
+		_, err = vm.PowerOn(ctx)
+		if err == nil {
+			t.Error("expected fault")
+		}
+		call(http.MethodDelete, "/vcsim/admin/faults", "", http.StatusNoContent)
+		tsk, err := vm.PowerOn(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = tsk.Wait(ctx); err != nil {
+			t.Error(err)
+		}
+
+		// clock
+		out = call(http.MethodPost, "/vcsim/admin/clock?freeze=true", "", http.StatusOK)
+		if !strings.Contains(out, `"frozen":true`) {
+			t.Errorf("clock=%s", out)
+		}
+		call(http.MethodPost, "/vcsim/admin/clock?freeze=false", "", http.StatusOK)
+
+		call(http.MethodGet, "/vcsim/admin/enoent", "", http.StatusNotFound)
+	})
+}
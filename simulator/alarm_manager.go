@@ -0,0 +1,1042 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/zhengkes/govmomi/vim25/methods"
+	"github.com/zhengkes/govmomi/vim25/mo"
+	"github.com/zhengkes/govmomi/vim25/soap"
+	"github.com/zhengkes/govmomi/vim25/types"
+)
+
+// AlarmManager evaluates Alarm triggers against Registry updates and posted events.
+// State triggers (StateAlarmExpression) are evaluated when an entity property referenced
+// by the expression StatePath changes. Event triggers (EventAlarmExpression) are evaluated
+// when an event is posted via the EventManager.
+// Triggered alarms are reflected in ManagedEntity.triggeredAlarmState of the entity and its
+// ancestors, and each status transition posts an AlarmStatusChangedEvent.
+type AlarmManager struct {
+	mo.AlarmManager
+
+	mu     sync.Mutex
+	alarms map[types.ManagedObjectReference]*Alarm
+	state  map[string]*types.AlarmState
+}
+
+// Alarm is a user defined alarm, as created by AlarmManager.CreateAlarm
+type Alarm struct {
+	mo.Alarm
+}
+
+// alarmStatusChange records an alarm state transition, to be applied outside of AlarmManager.mu
+type alarmStatusChange struct {
+	from  types.ManagedEntityStatus
+	state types.AlarmState
+}
+
+func (m *AlarmManager) init(r *Registry) {
+	m.alarms = make(map[types.ManagedObjectReference]*Alarm)
+	m.state = make(map[string]*types.AlarmState)
+
+	r.AddHandler(m)
+}
+
+// restore rebuilds the alarm index and triggered state from the objects loaded from a checkpoint
+func (m *AlarmManager) restore(ctx *Context) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, obj := range ctx.Map.AllReference("Alarm") {
+		alarm := obj.(*Alarm)
+		m.alarms[alarm.Self] = alarm
+	}
+
+	for _, e := range ctx.Map.All("") {
+		for _, state := range e.Entity().TriggeredAlarmState {
+			if state.Entity == e.Reference() && m.alarms[state.Alarm] != nil {
+				state := state
+				m.state[alarmStateKey(state.Alarm, state.Entity)] = &state
+			}
+		}
+	}
+}
+
+var alarmStatusLevel = map[types.ManagedEntityStatus]int{
+	types.ManagedEntityStatusGray:   0,
+	types.ManagedEntityStatusGreen:  1,
+	types.ManagedEntityStatusYellow: 2,
+	types.ManagedEntityStatusRed:    3,
+}
+
+func alarmStatusMax(a, b types.ManagedEntityStatus) types.ManagedEntityStatus {
+	if alarmStatusLevel[b] > alarmStatusLevel[a] {
+		return b
+	}
+	return a
+}
+
+func alarmStatusMin(a, b types.ManagedEntityStatus) types.ManagedEntityStatus {
+	if alarmStatusLevel[b] < alarmStatusLevel[a] {
+		return b
+	}
+	return a
+}
+
+func alarmStateKey(alarm, entity types.ManagedObjectReference) string {
+	return alarm.Value + "." + entity.Value
+}
+
+// alarmTypeMatches returns true if kind is empty, matches the entity type or an embedded base type.
+func alarmTypeMatches(ctx *Context, kind string, ref types.ManagedObjectReference) bool {
+	if kind == "" || kind == ref.Type || kind == "ManagedEntity" {
+		return true
+	}
+
+	obj := ctx.Map.Get(ref)
+	if obj == nil {
+		return false
+	}
+
+	field, ok := getManagedObject(obj).Type().FieldByName(kind)
+	return ok && field.Anonymous
+}
+
+// alarmAncestors returns the inventory ancestors of the given entity, which includes the
+// resource pool and host hierarchy for VirtualMachine entities.
+func alarmAncestors(ctx *Context, ref types.ManagedObjectReference) []types.ManagedObjectReference {
+	var refs []types.ManagedObjectReference
+	seen := map[types.ManagedObjectReference]bool{ref: true}
+
+	var parents func(types.ManagedObjectReference)
+
+	parents = func(ref types.ManagedObjectReference) {
+		e, ok := ctx.Map.Get(ref).(mo.Entity)
+		if !ok {
+			return
+		}
+
+		next := []*types.ManagedObjectReference{e.Entity().Parent}
+
+		if vm, ok := e.(*VirtualMachine); ok {
+			next = append(next, vm.ResourcePool, vm.Runtime.Host)
+		}
+
+		for _, p := range next {
+			if p == nil || seen[*p] {
+				continue
+			}
+			seen[*p] = true
+			refs = append(refs, *p)
+			parents(*p)
+		}
+	}
+
+	parents(ref)
+
+	return refs
+}
+
+// applies returns true if the alarm is defined on the given entity or one of its ancestors.
+func (a *Alarm) applies(ctx *Context, ref types.ManagedObjectReference) bool {
+	if !a.Info.Enabled {
+		return false
+	}
+
+	if a.Info.Entity == ref {
+		return true
+	}
+
+	return FindReference(alarmAncestors(ctx, ref), a.Info.Entity) != nil
+}
+
+// alarmStatePaths returns the StateAlarmExpression.StatePath values used by the given expression.
+func alarmStatePaths(expr types.BaseAlarmExpression) []string {
+	switch x := expr.(type) {
+	case *types.StateAlarmExpression:
+		return []string{x.StatePath}
+	case *types.AndAlarmExpression:
+		var paths []string
+		for _, e := range x.Expression {
+			paths = append(paths, alarmStatePaths(e)...)
+		}
+		return paths
+	case *types.OrAlarmExpression:
+		var paths []string
+		for _, e := range x.Expression {
+			paths = append(paths, alarmStatePaths(e)...)
+		}
+		return paths
+	}
+
+	return nil
+}
+
+// alarmChangeMatches returns true if one of the changes applies to one of the expression's state paths.
+func alarmChangeMatches(expr types.BaseAlarmExpression, changes []types.PropertyChange) bool {
+	for _, path := range alarmStatePaths(expr) {
+		for _, change := range changes {
+			if change.Name == path ||
+				strings.HasPrefix(path, change.Name+".") ||
+				strings.HasPrefix(change.Name, path+".") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// alarmValueString returns the string form of a property value, as compared against alarm expression values.
+func alarmValueString(val interface{}) string {
+	rval := reflect.ValueOf(val)
+	if !rval.IsValid() {
+		return ""
+	}
+	if rval.Kind() == reflect.Ptr {
+		if rval.IsNil() {
+			return ""
+		}
+		rval = rval.Elem()
+	}
+	return fmt.Sprintf("%v", rval.Interface())
+}
+
+// evalStateExpression returns the status of a StateAlarmExpression for the given entity.
+func evalStateExpression(ctx *Context, ref types.ManagedObjectReference, x *types.StateAlarmExpression) types.ManagedEntityStatus {
+	if !alarmTypeMatches(ctx, x.Type, ref) {
+		return ""
+	}
+
+	obj := ctx.Map.Get(ref)
+	if obj == nil {
+		return ""
+	}
+
+	val, err := fieldValue(getManagedObject(obj), x.StatePath)
+	if err != nil && err != errEmptyField {
+		return ""
+	}
+
+	s := alarmValueString(val)
+
+	switch x.Operator {
+	case types.StateAlarmOperatorIsEqual:
+		if x.Red != "" && s == x.Red {
+			return types.ManagedEntityStatusRed
+		}
+		if x.Yellow != "" && s == x.Yellow {
+			return types.ManagedEntityStatusYellow
+		}
+	case types.StateAlarmOperatorIsUnequal:
+		if x.Red != "" && s != x.Red {
+			return types.ManagedEntityStatusRed
+		}
+		if x.Yellow != "" && s != x.Yellow {
+			return types.ManagedEntityStatusYellow
+		}
+	}
+
+	return types.ManagedEntityStatusGreen
+}
+
+// alarmEventEntity returns the event's entity argument of the given type.
+// If kind is empty, the most specific entity argument is returned.
+func alarmEventEntity(event types.BaseEvent, kind string) *types.ManagedObjectReference {
+	e := event.GetEvent()
+
+	args := []struct {
+		kind string
+		ref  func() *types.ManagedObjectReference
+	}{
+		{"VirtualMachine", func() *types.ManagedObjectReference {
+			if e.Vm == nil {
+				return nil
+			}
+			return &e.Vm.Vm
+		}},
+		{"HostSystem", func() *types.ManagedObjectReference {
+			if e.Host == nil {
+				return nil
+			}
+			return &e.Host.Host
+		}},
+		{"Datastore", func() *types.ManagedObjectReference {
+			if e.Ds == nil {
+				return nil
+			}
+			return &e.Ds.Datastore
+		}},
+		{"ComputeResource", func() *types.ManagedObjectReference {
+			if e.ComputeResource == nil {
+				return nil
+			}
+			return &e.ComputeResource.ComputeResource
+		}},
+		{"Network", func() *types.ManagedObjectReference {
+			if e.Net == nil {
+				return nil
+			}
+			return &e.Net.Network
+		}},
+		{"DistributedVirtualSwitch", func() *types.ManagedObjectReference {
+			if e.Dvs == nil {
+				return nil
+			}
+			return &e.Dvs.Dvs
+		}},
+		{"Datacenter", func() *types.ManagedObjectReference {
+			if e.Datacenter == nil {
+				return nil
+			}
+			return &e.Datacenter.Datacenter
+		}},
+	}
+
+	for _, arg := range args {
+		ref := arg.ref()
+		if ref == nil {
+			continue
+		}
+		if kind == "" || kind == arg.kind || kind == ref.Type {
+			return ref
+		}
+	}
+
+	return nil
+}
+
+// alarmEventTypeMatches returns true if the EventAlarmExpression EventType or EventTypeId matches the event.
+func alarmEventTypeMatches(x *types.EventAlarmExpression, event types.BaseEvent) bool {
+	kind := reflect.ValueOf(event).Elem().Type()
+
+	if x.EventTypeId != "" {
+		switch e := event.(type) {
+		case *types.EventEx:
+			return e.EventTypeId == x.EventTypeId
+		case *types.ExtendedEvent:
+			return e.EventTypeId == x.EventTypeId
+		}
+		return kind.Name() == x.EventTypeId
+	}
+
+	if kind.Name() == x.EventType {
+		return true
+	}
+
+	field, ok := kind.FieldByName(x.EventType)
+	return ok && field.Anonymous // base type (embedded field)
+}
+
+// alarmEventComparisonMatches returns true if all of the expression's attribute comparisons match the event.
+func alarmEventComparisonMatches(x *types.EventAlarmExpression, event types.BaseEvent) bool {
+	for _, c := range x.Comparisons {
+		val, err := fieldValue(reflect.ValueOf(event).Elem(), c.AttributeName)
+		if err != nil && err != errEmptyField {
+			return false
+		}
+
+		s := alarmValueString(val)
+
+		var ok bool
+
+		switch types.EventAlarmExpressionComparisonOperator(c.Operator) {
+		case types.EventAlarmExpressionComparisonOperatorEquals:
+			ok = s == c.Value
+		case types.EventAlarmExpressionComparisonOperatorNotEqualTo:
+			ok = s != c.Value
+		case types.EventAlarmExpressionComparisonOperatorStartsWith:
+			ok = strings.HasPrefix(s, c.Value)
+		case types.EventAlarmExpressionComparisonOperatorDoesNotStartWith:
+			ok = !strings.HasPrefix(s, c.Value)
+		case types.EventAlarmExpressionComparisonOperatorEndsWith:
+			ok = strings.HasSuffix(s, c.Value)
+		case types.EventAlarmExpressionComparisonOperatorDoesNotEndWith:
+			ok = !strings.HasSuffix(s, c.Value)
+		}
+
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// evalAlarmExpression returns the status of the given expression for the entity.
+// When event is nil, only state expressions are evaluated.
+// When event is non-nil, only event expressions are evaluated.
+// An empty status is returned if the expression does not apply.
+func evalAlarmExpression(ctx *Context, ref types.ManagedObjectReference, expr types.BaseAlarmExpression, event types.BaseEvent) types.ManagedEntityStatus {
+	switch x := expr.(type) {
+	case *types.StateAlarmExpression:
+		if event != nil {
+			return ""
+		}
+		return evalStateExpression(ctx, ref, x)
+	case *types.EventAlarmExpression:
+		if event == nil {
+			return ""
+		}
+		if !alarmEventTypeMatches(x, event) || !alarmEventComparisonMatches(x, event) {
+			return ""
+		}
+		if e := alarmEventEntity(event, x.ObjectType); e == nil || *e != ref {
+			return ""
+		}
+		if x.Status == "" {
+			return types.ManagedEntityStatusRed
+		}
+		return x.Status
+	case *types.OrAlarmExpression:
+		var status types.ManagedEntityStatus
+		for _, e := range x.Expression {
+			if s := evalAlarmExpression(ctx, ref, e, event); s != "" {
+				status = alarmStatusMax(status, s)
+			}
+		}
+		return status
+	case *types.AndAlarmExpression:
+		var status types.ManagedEntityStatus
+		for _, e := range x.Expression {
+			s := evalAlarmExpression(ctx, ref, e, event)
+			if s == "" {
+				continue
+			}
+			if status == "" {
+				status = s
+			} else {
+				status = alarmStatusMin(status, s)
+			}
+		}
+		return status
+	}
+
+	return "" // MetricAlarmExpression, etc
+}
+
+// setStatus records the given alarm status for the entity, returning a change if the status differs from the current state.
+func (m *AlarmManager) setStatus(ctx *Context, alarm *Alarm, entity types.ManagedObjectReference, status types.ManagedEntityStatus, eventKey int32) *alarmStatusChange {
+	key := alarmStateKey(alarm.Self, entity)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	from := types.ManagedEntityStatusGreen
+
+	prev, ok := m.state[key]
+	if ok {
+		from = prev.OverallStatus
+		if from == status {
+			return nil
+		}
+	} else if status == types.ManagedEntityStatusGreen {
+		return nil
+	}
+
+	state := &types.AlarmState{
+		Key:           key,
+		Entity:        entity,
+		Alarm:         alarm.Self,
+		OverallStatus: status,
+		Time:          ctx.Now(),
+		Acknowledged:  types.NewBool(false),
+		EventKey:      eventKey,
+	}
+
+	m.state[key] = state
+
+	return &alarmStatusChange{from: from, state: *state}
+}
+
+// triggered returns the triggered (non-green) alarm states for entity and its descendants.
+func (m *AlarmManager) triggered(ctx *Context, ref types.ManagedObjectReference) []types.AlarmState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var states []types.AlarmState
+
+	for _, state := range m.state {
+		switch state.OverallStatus {
+		case types.ManagedEntityStatusGray, types.ManagedEntityStatusGreen:
+			continue
+		}
+
+		if state.Entity == ref || FindReference(alarmAncestors(ctx, state.Entity), ref) != nil {
+			states = append(states, *state)
+		}
+	}
+
+	return states
+}
+
+// overallStatus returns the highest alarm status of the alarms triggered on the given entity itself.
+func (m *AlarmManager) overallStatus(ref types.ManagedObjectReference) types.ManagedEntityStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	status := types.ManagedEntityStatusGreen
+
+	for _, state := range m.state {
+		if state.Entity == ref {
+			status = alarmStatusMax(status, state.OverallStatus)
+		}
+	}
+
+	return status
+}
+
+// updateEntity propagates alarm states to the triggeredAlarmState property of the entity and its ancestors
+func (m *AlarmManager) updateEntity(ctx *Context, ref types.ManagedObjectReference) {
+	refs := append([]types.ManagedObjectReference{ref}, alarmAncestors(ctx, ref)...)
+
+	for i, ref := range refs {
+		obj := ctx.Map.Get(ref)
+		if obj == nil {
+			continue
+		}
+
+		changes := []types.PropertyChange{
+			{Name: "triggeredAlarmState", Val: m.triggered(ctx, ref)},
+		}
+
+		if i == 0 {
+			changes = append(changes, types.PropertyChange{Name: "overallStatus", Val: m.overallStatus(ref)})
+		}
+
+		ctx.Map.Update(obj, changes)
+	}
+}
+
+// entityEvent returns an Event with entity arguments populated for the given entity.
+func entityEvent(ctx *Context, ref types.ManagedObjectReference) types.Event {
+	switch obj := ctx.Map.Get(ref).(type) {
+	case *VirtualMachine:
+		if obj.Runtime.Host != nil && len(obj.Datastore) != 0 {
+			return obj.event().Event
+		}
+	case *HostSystem:
+		if obj.Parent != nil {
+			return obj.event().Event
+		}
+	case *Datacenter:
+		return types.Event{Datacenter: datacenterEventArgument(obj)}
+	}
+
+	return types.Event{}
+}
+
+// entityEventArgument returns a ManagedEntityEventArgument for the given entity.
+func entityEventArgument(ctx *Context, ref types.ManagedObjectReference) types.ManagedEntityEventArgument {
+	arg := types.ManagedEntityEventArgument{Entity: ref}
+
+	if e, ok := ctx.Map.Get(ref).(mo.Entity); ok {
+		arg.Name = entityName(e)
+	}
+
+	return arg
+}
+
+func (m *AlarmManager) alarmArgument(alarm *Alarm) types.AlarmEventArgument {
+	return types.AlarmEventArgument{
+		EntityEventArgument: types.EntityEventArgument{Name: alarm.Info.Name},
+		Alarm:               alarm.Self,
+	}
+}
+
+// apply updates entity properties and posts an AlarmStatusChangedEvent for each change.
+func (m *AlarmManager) apply(ctx *Context, changes ...*alarmStatusChange) {
+	for _, change := range changes {
+		if change == nil {
+			continue
+		}
+
+		m.updateEntity(ctx, change.state.Entity)
+
+		m.mu.Lock()
+		alarm := m.alarms[change.state.Alarm]
+		m.mu.Unlock()
+
+		if alarm == nil {
+			continue
+		}
+
+		ctx.postEvent(&types.AlarmStatusChangedEvent{
+			AlarmEvent: types.AlarmEvent{
+				Event: entityEvent(ctx, change.state.Entity),
+				Alarm: m.alarmArgument(alarm),
+			},
+			Source: entityEventArgument(ctx, change.state.Entity),
+			Entity: entityEventArgument(ctx, alarm.Info.Entity),
+			From:   string(change.from),
+			To:     string(change.state.OverallStatus),
+		})
+	}
+}
+
+// list returns a copy of the current set of alarms.
+func (m *AlarmManager) list() []*Alarm {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	alarms := make([]*Alarm, 0, len(m.alarms))
+	for _, alarm := range m.alarms {
+		alarms = append(alarms, alarm)
+	}
+
+	return alarms
+}
+
+// evalState evaluates state alarms for the given entity.
+// If changes is non-nil, only alarms with a StatePath matching the changes are evaluated.
+func (m *AlarmManager) evalState(ctx *Context, ref types.ManagedObjectReference, alarms []*Alarm, changes []types.PropertyChange) []*alarmStatusChange {
+	var res []*alarmStatusChange
+
+	for _, alarm := range alarms {
+		if changes != nil && !alarmChangeMatches(alarm.Info.Expression, changes) {
+			continue
+		}
+
+		if !alarm.applies(ctx, ref) {
+			continue
+		}
+
+		status := evalAlarmExpression(ctx, ref, alarm.Info.Expression, nil)
+		if status == "" {
+			continue
+		}
+
+		if change := m.setStatus(ctx, alarm, ref, status, 0); change != nil {
+			res = append(res, change)
+		}
+	}
+
+	return res
+}
+
+// eventPosted evaluates event alarms for the given event, called by EventManager.PostEvent.
+func (m *AlarmManager) eventPosted(ctx *Context, event types.BaseEvent) {
+	if _, ok := event.(types.BaseAlarmEvent); ok {
+		return // avoid alarms triggering on alarm events
+	}
+
+	alarms := m.list()
+	if len(alarms) == 0 {
+		return
+	}
+
+	var changes []*alarmStatusChange
+
+	for _, alarm := range alarms {
+		ref := alarmEventEntity(event, "")
+		if x, ok := alarm.Info.Expression.(*types.EventAlarmExpression); ok {
+			ref = alarmEventEntity(event, x.ObjectType)
+		}
+		if ref == nil || !alarm.applies(ctx, *ref) {
+			continue
+		}
+
+		status := evalAlarmExpression(ctx, *ref, alarm.Info.Expression, event)
+		if status == "" {
+			continue
+		}
+
+		changes = append(changes, m.setStatus(ctx, alarm, *ref, status, event.GetEvent().Key))
+	}
+
+	m.apply(ctx, changes...)
+}
+
+func (m *AlarmManager) PutObject(obj mo.Reference) {
+	if _, ok := obj.(mo.Entity); !ok {
+		return
+	}
+
+	alarms := m.list()
+	if len(alarms) == 0 {
+		return
+	}
+
+	ctx := SpoofContext()
+	m.apply(ctx, m.evalState(ctx, obj.Reference(), alarms, nil)...)
+}
+
+func (m *AlarmManager) UpdateObject(obj mo.Reference, changes []types.PropertyChange) {
+	alarms := m.list()
+	if len(alarms) == 0 {
+		return
+	}
+
+	ctx := SpoofContext()
+	ref := obj.Reference()
+	if _, ok := ctx.Map.Get(ref).(mo.Entity); !ok {
+		return
+	}
+
+	m.apply(ctx, m.evalState(ctx, ref, alarms, changes)...)
+}
+
+func (m *AlarmManager) RemoveObject(ctx *Context, ref types.ManagedObjectReference) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, state := range m.state {
+		if state.Entity == ref {
+			delete(m.state, key)
+		}
+	}
+}
+
+// findState returns a copy of the alarm state for the given alarm and entity.
+func (m *AlarmManager) findState(alarm, entity types.ManagedObjectReference) (types.AlarmState, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state, ok := m.state[alarmStateKey(alarm, entity)]
+	if !ok {
+		return types.AlarmState{}, false
+	}
+
+	return *state, true
+}
+
+func (m *AlarmManager) CreateAlarm(ctx *Context, req *types.CreateAlarm) soap.HasFault {
+	body := new(methods.CreateAlarmBody)
+
+	if _, ok := ctx.Map.Get(req.Entity).(mo.Entity); !ok {
+		body.Fault_ = Fault("", &types.ManagedObjectNotFound{Obj: req.Entity})
+		return body
+	}
+
+	spec := req.Spec.GetAlarmSpec()
+	if spec.Expression == nil {
+		body.Fault_ = Fault("", &types.InvalidArgument{InvalidProperty: "spec.expression"})
+		return body
+	}
+
+	for _, alarm := range m.list() {
+		if alarm.Info.Entity == req.Entity && alarm.Info.Name == spec.Name {
+			body.Fault_ = Fault("", &types.DuplicateName{Name: spec.Name, Object: alarm.Self})
+			return body
+		}
+	}
+
+	alarm := &Alarm{}
+	alarm.Info.AlarmSpec = *spec
+	alarm.Info.Entity = req.Entity
+	alarm.Info.LastModifiedTime = ctx.Now()
+	alarm.Info.LastModifiedUser = ctx.Session.UserName
+
+	ref := ctx.Map.Put(alarm).Reference()
+	alarm.Info.Key = ref.Value
+	alarm.Info.Alarm = ref
+
+	event := &types.AlarmCreatedEvent{
+		AlarmEvent: types.AlarmEvent{
+			Event: entityEvent(ctx, req.Entity),
+			Alarm: m.alarmArgument(alarm),
+		},
+		Entity: entityEventArgument(ctx, req.Entity),
+	}
+	ctx.postEvent(event)
+	alarm.Info.CreationEventId = event.Key
+
+	m.mu.Lock()
+	m.alarms[ref] = alarm
+	m.mu.Unlock()
+
+	// Evaluate state alarm triggers for existing entities
+	var changes []*alarmStatusChange
+	alarms := []*Alarm{alarm}
+	for _, e := range ctx.Map.All("") {
+		eref := e.Reference()
+		if alarm.applies(ctx, eref) {
+			changes = append(changes, m.evalState(ctx, eref, alarms, nil)...)
+		}
+	}
+	m.apply(ctx, changes...)
+
+	body.Res = &types.CreateAlarmResponse{Returnval: ref}
+
+	return body
+}
+
+func (m *AlarmManager) GetAlarm(ctx *Context, req *types.GetAlarm) soap.HasFault {
+	body := &methods.GetAlarmBody{
+		Res: new(types.GetAlarmResponse),
+	}
+
+	for _, alarm := range m.list() {
+		if req.Entity == nil || *req.Entity == alarm.Info.Entity {
+			body.Res.Returnval = append(body.Res.Returnval, alarm.Self)
+		}
+	}
+
+	return body
+}
+
+func (m *AlarmManager) GetAlarmState(ctx *Context, req *types.GetAlarmState) soap.HasFault {
+	body := new(methods.GetAlarmStateBody)
+
+	if ctx.Map.Get(req.Entity) == nil {
+		body.Fault_ = Fault("", &types.ManagedObjectNotFound{Obj: req.Entity})
+		return body
+	}
+
+	body.Res = new(types.GetAlarmStateResponse)
+
+	m.mu.Lock()
+	for _, state := range m.state {
+		if state.Entity == req.Entity {
+			body.Res.Returnval = append(body.Res.Returnval, *state)
+		}
+	}
+	m.mu.Unlock()
+
+	return body
+}
+
+func (m *AlarmManager) AcknowledgeAlarm(ctx *Context, req *types.AcknowledgeAlarm) soap.HasFault {
+	body := new(methods.AcknowledgeAlarmBody)
+
+	m.mu.Lock()
+	alarm := m.alarms[req.Alarm]
+	state, ok := m.state[alarmStateKey(req.Alarm, req.Entity)]
+	if ok && alarm != nil {
+		now := ctx.Now()
+		state.Acknowledged = types.NewBool(true)
+		state.AcknowledgedByUser = ctx.Session.UserName
+		state.AcknowledgedTime = &now
+	}
+	m.mu.Unlock()
+
+	if alarm == nil {
+		body.Fault_ = Fault("", &types.ManagedObjectNotFound{Obj: req.Alarm})
+		return body
+	}
+
+	if ok {
+		m.updateEntity(ctx, req.Entity)
+
+		ctx.postEvent(&types.AlarmAcknowledgedEvent{
+			AlarmEvent: types.AlarmEvent{
+				Event: entityEvent(ctx, req.Entity),
+				Alarm: m.alarmArgument(alarm),
+			},
+			Source: entityEventArgument(ctx, req.Entity),
+			Entity: entityEventArgument(ctx, alarm.Info.Entity),
+		})
+	}
+
+	body.Res = new(types.AcknowledgeAlarmResponse)
+
+	return body
+}
+
+func (m *AlarmManager) ClearTriggeredAlarms(ctx *Context, req *types.ClearTriggeredAlarms) soap.HasFault {
+	filter := req.Filter
+
+	matches := func(alarm *Alarm, state *types.AlarmState) bool {
+		if len(filter.Status) != 0 {
+			found := false
+			for _, s := range filter.Status {
+				if s == state.OverallStatus {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+
+		switch types.AlarmFilterSpecAlarmTypeByEntity(filter.TypeEntity) {
+		case types.AlarmFilterSpecAlarmTypeByEntityEntityTypeHost:
+			if state.Entity.Type != "HostSystem" {
+				return false
+			}
+		case types.AlarmFilterSpecAlarmTypeByEntityEntityTypeVm:
+			if state.Entity.Type != "VirtualMachine" {
+				return false
+			}
+		}
+
+		switch types.AlarmFilterSpecAlarmTypeByTrigger(filter.TypeTrigger) {
+		case types.AlarmFilterSpecAlarmTypeByTriggerTriggerTypeEvent:
+			if _, ok := alarm.Info.Expression.(*types.EventAlarmExpression); !ok {
+				return false
+			}
+		case types.AlarmFilterSpecAlarmTypeByTriggerTriggerTypeMetric:
+			if _, ok := alarm.Info.Expression.(*types.MetricAlarmExpression); !ok {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	type cleared struct {
+		alarm *Alarm
+		state types.AlarmState
+	}
+	var states []cleared
+
+	m.mu.Lock()
+	for key, state := range m.state {
+		alarm := m.alarms[state.Alarm]
+		if alarm == nil || state.OverallStatus == types.ManagedEntityStatusGreen {
+			continue
+		}
+		if matches(alarm, state) {
+			states = append(states, cleared{alarm, *state})
+			delete(m.state, key)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, c := range states {
+		m.updateEntity(ctx, c.state.Entity)
+
+		ctx.postEvent(&types.AlarmClearedEvent{
+			AlarmEvent: types.AlarmEvent{
+				Event: entityEvent(ctx, c.state.Entity),
+				Alarm: m.alarmArgument(c.alarm),
+			},
+			Source: entityEventArgument(ctx, c.state.Entity),
+			Entity: entityEventArgument(ctx, c.alarm.Info.Entity),
+			From:   string(c.state.OverallStatus),
+		})
+	}
+
+	return &methods.ClearTriggeredAlarmsBody{
+		Res: new(types.ClearTriggeredAlarmsResponse),
+	}
+}
+
+func (m *AlarmManager) EnableAlarmActions(ctx *Context, req *types.EnableAlarmActions) soap.HasFault {
+	body := new(methods.EnableAlarmActionsBody)
+
+	obj, ok := ctx.Map.Get(req.Entity).(mo.Entity)
+	if !ok {
+		body.Fault_ = Fault("", &types.ManagedObjectNotFound{Obj: req.Entity})
+		return body
+	}
+
+	ctx.Map.WithLock(ctx, obj, func() {
+		ctx.Map.Update(obj, []types.PropertyChange{
+			{Name: "alarmActionsEnabled", Val: types.NewBool(req.Enabled)},
+		})
+	})
+
+	body.Res = new(types.EnableAlarmActionsResponse)
+
+	return body
+}
+
+func (m *AlarmManager) AreAlarmActionsEnabled(ctx *Context, req *types.AreAlarmActionsEnabled) soap.HasFault {
+	body := new(methods.AreAlarmActionsEnabledBody)
+
+	obj, ok := ctx.Map.Get(req.Entity).(mo.Entity)
+	if !ok {
+		body.Fault_ = Fault("", &types.ManagedObjectNotFound{Obj: req.Entity})
+		return body
+	}
+
+	body.Res = &types.AreAlarmActionsEnabledResponse{
+		Returnval: obj.Entity().AlarmActionsEnabled == nil || *obj.Entity().AlarmActionsEnabled,
+	}
+
+	return body
+}
+
+func (a *Alarm) ReconfigureAlarm(ctx *Context, req *types.ReconfigureAlarm) soap.HasFault {
+	body := new(methods.ReconfigureAlarmBody)
+
+	spec := req.Spec.GetAlarmSpec()
+	if spec.Expression == nil {
+		body.Fault_ = Fault("", &types.InvalidArgument{InvalidProperty: "spec.expression"})
+		return body
+	}
+
+	m := ctx.Map.AlarmManager()
+
+	m.mu.Lock()
+	a.Info.AlarmSpec = *spec
+	a.Info.LastModifiedTime = ctx.Now()
+	a.Info.LastModifiedUser = ctx.Session.UserName
+	m.mu.Unlock()
+
+	ctx.postEvent(&types.AlarmReconfiguredEvent{
+		AlarmEvent: types.AlarmEvent{
+			Event: entityEvent(ctx, a.Info.Entity),
+			Alarm: m.alarmArgument(a),
+		},
+		Entity: entityEventArgument(ctx, a.Info.Entity),
+	})
+
+	// Re-evaluate state alarm triggers with the new spec
+	var changes []*alarmStatusChange
+	alarms := []*Alarm{a}
+	for _, e := range ctx.Map.All("") {
+		if a.applies(ctx, e.Reference()) {
+			changes = append(changes, m.evalState(ctx, e.Reference(), alarms, nil)...)
+		}
+	}
+	m.apply(ctx, changes...)
+
+	body.Res = new(types.ReconfigureAlarmResponse)
+
+	return body
+}
+
+func (a *Alarm) RemoveAlarm(ctx *Context, req *types.RemoveAlarm) soap.HasFault {
+	m := ctx.Map.AlarmManager()
+
+	var entities []types.ManagedObjectReference
+
+	m.mu.Lock()
+	delete(m.alarms, a.Self)
+	for key, state := range m.state {
+		if state.Alarm == a.Self {
+			entities = append(entities, state.Entity)
+			delete(m.state, key)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, ref := range entities {
+		m.updateEntity(ctx, ref)
+	}
+
+	ctx.postEvent(&types.AlarmRemovedEvent{
+		AlarmEvent: types.AlarmEvent{
+			Event: entityEvent(ctx, a.Info.Entity),
+			Alarm: m.alarmArgument(a),
+		},
+		Entity: entityEventArgument(ctx, a.Info.Entity),
+	})
+
+	ctx.Map.Remove(ctx, a.Self)
+
+	return &methods.RemoveAlarmBody{
+		Res: new(types.RemoveAlarmResponse),
+	}
+}
@@ -0,0 +1,285 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"context"
+	"testing"
+
+	"github.com/zhengkes/govmomi/event"
+	"github.com/zhengkes/govmomi/find"
+	"github.com/zhengkes/govmomi/object"
+	"github.com/zhengkes/govmomi/vim25"
+	"github.com/zhengkes/govmomi/vim25/methods"
+	"github.com/zhengkes/govmomi/vim25/mo"
+	"github.com/zhengkes/govmomi/vim25/types"
+)
+
+func createAlarm(ctx context.Context, t *testing.T, c *vim25.Client, entity types.ManagedObjectReference, spec types.AlarmSpec) types.ManagedObjectReference {
+	t.Helper()
+
+	res, err := methods.CreateAlarm(ctx, c, &types.CreateAlarm{
+		This:   *c.ServiceContent.AlarmManager,
+		Entity: entity,
+		Spec:   &spec,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return res.Returnval
+}
+
+func alarmState(ctx context.Context, t *testing.T, c *vim25.Client, entity types.ManagedObjectReference) []types.AlarmState {
+	t.Helper()
+
+	res, err := methods.GetAlarmState(ctx, c, &types.GetAlarmState{
+		This:   *c.ServiceContent.AlarmManager,
+		Entity: entity,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return res.Returnval
+}
+
+func TestAlarmManagerStateTrigger(t *testing.T) {
+	Test(func(ctx context.Context, c *vim25.Client) {
+		vm, err := find.NewFinder(c).VirtualMachine(ctx, "DC0_H0_VM0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		root := c.ServiceContent.RootFolder
+
+		alarm := createAlarm(ctx, t, c, root, types.AlarmSpec{
+			Name:    "vm.powered.off",
+			Enabled: true,
+			Expression: &types.StateAlarmExpression{
+				Operator:  types.StateAlarmOperatorIsEqual,
+				Type:      "VirtualMachine",
+				StatePath: "runtime.powerState",
+				Red:       string(types.VirtualMachinePowerStatePoweredOff),
+			},
+		})
+
+		_, err = methods.CreateAlarm(ctx, c, &types.CreateAlarm{
+			This:   *c.ServiceContent.AlarmManager,
+			Entity: root,
+			Spec:   &types.AlarmSpec{Name: "vm.powered.off", Expression: &types.StateAlarmExpression{}},
+		})
+		if err == nil {
+			t.Error("expected DuplicateName fault")
+		}
+
+		alarms, err := methods.GetAlarm(ctx, c, &types.GetAlarm{This: *c.ServiceContent.AlarmManager, Entity: &root})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(alarms.Returnval) != 1 || alarms.Returnval[0] != alarm {
+			t.Errorf("alarms=%v", alarms.Returnval)
+		}
+
+		if s := alarmState(ctx, t, c, vm.Reference()); len(s) != 0 {
+			t.Fatalf("state=%#v", s)
+		}
+
+		task, err := vm.PowerOff(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = task.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		states := alarmState(ctx, t, c, vm.Reference())
+		if len(states) != 1 {
+			t.Fatalf("state=%#v", states)
+		}
+		state := states[0]
+		if state.OverallStatus != types.ManagedEntityStatusRed || state.Alarm != alarm {
+			t.Errorf("state=%#v", state)
+		}
+
+		var entity mo.ManagedEntity
+		for _, ref := range []types.ManagedObjectReference{vm.Reference(), root} {
+			err = vm.Properties(ctx, ref, []string{"triggeredAlarmState", "overallStatus"}, &entity)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entity.TriggeredAlarmState) != 1 {
+				t.Errorf("%s triggeredAlarmState=%#v", ref, entity.TriggeredAlarmState)
+			}
+		}
+		if entity.OverallStatus != types.ManagedEntityStatusGreen {
+			t.Errorf("root overallStatus=%s", entity.OverallStatus)
+		}
+
+		_, err = methods.AcknowledgeAlarm(ctx, c, &types.AcknowledgeAlarm{
+			This:   *c.ServiceContent.AlarmManager,
+			Alarm:  alarm,
+			Entity: vm.Reference(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		state = alarmState(ctx, t, c, vm.Reference())[0]
+		if state.Acknowledged == nil || !*state.Acknowledged || state.AcknowledgedTime == nil {
+			t.Errorf("state=%#v", state)
+		}
+
+		task, err = vm.PowerOn(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = task.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		state = alarmState(ctx, t, c, vm.Reference())[0]
+		if state.OverallStatus != types.ManagedEntityStatusGreen {
+			t.Errorf("state=%#v", state)
+		}
+
+		err = vm.Properties(ctx, vm.Reference(), []string{"triggeredAlarmState"}, &entity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entity.TriggeredAlarmState) != 0 {
+			t.Errorf("triggeredAlarmState=%#v", entity.TriggeredAlarmState)
+		}
+
+		events, err := event.NewManager(c).QueryEvents(ctx, types.EventFilterSpec{
+			EventTypeId: []string{"AlarmStatusChangedEvent"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != 2 {
+			t.Fatalf("events=%d", len(events))
+		}
+		change := events[0].(*types.AlarmStatusChangedEvent)
+		if change.To != string(types.ManagedEntityStatusGreen) || change.From != string(types.ManagedEntityStatusRed) {
+			t.Errorf("from=%s to=%s", change.From, change.To)
+		}
+		if change.Source.Entity != vm.Reference() || change.Entity.Entity != root {
+			t.Errorf("source=%s entity=%s", change.Source.Entity, change.Entity.Entity)
+		}
+
+		_, err = methods.RemoveAlarm(ctx, c, &types.RemoveAlarm{This: alarm})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if s := alarmState(ctx, t, c, vm.Reference()); len(s) != 0 {
+			t.Errorf("state=%#v", s)
+		}
+	})
+}
+
+func TestAlarmManagerHostConnection(t *testing.T) {
+	Test(func(ctx context.Context, c *vim25.Client) {
+		host := object.NewHostSystem(c, Map.Any("HostSystem").Reference())
+
+		createAlarm(ctx, t, c, host.Reference(), types.AlarmSpec{
+			Name:    "host.connection",
+			Enabled: true,
+			Expression: &types.StateAlarmExpression{
+				Operator:  types.StateAlarmOperatorIsUnequal,
+				Type:      "HostSystem",
+				StatePath: "runtime.connectionState",
+				Yellow:    string(types.HostSystemConnectionStateConnected),
+			},
+		})
+
+		task, err := host.Disconnect(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = task.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		var h mo.HostSystem
+		err = host.Properties(ctx, host.Reference(), []string{"triggeredAlarmState", "overallStatus"}, &h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(h.TriggeredAlarmState) != 1 || h.OverallStatus != types.ManagedEntityStatusYellow {
+			t.Errorf("overallStatus=%s triggeredAlarmState=%#v", h.OverallStatus, h.TriggeredAlarmState)
+		}
+
+		task, err = host.Reconnect(ctx, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = task.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		state := alarmState(ctx, t, c, host.Reference())
+		if len(state) != 1 || state[0].OverallStatus != types.ManagedEntityStatusGreen {
+			t.Errorf("state=%#v", state)
+		}
+	})
+}
+
+func TestAlarmManagerEventTrigger(t *testing.T) {
+	Test(func(ctx context.Context, c *vim25.Client) {
+		vm := Map.Any("VirtualMachine").(*VirtualMachine)
+
+		alarm := createAlarm(ctx, t, c, c.ServiceContent.RootFolder, types.AlarmSpec{
+			Name:    "vm.suspended",
+			Enabled: true,
+			Expression: &types.EventAlarmExpression{
+				EventType:  "VmSuspendedEvent",
+				ObjectType: "VirtualMachine",
+				Status:     types.ManagedEntityStatusYellow,
+			},
+		})
+
+		task, err := object.NewVirtualMachine(c, vm.Reference()).Suspend(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = task.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		state := alarmState(ctx, t, c, vm.Reference())
+		if len(state) != 1 {
+			t.Fatalf("state=%#v", state)
+		}
+		if state[0].Alarm != alarm || state[0].OverallStatus != types.ManagedEntityStatusYellow || state[0].EventKey == 0 {
+			t.Errorf("state=%#v", state[0])
+		}
+
+		_, err = methods.ClearTriggeredAlarms(ctx, c, &types.ClearTriggeredAlarms{
+			This:   *c.ServiceContent.AlarmManager,
+			Filter: types.AlarmFilterSpec{TypeTrigger: string(types.AlarmFilterSpecAlarmTypeByTriggerTriggerTypeEvent)},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if state = alarmState(ctx, t, c, vm.Reference()); len(state) != 0 {
+			t.Errorf("state=%#v", state)
+		}
+	})
+}
@@ -243,12 +243,13 @@ func (m *AuthorizationManager) AddAuthorizationRole(req *types.AddAuthorizationR
 		return body
 	}
 
+	id := m.nextID
 	m.RoleList = append(m.RoleList, types.AuthorizationRole{
 		Info: &types.Description{
 			Label:   req.Name,
 			Summary: req.Name,
 		},
-		RoleId:    m.nextID,
+		RoleId:    id,
 		Privilege: ids,
 		Name:      req.Name,
 		System:    false,
@@ -256,7 +257,9 @@ func (m *AuthorizationManager) AddAuthorizationRole(req *types.AddAuthorizationR
 
 	m.nextID++
 
-	body.Res = &types.AddAuthorizationRoleResponse{}
+	body.Res = &types.AddAuthorizationRoleResponse{
+		Returnval: id,
+	}
 
 	return body
 }
@@ -311,6 +314,62 @@ func (m *AuthorizationManager) RemoveAuthorizationRole(req *types.RemoveAuthoriz
 	return body
 }
 
+// principalName normalizes the given user name, such that "DOMAIN\user" and "user@domain" are equal.
+func principalName(name string) string {
+	name = strings.ToLower(name)
+	if domain, user, ok := strings.Cut(name, "\\"); ok {
+		return user + "@" + domain
+	}
+	return name
+}
+
+// hasPrivilege returns true if the given user has been granted the privilege on the given entity.
+// Permissions defined on an entity take precedence over those defined on its ancestors,
+// and permissions defined on an ancestor only apply when Propagate is true.
+// Permissions for groups are not applied, as the simulator does not track group membership.
+func (m *AuthorizationManager) hasPrivilege(ctx *Context, user string, entity types.ManagedObjectReference, id string) bool {
+	user = principalName(user)
+	ref := &entity
+
+	for ref != nil {
+		var roles []int32
+
+		for _, p := range m.permissions[*ref] {
+			if p.Group || principalName(p.Principal) != user {
+				continue
+			}
+			if *ref != entity && !p.Propagate {
+				continue
+			}
+			roles = append(roles, p.RoleId)
+		}
+
+		if len(roles) != 0 {
+			for _, role := range m.RoleList {
+				for _, rid := range roles {
+					if role.RoleId != rid {
+						continue
+					}
+					for _, priv := range role.Privilege {
+						if priv == id {
+							return true
+						}
+					}
+				}
+			}
+			return false
+		}
+
+		e, ok := ctx.Map.Get(*ref).(mo.Entity)
+		if !ok {
+			break
+		}
+		ref = e.Entity().Parent
+	}
+
+	return false
+}
+
 func (m *AuthorizationManager) privIDs(ids []string) ([]string, *soap.Fault) {
 	system := make(map[string]struct{}, len(m.system))
 
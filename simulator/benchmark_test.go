@@ -0,0 +1,317 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"github.com/zhengkes/govmomi/property"
+	"github.com/zhengkes/govmomi/simulator/esx"
+	"github.com/zhengkes/govmomi/view"
+	"github.com/zhengkes/govmomi/vim25"
+	"github.com/zhengkes/govmomi/vim25/methods"
+	"github.com/zhengkes/govmomi/vim25/mo"
+	"github.com/zhengkes/govmomi/vim25/types"
+)
+
+// benchSize is an inventory size used by the scale benchmarks
+type benchSize struct {
+	name    string
+	cluster int
+	host    int // per cluster
+	vm      int // per cluster
+}
+
+// benchSizes returns the inventory sizes used by the scale benchmarks.
+// Set VCSIM_BENCH_SCALE=1 to include the 100k VM, 2k host inventory, for example:
+//
+//	VCSIM_BENCH_SCALE=1 go test -run NONE -bench 'ModelCreate|RetrieveProperties|WaitForUpdates' -benchtime 1x ./simulator
+func benchSizes() []benchSize {
+	sizes := []benchSize{
+		{"vm=1000", 1, 10, 1000},
+		{"vm=10000", 5, 40, 2000},
+	}
+
+	if os.Getenv("VCSIM_BENCH_SCALE") != "" {
+		sizes = append(sizes, benchSize{"vm=100000", 20, 100, 5000})
+	}
+
+	return sizes
+}
+
+func (s benchSize) model() *Model {
+	m := VPX()
+	m.Host = 0
+	m.Cluster = s.cluster
+	m.ClusterHost = s.host
+	m.Machine = s.vm
+	return m
+}
+
+// run creates the Model, then calls f with the benchmark timer reset
+func (s benchSize) run(b *testing.B, f func(context.Context, *vim25.Client)) {
+	m := s.model()
+
+	if err := m.Create(); err != nil {
+		m.Remove()
+		b.Fatal(err)
+	}
+
+	err := m.Run(func(ctx context.Context, c *vim25.Client) error {
+		b.ResetTimer()
+		f(ctx, c)
+		b.StopTimer()
+		return nil
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkModelCreate(b *testing.B) {
+	for _, size := range benchSizes() {
+		size := size
+		b.Run(size.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				m := size.model()
+				err := m.Create()
+				b.StopTimer()
+				m.Remove()
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+func BenchmarkRetrieveProperties(b *testing.B) {
+	for _, size := range benchSizes() {
+		size := size
+		b.Run(size.name, func(b *testing.B) {
+			size.run(b, func(ctx context.Context, c *vim25.Client) {
+				v, err := view.NewManager(c).CreateContainerView(ctx, c.ServiceContent.RootFolder, []string{"VirtualMachine"}, true)
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				for i := 0; i < b.N; i++ {
+					var vms []mo.VirtualMachine
+					err = v.Retrieve(ctx, []string{"VirtualMachine"}, []string{"name", "runtime.powerState"}, &vms)
+					if err != nil {
+						b.Fatal(err)
+					}
+					if len(vms) != size.cluster*size.vm {
+						b.Fatalf("%d VMs", len(vms))
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkWaitForUpdates measures the time to receive updates for a batch of VMs
+// using a filter that selects all VMs in the inventory via a ContainerView.
+func BenchmarkWaitForUpdates(b *testing.B) {
+	const batch = 100
+
+	for _, size := range benchSizes() {
+		size := size
+		b.Run(size.name, func(b *testing.B) {
+			b.StopTimer()
+			size.run(b, func(ctx context.Context, c *vim25.Client) {
+				b.StopTimer()
+
+				v, err := view.NewManager(c).CreateContainerView(ctx, c.ServiceContent.RootFolder, []string{"VirtualMachine"}, true)
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				pc, err := property.DefaultCollector(c).Create(ctx)
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				filter := new(property.WaitFilter).Add(v.Reference(), "VirtualMachine",
+					[]string{"summary.quickStats.overallCpuUsage"}, v.TraversalSpec())
+				if _, err = pc.CreateFilter(ctx, filter.CreateFilter); err != nil {
+					b.Fatal(err)
+				}
+
+				wait := func(version string, n int) string {
+					for n > 0 {
+						res, err := methods.WaitForUpdatesEx(ctx, c, &types.WaitForUpdatesEx{This: pc.Reference(), Version: version})
+						if err != nil {
+							b.Fatal(err)
+						}
+						version = res.Returnval.Version
+						for _, fs := range res.Returnval.FilterSet {
+							n -= len(fs.ObjectSet)
+						}
+					}
+					return version
+				}
+
+				vms := Map.All("VirtualMachine")
+				version := wait("", len(vms)) // initial Enter of all VMs
+				spoof := SpoofContext()
+
+				b.StartTimer()
+				for i := 0; i < b.N; i++ {
+					for j := 0; j < batch; j++ {
+						vm := vms[(i*batch+j)%len(vms)]
+						Map.AtomicUpdate(spoof, vm, []types.PropertyChange{
+							{Name: "summary.quickStats.overallCpuUsage", Val: int32(i + 1)},
+						})
+					}
+					version = wait(version, batch)
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkWaitForUpdatesFilters measures the time to receive updates for a batch of VMs,
+// with the given number of filters that each select all VMs in the inventory via a ContainerView.
+// Filters are only traversed again when an update changes a path they traverse,
+// so the time per batch grows with the number of filters, but not the inventory size.
+func BenchmarkWaitForUpdatesFilters(b *testing.B) {
+	const batch = 100
+
+	for _, size := range benchSizes() {
+		for _, nfilters := range []int{1, 10, 50} {
+			size, nfilters := size, nfilters
+			b.Run(fmt.Sprintf("%s/filters=%d", size.name, nfilters), func(b *testing.B) {
+				b.StopTimer()
+				size.run(b, func(ctx context.Context, c *vim25.Client) {
+					b.StopTimer()
+
+					v, err := view.NewManager(c).CreateContainerView(ctx, c.ServiceContent.RootFolder, []string{"VirtualMachine"}, true)
+					if err != nil {
+						b.Fatal(err)
+					}
+
+					pc, err := property.DefaultCollector(c).Create(ctx)
+					if err != nil {
+						b.Fatal(err)
+					}
+
+					for i := 0; i < nfilters; i++ {
+						filter := new(property.WaitFilter).Add(v.Reference(), "VirtualMachine",
+							[]string{"summary.quickStats.overallCpuUsage"}, v.TraversalSpec())
+						if _, err = pc.CreateFilter(ctx, filter.CreateFilter); err != nil {
+							b.Fatal(err)
+						}
+					}
+
+					wait := func(version string, n int) string {
+						for n > 0 {
+							res, err := methods.WaitForUpdatesEx(ctx, c, &types.WaitForUpdatesEx{This: pc.Reference(), Version: version})
+							if err != nil {
+								b.Fatal(err)
+							}
+							version = res.Returnval.Version
+							for _, fs := range res.Returnval.FilterSet {
+								n -= len(fs.ObjectSet)
+							}
+						}
+						return version
+					}
+
+					vms := Map.All("VirtualMachine")
+					version := wait("", len(vms)*nfilters) // initial Enter of all VMs, per filter
+					spoof := SpoofContext()
+
+					b.StartTimer()
+					for i := 0; i < b.N; i++ {
+						for j := 0; j < batch; j++ {
+							vm := vms[(i*batch+j)%len(vms)]
+							Map.AtomicUpdate(spoof, vm, []types.PropertyChange{
+								{Name: "summary.quickStats.overallCpuUsage", Val: int32(i + 1)},
+							})
+						}
+						version = wait(version, batch*nfilters)
+					}
+				})
+			})
+		}
+	}
+}
+
+func BenchmarkRegistryGet(b *testing.B) {
+	r := NewRegistry()
+
+	var refs []types.ManagedObjectReference
+	for i := 0; i < 100000; i++ {
+		refs = append(refs, r.Put(new(mo.Folder)).Reference())
+	}
+
+	var n int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := int(atomic.AddInt64(&n, 1))
+		for pb.Next() {
+			if r.Get(refs[i%len(refs)]) == nil {
+				b.Error("not found")
+			}
+			i += 7
+		}
+	})
+}
+
+func BenchmarkRegistryWithLock(b *testing.B) {
+	r := NewRegistry()
+
+	var objs []mo.Reference
+	for i := 0; i < 100000; i++ {
+		objs = append(objs, r.Put(new(mo.Folder)))
+	}
+
+	var n int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		ctx := &Context{}
+		i := int(atomic.AddInt64(&n, 1))
+		for pb.Next() {
+			r.WithLock(ctx, objs[i%len(objs)], func() {})
+			i += 7
+		}
+	})
+}
+
+func BenchmarkWrapValue(b *testing.B) {
+	values := []interface{}{
+		[]types.ManagedObjectReference{{Type: "VirtualMachine", Value: "vm-1"}},
+		esx.VirtualDevice,
+		[]types.BaseOptionValue{&types.OptionValue{Key: "config.vpxd.stats.maxQueryMetrics", Value: "64"}},
+	}
+
+	for _, val := range values {
+		rval := reflect.ValueOf(val)
+		b.Run(rval.Type().String(), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_ = wrapValue(rval, rval.Type())
+			}
+		})
+	}
+}
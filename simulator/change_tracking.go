@@ -0,0 +1,343 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/google/uuid"
+
+	"github.com/zhengkes/govmomi/object"
+	"github.com/zhengkes/govmomi/vim25/methods"
+	"github.com/zhengkes/govmomi/vim25/soap"
+	"github.com/zhengkes/govmomi/vim25/types"
+)
+
+// changeBlockSize is the granularity at which changed disk areas are tracked
+const changeBlockSize = 64 * 1024
+
+// diskBlocks maps the offset of each non-zero block of a disk to the digest of its content
+type diskBlocks map[int64][sha1.Size]byte
+
+// changeTracker implements Changed Block Tracking for a virtual disk.
+// The state of the disk's blocks is recorded each time a change ID is assigned,
+// such that the areas written since any change ID can be computed.
+type changeTracker struct {
+	id     uuid.UUID
+	seq    int
+	epochs map[string]diskBlocks
+}
+
+func newChangeTracker() *changeTracker {
+	return &changeTracker{
+		id:     uuid.New(),
+		epochs: make(map[string]diskBlocks),
+	}
+}
+
+// checkpoint records the current state of the given disk file, returning its change ID
+func (t *changeTracker) checkpoint(file string) (string, error) {
+	blocks, err := readDiskBlocks(file)
+	if err != nil {
+		return "", err
+	}
+
+	id := fmt.Sprintf("%s/%d", t.id, t.seq)
+	t.seq++
+	t.epochs[id] = blocks
+
+	return id, nil
+}
+
+// readDiskBlocks returns the digest of each non-zero block in the given file.
+// A file that does not exist is treated as a disk containing only zeros.
+func readDiskBlocks(file string) (diskBlocks, error) {
+	blocks := make(diskBlocks)
+
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return blocks, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, changeBlockSize)
+	zero := make([]byte, changeBlockSize)
+
+	for offset := int64(0); ; offset += changeBlockSize {
+		n, err := io.ReadFull(f, buf)
+		copy(buf[n:], zero) // digest of a partial block must match that of the same block when the file is extended
+		if !bytes.Equal(buf, zero) {
+			blocks[offset] = sha1.Sum(buf)
+		}
+
+		switch err {
+		case nil:
+		case io.EOF, io.ErrUnexpectedEOF:
+			return blocks, nil
+		default:
+			return nil, err
+		}
+	}
+}
+
+// changedAreas returns the areas within start and end that differ between the base and target disk states.
+// A nil base matches all non-zero blocks of the target, as is the case for change ID "*".
+func changedAreas(base, target diskBlocks, start, end int64) []types.DiskChangeExtent {
+	var offsets []int64
+
+	for offset, digest := range target {
+		if prev, ok := base[offset]; !ok || prev != digest {
+			offsets = append(offsets, offset)
+		}
+	}
+
+	for offset := range base {
+		if _, ok := target[offset]; !ok {
+			offsets = append(offsets, offset)
+		}
+	}
+
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+
+	var areas []types.DiskChangeExtent
+
+	for _, offset := range offsets {
+		s, e := offset, offset+changeBlockSize
+		if s < start {
+			s = start
+		}
+		if e > end {
+			e = end
+		}
+		if s >= e {
+			continue
+		}
+
+		if n := len(areas); n != 0 && areas[n-1].Start+areas[n-1].Length == s {
+			areas[n-1].Length += e - s
+			continue
+		}
+
+		areas = append(areas, types.DiskChangeExtent{Start: s, Length: e - s})
+	}
+
+	return areas
+}
+
+// diskChangeID returns a pointer to the ChangeId field of the given disk's backing,
+// or nil if the backing type does not support Changed Block Tracking.
+func diskChangeID(disk *types.VirtualDisk) *string {
+	switch b := disk.Backing.(type) {
+	case *types.VirtualDiskFlatVer2BackingInfo:
+		return &b.ChangeId
+	case *types.VirtualDiskSparseVer2BackingInfo:
+		return &b.ChangeId
+	case *types.VirtualDiskRawDiskMappingVer1BackingInfo:
+		return &b.ChangeId
+	case *types.VirtualDiskRawDiskVer2BackingInfo:
+		return &b.ChangeId
+	}
+	return nil
+}
+
+// diskDataFile returns the local path of the file containing the given disk's data
+func (vm *VirtualMachine) diskDataFile(disk *types.VirtualDisk) string {
+	info := diskDataBacking(disk.Backing)
+	if info == nil {
+		return ""
+	}
+
+	var p object.DatastorePath
+	p.FromString(info.GetVirtualDeviceFileBackingInfo().FileName)
+	ds := vm.findDatastore(p.Datastore)
+
+	return diskDataFile(path.Join(ds.Info.GetDatastoreInfo().Url, p.Path))
+}
+
+// configureChangeTracking starts or stops tracking of changed disk areas,
+// as per the VM's changeTrackingEnabled config property.
+func (vm *VirtualMachine) configureChangeTracking() types.BaseMethodFault {
+	disks := object.VirtualDeviceList(vm.Config.Hardware.Device).SelectByType((*types.VirtualDisk)(nil))
+
+	if !isTrue(vm.Config.ChangeTrackingEnabled) {
+		for _, d := range disks {
+			if id := diskChangeID(d.(*types.VirtualDisk)); id != nil {
+				*id = ""
+			}
+		}
+		for _, t := range vm.cbt {
+			t.epochs = make(map[string]diskBlocks)
+		}
+		vm.cbt = nil
+		return nil
+	}
+
+	tracked := make(map[int32]*changeTracker)
+
+	for _, d := range disks {
+		disk := d.(*types.VirtualDisk)
+		id := diskChangeID(disk)
+		if id == nil {
+			continue
+		}
+
+		t := vm.cbt[disk.Key]
+		if t == nil {
+			t = newChangeTracker()
+			file := vm.diskDataFile(disk)
+			cid, err := t.checkpoint(file)
+			if err != nil {
+				return &types.FileFault{File: file}
+			}
+			*id = cid
+		}
+
+		tracked[disk.Key] = t
+	}
+
+	vm.cbt = tracked
+
+	return nil
+}
+
+// checkpointChangeTracking assigns a new change ID to each tracked disk, as done when a snapshot is created.
+func (vm *VirtualMachine) checkpointChangeTracking() types.BaseMethodFault {
+	for _, d := range object.VirtualDeviceList(vm.Config.Hardware.Device).SelectByType((*types.VirtualDisk)(nil)) {
+		disk := d.(*types.VirtualDisk)
+		t := vm.cbt[disk.Key]
+		if t == nil {
+			continue
+		}
+
+		file := vm.diskDataFile(disk)
+		id, err := t.checkpoint(file)
+		if err != nil {
+			return &types.FileFault{File: file}
+		}
+		*diskChangeID(disk) = id
+	}
+
+	return nil
+}
+
+// pruneChangeTracking discards the disk states recorded when the given snapshot was created, as done when it is removed.
+// A state that is still the current change ID of the VM's disk is retained, as the base for the next incremental backup.
+func (vm *VirtualMachine) pruneChangeTracking(snapshot *VirtualMachineSnapshot) {
+	devices := object.VirtualDeviceList(vm.Config.Hardware.Device)
+
+	for _, d := range snapshot.Config.Hardware.Device {
+		disk, ok := d.(*types.VirtualDisk)
+		if !ok {
+			continue
+		}
+		t := vm.cbt[disk.Key]
+		id := diskChangeID(disk)
+		if t == nil || id == nil {
+			continue
+		}
+
+		if current, ok := devices.FindByKey(disk.Key).(*types.VirtualDisk); ok {
+			if cid := diskChangeID(current); cid != nil && *cid == *id {
+				continue
+			}
+		}
+
+		delete(t.epochs, *id)
+	}
+}
+
+func (vm *VirtualMachine) queryChangedDiskAreas(ctx *Context, req *types.QueryChangedDiskAreas) (*types.DiskChangeInfo, types.BaseMethodFault) {
+	devices := object.VirtualDeviceList(vm.Config.Hardware.Device)
+
+	if req.Snapshot != nil {
+		snapshot, ok := ctx.Map.Get(*req.Snapshot).(*VirtualMachineSnapshot)
+		if !ok || snapshot.Vm != vm.Self {
+			return nil, &types.ManagedObjectNotFound{Obj: *req.Snapshot}
+		}
+		devices = snapshot.Config.Hardware.Device
+	}
+
+	disk, ok := devices.FindByKey(req.DeviceKey).(*types.VirtualDisk)
+	if !ok {
+		return nil, &types.InvalidArgument{InvalidProperty: "deviceKey"}
+	}
+
+	file := vm.diskDataFile(disk)
+	t := vm.cbt[disk.Key]
+	id := diskChangeID(disk)
+	if t == nil || id == nil || *id == "" {
+		return nil, &types.FileFault{File: file}
+	}
+
+	capacity := disk.CapacityInBytes
+	if capacity == 0 {
+		capacity = disk.CapacityInKB * 1024
+	}
+	if req.StartOffset < 0 || req.StartOffset > capacity {
+		return nil, &types.InvalidArgument{InvalidProperty: "startOffset"}
+	}
+
+	var base diskBlocks
+	if req.ChangeId != "*" {
+		base, ok = t.epochs[req.ChangeId]
+		if !ok {
+			return nil, &types.InvalidArgument{InvalidProperty: "changeId"}
+		}
+	}
+
+	target, ok := t.epochs[*id]
+	if req.Snapshot == nil {
+		var err error
+		target, err = readDiskBlocks(file)
+		if err != nil {
+			return nil, &types.FileFault{File: file}
+		}
+	} else if !ok {
+		return nil, &types.FileFault{File: file}
+	}
+
+	return &types.DiskChangeInfo{
+		StartOffset: req.StartOffset,
+		Length:      capacity - req.StartOffset,
+		ChangedArea: changedAreas(base, target, req.StartOffset, capacity),
+	}, nil
+}
+
+func (vm *VirtualMachine) QueryChangedDiskAreas(ctx *Context, req *types.QueryChangedDiskAreas) soap.HasFault {
+	body := new(methods.QueryChangedDiskAreasBody)
+
+	info, err := vm.queryChangedDiskAreas(ctx, req)
+	if err != nil {
+		body.Fault_ = Fault("", err)
+		return body
+	}
+
+	body.Res = &types.QueryChangedDiskAreasResponse{
+		Returnval: *info,
+	}
+
+	return body
+}
@@ -0,0 +1,178 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/zhengkes/govmomi/object"
+	"github.com/zhengkes/govmomi/vim25"
+	"github.com/zhengkes/govmomi/vim25/methods"
+	"github.com/zhengkes/govmomi/vim25/mo"
+	"github.com/zhengkes/govmomi/vim25/types"
+)
+
+func TestQueryChangedDiskAreas(t *testing.T) {
+	Test(func(ctx context.Context, c *vim25.Client) {
+		sim := Map.Any("VirtualMachine").(*VirtualMachine)
+		vm := object.NewVirtualMachine(c, sim.Self)
+
+		disk := object.VirtualDeviceList(sim.Config.Hardware.Device).SelectByType((*types.VirtualDisk)(nil))[0].(*types.VirtualDisk)
+		file := sim.diskDataFile(disk)
+
+		query := func(snapshot *types.ManagedObjectReference, changeID string) ([]types.DiskChangeExtent, error) {
+			res, err := methods.QueryChangedDiskAreas(ctx, c, &types.QueryChangedDiskAreas{
+				This:      sim.Self,
+				Snapshot:  snapshot,
+				DeviceKey: disk.Key,
+				ChangeId:  changeID,
+			})
+			if err != nil {
+				return nil, err
+			}
+			return res.Returnval.ChangedArea, nil
+		}
+
+		if _, err := query(nil, "*"); err == nil {
+			t.Error("expected error, CBT is not enabled")
+		}
+
+		task, err := vm.Reconfigure(ctx, types.VirtualMachineConfigSpec{ChangeTrackingEnabled: types.NewBool(true)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = task.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := os.OpenFile(file, os.O_RDWR, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		write := func(offset int64) {
+			if _, err := f.WriteAt([]byte("data"), offset); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		write(0)
+		write(changeBlockSize) // adjacent blocks are merged
+		write(changeBlockSize * 4)
+
+		areas, err := query(nil, "*")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect := []types.DiskChangeExtent{
+			{Start: 0, Length: changeBlockSize * 2},
+			{Start: changeBlockSize * 4, Length: changeBlockSize},
+		}
+		if !reflect.DeepEqual(areas, expect) {
+			t.Errorf("areas=%#v", areas)
+		}
+
+		snapshot := func(name string) *types.ManagedObjectReference {
+			task, err := vm.CreateSnapshot(ctx, name, "", false, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := task.WaitForResult(ctx, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ref := res.Result.(types.ManagedObjectReference)
+			return &ref
+		}
+
+		base := snapshot("base")
+		write(changeBlockSize * 8)
+		cur := snapshot("current")
+		write(changeBlockSize * 16) // not included in the "current" snapshot
+
+		info, err := vm.QueryChangedDiskAreas(ctx, base, cur, disk, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect = []types.DiskChangeExtent{{Start: changeBlockSize * 8, Length: changeBlockSize}}
+		if !reflect.DeepEqual(info.ChangedArea, expect) {
+			t.Errorf("areas=%#v", info.ChangedArea)
+		}
+
+		changeID := func(snapshot *types.ManagedObjectReference) string {
+			var s mo.VirtualMachineSnapshot
+			if err := vm.Properties(ctx, *snapshot, []string{"config.hardware"}, &s); err != nil {
+				t.Fatal(err)
+			}
+			return object.VirtualDeviceList(s.Config.Hardware.Device).FindByKey(disk.Key).(*types.VirtualDisk).Backing.(*types.VirtualDiskFlatVer2BackingInfo).ChangeId
+		}
+
+		id := changeID(cur)
+
+		areas, err = query(nil, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect = []types.DiskChangeExtent{{Start: changeBlockSize * 16, Length: changeBlockSize}}
+		if !reflect.DeepEqual(areas, expect) {
+			t.Errorf("areas=%#v", areas)
+		}
+
+		if _, err = query(nil, "invalid/0"); err == nil {
+			t.Error("expected error, invalid change ID")
+		}
+
+		// removing a snapshot prunes its disk state, unless it is still the current change ID
+		removeSnapshot := func(ref *types.ManagedObjectReference) {
+			task, err := vm.RemoveSnapshot(ctx, ref.Value, false, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = task.Wait(ctx); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		baseID := changeID(base)
+		removeSnapshot(base)
+		if _, err = query(nil, baseID); err == nil {
+			t.Error("expected error, change ID of removed snapshot")
+		}
+
+		removeSnapshot(cur)
+		if _, err = query(nil, id); err != nil {
+			t.Errorf("current change ID: %s", err)
+		}
+
+		// disabling CBT discards all disk states
+		tracker := sim.cbt[disk.Key]
+		task, err = vm.Reconfigure(ctx, types.VirtualMachineConfigSpec{ChangeTrackingEnabled: types.NewBool(false)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = task.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if sim.cbt != nil || len(tracker.epochs) != 0 {
+			t.Errorf("trackers=%d epochs=%d", len(sim.cbt), len(tracker.epochs))
+		}
+	})
+}
@@ -0,0 +1,570 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/zhengkes/govmomi/vim25"
+	"github.com/zhengkes/govmomi/vim25/mo"
+	"github.com/zhengkes/govmomi/vim25/types"
+	"github.com/zhengkes/govmomi/vim25/xml"
+)
+
+// checkpointDir is the subdirectory of a checkpoint containing state that is not represented by object properties.
+// Model.Load does not descend into subdirectories, so a checkpoint can also be loaded via 'vcsim -load'.
+const checkpointDir = "vcsim"
+
+// checkpointState is the vcsim specific state saved with a checkpoint.
+type checkpointState struct {
+	XMLName  xml.Name          `xml:"checkpoint"`
+	Counter  int64             `xml:"counter"`
+	EventKey int32             `xml:"eventKey"`
+	Event    []types.BaseEvent `xml:"event,omitempty,typeattr"`
+}
+
+// checkpointSkip are the types created by another object's init method and not saved with a checkpoint.
+var checkpointSkip = map[string]bool{
+	"GuestFileManager":         true,
+	"GuestProcessManager":      true,
+	"LicenseAssignmentManager": true,
+}
+
+// checkpointKeep are the types that are not replaced by Restore, such that sessions remain valid.
+var checkpointKeep = map[string]bool{
+	"ServiceInstance":   true,
+	"SessionManager":    true,
+	"PropertyCollector": true,
+}
+
+// restoreObject is implemented by objects with state derived from other objects,
+// called after all objects have been loaded by Model.Load or Registry.Restore.
+type restoreObject interface {
+	restore(*Context)
+}
+
+// discardObject is implemented by objects that need to release resources when replaced by Registry.Restore.
+type discardObject interface {
+	discard(*Context)
+}
+
+// Checkpoint saves the objects in the Registry to the given directory, along with the event history
+// and the files of each Datastore. The directory format is that of the 'govc object.save' command,
+// such that a checkpoint can be loaded into a new instance via Model.Load or restored in-process via Restore.
+func (r *Registry) Checkpoint(dir string) error {
+	ctx := SpoofContext()
+	ctx.Map = r
+
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+
+	// remove any previous checkpoint, as files are loaded by name
+	files, err := filepath.Glob(filepath.Join(dir, "*.xml"))
+	if err != nil {
+		return err
+	}
+	for _, name := range files {
+		if err = os.Remove(name); err != nil {
+			return err
+		}
+	}
+	if err = os.RemoveAll(filepath.Join(dir, checkpointDir)); err != nil {
+		return err
+	}
+
+	content, err := r.checkpointContent(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i, obj := range content {
+		name := filepath.Join(dir, fmt.Sprintf("%04d-%s.xml", i, obj.Obj.Encode()))
+		if err = writeXML(name, obj); err != nil {
+			return err
+		}
+	}
+
+	state := checkpointState{Counter: atomic.LoadInt64(&r.counter)}
+
+	if m := r.EventManager(); m != nil {
+		ctx.WithLock(m, func() {
+			state.EventKey = m.key
+			for e := m.history.page.Front(); e != nil; e = e.Next() {
+				state.Event = append(state.Event, e.Value.(types.BaseEvent))
+			}
+		})
+	}
+
+	if err = os.MkdirAll(filepath.Join(dir, checkpointDir), 0750); err != nil {
+		return err
+	}
+
+	if err = writeXML(filepath.Join(dir, checkpointDir, "checkpoint.xml"), state); err != nil {
+		return err
+	}
+
+	for _, obj := range r.All("Datastore") {
+		ds := obj.(*Datastore)
+		src := ds.Info.GetDatastoreInfo().Url
+		if _, err := os.Stat(src); err != nil {
+			continue // e.g. remote url of a loaded inventory
+		}
+		if err = copyDir(src, checkpointDatastoreDir(dir, ds)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkpointContent collects the properties of all objects in the Registry, starting with the ServiceInstance.
+func (r *Registry) checkpointContent(ctx *Context) ([]types.ObjectContent, error) {
+	var refs []types.ManagedObjectReference
+	kinds := make(map[string]bool)
+
+	r.m.RLock()
+	for ref := range r.objects {
+		if checkpointSkip[ref.Type] || ref == vim25.ServiceInstance {
+			continue
+		}
+		refs = append(refs, ref)
+		kinds[ref.Type] = true
+	}
+	r.m.RUnlock()
+
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].Type == refs[j].Type {
+			return refs[i].Value < refs[j].Value
+		}
+		return refs[i].Type < refs[j].Type
+	})
+
+	spec := types.PropertyFilterSpec{
+		ObjectSet: []types.ObjectSpec{{Obj: vim25.ServiceInstance}},
+		PropSet:   []types.PropertySpec{{Type: vim25.ServiceInstance.Type, PathSet: []string{"content"}}},
+	}
+
+	for _, ref := range refs {
+		spec.ObjectSet = append(spec.ObjectSet, types.ObjectSpec{Obj: ref})
+	}
+
+	for kind := range kinds {
+		spec.PropSet = append(spec.PropSet, types.PropertySpec{Type: kind, All: types.NewBool(true)})
+	}
+
+	pc := r.Get(r.content().PropertyCollector).(*PropertyCollector)
+
+	res, fault := pc.collect(ctx, &types.RetrievePropertiesEx{SpecSet: []types.PropertyFilterSpec{spec}})
+	if fault != nil {
+		return nil, fmt.Errorf("checkpoint: %#v", fault)
+	}
+
+	for i := range res.Objects {
+		obj := &res.Objects[i]
+		obj.MissingSet = nil
+
+		if obj.Obj.Type == "SessionManager" {
+			// sessions are not saved, as they remain valid after a Restore
+			var props []types.DynamicProperty
+			for _, p := range obj.PropSet {
+				switch p.Name {
+				case "currentSession", "sessionList":
+				default:
+					props = append(props, p)
+				}
+			}
+			obj.PropSet = props
+		}
+	}
+
+	return res.Objects, nil
+}
+
+// Restore replaces the objects in the Registry with those saved in the given directory by Checkpoint,
+// restoring the event history and the files of each Datastore.
+// The ServiceInstance, SessionManager and PropertyCollector are not replaced, such that client sessions remain valid.
+// The checkpoint is decoded and validated before any objects are replaced, such that an invalid checkpoint
+// leaves the Registry as-is. Datastore files are only restored to the directories of the Registry's own Datastores.
+// Restore should not be called while requests are in flight.
+func (r *Registry) Restore(dir string) error {
+	ctx := SpoofContext()
+	ctx.Map = r
+
+	content, err := readCheckpoint(dir)
+	if err != nil {
+		return err
+	}
+
+	state, err := readCheckpointState(dir)
+	if err != nil {
+		return err
+	}
+
+	var objs []mo.Reference
+
+	for _, c := range content {
+		if checkpointKeep[c.Obj.Type] {
+			continue
+		}
+
+		obj, err := decodeObject(r, c)
+		if err != nil {
+			return err
+		}
+		objs = append(objs, obj)
+	}
+
+	if err = r.validateDatastores(dir, objs); err != nil {
+		return err
+	}
+
+	r.m.Lock()
+	old := r.objects
+	r.objects = make(map[types.ManagedObjectReference]mo.Reference)
+	r.kinds = make(map[string]map[types.ManagedObjectReference]mo.Reference)
+	for ref, obj := range old {
+		if checkpointKeep[ref.Type] {
+			r.putObject(ref, obj)
+			continue
+		}
+		delete(r.handlers, ref)
+		r.removeLock(ref)
+	}
+	r.m.Unlock()
+
+	for _, obj := range old {
+		if x, ok := obj.(discardObject); ok && !checkpointKeep[obj.Reference().Type] {
+			x.discard(ctx)
+		}
+	}
+
+	for _, obj := range objs {
+		initObject(r, obj)
+
+		// objects are added without calling Put, which would reset entity status and notify handlers
+		r.m.Lock()
+		r.putObject(obj.Reference(), obj)
+		r.m.Unlock()
+	}
+
+	_, err = r.restoreState(ctx, dir, state)
+	return err
+}
+
+// validateDatastores returns an error if the checkpoint in dir has files for a Datastore that is not
+// one of the Registry's Datastores, or has a different url, as restoreState replaces the contents of the url directory.
+func (r *Registry) validateDatastores(dir string, objs []mo.Reference) error {
+	for _, obj := range objs {
+		ds, ok := obj.(*Datastore)
+		if !ok {
+			continue
+		}
+		if _, err := os.Stat(checkpointDatastoreDir(dir, ds)); err != nil {
+			continue
+		}
+
+		var url string
+		if ds.Info != nil {
+			url = ds.Info.GetDatastoreInfo().Url
+		}
+
+		current, ok := r.Get(ds.Self).(*Datastore)
+		if !ok || current.Info == nil || url == "" || current.Info.GetDatastoreInfo().Url != url {
+			return fmt.Errorf("checkpoint: datastore %s url %q is not a datastore of this instance", ds.Self.Value, url)
+		}
+	}
+
+	return nil
+}
+
+// restoreState restores the vcsim specific checkpoint state, if any, after all objects have been loaded.
+// Returns the datastore directories created by the restore.
+func (r *Registry) restoreState(ctx *Context, dir string, state *checkpointState) ([]string, error) {
+	for _, obj := range r.AllReference("") {
+		if x, ok := obj.(restoreObject); ok {
+			x.restore(ctx)
+		}
+	}
+
+	if state == nil {
+		return nil, nil
+	}
+
+	// references created after the restore must not collide with those in the checkpoint
+	if state.Counter > atomic.LoadInt64(&r.counter) {
+		atomic.StoreInt64(&r.counter, state.Counter)
+	}
+
+	if m := r.EventManager(); m != nil {
+		ctx.WithLock(m, func() {
+			m.key = state.EventKey
+			m.history.page.Init()
+			for _, e := range state.Event {
+				pushHistory(m.history.page, e)
+			}
+		})
+	}
+
+	var created []string
+
+	for _, obj := range r.All("Datastore") {
+		ds := obj.(*Datastore)
+		src := checkpointDatastoreDir(dir, ds)
+		if _, err := os.Stat(src); err != nil {
+			continue
+		}
+
+		dst := ds.Info.GetDatastoreInfo().Url
+		if _, err := os.Stat(dst); os.IsNotExist(err) {
+			created = append(created, dst)
+		} else if err = os.RemoveAll(dst); err != nil {
+			return created, err
+		}
+
+		if err := copyDir(src, dst); err != nil {
+			return created, err
+		}
+	}
+
+	return created, nil
+}
+
+func checkpointDatastoreDir(dir string, ds *Datastore) string {
+	return filepath.Join(dir, checkpointDir, "datastore", ds.Self.Value)
+}
+
+// readCheckpoint decodes the ObjectContent files in the given directory, in the order they were saved.
+func readCheckpoint(dir string) ([]types.ObjectContent, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.xml"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no checkpoint found in %s: %w", dir, os.ErrNotExist)
+	}
+	sort.Strings(files)
+
+	var content []types.ObjectContent
+
+	for _, name := range files {
+		var c types.ObjectContent
+		if err = readXML(name, &c); err != nil {
+			return nil, err
+		}
+		content = append(content, c)
+	}
+
+	return content, nil
+}
+
+// readCheckpointState decodes the vcsim specific state of a checkpoint, returning nil if the directory
+// was not created by Checkpoint, such as a directory created by 'govc object.save'.
+func readCheckpointState(dir string) (*checkpointState, error) {
+	state := new(checkpointState)
+
+	err := readXML(filepath.Join(dir, checkpointDir, "checkpoint.xml"), state)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return state, nil
+}
+
+func readXML(name string, data interface{}) error {
+	f, err := os.Open(filepath.Clean(name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := xml.NewDecoder(f)
+	dec.TypeFunc = types.TypeFunc()
+	return dec.Decode(data)
+}
+
+func writeXML(name string, data interface{}) error {
+	f, err := os.Create(filepath.Clean(name))
+	if err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(f)
+	enc.Indent("", "  ")
+	if err = enc.Encode(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// copyDir recursively copies the regular files and directories in src to dst.
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		if info.IsDir() {
+			return os.MkdirAll(target, 0750)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		return copyFile(path, target, info.Mode())
+	})
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(filepath.Clean(src))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(filepath.Clean(dst), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+// ServeCheckpoint is the vcsim admin endpoint for saving and restoring the simulator state, see ServeAdmin.
+// Checkpoints are saved in subdirectories of Service.CheckpointDir, named by the "dir" query parameter.
+// POST saves a checkpoint to the named directory, or a new directory if not specified.
+// The response is a JSON object with the directory name and its path, which can be loaded via 'vcsim -load'.
+// PUT restores the checkpoint in the named directory.
+func (s *Service) ServeCheckpoint(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("dir")
+	if name != "" && !isCheckpointName(name) {
+		http.Error(w, fmt.Sprintf("invalid checkpoint name %q", name), http.StatusBadRequest)
+		return
+	}
+
+	registry := s.registry()
+	var root, dir string
+	var err error
+
+	switch r.Method {
+	case http.MethodPost:
+		if root, err = s.checkpointRoot(true); err != nil {
+			break
+		}
+		if err = os.MkdirAll(root, 0750); err != nil {
+			break
+		}
+		dir = filepath.Join(root, name)
+		if name == "" {
+			dir, err = os.MkdirTemp(root, "checkpoint-")
+			if err != nil {
+				break
+			}
+			name = filepath.Base(dir)
+		}
+		err = registry.Checkpoint(dir)
+	case http.MethodPut:
+		if name == "" {
+			http.Error(w, "dir parameter is required", http.StatusBadRequest)
+			return
+		}
+		if root, _ = s.checkpointRoot(false); root == "" {
+			http.Error(w, fmt.Sprintf("checkpoint %q not found", name), http.StatusNotFound)
+			return
+		}
+		dir = filepath.Join(root, name)
+		err = registry.Restore(dir)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, os.ErrNotExist) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{"dir": name, "path": dir})
+}
+
+// checkpointRoot returns the directory containing the checkpoints of ServeCheckpoint, which is Service.CheckpointDir if set.
+// Otherwise a temporary directory is created if create is true, else an empty string is returned until one is created.
+func (s *Service) checkpointRoot(create bool) (string, error) {
+	if s.CheckpointDir != "" {
+		return s.CheckpointDir, nil
+	}
+
+	s.checkpointMu.Lock()
+	defer s.checkpointMu.Unlock()
+
+	if s.checkpointTemp == "" && create {
+		dir, err := os.MkdirTemp("", "vcsim-checkpoint-")
+		if err != nil {
+			return "", err
+		}
+		s.checkpointTemp = dir
+	}
+
+	return s.checkpointTemp, nil
+}
+
+// removeCheckpointTemp removes the temporary checkpoint directory, if created by checkpointRoot.
+func (s *Service) removeCheckpointTemp() {
+	s.checkpointMu.Lock()
+	defer s.checkpointMu.Unlock()
+
+	if s.checkpointTemp != "" {
+		_ = os.RemoveAll(s.checkpointTemp)
+		s.checkpointTemp = ""
+	}
+}
+
+// isCheckpointName returns true if name is a single path element, such that it cannot refer to
+// a directory outside of Service.CheckpointDir.
+func isCheckpointName(name string) bool {
+	return name != "." && name != ".." && !strings.ContainsAny(name, `/\:`)
+}
@@ -0,0 +1,347 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zhengkes/govmomi/event"
+	"github.com/zhengkes/govmomi/find"
+	"github.com/zhengkes/govmomi/object"
+	"github.com/zhengkes/govmomi/vim25"
+	"github.com/zhengkes/govmomi/vim25/types"
+)
+
+func TestCheckpoint(t *testing.T) {
+	Test(func(ctx context.Context, c *vim25.Client) {
+		finder := find.NewFinder(c)
+
+		vm, err := finder.VirtualMachine(ctx, "DC0_H0_VM0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		task, err := vm.PowerOff(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = task.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		ds := Map.Any("Datastore").(*Datastore)
+		dsdir := ds.Info.GetDatastoreInfo().Url
+		if err = os.WriteFile(filepath.Join(dsdir, "saved.txt"), []byte("saved"), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		events := func() []types.BaseEvent {
+			e, err := event.NewManager(c).QueryEvents(ctx, types.EventFilterSpec{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return e
+		}
+		nevents := len(events())
+
+		checkpoint := func(method string, dir string) int {
+			u := c.URL()
+			u.Path = "/vcsim/admin/checkpoint"
+			u.RawQuery = url.Values{"dir": {dir}}.Encode()
+			req, err := http.NewRequest(method, u.String(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.SetBasicAuth("user", "pass")
+			res, err := (&http.Client{Transport: c.DefaultTransport()}).Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = res.Body.Close()
+			return res.StatusCode
+		}
+
+		// checkpoints can only be saved and restored within the Service.CheckpointDir
+		for _, dir := range []string{t.TempDir(), "../state", ".."} {
+			for _, method := range []string{http.MethodPost, http.MethodPut} {
+				if status := checkpoint(method, dir); status != http.StatusBadRequest {
+					t.Errorf("%s %s: status=%d", method, dir, status)
+				}
+			}
+		}
+
+		if status := checkpoint(http.MethodPut, "enoent"); status != http.StatusNotFound {
+			t.Errorf("status=%d", status)
+		}
+
+		if status := checkpoint(http.MethodPost, "state"); status != http.StatusOK {
+			t.Fatalf("status=%d", status)
+		}
+
+		// change the inventory, events and datastore files
+		task, err = vm.Destroy(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = task.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		folder, err := finder.DefaultFolder(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = folder.CreateFolder(ctx, "transient"); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = os.Remove(filepath.Join(dsdir, "saved.txt")); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(filepath.Join(dsdir, "transient.txt"), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		clock := Map.Clock()
+		handlers := func() int {
+			clock.mu.Lock()
+			defer clock.mu.Unlock()
+			return len(clock.handlers)
+		}
+		nhandlers := handlers()
+
+		if status := checkpoint(http.MethodPut, "state"); status != http.StatusOK {
+			t.Fatalf("status=%d", status)
+		}
+
+		// the restored ScheduledTaskManager replaces the Clock handler of the discarded one
+		if n := handlers(); n != nhandlers {
+			t.Errorf("clock handlers=%d, expected %d", n, nhandlers)
+		}
+		if stm := Map.ScheduledTaskManager(); stm.Clock != clock {
+			t.Error("restored ScheduledTaskManager does not use the Registry Clock")
+		}
+
+		// the client session remains valid after the restore
+		vm, err = finder.VirtualMachine(ctx, "DC0_H0_VM0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		state, err := vm.PowerState(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state != types.VirtualMachinePowerStatePoweredOff {
+			t.Errorf("state=%s", state)
+		}
+
+		if _, err = finder.Folder(ctx, "vm/transient"); err == nil {
+			t.Error("expected transient folder to be removed")
+		}
+
+		if n := len(events()); n != nevents {
+			t.Errorf("events=%d, expected %d", n, nevents)
+		}
+
+		if _, err = os.Stat(filepath.Join(dsdir, "saved.txt")); err != nil {
+			t.Error(err)
+		}
+		if _, err = os.Stat(filepath.Join(dsdir, "transient.txt")); err == nil {
+			t.Error("expected transient.txt to be removed")
+		}
+
+		// restored objects can be used as before
+		task, err = vm.PowerOn(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = task.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		host := Map.Any("HostSystem").(*HostSystem)
+		if Map.Get(*host.ConfigManager.DatastoreSystem).(*HostDatastoreSystem).Host != &host.HostSystem {
+			t.Error("HostDatastoreSystem.Host not restored")
+		}
+
+		// new references must not collide with restored objects
+		folder, err = finder.DefaultFolder(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := folder.CreateFolder(ctx, "new")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = object.NewFolder(c, f.Reference()).ObjectName(ctx); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestCheckpointLoad(t *testing.T) {
+	m := VPX()
+	defer m.Remove()
+
+	if err := m.Create(); err != nil {
+		t.Fatal(err)
+	}
+
+	nvm := len(Map.All("VirtualMachine"))
+	nevent := Map.EventManager().history.page.Len()
+
+	dir := t.TempDir()
+	if err := Map.Checkpoint(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	load := new(Model)
+	defer load.Remove()
+
+	if err := load.Load(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(Map.All("VirtualMachine")); n != nvm {
+		t.Errorf("vms=%d, expected %d", n, nvm)
+	}
+
+	if n := Map.EventManager().history.page.Len(); n != nevent {
+		t.Errorf("events=%d, expected %d", n, nevent)
+	}
+
+	if err := Map.Restore(filepath.Join(dir, "enoent")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("err=%v", err)
+	}
+}
+
+func TestCheckpointRestoreInvalid(t *testing.T) {
+	m := VPX()
+	defer m.Remove()
+
+	if err := m.Create(); err != nil {
+		t.Fatal(err)
+	}
+
+	nobj := len(Map.All(""))
+
+	dir := t.TempDir()
+	if err := Map.Checkpoint(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// an invalid object leaves the Registry as-is
+	bad := filepath.Join(dir, "9999-bad.xml")
+	if err := os.WriteFile(bad, []byte("<obj>"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Map.Restore(dir); err == nil {
+		t.Error("expected error")
+	}
+	if n := len(Map.All("")); n != nobj {
+		t.Errorf("objects=%d, expected %d", n, nobj)
+	}
+
+	if err := os.Remove(bad); err != nil {
+		t.Fatal(err)
+	}
+
+	// datastore files are only restored to the Registry's own datastore directories
+	ds := Map.Any("Datastore").(*Datastore)
+	dsurl := ds.Info.GetDatastoreInfo().Url
+	files, err := filepath.Glob(filepath.Join(dir, "*.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range files {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = bytes.ReplaceAll(data, []byte(dsurl), []byte(t.TempDir()))
+		if err = os.WriteFile(name, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := Map.Restore(dir); err == nil {
+		t.Error("expected error")
+	}
+	if n := len(Map.All("")); n != nobj {
+		t.Errorf("objects=%d, expected %d", n, nobj)
+	}
+}
+
+func TestCheckpointTempDir(t *testing.T) {
+	m := VPX()
+	defer m.Remove()
+
+	if err := m.Create(); err != nil {
+		t.Fatal(err)
+	}
+
+	s := m.Service.NewServer()
+	defer s.Close()
+
+	checkpoint := func(method string) int {
+		u := *s.URL
+		u.User = nil
+		u.Path = "/vcsim/admin/checkpoint"
+		u.RawQuery = "dir=state"
+		req, err := http.NewRequest(method, u.String(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth("user", "pass")
+		res, err := s.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = res.Body.Close()
+		return res.StatusCode
+	}
+
+	// the temporary directory is only created by the first save
+	if status := checkpoint(http.MethodPut); status != http.StatusNotFound {
+		t.Errorf("status=%d", status)
+	}
+	if dir, _ := m.Service.checkpointRoot(false); dir != "" {
+		t.Errorf("dir=%s", dir)
+	}
+
+	if status := checkpoint(http.MethodPost); status != http.StatusOK {
+		t.Fatalf("status=%d", status)
+	}
+	dir, _ := m.Service.checkpointRoot(false)
+	if _, err := os.Stat(filepath.Join(dir, "state")); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Close()
+	if _, err := os.Stat(dir); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("%s: %v", dir, err)
+	}
+}
@@ -0,0 +1,318 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Clock is a controllable time source.
+// The zero value follows the system clock. Freeze stops the clock, after which time
+// only moves when Advance or Set is called, making time based behavior deterministic in tests.
+// The Service Clock is used for task, event, session and performance sample times,
+// see Context.Now, and to time guest customization and NFC lease expiry, see Clock.AfterFunc.
+type Clock struct {
+	mu       sync.Mutex
+	offset   time.Duration
+	frozen   *time.Time
+	handlers []*func(time.Time)
+	timers   []*ClockTimer
+	timer    *time.Timer // fires when the next of timers is due, unless frozen
+}
+
+// ClockTimer calls a function once the Clock reaches a given time, see Clock.AfterFunc.
+type ClockTimer struct {
+	c    *Clock
+	when time.Time
+	f    func()
+}
+
+// Now returns the current time of the Clock.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now()
+}
+
+func (c *Clock) now() time.Time {
+	if c.frozen != nil {
+		return *c.frozen
+	}
+	return time.Now().Add(c.offset)
+}
+
+// Frozen returns true if the Clock has been stopped via Freeze.
+func (c *Clock) Frozen() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.frozen != nil
+}
+
+// Freeze stops the Clock at its current time.
+func (c *Clock) Freeze() {
+	c.mu.Lock()
+	if c.frozen == nil {
+		now := c.now()
+		c.frozen = &now
+	}
+	c.mu.Unlock()
+
+	c.notify()
+}
+
+// Resume restarts a frozen Clock, continuing from the time at which it was stopped.
+func (c *Clock) Resume() {
+	c.mu.Lock()
+	if c.frozen != nil {
+		c.offset = c.frozen.Sub(time.Now())
+		c.frozen = nil
+	}
+	c.mu.Unlock()
+
+	c.notify()
+}
+
+// Advance moves the Clock forward by the given duration.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	if c.frozen != nil {
+		now := c.frozen.Add(d)
+		c.frozen = &now
+	} else {
+		c.offset += d
+	}
+	c.mu.Unlock()
+
+	c.notify()
+}
+
+// Set changes the Clock's current time to the given time.
+func (c *Clock) Set(now time.Time) {
+	c.mu.Lock()
+	if c.frozen != nil {
+		c.frozen = &now
+	} else {
+		c.offset = now.Sub(time.Now())
+	}
+	c.mu.Unlock()
+
+	c.notify()
+}
+
+// OnChange registers a function to be called when the Clock is changed via Freeze, Resume, Advance or Set.
+// The returned function removes the registration.
+func (c *Clock) OnChange(f func(time.Time)) func() {
+	h := &f
+
+	c.mu.Lock()
+	c.handlers = append(c.handlers, h)
+	c.mu.Unlock()
+
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		for i := range c.handlers {
+			if c.handlers[i] == h {
+				c.handlers = append(c.handlers[:i], c.handlers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+func (c *Clock) notify() {
+	c.mu.Lock()
+	now := c.now()
+	handlers := append([]*func(time.Time){}, c.handlers...)
+	due := c.schedule()
+	c.mu.Unlock()
+
+	for _, t := range due {
+		go t.f()
+	}
+
+	for _, f := range handlers {
+		(*f)(now)
+	}
+}
+
+// AfterFunc calls f in its own goroutine once the Clock has advanced by the given duration,
+// whether via the passing of time or via Advance or Set. The timer does not fire while the Clock is frozen.
+func (c *Clock) AfterFunc(d time.Duration, f func()) *ClockTimer {
+	c.mu.Lock()
+	t := &ClockTimer{c: c, when: c.now().Add(d), f: f}
+	c.timers = append(c.timers, t)
+	due := c.schedule()
+	c.mu.Unlock()
+
+	for _, t := range due {
+		go t.f()
+	}
+
+	return t
+}
+
+// After waits for the Clock to advance by the given duration and then sends the current time on the returned channel.
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	c.AfterFunc(d, func() { ch <- c.Now() })
+	return ch
+}
+
+// Stop prevents the ClockTimer from firing, returning false if the timer has already fired or been stopped.
+func (t *ClockTimer) Stop() bool {
+	c := t.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := range c.timers {
+		if c.timers[i] == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// schedule removes and returns the timers that are due, arming a system timer for the next timer, if any.
+// The caller must hold c.mu.
+func (c *Clock) schedule() []*ClockTimer {
+	now := c.now()
+
+	var due, pending []*ClockTimer
+	var next time.Duration
+
+	for _, t := range c.timers {
+		d := t.when.Sub(now)
+		if d <= 0 {
+			due = append(due, t)
+			continue
+		}
+		if len(pending) == 0 || d < next {
+			next = d
+		}
+		pending = append(pending, t)
+	}
+
+	c.timers = pending
+
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+
+	if len(pending) != 0 && c.frozen == nil {
+		c.timer = time.AfterFunc(next, func() {
+			c.mu.Lock()
+			due := c.schedule()
+			c.mu.Unlock()
+
+			for _, t := range due {
+				go t.f()
+			}
+		})
+	}
+
+	return due
+}
+
+// systemClock is used when neither the Service or Registry have a Clock, following the system time.
+var systemClock = new(Clock)
+
+// Clock returns the time source of the Context's Service,
+// or the Registry's Clock for a Context not associated with a Service, such as SpoofContext.
+func (c *Context) Clock() *Clock {
+	if c.svc != nil && c.svc.Clock != nil {
+		return c.svc.Clock
+	}
+	if c.Map != nil {
+		return c.Map.Clock()
+	}
+	return systemClock
+}
+
+// Clock returns the time source of the Registry, as set by the Model.
+func (r *Registry) Clock() *Clock {
+	if r.clock != nil {
+		return r.clock
+	}
+	return systemClock
+}
+
+// Now returns the current time of the Context's Clock.
+func (c *Context) Now() time.Time {
+	return c.Clock().Now()
+}
+
+// ServeClock is the vcsim admin endpoint for the Service Clock, see ServeAdmin.
+// GET returns the current time and whether the Clock is frozen.
+// POST changes the Clock via one of the query parameters:
+// freeze=true|false to Freeze or Resume, advance=duration to Advance or set=RFC3339 time to Set.
+func (s *Service) ServeClock(w http.ResponseWriter, r *http.Request) {
+	clock := s.Clock
+	if clock == nil {
+		http.Error(w, "clock is not configured", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		q := r.URL.Query()
+		switch {
+		case q.Has("freeze"):
+			if q.Get("freeze") == "false" {
+				clock.Resume()
+			} else {
+				clock.Freeze()
+			}
+		case q.Has("advance"):
+			d, err := time.ParseDuration(q.Get("advance"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			clock.Advance(d)
+		case q.Has("set"):
+			now, err := time.Parse(time.RFC3339, q.Get("set"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			clock.Set(now)
+		default:
+			http.Error(w, "one of freeze, advance or set parameters is required", http.StatusBadRequest)
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(struct {
+		Now    time.Time `json:"now"`
+		Frozen bool      `json:"frozen"`
+	}{clock.Now(), clock.Frozen()})
+}
@@ -0,0 +1,179 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/zhengkes/govmomi/event"
+	"github.com/zhengkes/govmomi/object"
+	"github.com/zhengkes/govmomi/performance"
+	"github.com/zhengkes/govmomi/vim25"
+	"github.com/zhengkes/govmomi/vim25/methods"
+	"github.com/zhengkes/govmomi/vim25/types"
+)
+
+func TestServiceClock(t *testing.T) {
+	defer func(idle time.Duration) {
+		SessionIdleTimeout = idle
+	}(SessionIdleTimeout)
+
+	SessionIdleTimeout = time.Hour
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	m := VPX()
+	m.Clock = new(Clock)
+	m.Clock.Freeze()
+	m.Clock.Set(start)
+
+	err := m.Run(func(ctx context.Context, c *vim25.Client) error {
+		now, err := methods.GetCurrentTime(ctx, c)
+		if err != nil {
+			return err
+		}
+		if !now.Equal(start) {
+			t.Errorf("CurrentTime=%s", now)
+		}
+
+		// task times
+		m.Clock.Advance(time.Minute)
+		want := start.Add(time.Minute)
+
+		vm := object.NewVirtualMachine(c, Map.Any("VirtualMachine").Reference())
+		tsk, err := vm.PowerOff(ctx)
+		if err != nil {
+			return err
+		}
+		info, err := tsk.WaitForResult(ctx)
+		if err != nil {
+			return err
+		}
+		if !info.QueueTime.Equal(want) || !info.StartTime.Equal(want) || !info.CompleteTime.Equal(want) {
+			t.Errorf("queueTime=%s startTime=%s completeTime=%s", info.QueueTime, info.StartTime, info.CompleteTime)
+		}
+
+		// event times and time filters
+		events, err := event.NewManager(c).QueryEvents(ctx, types.EventFilterSpec{
+			Time: &types.EventFilterSpecByTime{BeginTime: types.NewTime(want)},
+		})
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			t.Error("no events")
+		}
+		for _, e := range events {
+			if created := e.GetEvent().CreatedTime; !created.Equal(want) {
+				t.Errorf("%T createdTime=%s", e, created)
+			}
+		}
+
+		// perf samples end at the current time
+		pm := performance.NewManager(c)
+		spec := types.PerfQuerySpec{MaxSample: 1, IntervalId: 20}
+		sample, err := pm.SampleByName(ctx, spec, []string{"cpu.usagemhz.average"}, []types.ManagedObjectReference{vm.Reference()})
+		if err != nil {
+			return err
+		}
+		if ts := sample[0].(*types.PerfEntityMetric).SampleInfo[0].Timestamp; !ts.Equal(want) {
+			t.Errorf("sample timestamp=%s", ts)
+		}
+
+		// advance past SessionIdleTimeout via the vcsim admin endpoint
+		u := c.URL()
+		u.Path = "/vcsim/admin/clock"
+		u.RawQuery = "advance=2h"
+		req, err := http.NewRequest(http.MethodPost, u.String(), nil)
+		if err != nil {
+			return err
+		}
+		req.SetBasicAuth("user", "pass")
+		res, err := (&http.Client{Transport: c.DefaultTransport()}).Do(req)
+		if err != nil {
+			return err
+		}
+		var state struct {
+			Now    time.Time `json:"now"`
+			Frozen bool      `json:"frozen"`
+		}
+		err = json.NewDecoder(res.Body).Decode(&state)
+		_ = res.Body.Close()
+		if err != nil {
+			return err
+		}
+		if !state.Now.Equal(want.Add(2*time.Hour)) || !state.Frozen {
+			t.Errorf("clock=%#v", state)
+		}
+
+		_, err = object.NewFolder(c, c.ServiceContent.RootFolder).CreateFolder(ctx, "expired")
+		if !isNotAuthenticated(err) {
+			t.Errorf("err=%v", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClockAfterFunc(t *testing.T) {
+	c := new(Clock)
+	c.Freeze()
+
+	fired := make(chan string, 3)
+	c.AfterFunc(time.Minute, func() { fired <- "minute" })
+	stopped := c.AfterFunc(time.Minute, func() { fired <- "stopped" })
+	c.AfterFunc(time.Hour, func() { fired <- "hour" })
+
+	if !stopped.Stop() {
+		t.Error("Stop=false")
+	}
+	if stopped.Stop() {
+		t.Error("Stop=true")
+	}
+
+	// a frozen clock only moves via Advance or Set
+	select {
+	case name := <-fired:
+		t.Fatalf("%s fired", name)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	c.Advance(time.Minute)
+	if name := <-fired; name != "minute" {
+		t.Errorf("fired=%s", name)
+	}
+
+	c.Set(c.Now().Add(time.Hour))
+	if name := <-fired; name != "hour" {
+		t.Errorf("fired=%s", name)
+	}
+
+	// timers follow the system time when the clock is not frozen
+	c.Resume()
+	select {
+	case <-c.After(10 * time.Millisecond):
+	case <-time.After(5 * time.Second):
+		t.Error("After did not fire")
+	}
+}
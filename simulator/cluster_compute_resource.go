@@ -19,8 +19,8 @@ package simulator
 import (
 	"log"
 	"math/rand"
+	"strconv"
 	"sync/atomic"
-	"time"
 
 	"github.com/google/uuid"
 
@@ -34,7 +34,8 @@ import (
 type ClusterComputeResource struct {
 	mo.ClusterComputeResource
 
-	ruleKey int32
+	ruleKey           int32
+	recommendationKey int32
 }
 
 func (c *ClusterComputeResource) RenameTask(ctx *Context, req *types.Rename_Task) soap.HasFault {
@@ -99,11 +100,29 @@ func (c *ClusterComputeResource) update(cfg *types.ClusterConfigInfoEx, cspec *t
 		if val := cspec.DasConfig.AdmissionControlEnabled; val != nil {
 			cfg.DasConfig.AdmissionControlEnabled = val
 		}
+		if val := cspec.DasConfig.AdmissionControlPolicy; val != nil {
+			cfg.DasConfig.AdmissionControlPolicy = val
+		}
+		if val := cspec.DasConfig.HostMonitoring; val != "" {
+			cfg.DasConfig.HostMonitoring = val
+		}
+		if val := cspec.DasConfig.DefaultVmSettings; val != nil {
+			cfg.DasConfig.DefaultVmSettings = val
+		}
 	}
 	if cspec.DrsConfig != nil {
 		if val := cspec.DrsConfig.Enabled; val != nil {
 			cfg.DrsConfig.Enabled = val
 		}
+		if val := cspec.DrsConfig.EnableVmBehaviorOverrides; val != nil {
+			cfg.DrsConfig.EnableVmBehaviorOverrides = val
+		}
+		if val := cspec.DrsConfig.DefaultVmBehavior; val != "" {
+			cfg.DrsConfig.DefaultVmBehavior = val
+		}
+		if val := cspec.DrsConfig.VmotionRate; val != 0 {
+			cfg.DrsConfig.VmotionRate = val
+		}
 	}
 
 	return nil
@@ -365,6 +384,8 @@ func (c *ClusterComputeResource) ReconfigureComputeResourceTask(ctx *Context, re
 			}
 		}
 
+		c.refreshRecommendation(ctx)
+
 		return nil, nil
 	})
 
@@ -420,7 +441,7 @@ func (c *ClusterComputeResource) PlaceVm(ctx *Context, req *types.PlaceVm) soap.
 	res := types.ClusterRecommendation{
 		Key:        "1",
 		Type:       "V1",
-		Time:       time.Now(),
+		Time:       ctx.Now(),
 		Rating:     1,
 		Reason:     string(types.RecommendationReasonCodeXvmotionPlacement),
 		ReasonText: string(types.RecommendationReasonCodeXvmotionPlacement),
@@ -465,6 +486,221 @@ func (c *ClusterComputeResource) PlaceVm(ctx *Context, req *types.PlaceVm) soap.
 	return body
 }
 
+// drsBehavior returns the DRS automation level for the given VM, and false if DRS is disabled for the VM.
+func (c *ClusterComputeResource) drsBehavior(vm types.ManagedObjectReference) (types.DrsBehavior, bool) {
+	cfg := c.ConfigurationEx.(*types.ClusterConfigInfoEx)
+	if !isTrue(cfg.DrsConfig.Enabled) {
+		return "", false
+	}
+
+	behavior := cfg.DrsConfig.DefaultVmBehavior
+	if behavior == "" {
+		behavior = types.DrsBehaviorFullyAutomated
+	}
+
+	if val := cfg.DrsConfig.EnableVmBehaviorOverrides; val == nil || *val {
+		for _, override := range cfg.DrsVmConfig {
+			if override.Key != vm {
+				continue
+			}
+			if override.Enabled != nil && !*override.Enabled {
+				return "", false
+			}
+			if override.Behavior != "" {
+				behavior = override.Behavior
+			}
+		}
+	}
+
+	return behavior, true
+}
+
+// automated returns true if all VMs migrated by the given recommendation have a fully automated DRS behavior.
+func (c *ClusterComputeResource) automated(rec *types.ClusterRecommendation) bool {
+	for _, action := range rec.Action {
+		if m, ok := action.(*types.ClusterMigrationAction); ok {
+			if behavior, _ := c.drsBehavior(m.DrsMigration.Vm); behavior != types.DrsBehaviorFullyAutomated {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// applyRecommendation migrates the VMs and sets the host maintenance mode as specified by the recommendation actions.
+// Migrations of VMs that have been removed or moved since the recommendation was made are skipped.
+func (c *ClusterComputeResource) applyRecommendation(ctx *Context, rec *types.ClusterRecommendation) types.BaseMethodFault {
+	for _, action := range rec.Action {
+		switch a := action.(type) {
+		case *types.ClusterMigrationAction:
+			vm, ok := ctx.Map.Get(a.DrsMigration.Vm).(*VirtualMachine)
+			if !ok {
+				continue // vm was removed since the recommendation was made
+			}
+			if vm.Runtime.Host == nil {
+				return &types.InvalidState{}
+			}
+			if *vm.Runtime.Host != a.DrsMigration.Source {
+				continue // vm was moved since the recommendation was made
+			}
+			host, ok := ctx.Map.Get(a.DrsMigration.Destination).(*HostSystem)
+			if !ok {
+				return &types.ManagedObjectNotFound{Obj: a.DrsMigration.Destination}
+			}
+			vm.drsMigrate(ctx, host)
+			ctx.Map.Update(c, []types.PropertyChange{
+				{Name: "migrationHistory", Val: append(c.MigrationHistory, *a.DrsMigration)},
+			})
+		case *types.ClusterAction:
+			if a.Type == string(types.ActionTypeHostMaintenanceV1) {
+				host, ok := ctx.Map.Get(*a.Target).(*HostSystem)
+				if !ok {
+					return &types.ManagedObjectNotFound{Obj: *a.Target}
+				}
+				ctx.Map.AtomicUpdate(ctx, host, []types.PropertyChange{
+					{Name: "runtime.inMaintenanceMode", Val: true},
+				})
+			}
+		}
+	}
+
+	return nil
+}
+
+// recommend assigns keys to the given recommendations, applying those that are fully automated when automate is true,
+// and adding the others to the cluster's recommendation list.
+func (c *ClusterComputeResource) recommend(ctx *Context, recs []types.ClusterRecommendation, automate bool) []types.ClusterRecommendation {
+	list := c.Recommendation
+
+	for i := range recs {
+		rec := &recs[i]
+		rec.Key = strconv.Itoa(int(atomic.AddInt32(&c.recommendationKey, 1)))
+		for _, action := range rec.Action {
+			if m, ok := action.(*types.ClusterMigrationAction); ok {
+				m.DrsMigration.Key = rec.Key
+			}
+		}
+
+		if automate && c.automated(rec) {
+			if fault := c.applyRecommendation(ctx, rec); fault != nil {
+				tracef("recommendation %s: %#v", rec.Key, fault)
+			}
+		} else {
+			list = append(list, *rec)
+		}
+	}
+
+	ctx.Map.Update(c, []types.PropertyChange{{Name: "recommendation", Val: list}})
+
+	return recs
+}
+
+// refreshRecommendation replaces the cluster's recommendation list with those made by DRSEngine,
+// applying the recommendations that are fully automated.
+func (c *ClusterComputeResource) refreshRecommendation(ctx *Context) {
+	c.Recommendation = nil
+
+	if !isTrue(c.ConfigurationEx.(*types.ClusterConfigInfoEx).DrsConfig.Enabled) {
+		ctx.Map.Update(c, []types.PropertyChange{{Name: "recommendation", Val: c.Recommendation}})
+		return
+	}
+
+	c.recommend(ctx, DRSEngine.Recommend(ctx, c), true)
+}
+
+// evacuate migrates the powered on VMs with a fully automated DRS behavior off the given host.
+func (c *ClusterComputeResource) evacuate(ctx *Context, host types.ManagedObjectReference) {
+	if !isTrue(c.ConfigurationEx.(*types.ClusterConfigInfoEx).DrsConfig.Enabled) {
+		return
+	}
+
+	recs, _ := DRSEngine.Evacuate(ctx, c, []types.ManagedObjectReference{host})
+
+	for _, rec := range recs {
+		for _, action := range rec.Action {
+			m, ok := action.(*types.ClusterMigrationAction)
+			if !ok {
+				continue
+			}
+			if behavior, _ := c.drsBehavior(m.DrsMigration.Vm); behavior == types.DrsBehaviorFullyAutomated {
+				if fault := c.applyRecommendation(ctx, &types.ClusterRecommendation{Action: []types.BaseClusterAction{m}}); fault != nil {
+					tracef("evacuate %s: %#v", host, fault)
+				}
+			}
+		}
+	}
+}
+
+func (c *ClusterComputeResource) RecommendHostsForVm(ctx *Context, req *types.RecommendHostsForVm) soap.HasFault {
+	body := new(methods.RecommendHostsForVmBody)
+
+	vm, ok := ctx.Map.Get(req.Vm).(*VirtualMachine)
+	if !ok {
+		body.Fault_ = Fault("", &types.ManagedObjectNotFound{Obj: req.Vm})
+		return body
+	}
+
+	body.Res = &types.RecommendHostsForVmResponse{
+		Returnval: DRSEngine.RecommendHosts(ctx, c, vm),
+	}
+
+	return body
+}
+
+func (c *ClusterComputeResource) RefreshRecommendation(ctx *Context, req *types.RefreshRecommendation) soap.HasFault {
+	c.refreshRecommendation(ctx)
+
+	return &methods.RefreshRecommendationBody{
+		Res: new(types.RefreshRecommendationResponse),
+	}
+}
+
+func (c *ClusterComputeResource) ApplyRecommendation(ctx *Context, req *types.ApplyRecommendation) soap.HasFault {
+	body := new(methods.ApplyRecommendationBody)
+
+	for i, rec := range c.Recommendation {
+		if rec.Key != req.Key {
+			continue
+		}
+
+		list := append(append([]types.ClusterRecommendation(nil), c.Recommendation[:i]...), c.Recommendation[i+1:]...)
+		ctx.Map.Update(c, []types.PropertyChange{{Name: "recommendation", Val: list}})
+
+		if fault := c.applyRecommendation(ctx, &rec); fault != nil {
+			body.Fault_ = Fault("", fault)
+			return body
+		}
+
+		body.Res = new(types.ApplyRecommendationResponse)
+		return body
+	}
+
+	body.Fault_ = Fault("", &types.InvalidArgument{InvalidProperty: "key"})
+	return body
+}
+
+func (c *ClusterComputeResource) ClusterEnterMaintenanceMode(ctx *Context, req *types.ClusterEnterMaintenanceMode) soap.HasFault {
+	body := new(methods.ClusterEnterMaintenanceModeBody)
+
+	for _, ref := range req.Host {
+		if FindReference(c.Host, ref) == nil {
+			body.Fault_ = Fault("", &types.InvalidArgument{InvalidProperty: "host"})
+			return body
+		}
+	}
+
+	recs, faults := DRSEngine.Evacuate(ctx, c, req.Host)
+
+	body.Res = &types.ClusterEnterMaintenanceModeResponse{
+		Returnval: types.ClusterEnterMaintenanceResult{
+			Recommendations: c.recommend(ctx, recs, false),
+			Fault:           faults,
+		},
+	}
+
+	return body
+}
+
 func CreateClusterComputeResource(ctx *Context, f *Folder, name string, spec types.ClusterConfigSpecEx) (*ClusterComputeResource, types.BaseMethodFault) {
 	if e := ctx.Map.FindByName(name, f.ChildEntity); e != nil {
 		return nil, &types.DuplicateName{
@@ -75,7 +75,7 @@ func createSimulationVM(vm *VirtualMachine) *simVM {
 }
 
 // applies container network settings to vm.Guest properties.
-func (svm *simVM) syncNetworkConfigToVMGuestProperties() error {
+func (svm *simVM) syncNetworkConfigToVMGuestProperties(ctx *Context) error {
 	if svm == nil {
 		return nil
 	}
@@ -96,6 +96,7 @@ func (svm *simVM) syncNetworkConfigToVMGuestProperties() error {
 		break
 	}
 
+	ctx.Map.reservationLock.Lock() // see VirtualMachine.updatePowerState
 	if detail.State.Paused {
 		svm.vm.Runtime.PowerState = types.VirtualMachinePowerStateSuspended
 	} else if detail.State.Running {
@@ -103,6 +104,7 @@ func (svm *simVM) syncNetworkConfigToVMGuestProperties() error {
 	} else {
 		svm.vm.Runtime.PowerState = types.VirtualMachinePowerStatePoweredOff
 	}
+	ctx.Map.reservationLock.Unlock()
 
 	svm.vm.Guest.IpAddress = netS.IPAddress
 	svm.vm.Summary.Guest.IpAddress = netS.IPAddress
@@ -291,7 +293,7 @@ func (svm *simVM) start(ctx *Context) error {
 
 	svm.vm.logPrintf("%s: %s", args, svm.c.id)
 
-	if err = svm.syncNetworkConfigToVMGuestProperties(); err != nil {
+	if err = svm.syncNetworkConfigToVMGuestProperties(ctx); err != nil {
 		log.Printf("%s inspect %s: %s", svm.vm.Name, svm.c.id, err)
 	}
 
@@ -316,7 +318,7 @@ func (svm *simVM) start(ctx *Context) error {
 			}
 		}
 
-		return svm.syncNetworkConfigToVMGuestProperties()
+		return svm.syncNetworkConfigToVMGuestProperties(spoofctx)
 	}
 
 	// Start watching the container resource.
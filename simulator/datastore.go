@@ -21,7 +21,6 @@ import (
 	"os"
 	"path"
 	"strings"
-	"time"
 
 	"github.com/zhengkes/govmomi/object"
 	"github.com/zhengkes/govmomi/vim25/methods"
@@ -70,7 +69,7 @@ func parseDatastorePath(dsPath string) (*object.DatastorePath, types.BaseMethodF
 	return nil, &types.InvalidDatastorePath{DatastorePath: dsPath}
 }
 
-func (ds *Datastore) RefreshDatastore(*types.RefreshDatastore) soap.HasFault {
+func (ds *Datastore) RefreshDatastore(ctx *Context, _ *types.RefreshDatastore) soap.HasFault {
 	r := &methods.RefreshDatastoreBody{}
 
 	_, err := os.Stat(ds.Info.GetDatastoreInfo().Url)
@@ -81,7 +80,7 @@ func (ds *Datastore) RefreshDatastore(*types.RefreshDatastore) soap.HasFault {
 
 	info := ds.Info.GetDatastoreInfo()
 
-	info.Timestamp = types.NewTime(time.Now())
+	info.Timestamp = types.NewTime(ctx.Now())
 
 	r.Res = &types.RefreshDatastoreResponse{}
 	return r
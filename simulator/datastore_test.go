@@ -86,7 +86,7 @@ func TestRefreshDatastore(t *testing.T) {
 			},
 		}
 
-		r := ds.RefreshDatastore(nil)
+		r := ds.RefreshDatastore(new(Context), nil)
 		res, ok := r.(*methods.RefreshDatastoreBody)
 		if !ok {
 			t.Fatalf("Unexpected response type: %T", r)
@@ -0,0 +1,524 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"sort"
+
+	"github.com/zhengkes/govmomi/vim25/types"
+)
+
+// DRS is the interface implemented by the Distributed Resource Scheduler used by ClusterComputeResource
+// for VM placement, cluster recommendations and maintenance mode evacuation.
+type DRS interface {
+	// RecommendHosts returns the hosts in the cluster that can run the given VM, best first.
+	RecommendHosts(ctx *Context, c *ClusterComputeResource, vm *VirtualMachine) []types.ClusterHostRecommendation
+
+	// Recommend returns the migrations needed to comply with the cluster's rules and balance its load.
+	Recommend(ctx *Context, c *ClusterComputeResource) []types.ClusterRecommendation
+
+	// Evacuate returns the migrations needed to move powered on VMs off the given hosts,
+	// and the VMs that cannot be moved.
+	Evacuate(ctx *Context, c *ClusterComputeResource, hosts []types.ManagedObjectReference) ([]types.ClusterRecommendation, *types.ClusterDrsFaults)
+}
+
+// DRSEngine is the DRS implementation used by all clusters.
+// The recommendation keys are assigned by the cluster.
+var DRSEngine DRS = DefaultDRS{}
+
+// DefaultDRS places VMs on the least loaded host, where the load of a host is the larger of its CPU and memory
+// utilization by powered on VMs. A VM's CPU usage is taken from Summary.QuickStats.OverallCpuUsage and its
+// memory usage from Summary.QuickStats.HostMemoryUsage, or Config.Hardware.MemoryMB if not set.
+// Enabled affinity, anti-affinity and VM-Host rules are honored, where VM-Host rules that are not mandatory
+// can be violated when no other host is available.
+// Migrations for load balancing are recommended while the difference between the most and least loaded hosts
+// exceeds the threshold set by the cluster's DrsConfig.VmotionRate, where 1 disables load balancing.
+type DefaultDRS struct{}
+
+// drsImbalance is the load difference threshold for each DrsConfig.VmotionRate
+var drsImbalance = map[int32]float64{2: 0.4, 3: 0.2, 4: 0.1, 5: 0.05}
+
+type drsHost struct {
+	*HostSystem
+
+	cpu, mem         int64 // capacity in MHz and MB
+	cpuUsed, memUsed int64
+}
+
+func (h *drsHost) load(cpu, mem int64) float64 {
+	var c, m float64
+	if h.cpu != 0 {
+		c = float64(h.cpuUsed+cpu) / float64(h.cpu)
+	}
+	if h.mem != 0 {
+		m = float64(h.memUsed+mem) / float64(h.mem)
+	}
+	if c > m {
+		return c
+	}
+	return m
+}
+
+// drsCandidate is a host that a VM can be placed on, with the number of rules that would be violated
+type drsCandidate struct {
+	*drsHost
+
+	hard, soft int
+	load       float64
+}
+
+// drsState is a snapshot of the cluster's powered on VM placement and host usage
+type drsState struct {
+	ctx     *Context
+	cluster *ClusterComputeResource
+	config  *types.ClusterConfigInfoEx
+
+	hosts     []*drsHost
+	host      map[types.ManagedObjectReference]*drsHost
+	vms       []*VirtualMachine
+	placement map[types.ManagedObjectReference]types.ManagedObjectReference
+}
+
+func drsEligible(h *HostSystem) bool {
+	r := h.Runtime
+	return !r.InMaintenanceMode &&
+		r.ConnectionState == types.HostSystemConnectionStateConnected &&
+		r.PowerState != types.HostSystemPowerStatePoweredOff &&
+		r.PowerState != types.HostSystemPowerStateStandBy
+}
+
+func drsVmLoad(vm *VirtualMachine) (int64, int64) {
+	cpu := int64(vm.Summary.QuickStats.OverallCpuUsage)
+	mem := int64(vm.Summary.QuickStats.HostMemoryUsage)
+	if mem == 0 && vm.Config != nil {
+		mem = int64(vm.Config.Hardware.MemoryMB)
+	}
+	return cpu, mem
+}
+
+func newDrsState(ctx *Context, c *ClusterComputeResource) *drsState {
+	s := &drsState{
+		ctx:       ctx,
+		cluster:   c,
+		config:    c.ConfigurationEx.(*types.ClusterConfigInfoEx),
+		host:      make(map[types.ManagedObjectReference]*drsHost),
+		placement: make(map[types.ManagedObjectReference]types.ManagedObjectReference),
+	}
+
+	for _, ref := range c.Host {
+		host, ok := ctx.Map.Get(ref).(*HostSystem)
+		if !ok {
+			continue
+		}
+
+		h := &drsHost{HostSystem: host}
+		if hw := host.Summary.Hardware; hw != nil {
+			h.cpu = int64(hw.CpuMhz) * int64(hw.NumCpuCores)
+			h.mem = hw.MemorySize / (1024 * 1024)
+		}
+		s.host[ref] = h
+		if drsEligible(host) {
+			s.hosts = append(s.hosts, h)
+		}
+
+		for _, vref := range host.Vm {
+			vm, ok := ctx.Map.Get(vref).(*VirtualMachine)
+			if !ok || vm.Runtime.PowerState != types.VirtualMachinePowerStatePoweredOn {
+				continue
+			}
+			cpu, mem := drsVmLoad(vm)
+			h.cpuUsed += cpu
+			h.memUsed += mem
+			s.vms = append(s.vms, vm)
+			s.placement[vm.Self] = ref
+		}
+	}
+
+	sort.Slice(s.vms, func(i, j int) bool {
+		return s.vms[i].Self.Value < s.vms[j].Self.Value
+	})
+
+	return s
+}
+
+// move updates the state as if the given VM was migrated to the given host
+func (s *drsState) move(vm *VirtualMachine, dst *drsHost) {
+	cpu, mem := drsVmLoad(vm)
+	if src, ok := s.host[s.placement[vm.Self]]; ok {
+		src.cpuUsed -= cpu
+		src.memUsed -= mem
+	}
+	dst.cpuUsed += cpu
+	dst.memUsed += mem
+	s.placement[vm.Self] = dst.Self
+}
+
+func (s *drsState) group(name string) []types.ManagedObjectReference {
+	for _, g := range s.config.Group {
+		if g.GetClusterGroupInfo().Name != name {
+			continue
+		}
+		switch g := g.(type) {
+		case *types.ClusterVmGroup:
+			return g.Vm
+		case *types.ClusterHostGroup:
+			return g.Host
+		}
+	}
+	return nil
+}
+
+// drsRuleViolation is a rule that a VM would violate when placed on a host
+type drsRuleViolation struct {
+	rule   types.BaseClusterRuleInfo
+	reason types.RecommendationReasonCode
+	hard   bool
+}
+
+// violations returns the enabled rules that the given VM would violate if placed on the given host
+func (s *drsState) violations(vm, host types.ManagedObjectReference) []drsRuleViolation {
+	var res []drsRuleViolation
+
+	for _, rule := range s.config.Rule {
+		info := rule.GetClusterRuleInfo()
+		if !isTrue(info.Enabled) {
+			continue
+		}
+
+		switch r := rule.(type) {
+		case *types.ClusterAffinityRuleSpec:
+			if FindReference(r.Vm, vm) == nil {
+				continue
+			}
+			for _, other := range r.Vm {
+				if h, ok := s.placement[other]; ok && other != vm && h != host {
+					res = append(res, drsRuleViolation{rule, types.RecommendationReasonCodeJointAffin, true})
+					break
+				}
+			}
+		case *types.ClusterAntiAffinityRuleSpec:
+			if FindReference(r.Vm, vm) == nil {
+				continue
+			}
+			for _, other := range r.Vm {
+				if h, ok := s.placement[other]; ok && other != vm && h == host {
+					res = append(res, drsRuleViolation{rule, types.RecommendationReasonCodeAntiAffin, true})
+					break
+				}
+			}
+		case *types.ClusterVmHostRuleInfo:
+			if FindReference(s.group(r.VmGroupName), vm) == nil {
+				continue
+			}
+			violated := false
+			if r.AffineHostGroupName != "" && FindReference(s.group(r.AffineHostGroupName), host) == nil {
+				violated = true
+			}
+			if r.AntiAffineHostGroupName != "" && FindReference(s.group(r.AntiAffineHostGroupName), host) != nil {
+				violated = true
+			}
+			if violated {
+				v := drsRuleViolation{rule, types.RecommendationReasonCodeVmHostSoftAffinity, false}
+				if isTrue(info.Mandatory) {
+					v.reason, v.hard = types.RecommendationReasonCodeVmHostHardAffinity, true
+				}
+				res = append(res, v)
+			}
+		}
+	}
+
+	return res
+}
+
+func countViolations(violations []drsRuleViolation) (int, int) {
+	var hard, soft int
+	for _, v := range violations {
+		if v.hard {
+			hard++
+		} else {
+			soft++
+		}
+	}
+	return hard, soft
+}
+
+// candidates returns the eligible hosts for the given VM, other than the given hosts,
+// ordered by the number of rules violated and then by the resulting host load.
+func (s *drsState) candidates(vm *VirtualMachine, exclude ...types.ManagedObjectReference) []drsCandidate {
+	var res []drsCandidate
+	cpu, mem := drsVmLoad(vm)
+	current := s.placement[vm.Self]
+
+	for _, h := range s.hosts {
+		if FindReference(exclude, h.Self) != nil {
+			continue
+		}
+		c := drsCandidate{drsHost: h, load: h.load(cpu, mem)}
+		if h.Self == current {
+			c.load = h.load(0, 0)
+		}
+		c.hard, c.soft = countViolations(s.violations(vm.Self, h.Self))
+		res = append(res, c)
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		a, b := res[i], res[j]
+		if a.hard != b.hard {
+			return a.hard < b.hard
+		}
+		if a.soft != b.soft {
+			return a.soft < b.soft
+		}
+		return a.load < b.load
+	})
+
+	return res
+}
+
+// migration returns a recommendation to migrate the given VM to the given host, updating the state
+func (s *drsState) migration(vm *VirtualMachine, dst *drsHost, reason types.RecommendationReasonCode, rating int32) types.ClusterRecommendation {
+	now := s.ctx.Now()
+	src := s.host[s.placement[vm.Self]]
+	cpu, mem := drsVmLoad(vm)
+
+	m := &types.ClusterDrsMigration{
+		Time:        now,
+		Vm:          vm.Self,
+		CpuLoad:     int32(cpu),
+		MemoryLoad:  mem,
+		Source:      src.Self,
+		Destination: dst.Self,
+	}
+	m.SourceCpuLoad, m.SourceMemoryLoad = int32(src.cpuUsed), src.memUsed
+	m.DestinationCpuLoad, m.DestinationMemoryLoad = int32(dst.cpuUsed), dst.memUsed
+
+	s.move(vm, dst)
+
+	return types.ClusterRecommendation{
+		Type:       "V1",
+		Time:       now,
+		Rating:     rating,
+		Reason:     string(reason),
+		ReasonText: string(reason),
+		Target:     &s.cluster.Self,
+		Action: []types.BaseClusterAction{
+			&types.ClusterMigrationAction{
+				ClusterAction: types.ClusterAction{
+					Type:   string(types.ActionTypeMigrationV1),
+					Target: &m.Vm,
+				},
+				DrsMigration: m,
+			},
+		},
+	}
+}
+
+func (DefaultDRS) RecommendHosts(ctx *Context, c *ClusterComputeResource, vm *VirtualMachine) []types.ClusterHostRecommendation {
+	s := newDrsState(ctx, c)
+	if h, ok := s.host[s.placement[vm.Self]]; ok {
+		cpu, mem := drsVmLoad(vm)
+		h.cpuUsed -= cpu
+		h.memUsed -= mem
+		delete(s.placement, vm.Self)
+	}
+
+	var res []types.ClusterHostRecommendation
+
+	for i, h := range s.candidates(vm) {
+		if h.hard != 0 {
+			break
+		}
+		rating := int32(5 - i)
+		if rating < 1 {
+			rating = 1
+		}
+		res = append(res, types.ClusterHostRecommendation{Host: h.Self, Rating: rating})
+	}
+
+	return res
+}
+
+func (DefaultDRS) Recommend(ctx *Context, c *ClusterComputeResource) []types.ClusterRecommendation {
+	s := newDrsState(ctx, c)
+	var res []types.ClusterRecommendation
+
+	movable := func(vm *VirtualMachine) bool {
+		_, enabled := c.drsBehavior(vm.Self)
+		return enabled
+	}
+
+	// bring together the VMs of affinity rules, on the host already running most of them
+	for _, rule := range s.config.Rule {
+		r, ok := rule.(*types.ClusterAffinityRuleSpec)
+		if !ok || !isTrue(r.Enabled) {
+			continue
+		}
+
+		count := make(map[types.ManagedObjectReference]int)
+		for _, vm := range r.Vm {
+			if h, ok := s.placement[vm]; ok {
+				count[h]++
+			}
+		}
+
+		var target *drsHost
+		for _, h := range s.hosts {
+			if target == nil || count[h.Self] > count[target.Self] ||
+				(count[h.Self] == count[target.Self] && h.load(0, 0) < target.load(0, 0)) {
+				target = h
+			}
+		}
+		if target == nil {
+			continue
+		}
+
+		for _, vm := range s.vms {
+			if FindReference(r.Vm, vm.Self) == nil || s.placement[vm.Self] == target.Self || !movable(vm) {
+				continue
+			}
+			hard := 0
+			for _, v := range s.violations(vm.Self, target.Self) {
+				if v.hard && v.rule != rule {
+					hard++
+				}
+			}
+			if hard == 0 {
+				res = append(res, s.migration(vm, target, types.RecommendationReasonCodeJointAffin, 5))
+			}
+		}
+	}
+
+	// move VMs that violate anti-affinity and VM-Host rules to a host with fewer violations
+	for _, vm := range s.vms {
+		violations := s.violations(vm.Self, s.placement[vm.Self])
+		if len(violations) == 0 || !movable(vm) {
+			continue
+		}
+		hard, soft := countViolations(violations)
+		if candidates := s.candidates(vm, s.placement[vm.Self]); len(candidates) != 0 {
+			h := candidates[0]
+			if h.hard < hard || (h.hard == hard && h.soft < soft) {
+				res = append(res, s.migration(vm, h.drsHost, violations[0].reason, 5))
+			}
+		}
+	}
+
+	// balance load by moving VMs from the most to the least loaded host
+	threshold, ok := drsImbalance[s.config.DrsConfig.VmotionRate]
+	if s.config.DrsConfig.VmotionRate == 0 {
+		threshold, ok = drsImbalance[3]
+	}
+	if !ok || len(s.hosts) < 2 {
+		return res
+	}
+
+	for range s.vms {
+		sort.SliceStable(s.hosts, func(i, j int) bool {
+			return s.hosts[i].load(0, 0) > s.hosts[j].load(0, 0)
+		})
+		src, dst := s.hosts[0], s.hosts[len(s.hosts)-1]
+		spread := src.load(0, 0) - dst.load(0, 0)
+		if spread <= threshold {
+			break
+		}
+
+		var move *VirtualMachine
+		best := spread
+		for _, vm := range s.vms {
+			if s.placement[vm.Self] != src.Self || !movable(vm) {
+				continue
+			}
+			if len(s.violations(vm.Self, dst.Self)) != 0 {
+				continue
+			}
+			cpu, mem := drsVmLoad(vm)
+			after := src.load(-cpu, -mem) - dst.load(cpu, mem)
+			if after < 0 {
+				after = -after
+			}
+			if after < best {
+				move, best = vm, after
+			}
+		}
+		if move == nil {
+			break
+		}
+
+		reason := types.RecommendationReasonCodeFairnessMemAvg
+		if src.cpu != 0 && src.mem != 0 && float64(src.cpuUsed)/float64(src.cpu) > float64(src.memUsed)/float64(src.mem) {
+			reason = types.RecommendationReasonCodeFairnessCpuAvg
+		}
+		rating := int32(1 + spread/threshold)
+		if rating > 5 {
+			rating = 5
+		}
+		res = append(res, s.migration(move, dst, reason, rating))
+	}
+
+	return res
+}
+
+func (DefaultDRS) Evacuate(ctx *Context, c *ClusterComputeResource, hosts []types.ManagedObjectReference) ([]types.ClusterRecommendation, *types.ClusterDrsFaults) {
+	s := newDrsState(ctx, c)
+	var res []types.ClusterRecommendation
+	var faults []types.BaseClusterDrsFaultsFaultsByVm
+
+	for _, ref := range hosts {
+		host := ref
+		rec := types.ClusterRecommendation{
+			Type:       "V1",
+			Time:       ctx.Now(),
+			Rating:     5,
+			Reason:     string(types.RecommendationReasonCodeHostMaint),
+			ReasonText: string(types.RecommendationReasonCodeHostMaint),
+			Target:     &host,
+		}
+
+		for _, vm := range s.vms {
+			if s.placement[vm.Self] != host {
+				continue
+			}
+			candidates := s.candidates(vm, hosts...)
+			if len(candidates) == 0 || candidates[0].hard != 0 {
+				vref := vm.Self
+				faults = append(faults, &types.ClusterDrsFaultsFaultsByVm{
+					Vm:    &vref,
+					Fault: []types.LocalizedMethodFault{{Fault: new(types.NoCompatibleHost), LocalizedMessage: "No compatible host for " + vm.Name}},
+				})
+				continue
+			}
+			m := s.migration(vm, candidates[0].drsHost, types.RecommendationReasonCodeHostMaint, 5)
+			rec.Action = append(rec.Action, m.Action...)
+		}
+
+		rec.Action = append(rec.Action, &types.ClusterAction{
+			Type:   string(types.ActionTypeHostMaintenanceV1),
+			Target: &host,
+		})
+
+		res = append(res, rec)
+	}
+
+	if len(faults) == 0 {
+		return res, nil
+	}
+
+	return res, &types.ClusterDrsFaults{
+		Reason:     string(types.RecommendationReasonCodeHostMaint),
+		FaultsByVm: faults,
+	}
+}
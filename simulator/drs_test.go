@@ -0,0 +1,203 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"context"
+	"testing"
+
+	"github.com/zhengkes/govmomi/find"
+	"github.com/zhengkes/govmomi/object"
+	"github.com/zhengkes/govmomi/vim25"
+	"github.com/zhengkes/govmomi/vim25/methods"
+	"github.com/zhengkes/govmomi/vim25/mo"
+	"github.com/zhengkes/govmomi/vim25/types"
+)
+
+func TestDRS(t *testing.T) {
+	Test(func(ctx context.Context, c *vim25.Client) {
+		finder := find.NewFinder(c)
+
+		cluster, err := finder.ClusterComputeResource(ctx, "DC0_C0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		reconfigure := func(spec *types.ClusterConfigSpecEx) {
+			task, err := cluster.Reconfigure(ctx, spec, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = task.Wait(ctx); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		recommendations := func() []types.ClusterRecommendation {
+			var cr mo.ClusterComputeResource
+			if err := cluster.Properties(ctx, cluster.Reference(), []string{"recommendation"}, &cr); err != nil {
+				t.Fatal(err)
+			}
+			return cr.Recommendation
+		}
+
+		refresh := func() {
+			_, err := methods.RefreshRecommendation(ctx, c, &types.RefreshRecommendation{This: cluster.Reference()})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		reconfigure(&types.ClusterConfigSpecEx{
+			DrsConfig: &types.ClusterDrsConfigInfo{DefaultVmBehavior: types.DrsBehaviorManual},
+		})
+
+		// load up the first host with both cluster vms
+		sctx := SpoofContext()
+		cr := Map.Get(cluster.Reference()).(*ClusterComputeResource)
+		h0 := Map.Get(cr.Host[0]).(*HostSystem)
+		var vms []*VirtualMachine
+		for _, name := range []string{"DC0_C0_RP0_VM0", "DC0_C0_RP0_VM1"} {
+			vm := Map.FindByName(name, Map.Get(*cr.ResourcePool).(*ResourcePool).Vm).(*VirtualMachine)
+			if *vm.Runtime.Host != h0.Self {
+				vm.drsMigrate(sctx, h0)
+			}
+			vm.Summary.QuickStats.HostMemoryUsage = 1500
+			vms = append(vms, vm)
+		}
+
+		host := func(vm *VirtualMachine) types.ManagedObjectReference {
+			return *vm.Runtime.Host
+		}
+
+		// manual mode: DRS recommends, but does not apply
+		refresh()
+		recs := recommendations()
+		if len(recs) != 1 {
+			t.Fatalf("recommendations=%d", len(recs))
+		}
+		if recs[0].Reason != string(types.RecommendationReasonCodeFairnessMemAvg) {
+			t.Errorf("reason=%s", recs[0].Reason)
+		}
+		if host(vms[0]) != h0.Self || host(vms[1]) != h0.Self {
+			t.Error("vm moved in manual mode")
+		}
+
+		_, err = methods.ApplyRecommendation(ctx, c, &types.ApplyRecommendation{This: cluster.Reference(), Key: recs[0].Key})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if host(vms[0]) == host(vms[1]) {
+			t.Error("recommendation not applied")
+		}
+		if len(recommendations()) != 0 {
+			t.Error("applied recommendation not removed")
+		}
+		var history mo.ClusterComputeResource
+		if err = cluster.Properties(ctx, cluster.Reference(), []string{"migrationHistory"}, &history); err != nil {
+			t.Fatal(err)
+		}
+		if len(history.MigrationHistory) != 1 {
+			t.Errorf("migrationHistory=%d", len(history.MigrationHistory))
+		}
+
+		_, err = methods.ApplyRecommendation(ctx, c, &types.ApplyRecommendation{This: cluster.Reference(), Key: recs[0].Key})
+		if err == nil {
+			t.Error("expected error")
+		}
+
+		// a vm without a host fails the recommendation
+		vmHost := vms[0].Runtime.Host
+		vms[0].Runtime.Host = nil
+		cr.Recommendation = []types.ClusterRecommendation{{
+			Key: "invalid",
+			Action: []types.BaseClusterAction{&types.ClusterMigrationAction{
+				DrsMigration: &types.ClusterDrsMigration{Vm: vms[0].Self, Source: *vmHost, Destination: h0.Self},
+			}},
+		}}
+		_, err = methods.ApplyRecommendation(ctx, c, &types.ApplyRecommendation{This: cluster.Reference(), Key: "invalid"})
+		if err == nil {
+			t.Error("expected error")
+		}
+		vms[0].Runtime.Host = vmHost
+
+		// fully automated mode: DRS separates the vms of an anti-affinity rule
+		vms[1].drsMigrate(sctx, Map.Get(host(vms[0])).(*HostSystem))
+
+		reconfigure(&types.ClusterConfigSpecEx{
+			DrsConfig: &types.ClusterDrsConfigInfo{DefaultVmBehavior: types.DrsBehaviorFullyAutomated},
+			RulesSpec: []types.ClusterRuleSpec{{
+				ArrayUpdateSpec: types.ArrayUpdateSpec{Operation: types.ArrayUpdateOperationAdd},
+				Info: &types.ClusterAntiAffinityRuleSpec{
+					ClusterRuleInfo: types.ClusterRuleInfo{Name: "separate", Enabled: types.NewBool(true)},
+					Vm:              []types.ManagedObjectReference{vms[0].Self, vms[1].Self},
+				},
+			}},
+		})
+		if host(vms[0]) == host(vms[1]) {
+			t.Error("anti-affinity rule not enforced")
+		}
+		if len(recommendations()) != 0 {
+			t.Error("automated recommendation not applied")
+		}
+
+		res, err := methods.RecommendHostsForVm(ctx, c, &types.RecommendHostsForVm{This: cluster.Reference(), Vm: vms[0].Self})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Returnval) != len(cr.Host)-1 {
+			t.Errorf("hosts=%d", len(res.Returnval))
+		}
+		for _, rec := range res.Returnval {
+			if rec.Host == host(vms[1]) {
+				t.Error("recommended host violates anti-affinity rule")
+			}
+		}
+
+		// maintenance mode evacuates the host, honoring the rule
+		src := host(vms[0])
+		mm, err := methods.ClusterEnterMaintenanceMode(ctx, c, &types.ClusterEnterMaintenanceMode{
+			This: cluster.Reference(),
+			Host: []types.ManagedObjectReference{src},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mm.Returnval.Fault != nil {
+			t.Errorf("fault=%#v", mm.Returnval.Fault)
+		}
+		recs = mm.Returnval.Recommendations
+		if len(recs) != 1 || recs[0].Reason != string(types.RecommendationReasonCodeHostMaint) {
+			t.Fatalf("recommendations=%#v", recs)
+		}
+		m := recs[0].Action[0].(*types.ClusterMigrationAction).DrsMigration
+		if m.Vm != vms[0].Self || m.Destination == src || m.Destination == host(vms[1]) {
+			t.Errorf("migration=%#v", m)
+		}
+
+		task, err := object.NewHostSystem(c, src).EnterMaintenanceMode(ctx, 0, false, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = task.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if host(vms[0]) != m.Destination {
+			t.Errorf("host=%s, expected %s", host(vms[0]), m.Destination)
+		}
+	})
+}
@@ -128,8 +128,8 @@ func (b *EnvironmentBrowser) initDescriptorReturnVal(
 }
 
 func (b *EnvironmentBrowser) hosts(ctx *Context) []types.ManagedObjectReference {
-	ctx.Map.m.Lock()
-	defer ctx.Map.m.Unlock()
+	ctx.Map.m.RLock()
+	defer ctx.Map.m.RUnlock()
 	for _, obj := range ctx.Map.objects {
 		switch e := obj.(type) {
 		case *mo.ComputeResource:
@@ -145,17 +145,40 @@ func (b *EnvironmentBrowser) hosts(ctx *Context) []types.ManagedObjectReference
 	return nil
 }
 
-func (b *EnvironmentBrowser) QueryConfigOption(req *types.QueryConfigOption) soap.HasFault {
-	body := new(methods.QueryConfigOptionBody)
+// configOption returns the VirtualMachineConfigOption for the given hardware version key, such as "vmx-13",
+// defaulting to esx.HardwareVersion. HardwareOptions only include the devices supported by that version.
+func (b *EnvironmentBrowser) configOption(key string) *types.VirtualMachineConfigOption {
+	if opt := b.QueryConfigOptionResponse.Returnval; opt != nil {
+		return opt
+	}
 
-	opt := b.QueryConfigOptionResponse.Returnval
-	if opt == nil {
-		opt = &types.VirtualMachineConfigOption{
-			Version:       esx.HardwareVersion,
-			DefaultDevice: esx.VirtualDevice,
+	if key == "" {
+		key = esx.HardwareVersion
+	}
+	version, _ := types.ParseHardwareVersion(key)
+
+	hw := esx.VirtualHardwareOption
+	hw.HwVersion = int32(version)
+	hw.VirtualDeviceOption = nil
+	for _, opt := range esx.VirtualHardwareOption.VirtualDeviceOption {
+		if min, ok := esx.VirtualDeviceMinHardwareVersion[opt.GetVirtualDeviceOption().Type]; ok && version < min {
+			continue
 		}
+		hw.VirtualDeviceOption = append(hw.VirtualDeviceOption, opt)
 	}
 
+	return &types.VirtualMachineConfigOption{
+		Version:         key,
+		DefaultDevice:   esx.VirtualDevice,
+		HardwareOptions: hw,
+	}
+}
+
+func (b *EnvironmentBrowser) QueryConfigOption(req *types.QueryConfigOption) soap.HasFault {
+	body := new(methods.QueryConfigOptionBody)
+
+	opt := b.configOption(req.Key)
+
 	body.Res = &types.QueryConfigOptionResponse{
 		Returnval: opt,
 	}
@@ -179,13 +202,11 @@ func guestFamily(id string) string {
 func (b *EnvironmentBrowser) QueryConfigOptionEx(req *types.QueryConfigOptionEx) soap.HasFault {
 	body := new(methods.QueryConfigOptionExBody)
 
-	opt := b.QueryConfigOptionResponse.Returnval
-	if opt == nil {
-		opt = &types.VirtualMachineConfigOption{
-			Version:       esx.HardwareVersion,
-			DefaultDevice: esx.VirtualDevice,
-		}
+	key := ""
+	if req.Spec != nil {
+		key = req.Spec.Key
 	}
+	opt := b.configOption(key)
 
 	if req.Spec != nil {
 		// From the SDK QueryConfigOptionEx doc:
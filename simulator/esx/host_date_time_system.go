@@ -0,0 +1,49 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package esx
+
+import "github.com/zhengkes/govmomi/vim25/types"
+
+// HostDateTimeInfo is the default template for the HostSystem config.dateTimeInfo property.
+// Capture method:
+// govc object.collect -s -dump HostSystem:ha-host config.dateTimeInfo
+var HostDateTimeInfo = types.HostDateTimeInfo{
+	DynamicData: types.DynamicData{},
+	TimeZone: types.HostDateTimeSystemTimeZone{
+		DynamicData: types.DynamicData{},
+		Key:         "UTC",
+		Name:        "UTC",
+		Description: "UTC",
+		GmtOffset:   0,
+	},
+	SystemClockProtocol: "ntp",
+	NtpConfig: &types.HostNtpConfig{
+		DynamicData: types.DynamicData{},
+		Server:      nil,
+		ConfigFile:  nil,
+	},
+	PtpConfig:       (*types.HostPtpConfig)(nil),
+	Enabled:         types.NewBool(false),
+	DisableEvents:   types.NewBool(false),
+	DisableFallback: types.NewBool(false),
+	InFallbackState: types.NewBool(false),
+	ServiceSync:     types.NewBool(false),
+	LastSyncTime:    nil,
+	RemoteNtpServer: "",
+	NtpRunTime:      0,
+	PtpRunTime:      0,
+}
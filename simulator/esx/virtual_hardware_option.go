@@ -0,0 +1,226 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package esx
+
+import "github.com/zhengkes/govmomi/vim25/types"
+
+// VirtualDeviceMinHardwareVersion is the minimum hardware version that supports a VirtualDeviceOption type.
+// Types not listed here are supported by all hardware versions.
+var VirtualDeviceMinHardwareVersion = map[string]types.HardwareVersion{
+	"ParaVirtualSCSIController":    types.VMX7,
+	"VirtualLsiLogicSASController": types.VMX7,
+	"VirtualVmxnet3":               types.VMX7,
+	"VirtualMachineVMCIDevice":     types.VMX7,
+	"VirtualE1000e":                types.VMX8,
+	"VirtualUSBXHCIController":     types.VMX8,
+	"VirtualHdAudioCard":           types.VMX8,
+	"VirtualAHCIController":        types.VMX10,
+	"VirtualSriovEthernetCard":     types.VMX10,
+	"VirtualNVMEController":        types.VMX13,
+	"VirtualVmxnet3Vrdma":          types.VMX13,
+	"VirtualTPM":                   types.VMX14,
+	"VirtualNVDIMMController":      types.VMX14,
+	"VirtualNVDIMM":                types.VMX14,
+	"VirtualWDT":                   types.VMX17,
+	"VirtualPrecisionClock":        types.VMX17,
+}
+
+var (
+	scsiControllerOption = types.VirtualSCSIControllerOption{
+		VirtualControllerOption: types.VirtualControllerOption{
+			Devices:         types.IntOption{Min: 0, Max: 15, DefaultValue: 0},
+			SupportedDevice: []string{"VirtualDisk", "VirtualCdrom", "VirtualSCSIPassthrough"},
+		},
+		NumSCSIDisks:       types.IntOption{Min: 0, Max: 15, DefaultValue: 1},
+		NumSCSICdroms:      types.IntOption{Min: 0, Max: 15, DefaultValue: 0},
+		NumSCSIPassthrough: types.IntOption{Min: 0, Max: 15, DefaultValue: 0},
+		Sharing: []types.VirtualSCSISharing{
+			types.VirtualSCSISharingNoSharing,
+			types.VirtualSCSISharingVirtualSharing,
+			types.VirtualSCSISharingPhysicalSharing,
+		},
+		HotAddRemove:       types.BoolOption{Supported: true, DefaultValue: true},
+		ScsiCtlrUnitNumber: 7,
+	}
+
+	ethernetCardOption = types.VirtualEthernetCardOption{
+		MacType: types.ChoiceOption{
+			ChoiceInfo: []types.BaseElementDescription{
+				&types.ElementDescription{Key: string(types.VirtualEthernetCardMacTypeManual)},
+				&types.ElementDescription{Key: string(types.VirtualEthernetCardMacTypeGenerated)},
+				&types.ElementDescription{Key: string(types.VirtualEthernetCardMacTypeAssigned)},
+			},
+			DefaultIndex: 1,
+		},
+		WakeOnLanEnabled: types.BoolOption{Supported: true, DefaultValue: true},
+	}
+)
+
+// VirtualHardwareOption is the default VirtualMachineConfigOption.HardwareOptions,
+// including every VirtualDeviceOption known to the simulator.
+// Capture method (abridged):
+//
+//	govc vm.option.info -json | jq .hardwareOptions
+var VirtualHardwareOption = types.VirtualHardwareOption{
+	HwVersion: int32(types.MaxValidHardwareVersion),
+	VirtualDeviceOption: []types.BaseVirtualDeviceOption{
+		&types.VirtualPCIControllerOption{
+			VirtualControllerOption: types.VirtualControllerOption{
+				VirtualDeviceOption: types.VirtualDeviceOption{Type: "VirtualPCIController"},
+				Devices:             types.IntOption{Min: 0, Max: 64, DefaultValue: 0},
+			},
+			NumSCSIControllers:            types.IntOption{Min: 0, Max: 4, DefaultValue: 1},
+			NumEthernetCards:              types.IntOption{Min: 0, Max: 10, DefaultValue: 1},
+			NumVideoCards:                 types.IntOption{Min: 1, Max: 1, DefaultValue: 1},
+			NumSoundCards:                 types.IntOption{Min: 0, Max: 1, DefaultValue: 0},
+			NumVmiRoms:                    types.IntOption{Min: 0, Max: 1, DefaultValue: 0},
+			NumVmciDevices:                &types.IntOption{Min: 1, Max: 1, DefaultValue: 1},
+			NumPCIPassthroughDevices:      &types.IntOption{Min: 0, Max: 16, DefaultValue: 0},
+			NumSasSCSIControllers:         &types.IntOption{Min: 0, Max: 4, DefaultValue: 0},
+			NumVmxnet3EthernetCards:       &types.IntOption{Min: 0, Max: 10, DefaultValue: 0},
+			NumParaVirtualSCSIControllers: &types.IntOption{Min: 0, Max: 4, DefaultValue: 0},
+			NumSATAControllers:            &types.IntOption{Min: 0, Max: 4, DefaultValue: 0},
+			NumNVMEControllers:            &types.IntOption{Min: 0, Max: 4, DefaultValue: 0},
+			NumVmxnet3VrdmaEthernetCards:  &types.IntOption{Min: 0, Max: 1, DefaultValue: 0},
+		},
+		&types.VirtualIDEControllerOption{
+			VirtualControllerOption: types.VirtualControllerOption{
+				VirtualDeviceOption: types.VirtualDeviceOption{Type: "VirtualIDEController"},
+				Devices:             types.IntOption{Min: 0, Max: 2, DefaultValue: 0},
+				SupportedDevice:     []string{"VirtualDisk", "VirtualCdrom"},
+			},
+			NumIDEDisks:  types.IntOption{Min: 0, Max: 2, DefaultValue: 0},
+			NumIDECdroms: types.IntOption{Min: 0, Max: 2, DefaultValue: 1},
+		},
+		&types.VirtualPS2ControllerOption{
+			VirtualControllerOption: types.VirtualControllerOption{
+				VirtualDeviceOption: types.VirtualDeviceOption{Type: "VirtualPS2Controller"},
+				Devices:             types.IntOption{Min: 0, Max: 2, DefaultValue: 2},
+				SupportedDevice:     []string{"VirtualKeyboard", "VirtualPointingDevice"},
+			},
+			NumKeyboards:       types.IntOption{Min: 1, Max: 1, DefaultValue: 1},
+			NumPointingDevices: types.IntOption{Min: 1, Max: 1, DefaultValue: 1},
+		},
+		&types.VirtualSIOControllerOption{
+			VirtualControllerOption: types.VirtualControllerOption{
+				VirtualDeviceOption: types.VirtualDeviceOption{Type: "VirtualSIOController"},
+				Devices:             types.IntOption{Min: 0, Max: 4, DefaultValue: 0},
+				SupportedDevice:     []string{"VirtualFloppy", "VirtualSerialPort", "VirtualParallelPort"},
+			},
+			NumFloppyDrives:  types.IntOption{Min: 0, Max: 2, DefaultValue: 1},
+			NumSerialPorts:   types.IntOption{Min: 0, Max: 4, DefaultValue: 1},
+			NumParallelPorts: types.IntOption{Min: 0, Max: 3, DefaultValue: 1},
+		},
+		&types.VirtualUSBControllerOption{
+			VirtualControllerOption: types.VirtualControllerOption{
+				VirtualDeviceOption: types.VirtualDeviceOption{Type: "VirtualUSBController"},
+				Devices:             types.IntOption{Min: 0, Max: 20, DefaultValue: 0},
+				SupportedDevice:     []string{"VirtualUSB"},
+			},
+		},
+		&types.VirtualUSBXHCIControllerOption{
+			VirtualControllerOption: types.VirtualControllerOption{
+				VirtualDeviceOption: types.VirtualDeviceOption{Type: "VirtualUSBXHCIController"},
+				Devices:             types.IntOption{Min: 0, Max: 20, DefaultValue: 0},
+				SupportedDevice:     []string{"VirtualUSB"},
+			},
+		},
+		&types.VirtualLsiLogicControllerOption{VirtualSCSIControllerOption: scsiController("VirtualLsiLogicController")},
+		&types.VirtualBusLogicControllerOption{VirtualSCSIControllerOption: scsiController("VirtualBusLogicController")},
+		&types.VirtualLsiLogicSASControllerOption{VirtualSCSIControllerOption: scsiController("VirtualLsiLogicSASController")},
+		&types.ParaVirtualSCSIControllerOption{VirtualSCSIControllerOption: scsiController("ParaVirtualSCSIController")},
+		&types.VirtualAHCIControllerOption{
+			VirtualSATAControllerOption: types.VirtualSATAControllerOption{
+				VirtualControllerOption: types.VirtualControllerOption{
+					VirtualDeviceOption: types.VirtualDeviceOption{Type: "VirtualAHCIController"},
+					Devices:             types.IntOption{Min: 0, Max: 30, DefaultValue: 0},
+					SupportedDevice:     []string{"VirtualDisk", "VirtualCdrom"},
+				},
+				NumSATADisks:  types.IntOption{Min: 0, Max: 30, DefaultValue: 0},
+				NumSATACdroms: types.IntOption{Min: 0, Max: 30, DefaultValue: 0},
+			},
+		},
+		&types.VirtualNVMEControllerOption{
+			VirtualControllerOption: types.VirtualControllerOption{
+				VirtualDeviceOption: types.VirtualDeviceOption{Type: "VirtualNVMEController"},
+				Devices:             types.IntOption{Min: 0, Max: 15, DefaultValue: 0},
+				SupportedDevice:     []string{"VirtualDisk"},
+			},
+			NumNVMEDisks: types.IntOption{Min: 0, Max: 15, DefaultValue: 0},
+		},
+		&types.VirtualNVDIMMControllerOption{
+			VirtualControllerOption: types.VirtualControllerOption{
+				VirtualDeviceOption: types.VirtualDeviceOption{Type: "VirtualNVDIMMController"},
+				Devices:             types.IntOption{Min: 0, Max: 64, DefaultValue: 0},
+				SupportedDevice:     []string{"VirtualNVDIMM"},
+			},
+			NumNVDIMMControllers: types.IntOption{Min: 0, Max: 1, DefaultValue: 0},
+		},
+		&types.VirtualDiskOption{
+			VirtualDeviceOption: types.VirtualDeviceOption{Type: "VirtualDisk"},
+			CapacityInKB:        types.LongOption{Min: 1024, Max: 68719476736, DefaultValue: 16777216},
+		},
+		&types.VirtualCdromOption{VirtualDeviceOption: types.VirtualDeviceOption{Type: "VirtualCdrom"}},
+		&types.VirtualFloppyOption{VirtualDeviceOption: types.VirtualDeviceOption{Type: "VirtualFloppy"}},
+		&types.VirtualSerialPortOption{VirtualDeviceOption: types.VirtualDeviceOption{Type: "VirtualSerialPort"}},
+		&types.VirtualParallelPortOption{VirtualDeviceOption: types.VirtualDeviceOption{Type: "VirtualParallelPort"}},
+		&types.VirtualKeyboardOption{VirtualDeviceOption: types.VirtualDeviceOption{Type: "VirtualKeyboard"}},
+		&types.VirtualPointingDeviceOption{VirtualDeviceOption: types.VirtualDeviceOption{Type: "VirtualPointingDevice"}},
+		&types.VirtualUSBOption{VirtualDeviceOption: types.VirtualDeviceOption{Type: "VirtualUSB"}},
+		&types.VirtualVideoCardOption{VirtualDeviceOption: types.VirtualDeviceOption{Type: "VirtualMachineVideoCard"}},
+		&types.VirtualMachineVMCIDeviceOption{VirtualDeviceOption: types.VirtualDeviceOption{Type: "VirtualMachineVMCIDevice"}},
+		&types.VirtualE1000Option{VirtualEthernetCardOption: ethernetCard("VirtualE1000")},
+		&types.VirtualE1000eOption{VirtualEthernetCardOption: ethernetCard("VirtualE1000e")},
+		&types.VirtualPCNet32Option{VirtualEthernetCardOption: ethernetCard("VirtualPCNet32")},
+		&types.VirtualVmxnet2Option{VirtualVmxnetOption: types.VirtualVmxnetOption{VirtualEthernetCardOption: ethernetCard("VirtualVmxnet2")}},
+		&types.VirtualVmxnet3Option{VirtualVmxnetOption: types.VirtualVmxnetOption{VirtualEthernetCardOption: ethernetCard("VirtualVmxnet3")}},
+		&types.VirtualVmxnet3VrdmaOption{VirtualVmxnet3Option: types.VirtualVmxnet3Option{VirtualVmxnetOption: types.VirtualVmxnetOption{VirtualEthernetCardOption: ethernetCard("VirtualVmxnet3Vrdma")}}},
+		&types.VirtualSriovEthernetCardOption{VirtualEthernetCardOption: ethernetCard("VirtualSriovEthernetCard")},
+		&types.VirtualPCIPassthroughOption{VirtualDeviceOption: types.VirtualDeviceOption{Type: "VirtualPCIPassthrough"}},
+		&types.VirtualEnsoniq1371Option{VirtualSoundCardOption: types.VirtualSoundCardOption{VirtualDeviceOption: types.VirtualDeviceOption{Type: "VirtualEnsoniq1371"}}},
+		&types.VirtualHdAudioCardOption{VirtualSoundCardOption: types.VirtualSoundCardOption{VirtualDeviceOption: types.VirtualDeviceOption{Type: "VirtualHdAudioCard"}}},
+		&types.VirtualSoundBlaster16Option{VirtualSoundCardOption: types.VirtualSoundCardOption{VirtualDeviceOption: types.VirtualDeviceOption{Type: "VirtualSoundBlaster16"}}},
+		&types.VirtualTPMOption{VirtualDeviceOption: types.VirtualDeviceOption{Type: "VirtualTPM"}},
+		&types.VirtualNVDIMMOption{VirtualDeviceOption: types.VirtualDeviceOption{Type: "VirtualNVDIMM"}},
+		&types.VirtualWDTOption{VirtualDeviceOption: types.VirtualDeviceOption{Type: "VirtualWDT"}},
+		&types.VirtualPrecisionClockOption{VirtualDeviceOption: types.VirtualDeviceOption{Type: "VirtualPrecisionClock"}},
+	},
+	NumCPU:                []int32{1, 2, 4, 8, 16, 32, 64, 128, 256, 768},
+	NumCoresPerSocket:     &types.IntOption{Min: 1, Max: 64, DefaultValue: 1},
+	MemoryMB:              types.LongOption{Min: 4, Max: 25165824, DefaultValue: 1024},
+	NumPCIControllers:     types.IntOption{Min: 1, Max: 1, DefaultValue: 1},
+	NumIDEControllers:     types.IntOption{Min: 2, Max: 2, DefaultValue: 2},
+	NumUSBControllers:     types.IntOption{Min: 0, Max: 1, DefaultValue: 0},
+	NumUSBXHCIControllers: &types.IntOption{Min: 0, Max: 1, DefaultValue: 0},
+	NumSIOControllers:     types.IntOption{Min: 1, Max: 1, DefaultValue: 1},
+	NumPS2Controllers:     types.IntOption{Min: 1, Max: 1, DefaultValue: 1},
+	NumNVDIMMControllers:  &types.IntOption{Min: 0, Max: 1, DefaultValue: 0},
+	NumTPMDevices:         &types.IntOption{Min: 0, Max: 1, DefaultValue: 0},
+	NumWDTDevices:         &types.IntOption{Min: 0, Max: 1, DefaultValue: 0},
+}
+
+func scsiController(kind string) types.VirtualSCSIControllerOption {
+	opt := scsiControllerOption
+	opt.Type = kind
+	return opt
+}
+
+func ethernetCard(kind string) types.VirtualEthernetCardOption {
+	opt := ethernetCardOption
+	opt.Type = kind
+	return opt
+}
@@ -22,7 +22,6 @@ import (
 	"log"
 	"reflect"
 	"text/template"
-	"time"
 
 	"github.com/zhengkes/govmomi/simulator/esx"
 	"github.com/zhengkes/govmomi/vim25/methods"
@@ -149,7 +148,7 @@ func (m *EventManager) PostEvent(ctx *Context, req *types.PostEvent) soap.HasFau
 	event := req.EventToPost.GetEvent()
 	event.Key = m.key
 	event.ChainId = event.Key
-	event.CreatedTime = time.Now()
+	event.CreatedTime = ctx.Now()
 	event.UserName = ctx.Session.UserName
 
 	m.formatMessage(req.EventToPost)
@@ -166,6 +165,10 @@ func (m *EventManager) PostEvent(ctx *Context, req *types.PostEvent) soap.HasFau
 		})
 	}
 
+	if am := ctx.Map.AlarmManager(); am != nil {
+		am.eventPosted(ctx, req.EventToPost)
+	}
+
 	return &methods.PostEventBody{
 		Res: new(types.PostEventResponse),
 	}
@@ -0,0 +1,381 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/zhengkes/govmomi/vim25/soap"
+	"github.com/zhengkes/govmomi/vim25/types"
+)
+
+// FaultAction specifies how a method call matching a FaultRule fails.
+type FaultAction string
+
+const (
+	// FaultActionFault returns FaultRule.Fault as the method fault, without calling the method.
+	FaultActionFault = FaultAction("fault")
+	// FaultActionTask calls the method as usual, but the Task it returns fails with FaultRule.Fault,
+	// rather than running. Rules with this action only match methods that return a Task.
+	FaultActionTask = FaultAction("task")
+	// FaultActionDrop closes the client connection without sending a response.
+	FaultActionDrop = FaultAction("drop")
+	// FaultActionServer returns a SOAP server fault with FaultRule.Message as the fault string,
+	// and no method fault detail.
+	FaultActionServer = FaultAction("server")
+	// FaultActionHostFailure fails the HostSystem of the method's object, a HostSystem or VirtualMachine, see HostSystem.Fail.
+	// The method call then fails with FaultRule.Fault, which defaults to HostCommunication for this action.
+	FaultActionHostFailure = FaultAction("hostFailure")
+)
+
+// FaultRule specifies the method calls to fail and how to fail them.
+// Empty fields match any method call.
+type FaultRule struct {
+	// Method name, such as "PowerOnVM_Task". Patterns are supported, see path.Match.
+	Method string `json:"method,omitempty"`
+	// Type of the method's object, such as "VirtualMachine".
+	Type string `json:"type,omitempty"`
+	// Object is the ID of the method's object, such as "vm-42".
+	Object string `json:"object,omitempty"`
+	// User name of the session making the call.
+	User string `json:"user,omitempty"`
+
+	// After skips the given number of matching calls before the rule applies.
+	After int `json:"after,omitempty"`
+	// Count limits the number of times the rule applies, 0 means no limit.
+	Count int `json:"count,omitempty"`
+	// Probability of the rule applying to a matching call, 0 is the same as 1.
+	Probability float64 `json:"probability,omitempty"`
+
+	// Action defaults to FaultActionFault.
+	Action FaultAction `json:"action,omitempty"`
+	// Fault defaults to SystemError.
+	Fault types.BaseMethodFault `json:"fault,omitempty"`
+	// Message is used as the fault string.
+	Message string `json:"message,omitempty"`
+
+	calls int
+	fired int
+}
+
+// FaultConfig is used to inject faults into method calls, such that client retry and error handling can be tested.
+// FaultConfig is a Model field and Models are copied by value, so the rules are held by a pointer,
+// which copies of the FaultConfig share.
+type FaultConfig struct {
+	state *faultState
+}
+
+// faultState holds the FaultConfig rules and guards their call counts.
+type faultState struct {
+	mu    sync.Mutex
+	rules []*FaultRule
+}
+
+// init allocates the FaultConfig state, if needed.
+// Model calls init before starting the Service, such that the state is not allocated by concurrent calls.
+func (c *FaultConfig) init() *faultState {
+	if c.state == nil {
+		c.state = new(faultState)
+	}
+	return c.state
+}
+
+// Add the given rules. Rules are evaluated in the order they are added, the first rule that applies is used.
+func (c *FaultConfig) Add(rules ...*FaultRule) {
+	s := c.init()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rules = append(s.rules, rules...)
+}
+
+// Rules returns the current set of rules.
+func (c *FaultConfig) Rules() []*FaultRule {
+	s := c.state
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]*FaultRule(nil), s.rules...)
+}
+
+// Reset removes all rules.
+func (c *FaultConfig) Reset() {
+	s := c.state
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rules = nil
+}
+
+// Load adds the rules encoded as a JSON array in the given reader.
+// The vim25 JSON encoding is used for the "fault" field, for example:
+// [{"method": "PowerOnVM_Task", "action": "task", "fault": {"_typeName": "InvalidPowerState"}}]
+func (c *FaultConfig) Load(r io.Reader) error {
+	var rules []*FaultRule
+
+	if err := types.NewJSONDecoder(r).Decode(&rules); err != nil {
+		return fmt.Errorf("decoding fault rules: %s", err)
+	}
+
+	for _, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return err
+		}
+	}
+
+	c.Add(rules...)
+
+	return nil
+}
+
+// LoadFile adds the rules from the given file, see Load.
+func (c *FaultConfig) LoadFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return c.Load(f)
+}
+
+// ParseFaultRule parses a rule from a comma separated list of key=value pairs,
+// where keys are the json names of the FaultRule fields and the fault value is a type name, for example:
+// "method=PowerOnVM_Task,type=VirtualMachine,action=task,fault=InvalidPowerState,count=1"
+func ParseFaultRule(s string) (*FaultRule, error) {
+	rule := new(FaultRule)
+
+	for _, kv := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(kv), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid fault rule %q: expected key=value", kv)
+		}
+
+		var err error
+
+		switch k {
+		case "method":
+			rule.Method = v
+		case "type":
+			rule.Type = v
+		case "object":
+			rule.Object = v
+		case "user":
+			rule.User = v
+		case "after":
+			rule.After, err = strconv.Atoi(v)
+		case "count":
+			rule.Count, err = strconv.Atoi(v)
+		case "probability":
+			rule.Probability, err = strconv.ParseFloat(v, 64)
+		case "action":
+			rule.Action = FaultAction(v)
+		case "fault":
+			rule.Fault, err = newMethodFault(v)
+		case "message":
+			rule.Message = v
+		default:
+			err = fmt.Errorf("unknown key")
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid fault rule %q: %s", kv, err)
+		}
+	}
+
+	return rule, rule.validate()
+}
+
+// newMethodFault returns a new instance of the given fault type name
+func newMethodFault(name string) (types.BaseMethodFault, error) {
+	kind, ok := types.TypeFunc()(name)
+	if ok {
+		if fault, ok := reflect.New(kind).Interface().(types.BaseMethodFault); ok {
+			return fault, nil
+		}
+	}
+	return nil, fmt.Errorf("%q is not a method fault type", name)
+}
+
+func (r *FaultRule) validate() error {
+	switch r.Action {
+	case "", FaultActionFault, FaultActionTask, FaultActionDrop, FaultActionServer, FaultActionHostFailure:
+	default:
+		return fmt.Errorf("invalid fault rule action: %q", r.Action)
+	}
+
+	if _, err := path.Match(r.Method, ""); err != nil {
+		return fmt.Errorf("invalid fault rule method %q: %s", r.Method, err)
+	}
+
+	if r.Probability < 0 || r.Probability > 1 {
+		return fmt.Errorf("invalid fault rule probability: %v", r.Probability)
+	}
+
+	return nil
+}
+
+func (r *FaultRule) matches(ctx *Context, method *Method) bool {
+	if r.Method != "" {
+		if ok, _ := path.Match(r.Method, method.Name); !ok {
+			return false
+		}
+	}
+
+	if r.Action == FaultActionTask && !strings.HasSuffix(method.Name, vTaskSuffix) {
+		return false
+	}
+
+	if r.Type != "" && r.Type != method.This.Type {
+		return false
+	}
+
+	if r.Object != "" && r.Object != method.This.Value {
+		return false
+	}
+
+	if r.User != "" && (ctx.Session == nil || ctx.Session.UserName != r.User) {
+		return false
+	}
+
+	r.calls++
+	if r.calls <= r.After {
+		return false
+	}
+
+	if r.Count != 0 && r.fired >= r.Count {
+		return false
+	}
+
+	if r.Probability != 0 && rand.Float64() >= r.Probability {
+		return false
+	}
+
+	r.fired++
+
+	return true
+}
+
+func (r *FaultRule) fault() types.BaseMethodFault {
+	if r.Fault == nil {
+		return &types.SystemError{Reason: r.Message}
+	}
+	return r.Fault
+}
+
+// match returns the first rule that applies to the given method call, if any.
+func (c *FaultConfig) match(ctx *Context, method *Method) *FaultRule {
+	s := c.state
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, rule := range s.rules {
+		if rule.matches(ctx, method) {
+			return rule
+		}
+	}
+
+	return nil
+}
+
+// inject returns the response for a method call matching a FaultRule,
+// or nil if the method should be called, as is the case with FaultActionTask.
+func (c *FaultConfig) inject(ctx *Context, method *Method) soap.HasFault {
+	rule := c.match(ctx, method)
+	if rule == nil {
+		return nil
+	}
+
+	tracef("fault rule %+v matches %s %s", *rule, method.This, method.Name)
+
+	switch rule.Action {
+	case FaultActionTask:
+		ctx.taskFault = rule.fault()
+		return nil
+	case FaultActionDrop:
+		return &dropConnectionBody{Reason: Fault("connection dropped", &types.HostCommunication{})}
+	case FaultActionServer:
+		return &serverFaultBody{Reason: &soap.Fault{Code: "ServerFaultCode", String: rule.Message}}
+	case FaultActionHostFailure:
+		var host *HostSystem
+		switch obj := ctx.Map.Get(method.This).(type) {
+		case *HostSystem:
+			host = obj
+		case *VirtualMachine:
+			if obj.Runtime.Host != nil {
+				host = ctx.Map.Get(*obj.Runtime.Host).(*HostSystem)
+			}
+		}
+		if host != nil {
+			ctx.WithLock(host, func() {
+				host.Fail(ctx)
+			})
+		}
+		if rule.Fault == nil {
+			return &serverFaultBody{Reason: Fault(rule.Message, new(types.HostCommunication))}
+		}
+		return &serverFaultBody{Reason: Fault(rule.Message, rule.fault())}
+	default:
+		return &serverFaultBody{Reason: Fault(rule.Message, rule.fault())}
+	}
+}
+
+// dropConnectionBody is returned by Service.call when a FaultRule specifies FaultActionDrop.
+// The fault is only seen by clients that do not use an HTTP connection, such as Service.RoundTrip.
+type dropConnectionBody struct {
+	Reason *soap.Fault
+}
+
+func (b *dropConnectionBody) Fault() *soap.Fault { return b.Reason }
+
+// dropConnection closes the client connection of the given response, returning false if the connection cannot be closed.
+func dropConnection(w http.ResponseWriter) bool {
+	h, ok := w.(http.Hijacker)
+	if !ok {
+		return false
+	}
+
+	conn, _, err := h.Hijack()
+	if err != nil {
+		return false
+	}
+
+	_ = conn.Close()
+
+	return true
+}
@@ -0,0 +1,175 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/zhengkes/govmomi/find"
+	"github.com/zhengkes/govmomi/task"
+	"github.com/zhengkes/govmomi/vim25"
+	"github.com/zhengkes/govmomi/vim25/mo"
+	"github.com/zhengkes/govmomi/vim25/soap"
+	"github.com/zhengkes/govmomi/vim25/types"
+)
+
+func TestFaultInjection(t *testing.T) {
+	m := VPX()
+
+	Test(func(ctx context.Context, c *vim25.Client) {
+		vm, err := find.NewFinder(c).VirtualMachine(ctx, "DC0_H0_VM0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		powerState := func() types.VirtualMachinePowerState {
+			var o mo.VirtualMachine
+			if err := vm.Properties(ctx, vm.Reference(), []string{"runtime.powerState"}, &o); err != nil {
+				t.Fatal(err)
+			}
+			return o.Runtime.PowerState
+		}
+
+		// method fault, on the 2nd matching call only
+		m.FaultConfig.Add(&FaultRule{
+			Method: "PowerOffVM_Task",
+			Object: vm.Reference().Value,
+			After:  1,
+			Count:  1,
+			Fault:  &types.InvalidState{},
+		})
+
+		for i, fail := range []bool{false, true, false} {
+			ptask, err := vm.PowerOff(ctx)
+			if fail {
+				if !soap.IsSoapFault(err) {
+					t.Fatalf("%d: err=%#v", i, err)
+				}
+				if _, ok := soap.ToSoapFault(err).VimFault().(types.InvalidState); !ok {
+					t.Errorf("%d: err=%#v", i, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("%d: %s", i, err)
+			}
+			_ = ptask.Wait(ctx) // InvalidPowerState after the first call
+		}
+
+		// task fault, the method is not run
+		m.FaultConfig.Reset()
+		m.FaultConfig.Add(&FaultRule{
+			Method: "PowerOn*",
+			Type:   "VirtualMachine",
+			User:   DefaultLogin.Username(),
+			Action: FaultActionTask,
+			Fault:  &types.InsufficientResourcesFault{},
+		})
+
+		ptask, err := vm.PowerOn(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ptask.Wait(ctx)
+		if terr, ok := err.(task.Error); !ok {
+			t.Errorf("err=%#v", err)
+		} else if _, ok := terr.Fault().(*types.InsufficientResourcesFault); !ok {
+			t.Errorf("fault=%#v", terr.Fault())
+		}
+		if state := powerState(); state != types.VirtualMachinePowerStatePoweredOff {
+			t.Errorf("state=%s", state)
+		}
+
+		// server fault
+		m.FaultConfig.Reset()
+		m.FaultConfig.Add(&FaultRule{Method: "PowerOnVM_Task", Action: FaultActionServer, Message: "internal error"})
+
+		_, err = vm.PowerOn(ctx)
+		if !soap.IsSoapFault(err) {
+			t.Fatalf("err=%#v", err)
+		}
+		if f := soap.ToSoapFault(err); f.String != "internal error" || f.VimFault() != nil {
+			t.Errorf("fault=%#v", f)
+		}
+
+		// dropped connection
+		m.FaultConfig.Reset()
+		m.FaultConfig.Add(&FaultRule{Method: "PowerOnVM_Task", Action: FaultActionDrop, Count: 1})
+
+		_, err = vm.PowerOn(ctx)
+		if err == nil || soap.IsSoapFault(err) {
+			t.Fatalf("err=%#v", err)
+		}
+
+		// rule no longer applies
+		ptask, err = vm.PowerOn(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = ptask.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		// rules for other users do not apply
+		m.FaultConfig.Reset()
+		m.FaultConfig.Add(&FaultRule{User: "enoent"})
+
+		if state := powerState(); state != types.VirtualMachinePowerStatePoweredOn {
+			t.Errorf("state=%s", state)
+		}
+	}, m)
+}
+
+func TestFaultRuleConfig(t *testing.T) {
+	rule, err := ParseFaultRule("method=PowerOnVM_Task, type=VirtualMachine,action=task,fault=InvalidPowerState,count=1,probability=0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.Method != "PowerOnVM_Task" || rule.Type != "VirtualMachine" || rule.Action != FaultActionTask || rule.Count != 1 || rule.Probability != 0.5 {
+		t.Errorf("rule=%#v", rule)
+	}
+	if _, ok := rule.Fault.(*types.InvalidPowerState); !ok {
+		t.Errorf("fault=%#v", rule.Fault)
+	}
+
+	for _, s := range []string{"method", "fault=VirtualMachine", "action=enoent", "probability=2", "enoent=1"} {
+		if _, err = ParseFaultRule(s); err == nil {
+			t.Errorf("expected error parsing %q", s)
+		}
+	}
+
+	var config FaultConfig
+
+	err = config.Load(strings.NewReader(`[{"method": "Destroy_Task", "action": "fault", "fault": {"_typeName": "InvalidState"}, "message": "busy"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules := config.Rules()
+	if len(rules) != 1 || rules[0].Method != "Destroy_Task" || rules[0].Message != "busy" {
+		t.Fatalf("rules=%#v", rules)
+	}
+	if _, ok := rules[0].Fault.(*types.InvalidState); !ok {
+		t.Errorf("fault=%#v", rules[0].Fault)
+	}
+
+	if err = config.Load(strings.NewReader(`[{"action": "enoent"}]`)); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"path"
 	"path/filepath"
 
+	"github.com/zhengkes/govmomi/object"
 	"github.com/zhengkes/govmomi/simulator/esx"
 	"github.com/zhengkes/govmomi/vim25/methods"
 	"github.com/zhengkes/govmomi/vim25/mo"
@@ -67,16 +68,28 @@ func (f *FileManager) findDatastore(ref mo.Reference, name string) (*Datastore,
 }
 
 func (f *FileManager) resolve(dc *types.ManagedObjectReference, name string) (string, types.BaseMethodFault) {
-	p, fault := parseDatastorePath(name)
+	ds, p, fault := f.resolveDatastore(dc, name)
 	if fault != nil {
 		return "", fault
 	}
 
+	dir := ds.Info.GetDatastoreInfo().Url
+
+	return path.Join(dir, p.Path), nil
+}
+
+// resolveDatastore returns the Datastore of the given "[datastore] path" within dc, along with the parsed path.
+func (f *FileManager) resolveDatastore(dc *types.ManagedObjectReference, name string) (*Datastore, *object.DatastorePath, types.BaseMethodFault) {
+	p, fault := parseDatastorePath(name)
+	if fault != nil {
+		return nil, nil, fault
+	}
+
 	if dc == nil {
 		if Map.IsESX() {
 			dc = &esx.Datacenter.Self
 		} else {
-			return "", &types.InvalidArgument{InvalidProperty: "dc"}
+			return nil, nil, &types.InvalidArgument{InvalidProperty: "dc"}
 		}
 	}
 
@@ -84,12 +97,10 @@ func (f *FileManager) resolve(dc *types.ManagedObjectReference, name string) (st
 
 	ds, fault := f.findDatastore(Map.Get(folder), p.Datastore)
 	if fault != nil {
-		return "", fault
+		return nil, nil, fault
 	}
 
-	dir := ds.Info.GetDatastoreInfo().Url
-
-	return path.Join(dir, p.Path), nil
+	return ds, p, nil
 }
 
 func (f *FileManager) fault(name string, err error, fault types.BaseFileFault) types.BaseMethodFault {
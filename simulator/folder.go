@@ -23,7 +23,6 @@ import (
 	"net/url"
 	"path"
 	"strings"
-	"time"
 
 	"github.com/google/uuid"
 
@@ -814,7 +813,7 @@ func (f *Folder) PlaceVmsXCluster(ctx *Context, req *types.PlaceVmsXCluster) soa
 			res := types.ClusterRecommendation{
 				Key:        "1",
 				Type:       "V1",
-				Time:       time.Now(),
+				Time:       ctx.Now(),
 				Rating:     1,
 				Reason:     string(types.RecommendationReasonCodeXClusterPlacement),
 				ReasonText: string(types.RecommendationReasonCodeXClusterPlacement),
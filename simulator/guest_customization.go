@@ -0,0 +1,277 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/zhengkes/govmomi/object"
+	"github.com/zhengkes/govmomi/vim25/types"
+)
+
+// CustomizationOptionKey is the VM ExtraConfig key used to simulate a guest customization failure.
+// The value is the name of the failure event to post, such as "CustomizationNetworkSetupFailed",
+// see customizationFailures. Customization succeeds when the key is not set.
+const CustomizationOptionKey = "SIM.customization.result"
+
+// CustomizationTiming configures when guest customization takes place,
+// after a VM with pending customization is powered on.
+// The zero value customizes the guest while the VM is powered on.
+type CustomizationTiming struct {
+	// Start is the time from power on until CustomizationStartedEvent is posted.
+	Start time.Duration
+	// Duration is the time from start until the guest reflects the customization spec
+	// and CustomizationSucceeded or CustomizationFailed is posted.
+	Duration time.Duration
+}
+
+// customizationFailures are the CustomizationFailed events that can be selected via CustomizationOptionKey.
+var customizationFailures = map[string]func(types.CustomizationFailed) types.BaseEvent{
+	"CustomizationFailed": func(e types.CustomizationFailed) types.BaseEvent {
+		return &e
+	},
+	"CustomizationLinuxIdentityFailed": func(e types.CustomizationFailed) types.BaseEvent {
+		return &types.CustomizationLinuxIdentityFailed{CustomizationFailed: e}
+	},
+	"CustomizationNetworkSetupFailed": func(e types.CustomizationFailed) types.BaseEvent {
+		return &types.CustomizationNetworkSetupFailed{CustomizationFailed: e}
+	},
+	"CustomizationSysprepFailed": func(e types.CustomizationFailed) types.BaseEvent {
+		return &types.CustomizationSysprepFailed{CustomizationFailed: e}
+	},
+	"CustomizationUnknownFailure": func(e types.CustomizationFailed) types.BaseEvent {
+		return &types.CustomizationUnknownFailure{CustomizationFailed: e}
+	},
+}
+
+func (c *Context) customizationTiming() CustomizationTiming {
+	if c.svc != nil && c.svc.customization != nil {
+		return *c.svc.customization
+	}
+	return CustomizationTiming{}
+}
+
+// customizationLog returns the in-guest customization log location for the given spec.
+func customizationLog(spec *types.CustomizationSpec) string {
+	if _, ok := spec.Identity.(*types.CustomizationSysprep); ok {
+		return `C:\Windows\TEMP\vmware-imc\guestcust.log`
+	}
+	return "/var/log/vmware-imc/toolsDeployPkg.log"
+}
+
+// customizationFailure returns the failure event name for the given spec, if customization should fail.
+func (vm *VirtualMachine) customizationFailure(spec *types.CustomizationSpec) string {
+	for _, opt := range vm.Config.ExtraConfig {
+		if val := opt.GetOptionValue(); val.Key == CustomizationOptionKey {
+			name, _ := val.Value.(string)
+			if customizationFailures[name] != nil {
+				return name
+			}
+		}
+	}
+
+	for _, s := range spec.NicSettingMap {
+		if ip, ok := s.Adapter.Ip.(*types.CustomizationFixedIp); ok && net.ParseIP(ip.IpAddress) == nil {
+			return "CustomizationNetworkSetupFailed"
+		}
+	}
+
+	return ""
+}
+
+// customize applies the pending guest customization of a VM being powered on, according to the CustomizationTiming.
+func (vm *VirtualMachine) customize(ctx *Context) {
+	spec := vm.imc
+	if spec == nil {
+		return
+	}
+
+	timing := ctx.customizationTiming()
+	if timing == (CustomizationTiming{}) {
+		vm.customizeStart(ctx, spec)
+		vm.customizeGuest(ctx, spec)
+		return
+	}
+
+	// customization runs in the guest after the power on task completes,
+	// using its own Context as done for PowerOnMultiVM subtasks.
+	gctx := &Context{
+		Context: context.Background(),
+		Session: ctx.Session,
+		Map:     ctx.Map,
+		svc:     ctx.svc,
+	}
+	clock := gctx.Clock()
+
+	// pending returns true if the VM is still powered on with the given customization spec
+	pending := func() bool {
+		return gctx.Map.Get(vm.Self) != nil && vm.imc == spec &&
+			vm.Runtime.PowerState == types.VirtualMachinePowerStatePoweredOn
+	}
+
+	go func() {
+		<-clock.After(timing.Start)
+
+		ok := false
+		gctx.WithLock(vm, func() {
+			if ok = pending(); ok {
+				vm.customizeStart(gctx, spec)
+			}
+		})
+		if !ok {
+			return
+		}
+
+		<-clock.After(timing.Duration)
+
+		gctx.WithLock(vm, func() {
+			if pending() {
+				vm.customizeGuest(gctx, spec)
+			}
+		})
+	}()
+}
+
+func (vm *VirtualMachine) customizeStart(ctx *Context, spec *types.CustomizationSpec) {
+	ctx.postEvent(&types.CustomizationStartedEvent{
+		CustomizationEvent: types.CustomizationEvent{VmEvent: vm.event(), LogLocation: customizationLog(spec)},
+	})
+}
+
+// customizeGuest applies the identity and NIC settings of the given spec to the VM's guest properties,
+// unless the customization fails, in which case the guest is left as-is.
+func (vm *VirtualMachine) customizeGuest(ctx *Context, spec *types.CustomizationSpec) {
+	vm.imc = nil
+
+	event := types.CustomizationEvent{VmEvent: vm.event(), LogLocation: customizationLog(spec)}
+
+	changes := []types.PropertyChange{
+		{Name: "config.tools.pendingCustomization", Val: ""},
+	}
+
+	if name := vm.customizationFailure(spec); name != "" {
+		ctx.Map.Update(vm, changes)
+		ctx.postEvent(customizationFailures[name](types.CustomizationFailed{CustomizationEvent: event}))
+		return
+	}
+
+	hostname := ""
+	address := ""
+
+	switch c := spec.Identity.(type) {
+	case *types.CustomizationLinuxPrep:
+		hostname = customizeName(vm, c.HostName)
+	case *types.CustomizationSysprep:
+		hostname = customizeName(vm, c.UserData.ComputerName)
+	}
+
+	cards := object.VirtualDeviceList(vm.Config.Hardware.Device).SelectByType((*types.VirtualEthernetCard)(nil))
+
+	for i, s := range spec.NicSettingMap {
+		if i >= len(vm.Guest.Net) {
+			break
+		}
+		nic := &vm.Guest.Net[i]
+		if s.MacAddress != "" {
+			nic.MacAddress = strings.ToLower(s.MacAddress) // MacAddress in guest will always be lowercase
+			if i < len(cards) {
+				card := cards[i].(types.BaseVirtualEthernetCard).GetVirtualEthernetCard()
+				card.MacAddress = s.MacAddress // MacAddress in Virtual NIC can be any case
+				card.AddressType = string(types.VirtualEthernetCardMacTypeManual)
+			}
+		}
+		if nic.DnsConfig == nil {
+			nic.DnsConfig = new(types.NetDnsConfigInfo)
+		}
+		if s.Adapter.DnsDomain != "" {
+			nic.DnsConfig.DomainName = s.Adapter.DnsDomain
+		}
+		if len(s.Adapter.DnsServerList) != 0 {
+			nic.DnsConfig.IpAddress = s.Adapter.DnsServerList
+		}
+		if hostname != "" {
+			nic.DnsConfig.HostName = hostname
+		}
+		if len(spec.GlobalIPSettings.DnsSuffixList) != 0 {
+			nic.DnsConfig.SearchDomain = spec.GlobalIPSettings.DnsSuffixList
+		}
+		if nic.IpConfig == nil {
+			nic.IpConfig = new(types.NetIpConfigInfo)
+		}
+
+		var addrs []types.NetIpConfigInfoIpAddress
+
+		switch ip := s.Adapter.Ip.(type) {
+		case *types.CustomizationCustomIpGenerator:
+		case *types.CustomizationDhcpIpGenerator:
+		case *types.CustomizationFixedIp:
+			if address == "" {
+				address = ip.IpAddress
+			}
+			prefix := 0
+			if mask := net.ParseIP(s.Adapter.SubnetMask).To4(); mask != nil {
+				prefix, _ = net.IPMask(mask).Size()
+			}
+			addrs = append(addrs, types.NetIpConfigInfoIpAddress{
+				IpAddress:    ip.IpAddress,
+				PrefixLength: int32(prefix),
+				Origin:       string(types.NetIpConfigInfoIpAddressOriginManual),
+				State:        string(types.NetIpConfigInfoIpAddressStatusPreferred),
+			})
+		case *types.CustomizationUnknownIpGenerator:
+		}
+
+		if s.Adapter.IpV6Spec != nil {
+			for _, gen := range s.Adapter.IpV6Spec.Ip {
+				if ip, ok := gen.(*types.CustomizationFixedIpV6); ok {
+					addrs = append(addrs, types.NetIpConfigInfoIpAddress{
+						IpAddress:    ip.IpAddress,
+						PrefixLength: ip.SubnetMask,
+						Origin:       string(types.NetIpConfigInfoIpAddressOriginManual),
+						State:        string(types.NetIpConfigInfoIpAddressStatusPreferred),
+					})
+				}
+			}
+		}
+
+		if len(addrs) != 0 {
+			nic.IpAddress = nil
+			for _, addr := range addrs {
+				nic.IpAddress = append(nic.IpAddress, addr.IpAddress)
+			}
+			nic.IpConfig.IpAddress = addrs
+		}
+	}
+
+	if len(spec.NicSettingMap) != 0 {
+		changes = append(changes, types.PropertyChange{Name: "guest.net", Val: vm.Guest.Net})
+	}
+	if hostname != "" {
+		changes = append(changes, types.PropertyChange{Name: "guest.hostName", Val: hostname})
+		changes = append(changes, types.PropertyChange{Name: "summary.guest.hostName", Val: hostname})
+	}
+	if address != "" {
+		changes = append(changes, types.PropertyChange{Name: "guest.ipAddress", Val: address})
+		changes = append(changes, types.PropertyChange{Name: "summary.guest.ipAddress", Val: address})
+	}
+
+	ctx.Map.Update(vm, changes)
+	ctx.postEvent(&types.CustomizationSucceeded{CustomizationEvent: event})
+}
@@ -0,0 +1,212 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zhengkes/govmomi/event"
+	"github.com/zhengkes/govmomi/find"
+	"github.com/zhengkes/govmomi/task"
+	"github.com/zhengkes/govmomi/vim25"
+	"github.com/zhengkes/govmomi/vim25/mo"
+	"github.com/zhengkes/govmomi/vim25/types"
+)
+
+func TestGuestCustomization(t *testing.T) {
+	m := VPX()
+
+	err := m.Run(func(ctx context.Context, c *vim25.Client) error {
+		finder := find.NewFinder(c)
+		events := event.NewManager(c)
+
+		vm, err := finder.VirtualMachine(ctx, "DC0_H0_VM0")
+		if err != nil {
+			return err
+		}
+		folder, err := finder.Folder(ctx, "vm")
+		if err != nil {
+			return err
+		}
+
+		customizations := func(ref types.ManagedObjectReference) []string {
+			var kinds []string
+			res, err := events.QueryEvents(ctx, types.EventFilterSpec{
+				Entity:      &types.EventFilterSpecByEntity{Entity: ref, Recursion: types.EventFilterSpecRecursionOptionSelf},
+				EventTypeId: []string{"CustomizationStartedEvent", "CustomizationSucceeded", "CustomizationFailed"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := len(res) - 1; i >= 0; i-- { // oldest first
+				kinds = append(kinds, reflect.TypeOf(res[i]).Elem().Name())
+			}
+			return kinds
+		}
+
+		spec := func(ip string) *types.CustomizationSpec {
+			return &types.CustomizationSpec{
+				Identity: &types.CustomizationLinuxPrep{
+					HostName: &types.CustomizationFixedName{Name: "web-1"},
+				},
+				NicSettingMap: []types.CustomizationAdapterMapping{{
+					Adapter: types.CustomizationIPSettings{
+						Ip:         &types.CustomizationFixedIp{IpAddress: ip},
+						SubnetMask: "255.255.255.0",
+						IpV6Spec: &types.CustomizationIPSettingsIpV6AddressSpec{
+							Ip: []types.BaseCustomizationIpV6Generator{
+								&types.CustomizationFixedIpV6{IpAddress: "fd00::10", SubnetMask: 64},
+							},
+						},
+					},
+				}},
+			}
+		}
+
+		// customization is applied when the vm is powered on
+		tsk, err := vm.PowerOff(ctx)
+		if err != nil {
+			return err
+		}
+		if err = tsk.Wait(ctx); err != nil {
+			return err
+		}
+		tsk, err = vm.Customize(ctx, *spec("10.0.0.10"))
+		if err != nil {
+			return err
+		}
+		if err = tsk.Wait(ctx); err != nil {
+			return err
+		}
+		tsk, err = vm.PowerOn(ctx)
+		if err != nil {
+			return err
+		}
+		if err = tsk.Wait(ctx); err != nil {
+			return err
+		}
+
+		var mvm mo.VirtualMachine
+		if err = vm.Properties(ctx, vm.Reference(), []string{"guest", "config.tools"}, &mvm); err != nil {
+			return err
+		}
+		if mvm.Guest.HostName != "web-1" || mvm.Guest.IpAddress != "10.0.0.10" {
+			t.Errorf("hostName=%s ipAddress=%s", mvm.Guest.HostName, mvm.Guest.IpAddress)
+		}
+		if mvm.Config.Tools.PendingCustomization != "" {
+			t.Errorf("pendingCustomization=%s", mvm.Config.Tools.PendingCustomization)
+		}
+		addrs := mvm.Guest.Net[0].IpConfig.IpAddress
+		if len(addrs) != 2 || addrs[0].PrefixLength != 24 || addrs[1].IpAddress != "fd00::10" || addrs[1].PrefixLength != 64 {
+			t.Errorf("ipConfig=%#v", addrs)
+		}
+		if kinds := customizations(vm.Reference()); !reflect.DeepEqual(kinds, []string{"CustomizationStartedEvent", "CustomizationSucceeded"}) {
+			t.Errorf("events=%v", kinds)
+		}
+
+		// clone spec nics must match the vm
+		mismatch := spec("10.0.0.11")
+		mismatch.NicSettingMap = append(mismatch.NicSettingMap, mismatch.NicSettingMap[0])
+		tsk, err = vm.Clone(ctx, folder, "mismatch", types.VirtualMachineCloneSpec{Customization: mismatch})
+		if err != nil {
+			return err
+		}
+		err = tsk.Wait(ctx)
+		if _, ok := err.(task.Error).Fault().(*types.NicSettingMismatch); !ok {
+			t.Errorf("err=%v", err)
+		}
+
+		// clone with customization and power on, which fails in the guest after the configured delay
+		m.Customization = CustomizationTiming{Start: 10 * time.Millisecond, Duration: 50 * time.Millisecond}
+
+		tsk, err = vm.Clone(ctx, folder, "web-2", types.VirtualMachineCloneSpec{
+			Customization: spec("10.0.0"),
+			PowerOn:       true,
+		})
+		if err != nil {
+			return err
+		}
+		info, err := tsk.WaitForResult(ctx)
+		if err != nil {
+			return err
+		}
+		clone := info.Result.(types.ManagedObjectReference)
+
+		expect := []string{"CustomizationStartedEvent", "CustomizationNetworkSetupFailed"}
+		for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+			if kinds := customizations(clone); reflect.DeepEqual(kinds, expect) {
+				break
+			}
+		}
+		if kinds := customizations(clone); !reflect.DeepEqual(kinds, expect) {
+			t.Errorf("events=%v", kinds)
+		}
+
+		if err = vm.Properties(ctx, clone, []string{"guest", "runtime", "config.tools"}, &mvm); err != nil {
+			return err
+		}
+		if mvm.Runtime.PowerState != types.VirtualMachinePowerStatePoweredOn {
+			t.Errorf("powerState=%s", mvm.Runtime.PowerState)
+		}
+		if mvm.Guest.HostName == "web-1" || mvm.Config.Tools.PendingCustomization != "" {
+			t.Errorf("hostName=%s pendingCustomization=%s", mvm.Guest.HostName, mvm.Config.Tools.PendingCustomization)
+		}
+
+		// customization timing follows the Service Clock
+		m.Clock.Freeze()
+		defer m.Clock.Resume()
+		m.Customization = CustomizationTiming{Start: time.Minute, Duration: time.Hour}
+
+		tsk, err = vm.Clone(ctx, folder, "web-3", types.VirtualMachineCloneSpec{
+			Customization: spec("10.0.0.30"),
+			PowerOn:       true,
+		})
+		if err != nil {
+			return err
+		}
+		info, err = tsk.WaitForResult(ctx)
+		if err != nil {
+			return err
+		}
+		clone = info.Result.(types.ManagedObjectReference)
+
+		wait := func(expect ...string) {
+			t.Helper()
+			for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+				if kinds := customizations(clone); reflect.DeepEqual(kinds, expect) {
+					return
+				}
+			}
+			t.Errorf("events=%v, expected %v", customizations(clone), expect)
+		}
+
+		time.Sleep(50 * time.Millisecond)
+		wait()
+		m.Clock.Advance(time.Minute)
+		wait("CustomizationStartedEvent")
+		m.Clock.Advance(time.Hour)
+		wait("CustomizationStartedEvent", "CustomizationSucceeded")
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,156 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"sort"
+
+	"github.com/zhengkes/govmomi/vim25/types"
+)
+
+// dasRestartOrder is the order in which HA restarts VMs by restart priority, VMs with other priorities are not restarted.
+var dasRestartOrder = map[string]int{
+	string(types.ClusterDasVmSettingsRestartPriorityHighest): 0,
+	string(types.ClusterDasVmSettingsRestartPriorityHigh):    1,
+	string(types.ClusterDasVmSettingsRestartPriorityMedium):  2,
+	string(types.ClusterDasVmSettingsRestartPriorityLow):     3,
+	string(types.ClusterDasVmSettingsRestartPriorityLowest):  4,
+}
+
+// dasRestartPriority returns the HA restart priority of the given VM,
+// as specified by the cluster's DasVmConfig overrides or its default VM settings.
+func (c *ClusterComputeResource) dasRestartPriority(vm types.ManagedObjectReference) string {
+	cfg := c.ConfigurationEx.(*types.ClusterConfigInfoEx)
+	cluster := string(types.ClusterDasVmSettingsRestartPriorityClusterRestartPriority)
+
+	priority := string(types.ClusterDasVmSettingsRestartPriorityMedium)
+	if s := cfg.DasConfig.DefaultVmSettings; s != nil && s.RestartPriority != "" {
+		priority = s.RestartPriority
+	}
+
+	for _, override := range cfg.DasVmConfig {
+		if override.Key != vm {
+			continue
+		}
+		if s := override.DasSettings; s != nil && s.RestartPriority != "" && s.RestartPriority != cluster {
+			priority = s.RestartPriority
+		} else if p := string(override.RestartPriority); p != "" && p != cluster {
+			priority = p
+		}
+	}
+
+	return priority
+}
+
+// failover restarts the powered on VMs of the given failed host on the surviving hosts of the cluster,
+// as vSphere HA does when host monitoring is enabled.
+// VMs are restarted in order of restart priority, on hosts that do not violate the cluster's rules
+// and, when admission control is enabled, have the CPU and memory capacity to run the VM.
+// Hosts specified by a ClusterFailoverHostAdmissionControlPolicy are used first.
+func (c *ClusterComputeResource) failover(ctx *Context, failed *HostSystem) {
+	cfg := c.ConfigurationEx.(*types.ClusterConfigInfoEx)
+	das := cfg.DasConfig
+	if !isTrue(das.Enabled) || das.HostMonitoring == string(types.ClusterDasConfigInfoServiceStateDisabled) {
+		return
+	}
+
+	ctx.postEvent(&types.DasHostFailedEvent{
+		ClusterEvent: types.ClusterEvent{
+			Event: types.Event{
+				Datacenter: datacenterEventArgument(c),
+				ComputeResource: &types.ComputeResourceEventArgument{
+					ComputeResource:     c.Self,
+					EntityEventArgument: types.EntityEventArgument{Name: c.Name},
+				},
+			},
+		},
+		FailedHost: *failed.eventArgument(),
+	})
+
+	s := newDrsState(ctx, c)
+
+	var vms []*VirtualMachine
+	for _, vm := range s.vms {
+		if s.placement[vm.Self] != failed.Self {
+			continue
+		}
+		cpu, mem := drsVmLoad(vm)
+		h := s.host[failed.Self]
+		h.cpuUsed -= cpu
+		h.memUsed -= mem
+		delete(s.placement, vm.Self)
+
+		if _, ok := dasRestartOrder[c.dasRestartPriority(vm.Self)]; ok {
+			vms = append(vms, vm)
+		}
+	}
+
+	sort.SliceStable(vms, func(i, j int) bool {
+		return dasRestartOrder[c.dasRestartPriority(vms[i].Self)] < dasRestartOrder[c.dasRestartPriority(vms[j].Self)]
+	})
+
+	var failoverHosts []types.ManagedObjectReference
+	if policy, ok := das.AdmissionControlPolicy.(*types.ClusterFailoverHostAdmissionControlPolicy); ok {
+		failoverHosts = policy.FailoverHosts
+	}
+
+	for _, vm := range vms {
+		cpu, mem := drsVmLoad(vm)
+
+		candidates := s.candidates(vm, failed.Self)
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return FindReference(failoverHosts, candidates[i].Self) != nil && FindReference(failoverHosts, candidates[j].Self) == nil
+		})
+
+		var target *drsHost
+		for _, h := range candidates {
+			if h.hard != 0 {
+				continue
+			}
+			if isTrue(das.AdmissionControlEnabled) {
+				if (h.cpu != 0 && h.cpuUsed+cpu > h.cpu) || (h.mem != 0 && h.memUsed+mem > h.mem) {
+					continue
+				}
+			}
+			target = h.drsHost
+			break
+		}
+
+		if target == nil {
+			msg := "vSphere HA unable to restart " + vm.Name + ": insufficient resources"
+			ctx.postEvent(&types.VmFailoverFailed{
+				VmEvent: vm.event(),
+				Reason: &types.LocalizedMethodFault{
+					Fault:            new(types.InsufficientResourcesFault),
+					LocalizedMessage: msg,
+				},
+			})
+			continue
+		}
+
+		s.move(vm, target)
+
+		vm.setHost(ctx, target.HostSystem,
+			types.PropertyChange{Name: "summary.runtime.bootTime", Val: ctx.Now()},
+		)
+
+		ctx.postEvent(&types.VmRestartedOnAlternateHostEvent{
+			VmPoweredOnEvent: types.VmPoweredOnEvent{VmEvent: vm.event()},
+			SourceHost:       *failed.eventArgument(),
+		})
+	}
+}
@@ -0,0 +1,153 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/zhengkes/govmomi/event"
+	"github.com/zhengkes/govmomi/find"
+	"github.com/zhengkes/govmomi/object"
+	"github.com/zhengkes/govmomi/vim25"
+	"github.com/zhengkes/govmomi/vim25/soap"
+	"github.com/zhengkes/govmomi/vim25/types"
+)
+
+func TestHostFailover(t *testing.T) {
+	m := VPX()
+
+	err := m.Run(func(ctx context.Context, c *vim25.Client) error {
+		finder := find.NewFinder(c)
+
+		cluster, err := finder.ClusterComputeResource(ctx, "DC0_C0")
+		if err != nil {
+			return err
+		}
+
+		cr := Map.Get(cluster.Reference()).(*ClusterComputeResource)
+		pool := Map.Get(*cr.ResourcePool).(*ResourcePool)
+		vm0 := Map.FindByName("DC0_C0_RP0_VM0", pool.Vm).(*VirtualMachine)
+		vm1 := Map.FindByName("DC0_C0_RP0_VM1", pool.Vm).(*VirtualMachine)
+
+		// run both vms on the first host
+		h0 := Map.Get(cr.Host[0]).(*HostSystem)
+		for _, vm := range []*VirtualMachine{vm0, vm1} {
+			if *vm.Runtime.Host != h0.Self {
+				vm.drsMigrate(SpoofContext(), h0)
+			}
+		}
+
+		task, err := cluster.Reconfigure(ctx, &types.ClusterConfigSpecEx{
+			DasConfig: &types.ClusterDasConfigInfo{
+				Enabled:                 types.NewBool(true),
+				AdmissionControlEnabled: types.NewBool(true),
+			},
+			DasVmConfigSpec: []types.ClusterDasVmConfigSpec{{
+				ArrayUpdateSpec: types.ArrayUpdateSpec{Operation: types.ArrayUpdateOperationAdd},
+				Info: &types.ClusterDasVmConfigInfo{
+					Key: vm1.Self,
+					DasSettings: &types.ClusterDasVmSettings{
+						RestartPriority: string(types.ClusterDasVmSettingsRestartPriorityDisabled),
+					},
+				},
+			}},
+		}, true)
+		if err != nil {
+			return err
+		}
+		if err = task.Wait(ctx); err != nil {
+			return err
+		}
+
+		// fail the first host via fault rule
+		m.FaultConfig.Add(&FaultRule{Object: h0.Self.Value, Action: FaultActionHostFailure, Count: 1})
+
+		_, err = object.NewHostSystem(c, h0.Self).EnterMaintenanceMode(ctx, 0, false, nil)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if _, ok := soap.ToSoapFault(err).VimFault().(types.HostCommunication); !ok {
+			t.Errorf("err=%s", err)
+		}
+
+		if h0.Runtime.ConnectionState != types.HostSystemConnectionStateNotResponding {
+			t.Errorf("host state=%s", h0.Runtime.ConnectionState)
+		}
+
+		// vm0 was restarted on another host
+		if *vm0.Runtime.Host == h0.Self {
+			t.Error("vm0 not restarted")
+		}
+		if vm0.Runtime.PowerState != types.VirtualMachinePowerStatePoweredOn {
+			t.Errorf("vm0 state=%s", vm0.Runtime.PowerState)
+		}
+
+		// vm1 restart priority is disabled
+		if *vm1.Runtime.Host != h0.Self {
+			t.Error("vm1 restarted")
+		}
+		if vm1.Runtime.PowerState != types.VirtualMachinePowerStatePoweredOff {
+			t.Errorf("vm1 state=%s", vm1.Runtime.PowerState)
+		}
+		if vm1.Runtime.ConnectionState != types.VirtualMachineConnectionStateDisconnected {
+			t.Errorf("vm1 connection state=%s", vm1.Runtime.ConnectionState)
+		}
+
+		// vm0 cannot be restarted again, as no host has the memory capacity with admission control enabled
+		vm0.Summary.QuickStats.HostMemoryUsage = 1 << 20
+		h1 := Map.Get(*vm0.Runtime.Host).(*HostSystem)
+		SpoofContext().WithLock(h1, func() {
+			h1.Fail(SpoofContext())
+		})
+		if *vm0.Runtime.Host != h1.Self {
+			t.Error("vm0 restarted")
+		}
+
+		kinds := make(map[string]int)
+		events, err := event.NewManager(c).QueryEvents(ctx, types.EventFilterSpec{
+			Type: []string{"DasHostFailedEvent", "VmRestartedOnAlternateHostEvent", "VmFailoverFailed"},
+		})
+		if err != nil {
+			return err
+		}
+		for _, e := range events {
+			kinds[reflect.TypeOf(e).Elem().Name()]++
+		}
+		if kinds["DasHostFailedEvent"] != 2 || kinds["VmRestartedOnAlternateHostEvent"] != 1 || kinds["VmFailoverFailed"] != 1 {
+			t.Errorf("events=%v", kinds)
+		}
+
+		// recover the first host
+		task, err = object.NewHostSystem(c, h0.Self).Reconnect(ctx, nil, nil)
+		if err != nil {
+			return err
+		}
+		if err = task.Wait(ctx); err != nil {
+			return err
+		}
+		if vm1.Runtime.ConnectionState != types.VirtualMachineConnectionStateConnected {
+			t.Errorf("vm1 connection state=%s", vm1.Runtime.ConnectionState)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
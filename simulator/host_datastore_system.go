@@ -145,7 +145,7 @@ func (dss *HostDatastoreSystem) CreateLocalDatastore(ctx *Context, c *types.Crea
 		},
 	})
 
-	_ = ds.RefreshDatastore(&types.RefreshDatastore{This: ds.Self})
+	_ = ds.RefreshDatastore(ctx, &types.RefreshDatastore{This: ds.Self})
 
 	r.Res = &types.CreateLocalDatastoreResponse{
 		Returnval: ds.Self,
@@ -199,7 +199,7 @@ func (dss *HostDatastoreSystem) CreateNasDatastore(ctx *Context, c *types.Create
 		return r
 	}
 
-	_ = ds.RefreshDatastore(&types.RefreshDatastore{This: ds.Self})
+	_ = ds.RefreshDatastore(ctx, &types.RefreshDatastore{This: ds.Self})
 
 	r.Res = &types.CreateNasDatastoreResponse{
 		Returnval: ds.Self,
@@ -0,0 +1,155 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"time"
+
+	"github.com/zhengkes/govmomi/simulator/esx"
+	"github.com/zhengkes/govmomi/vim25/methods"
+	"github.com/zhengkes/govmomi/vim25/mo"
+	"github.com/zhengkes/govmomi/vim25/soap"
+	"github.com/zhengkes/govmomi/vim25/types"
+)
+
+type HostDateTimeSystem struct {
+	mo.HostDateTimeSystem
+
+	Host *mo.HostSystem
+
+	offset time.Duration // host clock offset, as changed by UpdateDateTime
+}
+
+func NewHostDateTimeSystem(host *mo.HostSystem) *HostDateTimeSystem {
+	var info types.HostDateTimeInfo
+	deepCopy(esx.HostDateTimeInfo, &info)
+
+	if host.Config != nil {
+		var config types.HostDateTimeInfo
+		deepCopy(info, &config)
+		host.Config.DateTimeInfo = &config
+	}
+
+	return &HostDateTimeSystem{
+		HostDateTimeSystem: mo.HostDateTimeSystem{
+			DateTimeInfo: info,
+		},
+		Host: host,
+	}
+}
+
+func (s *HostDateTimeSystem) now(ctx *Context) time.Time {
+	return ctx.Now().Add(s.offset).UTC()
+}
+
+// update keeps the HostSystem config.dateTimeInfo property in sync
+func (s *HostDateTimeSystem) update(ctx *Context) {
+	ctx.Map.Update(s, []types.PropertyChange{{Name: "dateTimeInfo", Val: s.DateTimeInfo}})
+
+	host := ctx.Map.Get(s.Host.Reference()).(*HostSystem)
+	ctx.WithLock(host, func() {
+		var info types.HostDateTimeInfo
+		deepCopy(s.DateTimeInfo, &info)
+		ctx.Map.Update(host, []types.PropertyChange{{Name: "config.dateTimeInfo", Val: &info}})
+	})
+}
+
+func (s *HostDateTimeSystem) QueryDateTime(ctx *Context, req *types.QueryDateTime) soap.HasFault {
+	return &methods.QueryDateTimeBody{
+		Res: &types.QueryDateTimeResponse{
+			Returnval: s.now(ctx),
+		},
+	}
+}
+
+func (s *HostDateTimeSystem) UpdateDateTime(ctx *Context, req *types.UpdateDateTime) soap.HasFault {
+	s.offset = req.DateTime.Sub(ctx.Now())
+
+	return &methods.UpdateDateTimeBody{
+		Res: new(types.UpdateDateTimeResponse),
+	}
+}
+
+func (s *HostDateTimeSystem) QueryAvailableTimeZones(ctx *Context, req *types.QueryAvailableTimeZones) soap.HasFault {
+	return &methods.QueryAvailableTimeZonesBody{
+		Res: &types.QueryAvailableTimeZonesResponse{
+			Returnval: []types.HostDateTimeSystemTimeZone{esx.HostDateTimeInfo.TimeZone},
+		},
+	}
+}
+
+func (s *HostDateTimeSystem) UpdateDateTimeConfig(ctx *Context, req *types.UpdateDateTimeConfig) soap.HasFault {
+	body := new(methods.UpdateDateTimeConfigBody)
+	config := req.Config
+
+	// ESX only supports the UTC time zone
+	if config.TimeZone != "" && config.TimeZone != esx.HostDateTimeInfo.TimeZone.Key {
+		body.Fault_ = Fault("", &types.InvalidArgument{InvalidProperty: "config.timeZone"})
+		return body
+	}
+
+	switch config.Protocol {
+	case "", string(types.HostDateTimeInfoProtocolNtp), string(types.HostDateTimeInfoProtocolPtp):
+	default:
+		body.Fault_ = Fault("", &types.InvalidArgument{InvalidProperty: "config.protocol"})
+		return body
+	}
+
+	if config.NtpConfig != nil {
+		for _, server := range config.NtpConfig.Server {
+			if server == "" {
+				body.Fault_ = Fault("", &types.InvalidArgument{InvalidProperty: "config.ntpConfig.server"})
+				return body
+			}
+		}
+
+		s.DateTimeInfo.NtpConfig = &types.HostNtpConfig{
+			Server:     config.NtpConfig.Server,
+			ConfigFile: config.NtpConfig.ConfigFile,
+		}
+	}
+
+	if config.PtpConfig != nil {
+		s.DateTimeInfo.PtpConfig = config.PtpConfig
+	}
+	if config.Protocol != "" {
+		s.DateTimeInfo.SystemClockProtocol = config.Protocol
+	}
+	if config.Enabled != nil {
+		s.DateTimeInfo.Enabled = config.Enabled
+	}
+	if config.DisableEvents != nil {
+		s.DateTimeInfo.DisableEvents = config.DisableEvents
+	}
+	if config.DisableFallback != nil {
+		s.DateTimeInfo.DisableFallback = config.DisableFallback
+	}
+	if isTrue(config.ResetToFactoryDefaults) {
+		deepCopy(esx.HostDateTimeInfo, &s.DateTimeInfo)
+	}
+
+	s.update(ctx)
+
+	body.Res = new(types.UpdateDateTimeConfigResponse)
+	return body
+}
+
+func (s *HostDateTimeSystem) RefreshDateTimeSystem(ctx *Context, req *types.RefreshDateTimeSystem) soap.HasFault {
+	return &methods.RefreshDateTimeSystemBody{
+		Res: new(types.RefreshDateTimeSystemResponse),
+	}
+}
@@ -0,0 +1,95 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zhengkes/govmomi/object"
+	"github.com/zhengkes/govmomi/simulator/esx"
+	"github.com/zhengkes/govmomi/vim25/mo"
+	"github.com/zhengkes/govmomi/vim25/types"
+)
+
+func TestHostDateTimeSystem(t *testing.T) {
+	ctx := context.Background()
+
+	m := ESX()
+	m.Datastore = 0
+	m.Machine = 0
+
+	err := m.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Remove()
+
+	c := m.Service.client
+
+	host := object.NewHostSystem(c, esx.HostSystem.Reference())
+
+	s, err := host.ConfigManager().DateTimeSystem(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	date := time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC)
+	if err = s.Update(ctx, date); err != nil {
+		t.Fatal(err)
+	}
+
+	now, err := s.Query(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if now.Before(date) || now.Sub(date) > time.Minute {
+		t.Errorf("now=%s", now)
+	}
+
+	servers := []string{"0.pool.ntp.org", "1.pool.ntp.org"}
+	err = s.UpdateConfig(ctx, types.HostDateTimeConfig{
+		NtpConfig: &types.HostNtpConfig{Server: servers},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.UpdateConfig(ctx, types.HostDateTimeConfig{TimeZone: "America/Los_Angeles"})
+	if err == nil {
+		t.Error("expected error")
+	}
+
+	var ds mo.HostDateTimeSystem
+	if err = s.Properties(ctx, s.Reference(), nil, &ds); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ds.DateTimeInfo.NtpConfig.Server, servers) {
+		t.Errorf("servers=%v", ds.DateTimeInfo.NtpConfig.Server)
+	}
+
+	// HostSystem config.dateTimeInfo is kept in sync
+	var props mo.HostSystem
+	if err = host.Properties(ctx, host.Reference(), []string{"config.dateTimeInfo"}, &props); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(props.Config.DateTimeInfo.NtpConfig.Server, servers) {
+		t.Errorf("servers=%v", props.Config.DateTimeInfo.NtpConfig.Server)
+	}
+}
@@ -26,15 +26,18 @@ import (
 
 type HostFirewallSystem struct {
 	mo.HostFirewallSystem
+
+	Host *mo.HostSystem
 }
 
-func NewHostFirewallSystem(_ *mo.HostSystem) *HostFirewallSystem {
+func NewHostFirewallSystem(host *mo.HostSystem) *HostFirewallSystem {
 	info := esx.HostFirewallInfo
 
 	return &HostFirewallSystem{
 		HostFirewallSystem: mo.HostFirewallSystem{
 			FirewallInfo: &info,
 		},
+		Host: host,
 	}
 }
 
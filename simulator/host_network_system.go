@@ -49,7 +49,7 @@ func NewHostNetworkSystem(host *mo.HostSystem) *HostNetworkSystem {
 }
 
 func (s *HostNetworkSystem) init(r *Registry) {
-	for _, obj := range r.objects {
+	for _, obj := range r.kinds["HostSystem"] {
 		if h, ok := obj.(*HostSystem); ok {
 			if h.ConfigManager.NetworkSystem.Value == s.Self.Value {
 				s.Host = &h.HostSystem
@@ -0,0 +1,154 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"github.com/zhengkes/govmomi/vim25/methods"
+	"github.com/zhengkes/govmomi/vim25/mo"
+	"github.com/zhengkes/govmomi/vim25/soap"
+	"github.com/zhengkes/govmomi/vim25/types"
+)
+
+type HostServiceSystem struct {
+	mo.HostServiceSystem
+
+	Host *mo.HostSystem
+}
+
+func NewHostServiceSystem(host *mo.HostSystem) *HostServiceSystem {
+	var info types.HostServiceInfo
+	if host.Config != nil && host.Config.Service != nil {
+		deepCopy(host.Config.Service, &info)
+	}
+
+	return &HostServiceSystem{
+		HostServiceSystem: mo.HostServiceSystem{
+			ServiceInfo: info,
+		},
+		Host: host,
+	}
+}
+
+func (s *HostServiceSystem) service(id string) *types.HostService {
+	for i := range s.ServiceInfo.Service {
+		if s.ServiceInfo.Service[i].Key == id {
+			return &s.ServiceInfo.Service[i]
+		}
+	}
+	return nil
+}
+
+// update applies the given change to the service with the given id,
+// keeping the HostSystem config.service property in sync.
+func (s *HostServiceSystem) update(ctx *Context, id string, f func(*types.HostService) types.BaseMethodFault) *soap.Fault {
+	service := s.service(id)
+	if service == nil {
+		return Fault("", &types.NotFound{})
+	}
+
+	if err := f(service); err != nil {
+		return Fault("", err)
+	}
+
+	ctx.Map.Update(s, []types.PropertyChange{{Name: "serviceInfo", Val: s.ServiceInfo}})
+
+	host := ctx.Map.Get(s.Host.Reference()).(*HostSystem)
+	ctx.WithLock(host, func() {
+		var info types.HostServiceInfo
+		deepCopy(s.ServiceInfo, &info)
+		ctx.Map.Update(host, []types.PropertyChange{{Name: "config.service", Val: &info}})
+	})
+
+	return nil
+}
+
+func (s *HostServiceSystem) StartService(ctx *Context, req *types.StartService) soap.HasFault {
+	body := new(methods.StartServiceBody)
+
+	body.Fault_ = s.update(ctx, req.Id, func(service *types.HostService) types.BaseMethodFault {
+		service.Running = true
+		return nil
+	})
+
+	if body.Fault_ == nil {
+		body.Res = new(types.StartServiceResponse)
+	}
+
+	return body
+}
+
+func (s *HostServiceSystem) StopService(ctx *Context, req *types.StopService) soap.HasFault {
+	body := new(methods.StopServiceBody)
+
+	body.Fault_ = s.update(ctx, req.Id, func(service *types.HostService) types.BaseMethodFault {
+		if service.Required {
+			return &types.InvalidState{}
+		}
+		service.Running = false
+		return nil
+	})
+
+	if body.Fault_ == nil {
+		body.Res = new(types.StopServiceResponse)
+	}
+
+	return body
+}
+
+func (s *HostServiceSystem) RestartService(ctx *Context, req *types.RestartService) soap.HasFault {
+	body := new(methods.RestartServiceBody)
+
+	body.Fault_ = s.update(ctx, req.Id, func(service *types.HostService) types.BaseMethodFault {
+		service.Running = true
+		return nil
+	})
+
+	if body.Fault_ == nil {
+		body.Res = new(types.RestartServiceResponse)
+	}
+
+	return body
+}
+
+func (s *HostServiceSystem) UpdateServicePolicy(ctx *Context, req *types.UpdateServicePolicy) soap.HasFault {
+	body := new(methods.UpdateServicePolicyBody)
+
+	body.Fault_ = s.update(ctx, req.Id, func(service *types.HostService) types.BaseMethodFault {
+		switch types.HostServicePolicy(req.Policy) {
+		case types.HostServicePolicyOn, types.HostServicePolicyOff, types.HostServicePolicyAutomatic:
+		default:
+			return &types.InvalidArgument{InvalidProperty: "policy"}
+		}
+		if service.Required && req.Policy == string(types.HostServicePolicyOff) {
+			return &types.InvalidArgument{InvalidProperty: "policy"}
+		}
+		service.Policy = req.Policy
+		return nil
+	})
+
+	if body.Fault_ == nil {
+		body.Res = new(types.UpdateServicePolicyResponse)
+	}
+
+	return body
+}
+
+func (s *HostServiceSystem) RefreshServices(ctx *Context, req *types.RefreshServices) soap.HasFault {
+	return &methods.RefreshServicesBody{
+		Res: new(types.RefreshServicesResponse),
+	}
+}
@@ -0,0 +1,106 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"context"
+	"testing"
+
+	"github.com/zhengkes/govmomi/object"
+	"github.com/zhengkes/govmomi/simulator/esx"
+	"github.com/zhengkes/govmomi/vim25/mo"
+	"github.com/zhengkes/govmomi/vim25/types"
+)
+
+func TestHostServiceSystem(t *testing.T) {
+	ctx := context.Background()
+
+	m := ESX()
+	m.Datastore = 0
+	m.Machine = 0
+
+	err := m.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Remove()
+
+	c := m.Service.client
+
+	host := object.NewHostSystem(c, esx.HostSystem.Reference())
+
+	hss, err := host.ConfigManager().ServiceSystem(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	find := func(id string) types.HostService {
+		services, err := hss.Service(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range services {
+			if s.Key == id {
+				return s
+			}
+		}
+		t.Fatalf("service %s not found", id)
+		return types.HostService{}
+	}
+
+	if err = hss.Start(ctx, "enoent"); err == nil {
+		t.Error("expected error")
+	}
+
+	if err = hss.Start(ctx, "TSM-SSH"); err != nil {
+		t.Fatal(err)
+	}
+	if !find("TSM-SSH").Running {
+		t.Error("expected TSM-SSH to be running")
+	}
+
+	if err = hss.UpdatePolicy(ctx, "TSM-SSH", "on"); err != nil {
+		t.Fatal(err)
+	}
+	if err = hss.UpdatePolicy(ctx, "TSM-SSH", "invalid"); err == nil {
+		t.Error("expected error")
+	}
+	if p := find("TSM-SSH").Policy; p != "on" {
+		t.Errorf("policy=%s", p)
+	}
+
+	if err = hss.Restart(ctx, "ntpd"); err != nil {
+		t.Fatal(err)
+	}
+	if err = hss.Stop(ctx, "TSM-SSH"); err != nil {
+		t.Fatal(err)
+	}
+	if find("TSM-SSH").Running || !find("ntpd").Running {
+		t.Error("unexpected service state")
+	}
+
+	// HostSystem config.service is kept in sync
+	var props mo.HostSystem
+	if err = host.Properties(ctx, host.Reference(), []string{"config.service"}, &props); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range props.Config.Service.Service {
+		if s.Key == "ntpd" && !s.Running {
+			t.Error("expected ntpd to be running")
+		}
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"reflect"
 	"sync"
 	"time"
 
@@ -100,6 +101,8 @@ func NewHostSystem(host mo.HostSystem) *HostSystem {
 		{&hs.ConfigManager.AdvancedOption, NewOptionManager(nil, nil, &hs.Config.Option)},
 		{&hs.ConfigManager.FirewallSystem, NewHostFirewallSystem(&hs.HostSystem)},
 		{&hs.ConfigManager.StorageSystem, NewHostStorageSystem(&hs.HostSystem)},
+		{&hs.ConfigManager.ServiceSystem, NewHostServiceSystem(&hs.HostSystem)},
+		{&hs.ConfigManager.DateTimeSystem, NewHostDateTimeSystem(&hs.HostSystem)},
 	}
 
 	for _, c := range config {
@@ -111,6 +114,26 @@ func NewHostSystem(host mo.HostSystem) *HostSystem {
 	return hs
 }
 
+// restore links the ConfigManager objects to the host when loaded from a checkpoint, as NewHostSystem does
+func (h *HostSystem) restore(ctx *Context) {
+	host := reflect.ValueOf(&h.HostSystem)
+
+	for _, ref := range mo.References(h.ConfigManager) {
+		switch obj := ctx.Map.Get(ref).(type) {
+		case nil:
+		case *OptionManager:
+			if h.Config != nil {
+				obj.mirror = &h.Config.Option
+			}
+		default:
+			field := reflect.ValueOf(obj).Elem().FieldByName("Host")
+			if field.IsValid() && field.Type() == host.Type() {
+				field.Set(host)
+			}
+		}
+	}
+}
+
 func (h *HostSystem) configure(ctx *Context, spec types.HostConnectSpec, connected bool) {
 	h.Runtime.ConnectionState = types.HostSystemConnectionStateDisconnected
 	if connected {
@@ -538,6 +561,13 @@ func (h *HostSystem) DestroyTask(ctx *Context, req *types.Destroy_Task) soap.Has
 
 func (h *HostSystem) EnterMaintenanceModeTask(ctx *Context, spec *types.EnterMaintenanceMode_Task) soap.HasFault {
 	task := CreateTask(h, "enterMaintenanceMode", func(t *Task) (types.AnyType, types.BaseMethodFault) {
+		if cluster, ok := ctx.Map.Get(*h.Parent).(*ClusterComputeResource); ok {
+			// DRS migrates the powered on VMs in fully automated mode
+			ctx.WithLock(cluster, func() {
+				cluster.evacuate(ctx, h.Self)
+			})
+		}
+
 		h.Runtime.InMaintenanceMode = true
 		return nil, nil
 	})
@@ -564,7 +594,13 @@ func (h *HostSystem) ExitMaintenanceModeTask(ctx *Context, spec *types.ExitMaint
 
 func (h *HostSystem) DisconnectHostTask(ctx *Context, spec *types.DisconnectHost_Task) soap.HasFault {
 	task := CreateTask(h, "disconnectHost", func(t *Task) (types.AnyType, types.BaseMethodFault) {
-		h.Runtime.ConnectionState = types.HostSystemConnectionStateDisconnected
+		ctx.Map.Update(h, []types.PropertyChange{
+			{Name: "runtime.connectionState", Val: types.HostSystemConnectionStateDisconnected},
+		})
+		ctx.postEvent(&types.HostDisconnectedEvent{
+			HostEvent: h.event(),
+			Reason:    string(types.HostDisconnectedEventReasonCodeUserRequest),
+		})
 		return nil, nil
 	})
 
@@ -575,9 +611,59 @@ func (h *HostSystem) DisconnectHostTask(ctx *Context, spec *types.DisconnectHost
 	}
 }
 
+// Fail simulates a failure of the host, such as a power loss: the host stops responding and its VMs are disconnected.
+// When the host is part of a cluster with vSphere HA enabled, the powered on VMs are restarted on the surviving hosts.
+// Otherwise, powered on VMs are powered off. The host can be recovered using ReconnectHost_Task.
+func (h *HostSystem) Fail(ctx *Context) {
+	if h.Runtime.ConnectionState == types.HostSystemConnectionStateNotResponding {
+		return
+	}
+
+	ctx.Map.Update(h, []types.PropertyChange{
+		{Name: "runtime.connectionState", Val: types.HostSystemConnectionStateNotResponding},
+	})
+	ctx.postEvent(&types.HostConnectionLostEvent{HostEvent: h.event()})
+
+	if cluster, ok := ctx.Map.Get(*h.Parent).(*ClusterComputeResource); ok {
+		ctx.WithLock(cluster, func() {
+			cluster.failover(ctx, h)
+		})
+	}
+
+	for _, ref := range append([]types.ManagedObjectReference(nil), h.Vm...) {
+		vm := ctx.Map.Get(ref).(*VirtualMachine)
+		ctx.WithLock(vm, func() {
+			changes := []types.PropertyChange{
+				{Name: "runtime.connectionState", Val: types.VirtualMachineConnectionStateDisconnected},
+				{Name: "summary.runtime.connectionState", Val: types.VirtualMachineConnectionStateDisconnected},
+			}
+			if vm.Runtime.PowerState == types.VirtualMachinePowerStatePoweredOn {
+				vm.svm.stop(ctx)
+				changes = append(changes,
+					types.PropertyChange{Name: "runtime.powerState", Val: types.VirtualMachinePowerStatePoweredOff},
+					types.PropertyChange{Name: "summary.runtime.powerState", Val: types.VirtualMachinePowerStatePoweredOff},
+				)
+			}
+			vm.updatePowerState(ctx, changes)
+		})
+	}
+}
+
 func (h *HostSystem) ReconnectHostTask(ctx *Context, spec *types.ReconnectHost_Task) soap.HasFault {
 	task := CreateTask(h, "reconnectHost", func(t *Task) (types.AnyType, types.BaseMethodFault) {
-		h.Runtime.ConnectionState = types.HostSystemConnectionStateConnected
+		ctx.Map.Update(h, []types.PropertyChange{
+			{Name: "runtime.connectionState", Val: types.HostSystemConnectionStateConnected},
+		})
+		for _, ref := range h.Vm {
+			vm := ctx.Map.Get(ref).(*VirtualMachine)
+			if vm.Runtime.ConnectionState == types.VirtualMachineConnectionStateDisconnected {
+				ctx.Map.AtomicUpdate(ctx, vm, []types.PropertyChange{
+					{Name: "runtime.connectionState", Val: types.VirtualMachineConnectionStateConnected},
+					{Name: "summary.runtime.connectionState", Val: types.VirtualMachineConnectionStateConnected},
+				})
+			}
+		}
+		ctx.postEvent(&types.HostConnectedEvent{HostEvent: h.event()})
 		return nil, nil
 	})
 
@@ -17,6 +17,7 @@ limitations under the License.
 package simulator
 
 import (
+	"context"
 	"crypto/sha1"
 	"encoding/hex"
 	"fmt"
@@ -24,14 +25,19 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/zhengkes/govmomi/object"
 	"github.com/zhengkes/govmomi/vim25/methods"
 	"github.com/zhengkes/govmomi/vim25/mo"
 	"github.com/zhengkes/govmomi/vim25/soap"
 	"github.com/zhengkes/govmomi/vim25/types"
+	"github.com/zhengkes/govmomi/vmdk"
 )
 
 type metadata struct {
@@ -43,6 +49,8 @@ type HttpNfcLease struct {
 	mo.HttpNfcLease
 	files    map[string]string
 	metadata map[string]metadata
+	capacity map[string]int64 // export disks, streamed as streamOptimized
+	timer    *ClockTimer      // expires the lease when LeaseTimeout passes without progress
 }
 
 var (
@@ -72,24 +80,32 @@ func ServeNFC(w http.ResponseWriter, r *http.Request) {
 	status := http.StatusOK
 	var dst hash.Hash
 	var src io.ReadCloser
+	var n int64
+	var err error
 
 	switch r.Method {
 	case http.MethodPut, http.MethodPost:
 		dst = sha1.New()
 		src = r.Body
+		n, err = io.Copy(dst, src)
+		_ = src.Close()
 	case http.MethodGet:
-		f, err := os.Open(file)
-		if err != nil {
+		f, ferr := os.Open(diskDataFile(file))
+		if ferr != nil {
 			http.NotFound(w, r)
 			return
 		}
 		src = f
+		if capacity, ok := lease.capacity[name]; ok {
+			n, err = exportDisk(w, name, capacity, f)
+		} else {
+			n, err = io.Copy(w, f)
+		}
+		_ = src.Close()
 	default:
 		status = http.StatusMethodNotAllowed
 	}
 
-	n, err := io.Copy(dst, src)
-	_ = src.Close()
 	if dst != nil {
 		lease.metadata[name] = metadata{
 			sha1: dst.Sum(nil),
@@ -103,11 +119,52 @@ func ServeNFC(w http.ResponseWriter, r *http.Request) {
 		msg = err.Error()
 	}
 	tracef("nfc %s %s: %s", r.Method, file, msg)
-	w.WriteHeader(status)
+	if r.Method != http.MethodGet {
+		w.WriteHeader(status)
+	}
+}
+
+// diskDataFile returns the name of the file containing disk data,
+// which is the "-flat.vmdk" file if it exists, otherwise the given file.
+func diskDataFile(file string) string {
+	if strings.HasSuffix(file, ".vmdk") {
+		flat := strings.TrimSuffix(file, ".vmdk") + "-flat.vmdk"
+		if _, err := os.Stat(flat); err == nil {
+			return flat
+		}
+	}
+	return file
+}
+
+// exportDisk writes the given disk file to w as a streamOptimized VMDK.
+// If the file is already streamOptimized, the file is copied as-is.
+func exportDisk(w io.Writer, name string, capacity int64, f *os.File) (int64, error) {
+	header := make([]byte, 512)
+	_, _ = f.ReadAt(header, 0)
+
+	if vmdk.IsStreamOptimized(header) {
+		return io.Copy(w, f)
+	}
+
+	cw := &countingWriter{w: w}
+	err := vmdk.WriteStreamOptimized(cw, name, capacity, f)
+	return cw.n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
 }
 
 func (l *HttpNfcLease) error(ctx *Context, err *types.LocalizedMethodFault) {
 	ctx.WithLock(l, func() {
+		l.stop()
 		ctx.Map.Update(l, []types.PropertyChange{
 			{Name: "state", Val: types.HttpNfcLeaseStateError},
 			{Name: "error", Val: err},
@@ -115,6 +172,49 @@ func (l *HttpNfcLease) error(ctx *Context, err *types.LocalizedMethodFault) {
 	})
 }
 
+// renew arms the lease timer, such that the lease expires if LeaseTimeout seconds
+// of the Service Clock pass without a call to HttpNfcLeaseProgress.
+// The caller must hold the lease lock.
+func (l *HttpNfcLease) renew(ctx *Context) {
+	l.stop()
+
+	lctx := &Context{
+		Context: context.Background(),
+		Session: ctx.Session,
+		Map:     ctx.Map,
+		svc:     ctx.svc,
+	}
+	timeout := time.Duration(l.Info.LeaseTimeout) * time.Second
+
+	l.timer = ctx.Clock().AfterFunc(timeout, func() { l.expire(lctx) })
+}
+
+// stop disarms the lease timer. The caller must hold the lease lock.
+func (l *HttpNfcLease) stop() {
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+}
+
+// expire puts a Ready lease into the Error state with a Timedout fault and revokes NFC access.
+func (l *HttpNfcLease) expire(ctx *Context) {
+	ctx.WithLock(l, func() {
+		l.timer = nil
+		if l.State != types.HttpNfcLeaseStateReady {
+			return
+		}
+		nfcLease.Delete(l.Self)
+		ctx.Map.Update(l, []types.PropertyChange{
+			{Name: "state", Val: types.HttpNfcLeaseStateError},
+			{Name: "error", Val: &types.LocalizedMethodFault{
+				Fault:            new(types.Timedout),
+				LocalizedMessage: "The lease has timed out",
+			}},
+		})
+	})
+}
+
 func (l *HttpNfcLease) ready(ctx *Context, entity types.ManagedObjectReference, urls []types.HttpNfcLeaseDeviceUrl) {
 	info := &types.HttpNfcLeaseInfo{
 		Lease:        l.Self,
@@ -123,11 +223,16 @@ func (l *HttpNfcLease) ready(ctx *Context, entity types.ManagedObjectReference,
 		LeaseTimeout: 300,
 	}
 
+	for _, capacity := range l.capacity {
+		info.TotalDiskCapacityInKB += capacity / 1024
+	}
+
 	ctx.WithLock(l, func() {
 		ctx.Map.Update(l, []types.PropertyChange{
 			{Name: "state", Val: types.HttpNfcLeaseStateReady},
 			{Name: "info", Val: info},
 		})
+		l.renew(ctx)
 	})
 }
 
@@ -138,6 +243,7 @@ func newHttpNfcLease(ctx *Context) *HttpNfcLease {
 		},
 		files:    make(map[string]string),
 		metadata: make(map[string]metadata),
+		capacity: make(map[string]int64),
 	}
 
 	ctx.Session.Put(lease)
@@ -147,6 +253,7 @@ func newHttpNfcLease(ctx *Context) *HttpNfcLease {
 }
 
 func (l *HttpNfcLease) HttpNfcLeaseComplete(ctx *Context, req *types.HttpNfcLeaseComplete) soap.HasFault {
+	l.stop()
 	ctx.Session.Remove(ctx, req.This)
 	nfcLease.Delete(req.This)
 
@@ -156,6 +263,7 @@ func (l *HttpNfcLease) HttpNfcLeaseComplete(ctx *Context, req *types.HttpNfcLeas
 }
 
 func (l *HttpNfcLease) HttpNfcLeaseAbort(ctx *Context, req *types.HttpNfcLeaseAbort) soap.HasFault {
+	l.stop()
 	ctx.Session.Remove(ctx, req.This)
 	nfcLease.Delete(req.This)
 
@@ -165,11 +273,64 @@ func (l *HttpNfcLease) HttpNfcLeaseAbort(ctx *Context, req *types.HttpNfcLeaseAb
 }
 
 func (l *HttpNfcLease) HttpNfcLeaseProgress(ctx *Context, req *types.HttpNfcLeaseProgress) soap.HasFault {
-	l.TransferProgress = req.Percent
+	body := new(methods.HttpNfcLeaseProgressBody)
+
+	if req.Percent < 0 || req.Percent > 100 {
+		body.Fault_ = Fault("", &types.InvalidArgument{InvalidProperty: "percent"})
+		return body
+	}
 
-	return &methods.HttpNfcLeaseProgressBody{
-		Res: new(types.HttpNfcLeaseProgressResponse),
+	if l.State != types.HttpNfcLeaseStateReady {
+		body.Fault_ = Fault("", &types.InvalidState{})
+		return body
 	}
+
+	ctx.Map.Update(l, []types.PropertyChange{
+		{Name: "transferProgress", Val: req.Percent},
+	})
+	l.renew(ctx)
+
+	body.Res = new(types.HttpNfcLeaseProgressResponse)
+
+	return body
+}
+
+// export adds the given disk devices of a VM to the lease, returning the device URLs.
+// The prefix, if any, is prepended to the disk file names.
+func (l *HttpNfcLease) export(vm *VirtualMachine, devices object.VirtualDeviceList, prefix string) []types.HttpNfcLeaseDeviceUrl {
+	var urls []types.HttpNfcLeaseDeviceUrl
+
+	for i, d := range devices.SelectByType((*types.VirtualDisk)(nil)) {
+		disk := d.(*types.VirtualDisk)
+		info := diskDataBacking(disk.Backing)
+		if info == nil {
+			continue
+		}
+
+		var file object.DatastorePath
+		file.FromString(info.GetVirtualDeviceFileBackingInfo().FileName)
+		ds := vm.findDatastore(file.Datastore)
+
+		name := fmt.Sprintf("%sdisk-%d.vmdk", prefix, i)
+		l.files[name] = path.Join(ds.Info.GetDatastoreInfo().Url, file.Path)
+		l.capacity[name] = disk.CapacityInBytes
+		if l.capacity[name] == 0 {
+			l.capacity[name] = disk.CapacityInKB * 1024
+		}
+
+		urls = append(urls, types.HttpNfcLeaseDeviceUrl{
+			Key: fmt.Sprintf("/%s/%s", vm.Self.Value, devices.Name(disk)),
+			Url: (&url.URL{
+				Scheme: "https",
+				Host:   "*",
+				Path:   nfcPrefix + path.Join(l.Self.Value, name),
+			}).String(),
+			Disk:     types.NewBool(true),
+			TargetId: name,
+		})
+	}
+
+	return urls
 }
 
 func (l *HttpNfcLease) getDeviceKey(name string) string {
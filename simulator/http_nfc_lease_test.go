@@ -0,0 +1,278 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zhengkes/govmomi/find"
+	"github.com/zhengkes/govmomi/nfc"
+	"github.com/zhengkes/govmomi/object"
+	"github.com/zhengkes/govmomi/vim25"
+	"github.com/zhengkes/govmomi/vim25/methods"
+	"github.com/zhengkes/govmomi/vim25/mo"
+	"github.com/zhengkes/govmomi/vim25/soap"
+	"github.com/zhengkes/govmomi/vim25/types"
+	"github.com/zhengkes/govmomi/vmdk"
+)
+
+// exportLease downloads all files from the given export lease to dir
+func exportLease(ctx context.Context, t *testing.T, lease *nfc.Lease, dir string) *nfc.LeaseInfo {
+	t.Helper()
+
+	info, err := lease.Wait(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := lease.StartUpdater(ctx, info)
+
+	for _, item := range info.Items {
+		err = lease.DownloadFile(ctx, filepath.Join(dir, item.Path), item, soap.DefaultDownload)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	u.Done()
+
+	if err = lease.Complete(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	return info
+}
+
+func TestExportVm(t *testing.T) {
+	Test(func(ctx context.Context, c *vim25.Client) {
+		vm, err := find.NewFinder(c).VirtualMachine(ctx, "DC0_H0_VM0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = vm.Export(ctx)
+		if _, ok := soap.ToSoapFault(err).VimFault().(types.InvalidPowerState); !ok {
+			t.Errorf("expected InvalidPowerState, got %v", err)
+		}
+
+		task, err := vm.PowerOff(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = task.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		// write some data to the disk, exported as a grain of the streamOptimized vmdk
+		sim := Map.Get(vm.Reference()).(*VirtualMachine)
+		disk := object.VirtualDeviceList(sim.Config.Hardware.Device).SelectByType((*types.VirtualDisk)(nil))[0].(*types.VirtualDisk)
+		var p object.DatastorePath
+		p.FromString(disk.Backing.(types.BaseVirtualDeviceFileBackingInfo).GetVirtualDeviceFileBackingInfo().FileName)
+		ds := sim.findDatastore(p.Datastore)
+		flat := filepath.Join(ds.Info.GetDatastoreInfo().Url, strings.TrimSuffix(p.Path, ".vmdk")+"-flat.vmdk")
+		data := []byte("vcsim export")
+		if err = os.WriteFile(flat, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		lease, err := vm.Export(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dir := t.TempDir()
+		info := exportLease(ctx, t, lease, dir)
+
+		if len(info.Items) != 1 {
+			t.Fatalf("items=%d", len(info.Items))
+		}
+		if info.TotalDiskCapacityInKB != disk.CapacityInKB {
+			t.Errorf("capacity=%d", info.TotalDiskCapacityInKB)
+		}
+
+		f, err := os.Open(filepath.Join(dir, info.Items[0].Path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		raw, err := os.Create(filepath.Join(dir, "disk.raw"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer raw.Close()
+
+		capacity, err := vmdk.ReadStreamOptimized(f, raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if capacity != disk.CapacityInBytes {
+			t.Errorf("capacity=%d", capacity)
+		}
+
+		buf := make([]byte, len(data))
+		if _, err = raw.ReadAt(buf, 0); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf, data) {
+			t.Errorf("data=%q", buf)
+		}
+
+		var l mo.HttpNfcLease
+		err = vm.Properties(ctx, lease.Reference(), []string{"state"}, &l)
+		if err == nil {
+			t.Error("expected lease to be removed on Complete")
+		}
+	})
+}
+
+func TestExportProgress(t *testing.T) {
+	Test(func(ctx context.Context, c *vim25.Client) {
+		vm := Map.Any("VirtualMachine").(*VirtualMachine)
+		vm.Runtime.PowerState = types.VirtualMachinePowerStatePoweredOff
+
+		lease, err := object.NewVirtualMachine(c, vm.Self).Export(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = lease.Wait(ctx, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = lease.Progress(ctx, 50); err != nil {
+			t.Fatal(err)
+		}
+
+		var l mo.HttpNfcLease
+		err = object.NewCommon(c, lease.Reference()).Properties(ctx, lease.Reference(), []string{"transferProgress"}, &l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if l.TransferProgress != 50 {
+			t.Errorf("progress=%d", l.TransferProgress)
+		}
+
+		if err = lease.Progress(ctx, 101); err == nil {
+			t.Error("expected error")
+		}
+
+		if err = lease.Abort(ctx, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestExportVApp(t *testing.T) {
+	m := VPX()
+	m.App = 1
+
+	Test(func(ctx context.Context, c *vim25.Client) {
+		vapp := Map.Any("VirtualApp").(*VirtualApp)
+		if len(vapp.Vm) == 0 {
+			t.Fatal("no vApp VMs")
+		}
+
+		for _, ref := range vapp.Vm {
+			vm := Map.Get(ref).(*VirtualMachine)
+			vm.Runtime.PowerState = types.VirtualMachinePowerStatePoweredOff
+		}
+
+		res, err := methods.ExportVApp(ctx, c, &types.ExportVApp{This: vapp.Self})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		info := exportLease(ctx, t, nfc.NewLease(c, res.Returnval), t.TempDir())
+
+		if len(info.Items) != len(vapp.Vm) {
+			t.Errorf("items=%d", len(info.Items))
+		}
+
+		for _, item := range info.Items {
+			if !strings.Contains(item.Path, "-disk-") {
+				t.Errorf("path=%s", item.Path)
+			}
+		}
+	}, m)
+}
+
+func TestExportLeaseTimeout(t *testing.T) {
+	m := VPX()
+
+	err := m.Run(func(ctx context.Context, c *vim25.Client) error {
+		m.Clock.Freeze()
+
+		vm := Map.Any("VirtualMachine").(*VirtualMachine)
+		vm.Runtime.PowerState = types.VirtualMachinePowerStatePoweredOff
+
+		lease, err := object.NewVirtualMachine(c, vm.Self).Export(ctx)
+		if err != nil {
+			return err
+		}
+
+		info, err := lease.Wait(ctx, nil)
+		if err != nil {
+			return err
+		}
+		timeout := time.Duration(info.LeaseTimeout) * time.Second
+
+		state := func() types.HttpNfcLeaseState {
+			var l mo.HttpNfcLease
+			err := object.NewCommon(c, lease.Reference()).Properties(ctx, lease.Reference(), []string{"state"}, &l)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return l.State
+		}
+
+		// progress renews the lease
+		m.Clock.Advance(timeout - time.Second)
+		if err = lease.Progress(ctx, 50); err != nil {
+			return err
+		}
+		m.Clock.Advance(timeout - time.Second)
+		if s := state(); s != types.HttpNfcLeaseStateReady {
+			t.Errorf("state=%s", s)
+		}
+
+		m.Clock.Advance(time.Second)
+		for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+			if state() == types.HttpNfcLeaseStateError {
+				break
+			}
+		}
+		if s := state(); s != types.HttpNfcLeaseStateError {
+			t.Errorf("state=%s", s)
+		}
+
+		if err = lease.Progress(ctx, 60); err == nil {
+			t.Error("expected error")
+		}
+
+		return lease.Abort(ctx, nil)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -72,3 +72,37 @@ type InternalServiceInstanceContent struct {
 
 	NfcService types.ManagedObjectReference `xml:"nfcService"`
 }
+
+func init() {
+	types.Add("QueryVirtualDiskInfo_Task", reflect.TypeOf((*QueryVirtualDiskInfo_Task)(nil)).Elem())
+}
+
+type QueryVirtualDiskInfo_Task struct {
+	This           types.ManagedObjectReference  `xml:"_this"`
+	Name           string                        `xml:"name"`
+	Datacenter     *types.ManagedObjectReference `xml:"datacenter,omitempty"`
+	IncludeParents bool                          `xml:"includeParents"`
+}
+
+type QueryVirtualDiskInfo_TaskResponse struct {
+	Returnval types.ManagedObjectReference `xml:"returnval"`
+}
+
+type QueryVirtualDiskInfo_TaskBody struct {
+	Res    *QueryVirtualDiskInfo_TaskResponse `xml:"QueryVirtualDiskInfo_TaskResponse,omitempty"`
+	Fault_ *soap.Fault                        `xml:"http://schemas.xmlsoap.org/soap/envelope/ Fault,omitempty"`
+}
+
+func (b *QueryVirtualDiskInfo_TaskBody) Fault() *soap.Fault { return b.Fault_ }
+
+// ArrayOfVirtualDiskInfo is the QueryVirtualDiskInfo_Task result.
+// Note that it is not registered with types.Add, as the govmomi/object package registers its own type of the same name.
+type ArrayOfVirtualDiskInfo struct {
+	VirtualDiskInfo []VirtualDiskInfo `xml:"VirtualDiskInfo,omitempty"`
+}
+
+type VirtualDiskInfo struct {
+	Name     string `xml:"unit>name"`
+	DiskType string `xml:"diskType"`
+	Parent   string `xml:"parent,omitempty"`
+}
@@ -0,0 +1,288 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"github.com/zhengkes/govmomi/simulator/internal"
+	"github.com/zhengkes/govmomi/vim25/methods"
+	"github.com/zhengkes/govmomi/vim25/soap"
+	"github.com/zhengkes/govmomi/vim25/types"
+)
+
+// jsonSessionHeader is the header used by the vim25 JSON protocol to carry the session key
+const jsonSessionHeader = "vmware-api-session-id"
+
+// jsonPrefix returns the path prefix of the JSON protocol endpoint for the given SDK path and namespace
+func jsonPrefix(sdk string, namespace string) string {
+	return sdk + "/" + strings.TrimPrefix(namespace, "urn:") + "/"
+}
+
+// jsonRequest is a request parsed from the path of a JSON protocol endpoint:
+// {sdk}/{namespace}/{release}/{Type}/{moId}/{method or property}
+type jsonRequest struct {
+	sdk  string
+	this types.ManagedObjectReference
+	name string
+}
+
+func parseJSONRequest(u *url.URL) (*jsonRequest, error) {
+	p := strings.Split(strings.TrimSuffix(u.EscapedPath(), "/"), "/")
+	if len(p) < 6 {
+		return nil, fmt.Errorf("invalid path: %s", u.Path)
+	}
+
+	n := len(p) - 4
+	for i := n; i < len(p); i++ {
+		var err error
+		p[i], err = url.PathUnescape(p[i])
+		if err != nil || p[i] == "" {
+			return nil, fmt.Errorf("invalid path: %s", u.Path)
+		}
+	}
+
+	return &jsonRequest{
+		sdk:  strings.Join(p[:n-1], "/"),
+		this: types.ManagedObjectReference{Type: p[n+1], Value: p[n+2]},
+		name: p[n+3],
+	}, nil
+}
+
+// ServeJSON handler for the vim25 JSON protocol.
+// POST requests invoke the method of the given name, GET requests return the value of the given property.
+// Requests are dispatched to the same handlers as ServeSDK.
+func (s *Service) ServeJSON(w http.ResponseWriter, r *http.Request) {
+	req, err := parseJSONRequest(r.URL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	registry, ok := s.sdk[req.sdk]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	ctx := &Context{
+		req: r,
+		res: w,
+		svc: s,
+
+		Map:     registry,
+		Context: context.Background(),
+	}
+	ctx.Map.WithLock(ctx, s.sm, ctx.mapSession)
+
+	var res soap.HasFault
+
+	switch r.Method {
+	case http.MethodPost:
+		body, err := s.readAll(r.Body)
+		_ = r.Body.Close()
+		if err != nil {
+			log.Printf("error reading body: %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if Trace {
+			fmt.Fprintf(TraceFile, "Request: %s %s\n", r.URL.Path, string(body))
+		}
+
+		method, err := unmarshalJSONBody(ctx.Map.typeFunc, req, body)
+		if err != nil {
+			res = serverFault(err.Error())
+		} else {
+			res = s.call(ctx, method)
+		}
+	case http.MethodGet:
+		res = s.jsonProperty(ctx, req)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if _, ok := res.(*dropConnectionBody); ok && dropConnection(w) {
+		return
+	}
+
+	status := http.StatusOK
+	var val interface{}
+
+	if f := res.Fault(); f != nil {
+		status = http.StatusInternalServerError
+		val = f.Detail.Fault
+		if val == nil {
+			// JSON clients expect a typed fault
+			val = &types.SystemError{Reason: f.String}
+		}
+	} else if rval := jsonReturnValue(res); rval.IsValid() {
+		val = rval.Interface()
+	} else {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var out bytes.Buffer
+
+	err = types.NewJSONEncoder(&out).Encode(val)
+	if err == nil && status != http.StatusOK {
+		err = jsonFaultString(&out, res.Fault().String)
+	}
+	if err != nil {
+		log.Printf("error encoding %s response: %s", req.name, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if Trace {
+		fmt.Fprintf(TraceFile, "Response: %s\n", out.String())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(out.Bytes())
+}
+
+// unmarshalJSONBody decodes the JSON encoded parameters of the given method request.
+func unmarshalJSONBody(typeFunc func(string) (reflect.Type, bool), req *jsonRequest, data []byte) (*Method, error) {
+	rtype, ok := typeFunc(req.name)
+	if !ok {
+		return nil, fmt.Errorf("no vmomi type defined for '%s'", req.name)
+	}
+
+	val := reflect.New(rtype)
+
+	if len(bytes.TrimSpace(data)) != 0 {
+		if err := types.NewJSONDecoder(bytes.NewReader(data)).Decode(val.Interface()); err != nil {
+			return nil, fmt.Errorf("decoding %s: %s", req.name, err)
+		}
+	}
+
+	field := val.Elem().FieldByName("This")
+	if !field.IsValid() {
+		return nil, fmt.Errorf("'%s' is not a method", req.name)
+	}
+	field.Set(reflect.ValueOf(req.this))
+
+	return &Method{Name: req.name, This: req.this, Body: val.Interface()}, nil
+}
+
+// jsonReturnValue returns the Returnval field of the given method response body,
+// or an invalid Value if the method does not return a value.
+func jsonReturnValue(res soap.HasFault) reflect.Value {
+	rval := reflect.ValueOf(res)
+	if rval.Kind() == reflect.Ptr {
+		rval = rval.Elem()
+	}
+	if rval.Kind() != reflect.Struct {
+		return reflect.Value{}
+	}
+
+	rval = rval.FieldByName("Res")
+	if !rval.IsValid() || rval.IsNil() {
+		return reflect.Value{}
+	}
+
+	rval = rval.Elem().FieldByName("Returnval")
+	if !rval.IsValid() {
+		return reflect.Value{}
+	}
+
+	switch rval.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if rval.IsNil() {
+			return reflect.Value{}
+		}
+	}
+
+	return rval
+}
+
+// jsonFaultString adds the "faultstring" member to the JSON encoded fault in buf.
+func jsonFaultString(buf *bytes.Buffer, msg string) error {
+	var fault map[string]json.RawMessage
+	if err := json.Unmarshal(buf.Bytes(), &fault); err != nil {
+		return err
+	}
+
+	s, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	fault["faultstring"] = s
+
+	b, err := json.Marshal(fault)
+	if err != nil {
+		return err
+	}
+
+	buf.Reset()
+	_, err = buf.Write(b)
+	return err
+}
+
+// jsonProperty retrieves the given property via the PropertyCollector,
+// such that the same session and property path rules apply as with RetrievePropertiesEx.
+func (s *Service) jsonProperty(ctx *Context, req *jsonRequest) soap.HasFault {
+	method := &Method{
+		Name: "RetrievePropertiesEx",
+		This: ctx.Map.content().PropertyCollector,
+		Body: &types.RetrievePropertiesEx{
+			This: ctx.Map.content().PropertyCollector,
+			SpecSet: []types.PropertyFilterSpec{{
+				ObjectSet: []types.ObjectSpec{{Obj: req.this}},
+				PropSet:   []types.PropertySpec{{Type: req.this.Type, PathSet: []string{req.name}}},
+			}},
+		},
+	}
+
+	res := s.call(ctx, method)
+	if res.Fault() != nil {
+		return res
+	}
+
+	body := new(internal.FetchBody)
+
+	rr, ok := res.(*methods.RetrievePropertiesExBody)
+	if !ok || rr.Res == nil || rr.Res.Returnval == nil || len(rr.Res.Returnval.Objects) == 0 {
+		body.Fault_ = Fault("", &types.ManagedObjectNotFound{Obj: req.this})
+		return body
+	}
+
+	content := rr.Res.Returnval.Objects[0]
+	if len(content.MissingSet) != 0 {
+		fault := content.MissingSet[0].Fault
+		body.Fault_ = Fault(fault.LocalizedMessage, fault.Fault)
+		return body
+	}
+	for _, prop := range content.PropSet {
+		body.Res = &internal.FetchResponse{Returnval: prop.Val}
+	}
+
+	return body
+}
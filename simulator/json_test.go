@@ -0,0 +1,149 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/zhengkes/govmomi/find"
+	"github.com/zhengkes/govmomi/object"
+	"github.com/zhengkes/govmomi/session"
+	"github.com/zhengkes/govmomi/task"
+	"github.com/zhengkes/govmomi/vim25"
+	"github.com/zhengkes/govmomi/vim25/soap"
+	"github.com/zhengkes/govmomi/vim25/types"
+)
+
+func TestServeJSON(t *testing.T) {
+	Test(func(ctx context.Context, sc *vim25.Client) {
+		u := sc.URL()
+
+		soapClient := soap.NewClient(u, true)
+		soapClient.UseJSON(true)
+
+		// RetrieveServiceContent does not require a session
+		c, err := vim25.NewClient(ctx, soapClient)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.ServiceContent.About.InstanceUuid != sc.ServiceContent.About.InstanceUuid {
+			t.Errorf("about=%#v", c.ServiceContent.About)
+		}
+
+		vm, err := find.NewFinder(sc).VirtualMachine(ctx, "DC0_H0_VM0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		vm = object.NewVirtualMachine(c, vm.Reference())
+
+		_, err = vm.PowerOff(ctx)
+		if !isNotAuthenticated(err) {
+			t.Fatalf("err=%#v", err)
+		}
+
+		m := session.NewManager(c)
+		if err = m.Login(ctx, DefaultLogin); err != nil {
+			t.Fatal(err)
+		}
+
+		ptask, err := vm.PowerOff(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = ptask.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		ptask, err = vm.PowerOff(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ptask.Wait(ctx)
+		if terr, ok := err.(task.Error); !ok {
+			t.Errorf("err=%#v", err)
+		} else if _, ok := terr.Fault().(*types.InvalidPowerState); !ok {
+			t.Errorf("fault=%#v", terr.Fault())
+		}
+
+		// method faults are returned as JSON with status 500
+		missing := object.NewVirtualMachine(c, types.ManagedObjectReference{Type: "VirtualMachine", Value: "vm-missing"})
+		_, err = missing.PowerOn(ctx)
+		if !soap.IsSoapFault(err) {
+			t.Fatalf("err=%#v", err)
+		}
+		switch fault := soap.ToSoapFault(err).VimFault().(type) {
+		case types.ManagedObjectNotFound, *types.ManagedObjectNotFound:
+		default:
+			t.Errorf("fault=%#v", fault)
+		}
+
+		// property GET
+		us, err := m.UserSession(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		hc := &http.Client{Transport: soapClient.DefaultTransport()}
+
+		get := func(ref types.ManagedObjectReference, prop string, key string) (int, interface{}) {
+			url := fmt.Sprintf("%s/vim25/%s/%s/%s/%s", u.String(), c.Version, ref.Type, ref.Value, prop)
+			req, err := http.NewRequest(http.MethodGet, url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if key != "" {
+				req.Header.Set("vmware-api-session-id", key)
+			}
+			res, err := hc.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+
+			var val interface{}
+			if res.StatusCode != http.StatusNoContent {
+				if err = types.NewJSONDecoder(res.Body).Decode(&val); err != nil {
+					t.Fatal(err)
+				}
+			}
+			return res.StatusCode, val
+		}
+
+		status, val := get(vm.Reference(), "name", us.Key)
+		if status != http.StatusOK || val != "DC0_H0_VM0" {
+			t.Errorf("status=%d, val=%#v", status, val)
+		}
+
+		status, val = get(vm.Reference(), "runtime.powerState", us.Key)
+		if status != http.StatusOK || val != types.VirtualMachinePowerStatePoweredOff {
+			t.Errorf("status=%d, val=%#v", status, val)
+		}
+
+		status, val = get(vm.Reference(), "enoent", us.Key)
+		if status != http.StatusInternalServerError {
+			t.Errorf("status=%d, val=%#v", status, val)
+		}
+
+		status, val = get(vm.Reference(), "name", "")
+		if status != http.StatusInternalServerError {
+			t.Errorf("status=%d, val=%#v", status, val)
+		}
+	})
+}
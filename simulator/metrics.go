@@ -0,0 +1,213 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/zhengkes/govmomi/vim25/soap"
+)
+
+// metricBuckets are the upper bounds in seconds of the method duration histogram,
+// the same as the Prometheus client default buckets.
+var metricBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// methodMetrics are the call metrics of a single method.
+type methodMetrics struct {
+	calls    uint64
+	inflight int64
+	faults   map[string]uint64
+	buckets  []uint64
+	seconds  float64
+}
+
+// serviceMetrics records method calls when Service.Metrics is enabled.
+type serviceMetrics struct {
+	mu      sync.Mutex
+	methods map[string]*methodMetrics
+}
+
+func newServiceMetrics() *serviceMetrics {
+	return &serviceMetrics{methods: make(map[string]*methodMetrics)}
+}
+
+// method returns the metrics for the given method name, must be called with m.mu held.
+func (m *serviceMetrics) method(name string) *methodMetrics {
+	mm, ok := m.methods[name]
+	if !ok {
+		mm = &methodMetrics{
+			faults:  make(map[string]uint64),
+			buckets: make([]uint64, len(metricBuckets)),
+		}
+		m.methods[name] = mm
+	}
+	return mm
+}
+
+// start records the start of a method call.
+func (m *serviceMetrics) start(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.method(name).inflight++
+}
+
+// done records the completion of a method call, with the given duration and response.
+func (m *serviceMetrics) done(name string, d time.Duration, res soap.HasFault) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mm := m.method(name)
+	mm.inflight--
+	mm.calls++
+	mm.seconds += d.Seconds()
+	for i, le := range metricBuckets {
+		if d.Seconds() <= le {
+			mm.buckets[i]++
+		}
+	}
+
+	if res == nil {
+		return
+	}
+	if f := res.Fault(); f != nil {
+		kind := "ServerFault"
+		if detail := f.VimFault(); detail != nil {
+			kind = reflect.Indirect(reflect.ValueOf(detail)).Type().Name()
+		}
+		mm.faults[kind]++
+	}
+}
+
+// waiters returns the number of in-flight WaitForUpdates and WaitForUpdatesEx calls.
+func (m *serviceMetrics) waiters() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int64
+	for _, name := range []string{"WaitForUpdates", "WaitForUpdatesEx"} {
+		if mm, ok := m.methods[name]; ok {
+			n += mm.inflight
+		}
+	}
+	return n
+}
+
+// countByType returns the number of objects in the Registry of each type.
+func (r *Registry) countByType() map[string]int {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	count := make(map[string]int, len(r.kinds))
+	for kind, objs := range r.kinds {
+		count[kind] = len(objs)
+	}
+	return count
+}
+
+// sessionMetrics returns the number of active sessions and the number of PropertyFilters they have created.
+func (m *SessionManager) sessionMetrics() (int, int) {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+
+	filters := 0
+	for _, s := range m.sessions {
+		filters += len(s.Registry.AllReference("PropertyFilter"))
+	}
+	return len(m.sessions), filters
+}
+
+// sortedKeys returns the keys of the given map with string keys in sorted order.
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	for _, k := range reflect.ValueOf(m).MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ServeMetrics is the Prometheus metrics endpoint of the Server, registered as /metrics when Service.Metrics is enabled.
+// Metrics are written in the Prometheus text exposition format.
+func (s *Service) ServeMetrics(w http.ResponseWriter, r *http.Request) {
+	if s.metrics == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+
+	help := func(name, kind, text string) {
+		fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", name, text, name, kind)
+	}
+	quote := strconv.Quote
+
+	s.metrics.mu.Lock()
+	methods := sortedKeys(s.metrics.methods)
+
+	help("vcsim_method_calls_total", "counter", "Number of completed method calls.")
+	for _, name := range methods {
+		fmt.Fprintf(out, "vcsim_method_calls_total{method=%s} %d\n", quote(name), s.metrics.methods[name].calls)
+	}
+
+	help("vcsim_method_faults_total", "counter", "Number of method calls that returned a fault, by fault type.")
+	for _, name := range methods {
+		faults := s.metrics.methods[name].faults
+		for _, kind := range sortedKeys(faults) {
+			fmt.Fprintf(out, "vcsim_method_faults_total{method=%s,fault=%s} %d\n", quote(name), quote(kind), faults[kind])
+		}
+	}
+
+	help("vcsim_method_duration_seconds", "histogram", "Method call latency in seconds.")
+	for _, name := range methods {
+		mm := s.metrics.methods[name]
+		for i, le := range metricBuckets {
+			fmt.Fprintf(out, "vcsim_method_duration_seconds_bucket{method=%s,le=%q} %d\n",
+				quote(name), strconv.FormatFloat(le, 'g', -1, 64), mm.buckets[i])
+		}
+		fmt.Fprintf(out, "vcsim_method_duration_seconds_bucket{method=%s,le=\"+Inf\"} %d\n", quote(name), mm.calls)
+		fmt.Fprintf(out, "vcsim_method_duration_seconds_sum{method=%s} %g\n", quote(name), mm.seconds)
+		fmt.Fprintf(out, "vcsim_method_duration_seconds_count{method=%s} %d\n", quote(name), mm.calls)
+	}
+	s.metrics.mu.Unlock()
+
+	sessions, filters := s.sm.sessionMetrics()
+
+	help("vcsim_sessions", "gauge", "Number of active sessions.")
+	fmt.Fprintf(out, "vcsim_sessions %d\n", sessions)
+
+	help("vcsim_property_filters", "gauge", "Number of PropertyCollector filters created by active sessions.")
+	fmt.Fprintf(out, "vcsim_property_filters %d\n", filters)
+
+	help("vcsim_wait_for_updates_waiters", "gauge", "Number of in-flight WaitForUpdates and WaitForUpdatesEx calls.")
+	fmt.Fprintf(out, "vcsim_wait_for_updates_waiters %d\n", s.metrics.waiters())
+
+	help("vcsim_objects", "gauge", "Number of inventory objects by type.")
+	count := Map.countByType()
+	for _, kind := range sortedKeys(count) {
+		fmt.Fprintf(out, "vcsim_objects{type=%s} %d\n", quote(kind), count[kind])
+	}
+}
@@ -0,0 +1,132 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zhengkes/govmomi/object"
+	"github.com/zhengkes/govmomi/property"
+	"github.com/zhengkes/govmomi/vim25"
+	"github.com/zhengkes/govmomi/vim25/types"
+)
+
+func TestServeMetrics(t *testing.T) {
+	m := VPX()
+	defer m.Remove()
+
+	if err := m.Create(); err != nil {
+		t.Fatal(err)
+	}
+	m.Service.Metrics = true
+
+	err := m.Run(func(ctx context.Context, c *vim25.Client) error {
+		scrape := func() string {
+			u := c.URL()
+			u.User = nil
+			u.Path = "/metrics"
+			res, err := (&http.Client{Transport: c.DefaultTransport()}).Get(u.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			b, _ := io.ReadAll(res.Body)
+			return string(b)
+		}
+
+		vm := object.NewVirtualMachine(c, Map.Any("VirtualMachine").Reference())
+
+		// leave a WaitForUpdatesEx call waiting on a filter
+		pc, err := property.DefaultCollector(c).Create(ctx)
+		if err != nil {
+			return err
+		}
+		filter := new(property.WaitFilter).Add(vm.Reference(), "VirtualMachine", []string{"runtime.powerState"})
+		done := make(chan error)
+		go func() {
+			updates := 0
+			done <- property.WaitForUpdates(ctx, pc, filter, func([]types.ObjectUpdate) bool {
+				updates++
+				return updates == 2 // the first update is the current state, wait for the next
+			})
+		}()
+
+		var metrics string
+		for i := 0; i < 100; i++ {
+			metrics = scrape()
+			if strings.Contains(metrics, "vcsim_wait_for_updates_waiters 1\n") {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		// a method fault
+		_, err = object.NewVirtualMachine(c, types.ManagedObjectReference{Type: "VirtualMachine", Value: "enoent"}).PowerOn(ctx)
+		if err == nil {
+			t.Error("expected error")
+		}
+
+		tsk, err := vm.PowerOff(ctx)
+		if err != nil {
+			return err
+		}
+		if err = tsk.Wait(ctx); err != nil {
+			return err
+		}
+		if err = <-done; err != nil {
+			return err
+		}
+
+		want := []string{
+			"vcsim_wait_for_updates_waiters 1\n",
+			"vcsim_property_filters 1\n",
+			"vcsim_sessions 1\n",
+			`vcsim_method_calls_total{method="RetrieveServiceContent"} 1`,
+			fmt.Sprintf("vcsim_objects{type=\"VirtualMachine\"} %d\n", len(Map.All("VirtualMachine"))),
+		}
+		for _, s := range want {
+			if !strings.Contains(metrics, s) {
+				t.Errorf("missing %q", s)
+			}
+		}
+
+		metrics = scrape()
+		want = []string{
+			"vcsim_wait_for_updates_waiters 0\n",
+			`vcsim_method_faults_total{method="PowerOnVM_Task",fault="ManagedObjectNotFound"} 1`,
+			`vcsim_method_calls_total{method="PowerOffVM_Task"} 1`,
+			`vcsim_method_duration_seconds_bucket{method="PowerOffVM_Task",le="+Inf"} 1`,
+			`vcsim_method_duration_seconds_count{method="PowerOffVM_Task"} 1`,
+		}
+		for _, s := range want {
+			if !strings.Contains(metrics, s) {
+				t.Errorf("missing %q", s)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
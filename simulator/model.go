@@ -143,6 +143,31 @@ type Model struct {
 	// Delay configurations
 	DelayConfig DelayConfig `json:"-"`
 
+	// Fault injection rules, vcsim flags: -fault, -fault-file
+	FaultConfig FaultConfig `json:"-"`
+
+	// EnforcePrivileges checks that the session user has been granted the privilege required to invoke a method,
+	// via AuthorizationManager permissions on the method's entity or its ancestors.
+	// vcsim flag: -enforce-privileges
+	EnforcePrivileges bool `json:"-"`
+
+	// Workload is the name of the default Workload profile used to simulate the quickStats and performance counters
+	// of powered on VMs and their hosts, such as "idle", "steady" or "spiky". See Workloads.
+	// VMs can select a profile via the ExtraConfig key WorkloadOptionKey. The default of "" disables simulation.
+	// vcsim flag: -workload
+	Workload string `json:"-"`
+
+	// Customization configures the timing of guest customization applied to VMs when powered on,
+	// after CustomizeVM_Task or CloneVM_Task with a CustomizationSpec.
+	// vcsim flags: -customization-start, -customization-duration
+	Customization CustomizationTiming `json:"-"`
+
+	// Clock is the time source of the Service, used for task, event, session and performance sample times
+	// and to run scheduled tasks. Tests can Freeze, Advance or Set the Clock to make time based behavior deterministic.
+	// A Clock following the system time is created if nil.
+	// vcsim endpoint: /vcsim/admin/clock
+	Clock *Clock `json:"-"`
+
 	// total number of inventory objects, set by Count()
 	total int
 
@@ -232,6 +257,8 @@ func (*Model) fmtName(prefix string, num int) string {
 
 // kinds maps managed object types to their vcsim wrapper types
 var kinds = map[string]reflect.Type{
+	"Alarm":                           reflect.TypeOf((*Alarm)(nil)).Elem(),
+	"AlarmManager":                    reflect.TypeOf((*AlarmManager)(nil)).Elem(),
 	"AuthorizationManager":            reflect.TypeOf((*AuthorizationManager)(nil)).Elem(),
 	"ClusterComputeResource":          reflect.TypeOf((*ClusterComputeResource)(nil)).Elem(),
 	"CustomFieldsManager":             reflect.TypeOf((*CustomFieldsManager)(nil)).Elem(),
@@ -248,9 +275,15 @@ var kinds = map[string]reflect.Type{
 	"Folder":                          reflect.TypeOf((*Folder)(nil)).Elem(),
 	"GuestOperationsManager":          reflect.TypeOf((*GuestOperationsManager)(nil)).Elem(),
 	"HostDatastoreBrowser":            reflect.TypeOf((*HostDatastoreBrowser)(nil)).Elem(),
+	"HostDatastoreSystem":             reflect.TypeOf((*HostDatastoreSystem)(nil)).Elem(),
+	"HostDateTimeSystem":              reflect.TypeOf((*HostDateTimeSystem)(nil)).Elem(),
+	"HostFirewallSystem":              reflect.TypeOf((*HostFirewallSystem)(nil)).Elem(),
 	"HostLocalAccountManager":         reflect.TypeOf((*HostLocalAccountManager)(nil)).Elem(),
 	"HostNetworkSystem":               reflect.TypeOf((*HostNetworkSystem)(nil)).Elem(),
+	"HostServiceSystem":               reflect.TypeOf((*HostServiceSystem)(nil)).Elem(),
+	"HostStorageSystem":               reflect.TypeOf((*HostStorageSystem)(nil)).Elem(),
 	"HostSystem":                      reflect.TypeOf((*HostSystem)(nil)).Elem(),
+	"HostVirtualNicManager":           reflect.TypeOf((*HostVirtualNicManager)(nil)).Elem(),
 	"IpPoolManager":                   reflect.TypeOf((*IpPoolManager)(nil)).Elem(),
 	"LicenseManager":                  reflect.TypeOf((*LicenseManager)(nil)).Elem(),
 	"OptionManager":                   reflect.TypeOf((*OptionManager)(nil)).Elem(),
@@ -258,10 +291,13 @@ var kinds = map[string]reflect.Type{
 	"PerformanceManager":              reflect.TypeOf((*PerformanceManager)(nil)).Elem(),
 	"PropertyCollector":               reflect.TypeOf((*PropertyCollector)(nil)).Elem(),
 	"ResourcePool":                    reflect.TypeOf((*ResourcePool)(nil)).Elem(),
+	"ScheduledTask":                   reflect.TypeOf((*ScheduledTask)(nil)).Elem(),
+	"ScheduledTaskManager":            reflect.TypeOf((*ScheduledTaskManager)(nil)).Elem(),
 	"SearchIndex":                     reflect.TypeOf((*SearchIndex)(nil)).Elem(),
 	"SessionManager":                  reflect.TypeOf((*SessionManager)(nil)).Elem(),
 	"StoragePod":                      reflect.TypeOf((*StoragePod)(nil)).Elem(),
 	"StorageResourceManager":          reflect.TypeOf((*StorageResourceManager)(nil)).Elem(),
+	"Task":                            reflect.TypeOf((*Task)(nil)).Elem(),
 	"TaskManager":                     reflect.TypeOf((*TaskManager)(nil)).Elem(),
 	"TenantTenantManager":             reflect.TypeOf((*TenantManager)(nil)).Elem(),
 	"UserDirectory":                   reflect.TypeOf((*UserDirectory)(nil)).Elem(),
@@ -273,7 +309,19 @@ var kinds = map[string]reflect.Type{
 	"VmwareDistributedVirtualSwitch":  reflect.TypeOf((*DistributedVirtualSwitch)(nil)).Elem(),
 }
 
-func loadObject(content types.ObjectContent) (mo.Reference, error) {
+func loadObject(r *Registry, content types.ObjectContent) (mo.Reference, error) {
+	obj, err := decodeObject(r, content)
+	if err != nil {
+		return nil, err
+	}
+
+	initObject(r, obj)
+
+	return obj, nil
+}
+
+// decodeObject returns a new instance of the object in the given content, without adding it to the Registry.
+func decodeObject(r *Registry, content types.ObjectContent) (mo.Reference, error) {
 	var obj mo.Reference
 	id := content.Obj
 
@@ -292,7 +340,7 @@ func loadObject(content types.ObjectContent) (mo.Reference, error) {
 	} else {
 		if len(content.PropSet) == 0 {
 			// via NewServiceInstance()
-			Map.setReference(obj, id)
+			r.setReference(obj, id)
 		} else {
 			// via Model.Load()
 			dst := getManagedObject(obj).Addr().Interface().(mo.Reference)
@@ -301,15 +349,18 @@ func loadObject(content types.ObjectContent) (mo.Reference, error) {
 				return nil, err
 			}
 		}
-
-		if x, ok := obj.(interface{ init(*Registry) }); ok {
-			x.init(Map)
-		}
 	}
 
 	return obj, nil
 }
 
+// initObject calls the object's init method, if any, which may add related objects to the Registry.
+func initObject(r *Registry, obj mo.Reference) {
+	if x, ok := obj.(interface{ init(*Registry) }); ok {
+		x.init(r)
+	}
+}
+
 // resolveReferences attempts to resolve any object references that were not included via Load()
 // example: Load's dir only contains a single OpaqueNetwork, we need to create a Datacenter and
 // place the OpaqueNetwork in the Datacenter's network folder.
@@ -449,7 +500,7 @@ func (m *Model) Load(dir string) error {
 			ctx.Map = Map
 		}
 
-		obj, err := loadObject(content)
+		obj, err := loadObject(ctx.Map, content)
 		if err != nil {
 			return err
 		}
@@ -467,7 +518,27 @@ func (m *Model) Load(dir string) error {
 		return err
 	}
 
+	state, err := readCheckpointState(dir)
+	if err != nil {
+		return err
+	}
+
+	created, err := ctx.Map.restoreState(ctx, dir, state)
+	m.dirs = append(m.dirs, created...)
+	if err != nil {
+		return err
+	}
+
 	m.Service = New(s)
+	m.FaultConfig.init()
+	m.Service.faults = &m.FaultConfig
+	m.Service.privileges = &m.EnforcePrivileges
+	m.Service.customization = &m.Customization
+	m.useClock()
+	m.Service.workload, err = newWorkloadSimulation(m.Workload)
+	if err != nil {
+		return err
+	}
 
 	return m.resolveReferences(ctx)
 }
@@ -481,6 +552,14 @@ func (m *Model) Create() error {
 }
 
 func (m *Model) CreateInfrastructure(ctx *Context) error {
+	workload, err := newWorkloadSimulation(m.Workload)
+	if err != nil {
+		return err
+	}
+
+	// inventory is created using the Model Clock
+	m.useClock()
+
 	client := m.Service.client
 	root := object.NewRootFolder(client)
 
@@ -554,7 +633,8 @@ func (m *Model) CreateInfrastructure(ctx *Context) error {
 				var devices object.VirtualDeviceList
 
 				scsi, _ := devices.CreateSCSIController("pvscsi")
-				ide, _ := devices.CreateIDEController()
+				// the cdrom is attached to the first of the default IDE controllers created with the VM
+				ide := object.VirtualDeviceList(esx.VirtualDevice).PickController((*types.VirtualIDEController)(nil))
 				cdrom, _ := devices.CreateCdrom(ide.(*types.VirtualIDEController))
 				disk := devices.CreateDisk(scsi.(types.BaseVirtualController), ds,
 					config.Files.VmPathName+" "+path.Join(name, "disk1.vmdk"))
@@ -809,19 +889,40 @@ func (m *Model) CreateInfrastructure(ctx *Context) error {
 		}
 	}
 
+	// ResourcePool runtime info is updated once all VMs are created, rather than per VM
+	updatePools := deferResourcePoolRuntime(ctx)
 	for _, createVM := range vms {
 		err := createVM()
 		if err != nil {
+			updatePools()
 			return err
 		}
 	}
+	updatePools()
 
-	// Turn on delay AFTER we're done building the service content
+	// Turn on delay and fault injection AFTER we're done building the service content
 	m.Service.delay = &m.DelayConfig
+	m.FaultConfig.init()
+	m.Service.faults = &m.FaultConfig
+	m.Service.privileges = &m.EnforcePrivileges
+	m.Service.workload = workload
+	m.Service.customization = &m.Customization
 
 	return nil
 }
 
+// useClock sets the Clock of the Service, Registry and ScheduledTaskManager, creating the Model Clock if needed.
+func (m *Model) useClock() {
+	if m.Clock == nil {
+		m.Clock = new(Clock)
+	}
+	m.Service.Clock = m.Clock
+	Map.clock = m.Clock
+	if stm := Map.ScheduledTaskManager(); stm != nil {
+		stm.useClock(m.Clock)
+	}
+}
+
 func (m *Model) createTempDir(dc string, name string) (string, error) {
 	dir, err := os.MkdirTemp("", fmt.Sprintf("govcsim-%s-%s-", dc, name))
 	if err == nil {
@@ -855,13 +956,13 @@ func (m *Model) createLocalDatastore(dc string, name string, hosts []*object.Hos
 // Remove cleans up items created by the Model, such as local datastore directories
 func (m *Model) Remove() {
 	// Remove associated vm containers, if any
-	Map.m.Lock()
-	for _, obj := range Map.objects {
+	Map.m.RLock()
+	for _, obj := range Map.kinds["VirtualMachine"] {
 		if vm, ok := obj.(*VirtualMachine); ok {
 			vm.svm.remove(SpoofContext())
 		}
 	}
-	Map.m.Unlock()
+	Map.m.RUnlock()
 
 	for _, dir := range m.dirs {
 		_ = os.RemoveAll(dir)
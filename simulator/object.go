@@ -18,6 +18,7 @@ package simulator
 
 import (
 	"bytes"
+	"reflect"
 
 	"github.com/google/uuid"
 
@@ -64,8 +65,17 @@ func sha1UUID(s string) uuid.UUID {
 	return uuid.NewSHA1(uuid.NameSpaceOID, []byte(s))
 }
 
-// deepCopy uses xml encode/decode to copy src to dst
+// deepCopy copies src to dst, where dst is a pointer.
+// When dst points to the same type as src, the copy is made via reflection,
+// otherwise xml encode/decode is used to copy between the types.
 func deepCopy(src, dst interface{}) {
+	sval := reflect.Indirect(reflect.ValueOf(src))
+	dval := reflect.ValueOf(dst)
+	if dval.Kind() == reflect.Ptr && !dval.IsNil() && sval.IsValid() && sval.Type() == dval.Elem().Type() {
+		dval.Elem().Set(copyValue(sval))
+		return
+	}
+
 	b, err := xml.Marshal(src)
 	if err != nil {
 		panic(err)
@@ -78,3 +88,50 @@ func deepCopy(src, dst interface{}) {
 		panic(err)
 	}
 }
+
+// copyValue returns a deep copy of the given value
+func copyValue(src reflect.Value) reflect.Value {
+	dst := reflect.New(src.Type()).Elem()
+
+	switch src.Kind() {
+	case reflect.Ptr:
+		if !src.IsNil() {
+			dst.Set(reflect.New(src.Type().Elem()))
+			dst.Elem().Set(copyValue(src.Elem()))
+		}
+	case reflect.Interface:
+		if !src.IsNil() {
+			dst.Set(copyValue(src.Elem()))
+		}
+	case reflect.Struct:
+		dst.Set(src) // unexported fields, such as those of time.Time, are copied as-is
+		for i := 0; i < src.NumField(); i++ {
+			if f := dst.Field(i); f.CanSet() {
+				f.Set(copyValue(src.Field(i)))
+			}
+		}
+	case reflect.Slice:
+		if !src.IsNil() {
+			dst.Set(reflect.MakeSlice(src.Type(), src.Len(), src.Len()))
+			for i := 0; i < src.Len(); i++ {
+				dst.Index(i).Set(copyValue(src.Index(i)))
+			}
+		}
+	case reflect.Array:
+		for i := 0; i < src.Len(); i++ {
+			dst.Index(i).Set(copyValue(src.Index(i)))
+		}
+	case reflect.Map:
+		if !src.IsNil() {
+			dst.Set(reflect.MakeMapWithSize(src.Type(), src.Len()))
+			iter := src.MapRange()
+			for iter.Next() {
+				dst.SetMapIndex(iter.Key(), copyValue(iter.Value()))
+			}
+		}
+	default:
+		dst.Set(src)
+	}
+
+	return dst
+}
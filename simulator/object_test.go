@@ -18,7 +18,9 @@ package simulator
 
 import (
 	"context"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/zhengkes/govmomi"
 	"github.com/zhengkes/govmomi/object"
@@ -201,6 +203,37 @@ func TestObjectCustomFields(t *testing.T) {
 	}
 }
 
+func TestDeepCopy(t *testing.T) {
+	now := time.Now()
+	src := types.HostConfigInfo{
+		Option:       []types.BaseOptionValue{&types.OptionValue{Key: "a", Value: "b"}},
+		DateTimeInfo: &types.HostDateTimeInfo{NtpConfig: &types.HostNtpConfig{Server: []string{"pool.ntp.org"}}},
+		Certificate:  []uint8("cert"),
+	}
+	src.Host = types.ManagedObjectReference{Type: "HostSystem", Value: "host-1"}
+	src.SystemResources = &types.HostSystemResourceInfo{Key: "root"}
+	event := types.Event{CreatedTime: now, FullFormattedMessage: "copy"}
+
+	var dst types.HostConfigInfo
+	deepCopy(&src, &dst)
+
+	if !reflect.DeepEqual(src, dst) {
+		t.Fatalf("%#v != %#v", src, dst)
+	}
+
+	dst.Option[0].GetOptionValue().Value = "c"
+	dst.DateTimeInfo.NtpConfig.Server[0] = "time.example.com"
+	if src.Option[0].GetOptionValue().Value != "b" || src.DateTimeInfo.NtpConfig.Server[0] != "pool.ntp.org" {
+		t.Error("dst shares src values")
+	}
+
+	var devent types.Event
+	deepCopy(event, &devent)
+	if !devent.CreatedTime.Equal(now) || devent.FullFormattedMessage != "copy" {
+		t.Errorf("%#v", devent)
+	}
+}
+
 func BenchmarkDeepCopy(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		config := new(types.HostConfigInfo)
@@ -186,12 +186,12 @@ func (p *PerformanceManager) QueryPerf(ctx *Context, req *types.QueryPerf) soap.
 		}
 		var start, end time.Time
 		if qs.StartTime == nil {
-			start = time.Now().Add(time.Duration(-365*24) * time.Hour) // Assume we have data for a year
+			start = ctx.Now().Add(time.Duration(-365*24) * time.Hour) // Assume we have data for a year
 		} else {
 			start = *qs.StartTime
 		}
 		if qs.EndTime == nil {
-			end = time.Now()
+			end = ctx.Now()
 		} else {
 			end = *qs.EndTime
 		}
@@ -223,12 +223,16 @@ func (p *PerformanceManager) QueryPerf(ctx *Context, req *types.QueryPerf) soap.
 			series[j].Id = mid
 			points := metricData[mid.CounterId]
 			offset := int64(start.Unix()) / int64(interval)
+			simulated := p.simulated(ctx, qs.Entity, mid)
 
 			for tick := int32(0); tick < n; tick++ {
 				var p int64
 
-				// Use sample data if we have it. Otherwise, just send 0.
-				if len(points) > 0 {
+				// Use the workload simulation if enabled, consistent with quickStats.
+				// Otherwise use sample data if we have it, or just send 0.
+				if v, ok := simulated(metrics.SampleInfo[tick].Timestamp); ok {
+					p = v
+				} else if len(points) > 0 {
 					p = points[(offset+int64(tick))%int64(len(points))]
 					scale := p / 5
 					if scale > 0 {
@@ -269,6 +273,21 @@ func (p *PerformanceManager) QueryPerf(ctx *Context, req *types.QueryPerf) soap.
 	return body
 }
 
+// simulated returns a function providing the value of the given metric at a given time,
+// when the entity's usage is simulated by a Workload.
+func (p *PerformanceManager) simulated(ctx *Context, entity types.ManagedObjectReference, id types.PerfMetricId) func(time.Time) (int64, bool) {
+	info, ok := p.perfCounterIndex[id.CounterId]
+	if !ok || id.Instance != "" || ctx.svc == nil || ctx.svc.workload == nil {
+		return func(time.Time) (int64, bool) { return 0, false }
+	}
+
+	name := info.GroupInfo.GetElementDescription().Key + "." + info.NameInfo.GetElementDescription().Key
+
+	return func(now time.Time) (int64, bool) {
+		return ctx.svc.workload.counter(ctx, entity, name, now)
+	}
+}
+
 // sampleInfoCSV converts the SampleInfo field to a CSV string
 func sampleInfoCSV(m *types.PerfEntityMetric) string {
 	values := make([]string, len(m.SampleInfo)*2)
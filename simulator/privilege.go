@@ -0,0 +1,364 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"reflect"
+
+	"github.com/zhengkes/govmomi/vim25/mo"
+	"github.com/zhengkes/govmomi/vim25/soap"
+	"github.com/zhengkes/govmomi/vim25/types"
+)
+
+// methodPrivileges maps a method name to the privilege required to invoke it, when Model.EnforcePrivileges is enabled.
+// Keys of the form "Type.Method" take precedence, for methods such as Destroy_Task where the privilege depends on the type.
+// Methods that are not listed can be invoked by any session, see TestMethodPrivileges for the list of such methods.
+var methodPrivileges = map[string]string{
+	// VirtualMachine
+	"PowerOnVM_Task":               "VirtualMachine.Interact.PowerOn",
+	"PowerOffVM_Task":              "VirtualMachine.Interact.PowerOff",
+	"ResetVM_Task":                 "VirtualMachine.Interact.Reset",
+	"SuspendVM_Task":               "VirtualMachine.Interact.Suspend",
+	"ShutdownGuest":                "VirtualMachine.Interact.PowerOff",
+	"RebootGuest":                  "VirtualMachine.Interact.Reset",
+	"StandbyGuest":                 "VirtualMachine.Interact.Suspend",
+	"AcquireTicket":                "VirtualMachine.Interact.ConsoleInteract",
+	"AcquireMksTicket":             "VirtualMachine.Interact.ConsoleInteract",
+	"ReconfigVM_Task":              "VirtualMachine.Config.Settings",
+	"UpgradeVM_Task":               "VirtualMachine.Config.UpgradeVirtualHardware",
+	"CloneVM_Task":                 "VirtualMachine.Provisioning.Clone",
+	"InstantClone_Task":            "VirtualMachine.Provisioning.Clone",
+	"CustomizeVM_Task":             "VirtualMachine.Provisioning.Customize",
+	"MarkAsTemplate":               "VirtualMachine.Provisioning.MarkAsTemplate",
+	"MarkAsVirtualMachine":         "VirtualMachine.Provisioning.MarkAsVM",
+	"ExportVm":                     "VirtualMachine.Provisioning.GetVmFiles",
+	"RelocateVM_Task":              "Resource.ColdMigrate",
+	"MigrateVM_Task":               "Resource.HotMigrate",
+	"UnregisterVM":                 "VirtualMachine.Inventory.Unregister",
+	"CreateSnapshot_Task":          "VirtualMachine.State.CreateSnapshot",
+	"CreateSnapshotEx_Task":        "VirtualMachine.State.CreateSnapshot",
+	"RemoveSnapshot_Task":          "VirtualMachine.State.RemoveSnapshot",
+	"RemoveAllSnapshots_Task":      "VirtualMachine.State.RemoveSnapshot",
+	"RevertToSnapshot_Task":        "VirtualMachine.State.RevertToSnapshot",
+	"RevertToCurrentSnapshot_Task": "VirtualMachine.State.RevertToSnapshot",
+	"RenameSnapshot":               "VirtualMachine.State.RenameSnapshot",
+	"QueryChangedDiskAreas":        "VirtualMachine.Provisioning.DiskRandomRead",
+	"ConsolidateVMDisks_Task":      "VirtualMachine.State.RemoveSnapshot",
+
+	// Guest operations, checked on the request's vm
+	"StartProgramInGuest":             "VirtualMachine.GuestOperations.Execute",
+	"TerminateProcessInGuest":         "VirtualMachine.GuestOperations.Execute",
+	"ListProcessesInGuest":            "VirtualMachine.GuestOperations.Query",
+	"ReadEnvironmentVariableInGuest":  "VirtualMachine.GuestOperations.Query",
+	"ListFilesInGuest":                "VirtualMachine.GuestOperations.Query",
+	"InitiateFileTransferFromGuest":   "VirtualMachine.GuestOperations.Query",
+	"InitiateFileTransferToGuest":     "VirtualMachine.GuestOperations.Modify",
+	"MakeDirectoryInGuest":            "VirtualMachine.GuestOperations.Modify",
+	"DeleteDirectoryInGuest":          "VirtualMachine.GuestOperations.Modify",
+	"DeleteFileInGuest":               "VirtualMachine.GuestOperations.Modify",
+	"MoveDirectoryInGuest":            "VirtualMachine.GuestOperations.Modify",
+	"MoveFileInGuest":                 "VirtualMachine.GuestOperations.Modify",
+	"CreateTemporaryDirectoryInGuest": "VirtualMachine.GuestOperations.Modify",
+	"CreateTemporaryFileInGuest":      "VirtualMachine.GuestOperations.Modify",
+	"ChangeFileAttributesInGuest":     "VirtualMachine.GuestOperations.Modify",
+
+	// Folder
+	"CreateFolder":                     "Folder.Create",
+	"CreateDatacenter":                 "Datacenter.Create",
+	"CreateVM_Task":                    "VirtualMachine.Inventory.Create",
+	"RegisterVM_Task":                  "VirtualMachine.Inventory.Register",
+	"CreateClusterEx":                  "Host.Inventory.CreateCluster",
+	"CreateCluster":                    "Host.Inventory.CreateCluster",
+	"AddStandaloneHost_Task":           "Host.Inventory.AddStandaloneHost",
+	"CreateDVS_Task":                   "DVSwitch.Create",
+	"CreateStoragePod":                 "Folder.Create",
+	"Folder.MoveIntoFolder_Task":       "Folder.Move",
+	"Folder.Destroy_Task":              "Folder.Delete",
+	"Folder.Rename_Task":               "Folder.Rename",
+	"Folder.UnregisterAndDestroy_Task": "Folder.Delete",
+	"StoragePod.MoveIntoFolder_Task":   "Datastore.Move",
+	"PlaceVmsXCluster":                 "System.View",
+	"PowerOnMultiVM_Task":              "VirtualMachine.Interact.PowerOn",
+
+	// Entity specific Destroy_Task and Rename_Task
+	"VirtualMachine.Destroy_Task":                 "VirtualMachine.Inventory.Delete",
+	"VirtualMachine.Rename_Task":                  "VirtualMachine.Config.Rename",
+	"Datacenter.Destroy_Task":                     "Datacenter.Delete",
+	"Datacenter.Rename_Task":                      "Datacenter.Rename",
+	"ClusterComputeResource.Destroy_Task":         "Host.Inventory.DeleteCluster",
+	"ClusterComputeResource.Rename_Task":          "Host.Inventory.RenameCluster",
+	"ComputeResource.Destroy_Task":                "Host.Inventory.RemoveHostFromCluster",
+	"HostSystem.Destroy_Task":                     "Host.Inventory.RemoveHostFromCluster",
+	"ResourcePool.Destroy_Task":                   "Resource.DeletePool",
+	"ResourcePool.Rename_Task":                    "Resource.RenamePool",
+	"VirtualApp.Destroy_Task":                     "VApp.Delete",
+	"VirtualApp.Rename_Task":                      "VApp.Rename",
+	"Datastore.Destroy_Task":                      "Datastore.Delete",
+	"Datastore.RenameDatastore":                   "Datastore.Rename",
+	"Network.Destroy_Task":                        "Network.Delete",
+	"DistributedVirtualSwitch.Destroy_Task":       "DVSwitch.Delete",
+	"VmwareDistributedVirtualSwitch.Destroy_Task": "DVSwitch.Delete",
+	"DistributedVirtualPortgroup.Destroy_Task":    "DVPortgroup.Delete",
+	"DistributedVirtualPortgroup.Rename_Task":     "DVPortgroup.Modify",
+	"ClusterComputeResource.MoveInto_Task":        "Host.Inventory.MoveHost",
+
+	// Compute
+	"AddHost_Task":                      "Host.Inventory.AddHostToCluster",
+	"ReconfigureComputeResource_Task":   "Host.Inventory.EditCluster",
+	"RefreshRecommendation":             "Host.Inventory.EditCluster",
+	"ApplyRecommendation":               "Resource.ApplyRecommendation",
+	"EnterMaintenanceMode_Task":         "Host.Config.Maintenance",
+	"ExitMaintenanceMode_Task":          "Host.Config.Maintenance",
+	"ClusterEnterMaintenanceMode":       "Host.Config.Maintenance",
+	"RecommendHostsForVm":               "System.View",
+	"PlaceVm":                           "System.View",
+	"DisconnectHost_Task":               "Host.Config.Connection",
+	"ReconnectHost_Task":                "Host.Config.Connection",
+	"CreateResourcePool":                "Resource.CreatePool",
+	"ResourcePool.UpdateConfig":         "Resource.EditPool",
+	"ResourcePool.MoveIntoResourcePool": "Resource.MovePool",
+	"CreateVApp":                        "VApp.Create",
+	"PowerOnVApp_Task":                  "VApp.PowerOn",
+	"PowerOffVApp_Task":                 "VApp.PowerOff",
+	"SuspendVApp_Task":                  "VApp.Suspend",
+	"CloneVApp_Task":                    "VApp.Clone",
+	"CreateChildVM_Task":                "VirtualMachine.Inventory.Create",
+	"ExportVApp":                        "VApp.Export",
+	"ImportVApp":                        "VApp.Import",
+	"DestroyChildren":                   "Resource.DeletePool",
+	"ConfigureStorageDrsForPod_Task":    "StoragePod.Config",
+
+	// Host configuration, checked on the host of the manager the methods are invoked on
+	"StartService":         "Host.Config.NetService",
+	"StopService":          "Host.Config.NetService",
+	"RestartService":       "Host.Config.NetService",
+	"UpdateServicePolicy":  "Host.Config.NetService",
+	"EnableRuleset":        "Host.Config.NetService",
+	"DisableRuleset":       "Host.Config.NetService",
+	"UpdateDateTime":       "Host.Config.DateTime",
+	"UpdateDateTimeConfig": "Host.Config.DateTime",
+	"AddVirtualSwitch":     "Host.Config.Network",
+	"RemoveVirtualSwitch":  "Host.Config.Network",
+	"AddPortGroup":         "Host.Config.Network",
+	"RemovePortGroup":      "Host.Config.Network",
+	"UpdateNetworkConfig":  "Host.Config.Network",
+	"CreateLocalDatastore": "Host.Config.Storage",
+	"CreateNasDatastore":   "Host.Config.Storage",
+	"RefreshStorageSystem": "Host.Config.Storage",
+	"RescanAllHba":         "Host.Config.Storage",
+	"RescanVmfs":           "Host.Config.Storage",
+	"CreateUser":           "Host.Local.ManageUserGroups",
+	"UpdateUser":           "Host.Local.ManageUserGroups",
+	"RemoveUser":           "Host.Local.ManageUserGroups",
+
+	// Datastore files, virtual disks and first class disks, checked on the datastore of the request
+	"CopyDatastoreFile_Task":            "Datastore.FileManagement",
+	"MoveDatastoreFile_Task":            "Datastore.FileManagement",
+	"DeleteDatastoreFile_Task":          "Datastore.FileManagement",
+	"MakeDirectory":                     "Datastore.FileManagement",
+	"SearchDatastore_Task":              "Datastore.Browse",
+	"SearchDatastoreSubFolders_Task":    "Datastore.Browse",
+	"CreateVirtualDisk_Task":            "Datastore.FileManagement",
+	"CopyVirtualDisk_Task":              "Datastore.FileManagement",
+	"MoveVirtualDisk_Task":              "Datastore.FileManagement",
+	"DeleteVirtualDisk_Task":            "Datastore.FileManagement",
+	"ExtendVirtualDisk_Task":            "Datastore.FileManagement",
+	"InflateVirtualDisk_Task":           "Datastore.FileManagement",
+	"ShrinkVirtualDisk_Task":            "Datastore.FileManagement",
+	"ZeroFillVirtualDisk_Task":          "Datastore.FileManagement",
+	"EagerZeroVirtualDisk_Task":         "Datastore.FileManagement",
+	"SetVirtualDiskUuid":                "Datastore.FileManagement",
+	"CreateDisk_Task":                   "Datastore.FileManagement",
+	"RegisterDisk":                      "Datastore.FileManagement",
+	"ExtendDisk_Task":                   "Datastore.FileManagement",
+	"DeleteVStorageObject_Task":         "Datastore.FileManagement",
+	"VStorageObjectCreateSnapshot_Task": "Datastore.FileManagement",
+	"DeleteSnapshot_Task":               "Datastore.FileManagement",
+	"ReconcileDatastoreInventory_Task":  "Datastore.FileManagement",
+	"AttachTagToVStorageObject":         "Datastore.FileManagement",
+	"DetachTagFromVStorageObject":       "Datastore.FileManagement",
+
+	// Network
+	"AddDVPortgroup_Task":         "DVPortgroup.Create",
+	"ReconfigureDvs_Task":         "DVSwitch.Modify",
+	"ReconfigureDVPortgroup_Task": "DVPortgroup.Modify",
+
+	// Authorization, checked on the request's entity or the root folder
+	"SetEntityPermissions":    "Authorization.ModifyPermissions",
+	"RemoveEntityPermission":  "Authorization.ModifyPermissions",
+	"AddAuthorizationRole":    "Authorization.ModifyRoles",
+	"UpdateAuthorizationRole": "Authorization.ModifyRoles",
+	"RemoveAuthorizationRole": "Authorization.ModifyRoles",
+
+	// Alarms and scheduled tasks, checked on the request's entity
+	"CreateAlarm":              "Alarm.Create",
+	"RemoveAlarm":              "Alarm.Delete",
+	"ReconfigureAlarm":         "Alarm.Edit",
+	"AcknowledgeAlarm":         "Alarm.Acknowledge",
+	"SetAlarmStatus":           "Alarm.SetStatus",
+	"CreateScheduledTask":      "ScheduledTask.Create",
+	"ReconfigureScheduledTask": "ScheduledTask.Edit",
+	"RemoveScheduledTask":      "ScheduledTask.Delete",
+	"RunScheduledTask":         "ScheduledTask.Run",
+	"ClearTriggeredAlarms":     "Alarm.SetStatus",
+	"EnableAlarmActions":       "Alarm.DisableActions",
+
+	// Global
+	"SetField":             "Global.SetCustomField",
+	"SetCustomValue":       "Global.SetCustomField",
+	"AddCustomFieldDef":    "Global.ManageCustomFields",
+	"RemoveCustomFieldDef": "Global.ManageCustomFields",
+	"RenameCustomFieldDef": "Global.ManageCustomFields",
+	"CancelTask":           "Global.CancelTask",
+	"LogUserEvent":         "Global.LogEvent",
+	"PostEvent":            "Global.LogEvent",
+	"TerminateSession":     "Sessions.TerminateSession",
+	"RegisterExtension":    "Extension.Register",
+	"UnregisterExtension":  "Extension.Unregister",
+	"UpdateExtension":      "Extension.Update",
+
+	// Global settings, checked on the root folder
+	"SetExtensionCertificate":       "Extension.Update",
+	"CreateCustomizationSpec":       "VirtualMachine.Provisioning.ModifyCustSpecs",
+	"OverwriteCustomizationSpec":    "VirtualMachine.Provisioning.ModifyCustSpecs",
+	"GetCustomizationSpec":          "VirtualMachine.Provisioning.ReadCustSpecs",
+	"AddLicense":                    "Global.Licenses",
+	"RemoveLicense":                 "Global.Licenses",
+	"UpdateLicenseLabel":            "Global.Licenses",
+	"UpdateOptions":                 "Global.Settings",
+	"MarkServiceProviderEntities":   "Global.Settings",
+	"UnmarkServiceProviderEntities": "Global.Settings",
+	"CreateIpPool":                  "Datacenter.IpPoolConfig",
+	"UpdateIpPool":                  "Datacenter.IpPoolConfig",
+	"DestroyIpPool":                 "Datacenter.IpPoolConfig",
+	"AllocateIpv4Address":           "Datacenter.IpPoolReleaseIp",
+	"AllocateIpv6Address":           "Datacenter.IpPoolReleaseIp",
+	"ReleaseIpAllocation":           "Datacenter.IpPoolReleaseIp",
+	"QueryIPAllocations":            "Datacenter.IpPoolQueryAllocations",
+	"CreateTask":                    "Task.Create",
+	"SetTaskState":                  "Task.Update",
+	"SetTaskDescription":            "Task.Update",
+	"UpdateProgress":                "Task.Update",
+}
+
+// methodPrivilege returns the privilege required to invoke the given method, if any.
+func methodPrivilege(method *Method) (string, bool) {
+	if id, ok := methodPrivileges[method.This.Type+"."+method.Name]; ok {
+		return id, true
+	}
+	id, ok := methodPrivileges[method.Name]
+	return id, ok
+}
+
+// privilegeEntity returns the entity on which the method's privilege is checked:
+// the method's object if it is an entity, the entity, vm or datastore specified by the request,
+// the vm of a snapshot, the host of a host manager, the datastore of a file manager or virtual disk manager
+// request's path, the datastore searched by a datastore browser, or the root folder for all other objects.
+func privilegeEntity(ctx *Context, obj mo.Reference, method *Method) types.ManagedObjectReference {
+	if _, ok := obj.(mo.Entity); ok {
+		return method.This
+	}
+
+	field := func(name string) interface{} {
+		req := reflect.ValueOf(method.Body)
+		if req.Kind() != reflect.Ptr || req.Elem().Kind() != reflect.Struct {
+			return nil
+		}
+		f := req.Elem().FieldByName(name)
+		if !f.IsValid() {
+			return nil
+		}
+		return f.Interface()
+	}
+
+	for _, name := range []string{"Entity", "Vm", "Datastore"} {
+		if ref, ok := field(name).(types.ManagedObjectReference); ok && ref.Value != "" {
+			return ref
+		}
+	}
+
+	switch obj := obj.(type) {
+	case *VirtualMachineSnapshot:
+		return obj.Vm
+	case *FileManager, *VirtualDiskManager:
+		for _, prefix := range []string{"", "Source"} {
+			name, _ := field(prefix + "Name").(string)
+			dc, _ := field(prefix + "Datacenter").(*types.ManagedObjectReference)
+			if name == "" {
+				continue
+			}
+			if ds, _, fault := ctx.Map.FileManager().resolveDatastore(dc, name); fault == nil {
+				return ds.Self
+			}
+		}
+	case *HostDatastoreBrowser:
+		name, _ := field("DatastorePath").(string)
+		if p, fault := parseDatastorePath(name); fault == nil {
+			for _, ref := range obj.Datastore {
+				if ds, ok := ctx.Map.Get(ref).(*Datastore); ok && ds.Name == p.Datastore {
+					return ref
+				}
+			}
+		}
+	default:
+		// host managers such as HostServiceSystem refer to their HostSystem
+		if v := reflect.ValueOf(obj); v.Kind() == reflect.Ptr && v.Elem().Kind() == reflect.Struct {
+			if f := v.Elem().FieldByName("Host"); f.IsValid() {
+				if host, ok := f.Interface().(*mo.HostSystem); ok && host != nil {
+					return host.Self
+				}
+			}
+		}
+	}
+
+	return ctx.Map.content().RootFolder
+}
+
+// checkPrivilege returns a NoPermission fault if the session user does not have the privilege required to invoke the method.
+func (s *Service) checkPrivilege(ctx *Context, obj mo.Reference, method *Method) soap.HasFault {
+	if s.privileges == nil || !*s.privileges || ctx.Session == nil {
+		return nil
+	}
+
+	id, ok := methodPrivilege(method)
+	if !ok {
+		return nil
+	}
+
+	m := ctx.Map.AuthorizationManager()
+	if m == nil {
+		return nil
+	}
+
+	entity := privilegeEntity(ctx, obj, method)
+	granted := false
+
+	ctx.WithLock(m, func() {
+		granted = m.hasPrivilege(ctx, ctx.Session.UserName, entity, id)
+	})
+
+	if granted {
+		return nil
+	}
+
+	fault := &types.NoPermission{
+		Object:      &entity,
+		PrivilegeId: id,
+	}
+
+	return &serverFaultBody{Reason: Fault("Permission to perform this operation was denied.", fault)}
+}
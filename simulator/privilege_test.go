@@ -0,0 +1,354 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"context"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zhengkes/govmomi/find"
+	"github.com/zhengkes/govmomi/object"
+	"github.com/zhengkes/govmomi/session"
+	"github.com/zhengkes/govmomi/simulator/esx"
+	"github.com/zhengkes/govmomi/vim25"
+	"github.com/zhengkes/govmomi/vim25/soap"
+	"github.com/zhengkes/govmomi/vim25/types"
+)
+
+func TestMethodPrivileges(t *testing.T) {
+	admin := object.AuthorizationRoleList(esx.RoleList).ByName("Admin")
+	privileges := make(map[string]bool, len(admin.Privilege))
+	for _, id := range admin.Privilege {
+		privileges[id] = true
+	}
+
+	for method, id := range methodPrivileges {
+		if !privileges[id] {
+			t.Errorf("%s: unknown privilege %s", method, id)
+		}
+	}
+}
+
+// methodNoPrivilege are the methods that can be invoked by any session, as they do not modify state.
+var methodNoPrivilege = []string{
+	"AcquireCloneTicket", "AcquireGenericServiceTicket", "AreAlarmActionsEnabled", "CancelWaitForUpdates",
+	"CloneSession", "ContinueRetrievePropertiesEx", "CreateCollectorForEvents", "CreateCollectorForTasks",
+	"CreateContainerView", "CreateFilter", "CreateImportSpec", "CreateListView", "CreatePropertyCollector",
+	"DestroyPropertyCollector", "DoesCustomizationSpecExist", "DVSManagerLookupDvPortGroup", "Fetch",
+	"FetchDVPorts", "FetchUserPrivilegeOnEntities", "FindAllByDnsName", "FindAllByIp", "FindByDatastorePath",
+	"FindByDnsName", "FindByInventoryPath", "FindByIp", "FindByUuid", "FindChild", "FindExtension", "GetAlarm",
+	"GetAlarmState", "HasPrivilegeOnEntities", "HasPrivilegeOnEntity", "HasUserPrivilegeOnEntities",
+	"ListTagsAttachedToVStorageObject", "ListVStorageObject", "ListVStorageObjectsAttachedToTag", "Login",
+	"LoginByToken", "LoginExtensionByCertificate", "Logout", "QueryAvailablePerfMetric", "QueryAvailableTimeZones",
+	"QueryConfigOption", "QueryConfigOptionDescriptor", "QueryConfigOptionEx", "QueryConfigTarget", "QueryDateTime",
+	"QueryEvents", "QueryIpPools", "QueryNetConfig", "QueryNetworkHint", "QueryOptions", "QueryPerf",
+	"QueryPerfCounter", "QueryPerfProviderSummary", "QueryTargetCapabilities", "QueryVirtualDiskInfo_Task",
+	"QueryVirtualDiskUuid", "RecommendDatastores", "RefreshDatastore", "RefreshDateTimeSystem", "RefreshServices",
+	"RefreshStorageInfo", "Reload", "RetrieveAllPermissions", "RetrieveEntityPermissions", "RetrieveEntityScheduledTask",
+	"RetrieveProperties", "RetrievePropertiesEx", "RetrieveRolePermissions", "RetrieveServiceProviderEntities",
+	"RetrieveSnapshotInfo", "RetrieveUserGroups", "RetrieveVStorageObject", "SessionIsActive", "WaitForUpdates",
+	"WaitForUpdatesEx",
+}
+
+// TestMethodPrivilegesComplete fails when a method handler has no methodPrivileges entry and is not in methodNoPrivilege,
+// such that new handlers are not allowed by default when Model.EnforcePrivileges is enabled.
+func TestMethodPrivilegesComplete(t *testing.T) {
+	none := make(map[string]bool)
+	for _, name := range methodNoPrivilege {
+		none[name] = true
+	}
+
+	hasFault := reflect.TypeOf((*soap.HasFault)(nil)).Elem()
+
+	for name, kind := range kinds {
+		ptr := reflect.PtrTo(kind)
+		for i := 0; i < ptr.NumMethod(); i++ {
+			m := ptr.Method(i)
+			if m.Type.NumOut() != 1 || !m.Type.Out(0).Implements(hasFault) {
+				continue
+			}
+
+			// handler "FooTask" is invoked as "Foo_Task", see Service.call
+			methods := []string{m.Name}
+			if strings.HasSuffix(m.Name, sTaskSuffix) {
+				methods = append(methods, strings.TrimSuffix(m.Name, sTaskSuffix)+vTaskSuffix)
+			}
+
+			found := false
+			for _, method := range methods {
+				if _, ok := methodPrivilege(&Method{Name: method, This: types.ManagedObjectReference{Type: name}}); ok || none[method] {
+					found = true
+				}
+			}
+
+			if !found {
+				t.Errorf("%s.%s: no methodPrivileges entry", name, m.Name)
+			}
+		}
+	}
+}
+
+func TestEnforcePrivileges(t *testing.T) {
+	m := VPX()
+	m.EnforcePrivileges = true
+
+	err := m.Run(func(ctx context.Context, c *vim25.Client) error {
+		sm := session.NewManager(c)
+		login := func(name string) {
+			if err := sm.Logout(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if err := sm.Login(ctx, url.UserPassword(name, "pass")); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		finder := find.NewFinder(c)
+		vm, err := finder.VirtualMachine(ctx, "DC0_H0_VM0")
+		if err != nil {
+			return err
+		}
+		folder, err := finder.Folder(ctx, "vm")
+		if err != nil {
+			return err
+		}
+
+		powerOff := func() error {
+			task, err := vm.PowerOff(ctx)
+			if err != nil {
+				return err
+			}
+			return task.Wait(ctx)
+		}
+
+		powerOn := func() error {
+			task, err := vm.PowerOn(ctx)
+			if err != nil {
+				return err
+			}
+			return task.Wait(ctx)
+		}
+
+		isNoPermission := func(err error, id string) {
+			t.Helper()
+			if err == nil {
+				t.Fatal("expected NoPermission fault")
+			}
+			if !soap.IsSoapFault(err) {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			fault, ok := soap.ToSoapFault(err).VimFault().(types.NoPermission)
+			if !ok {
+				t.Fatalf("unexpected fault: %#v", soap.ToSoapFault(err).VimFault())
+			}
+			if fault.PrivilegeId != id {
+				t.Errorf("PrivilegeId=%s, expected %s", fault.PrivilegeId, id)
+			}
+			if fault.Object == nil || *fault.Object != vm.Reference() {
+				t.Errorf("Object=%v", fault.Object)
+			}
+		}
+
+		// the default user has not been granted any permissions
+		isNoPermission(powerOff(), "VirtualMachine.Interact.PowerOff")
+
+		// admin has the Admin role on the root folder
+		login("admin")
+		if err = powerOff(); err != nil {
+			t.Fatal(err)
+		}
+
+		authz := object.NewAuthorizationManager(c)
+		operator, err := authz.AddRole(ctx, "VirtualMachineOperator", []string{"VirtualMachine.Interact.PowerOn"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = authz.SetEntityPermissions(ctx, folder.Reference(), []types.Permission{{
+			Principal: "alice",
+			RoleId:    operator,
+			Propagate: true,
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// alice inherits the PowerOn privilege from the vm folder
+		login("alice")
+		if err = powerOn(); err != nil {
+			t.Fatal(err)
+		}
+		isNoPermission(powerOff(), "VirtualMachine.Interact.PowerOff")
+
+		// a permission defined on the vm itself takes precedence
+		login("admin")
+		err = authz.SetEntityPermissions(ctx, vm.Reference(), []types.Permission{{
+			Principal: "alice",
+			RoleId:    -5, // NoAccess
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = powerOff(); err != nil {
+			t.Fatal(err)
+		}
+
+		login("alice")
+		isNoPermission(powerOn(), "VirtualMachine.Interact.PowerOn")
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEnforcePrivilegesHostDatastore(t *testing.T) {
+	m := VPX()
+	m.EnforcePrivileges = true
+
+	err := m.Run(func(ctx context.Context, c *vim25.Client) error {
+		sm := session.NewManager(c)
+		login := func(name string) {
+			if err := sm.Logout(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if err := sm.Login(ctx, url.UserPassword(name, "pass")); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		finder := find.NewFinder(c)
+		dc, err := finder.Datacenter(ctx, "DC0")
+		if err != nil {
+			return err
+		}
+		finder.SetDatacenter(dc)
+		host, err := finder.HostSystem(ctx, "DC0_H0")
+		if err != nil {
+			return err
+		}
+		other, err := finder.HostSystem(ctx, "DC0_C0_H0")
+		if err != nil {
+			return err
+		}
+		ds, err := finder.Datastore(ctx, "LocalDS_0")
+		if err != nil {
+			return err
+		}
+
+		isNoPermission := func(err error, obj types.ManagedObjectReference) {
+			t.Helper()
+			if err == nil {
+				t.Fatal("expected NoPermission fault")
+			}
+			fault, ok := soap.ToSoapFault(err).VimFault().(types.NoPermission)
+			if !ok {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if fault.Object == nil || *fault.Object != obj {
+				t.Errorf("Object=%v", fault.Object)
+			}
+		}
+
+		// roles granted on a single host and datastore
+		login("admin")
+		authz := object.NewAuthorizationManager(c)
+		hostRole, err := authz.AddRole(ctx, "HostServices", []string{"Host.Config.NetService"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		dsRole, err := authz.AddRole(ctx, "DatastoreFiles", []string{"Datastore.Browse", "Datastore.FileManagement"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for ref, role := range map[types.ManagedObjectReference]int32{host.Reference(): hostRole, ds.Reference(): dsRole} {
+			err = authz.SetEntityPermissions(ctx, ref, []types.Permission{{Principal: "bob", RoleId: role}})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		services := func(h *object.HostSystem) *object.HostServiceSystem {
+			s, err := h.ConfigManager().ServiceSystem(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return s
+		}
+		hs, ohs := services(host), services(other)
+
+		login("bob")
+
+		// host manager methods are checked on the manager's host
+		if err = hs.Start(ctx, "TSM-SSH"); err != nil {
+			t.Fatal(err)
+		}
+		isNoPermission(ohs.Start(ctx, "TSM-SSH"), other.Reference())
+
+		// file manager methods are checked on the datastore of the request's path
+		fm := object.NewFileManager(c)
+		if err = fm.MakeDirectory(ctx, ds.Path("bob"), dc, true); err != nil {
+			t.Fatal(err)
+		}
+
+		vdm := object.NewVirtualDiskManager(c)
+		spec := &types.FileBackedVirtualDiskSpec{
+			VirtualDiskSpec: types.VirtualDiskSpec{
+				DiskType:    string(types.VirtualDiskTypeThin),
+				AdapterType: string(types.VirtualDiskAdapterTypeLsiLogic),
+			},
+			CapacityKb: 1024,
+		}
+		task, err := vdm.CreateVirtualDisk(ctx, ds.Path("bob/disk.vmdk"), dc, spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = task.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		// datastore browser methods are checked on the datastore searched
+		browser, err := ds.Browser(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		task, err = browser.SearchDatastore(ctx, ds.Path("bob"), &types.HostDatastoreBrowserSearchSpec{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = task.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		// privileges not granted by the host role are still rejected on the host
+		dts, err := host.ConfigManager().DateTimeSystem(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		isNoPermission(dts.Update(ctx, time.Now()), host.Reference())
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
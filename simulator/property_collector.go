@@ -42,8 +42,14 @@ type PropertyCollector struct {
 
 	nopLocker
 	updates []types.ObjectUpdate
+	notify  chan struct{}
 	mu      sync.Mutex
 	cancel  context.CancelFunc
+
+	// watchers is the reverse index of PropertyFilter.watch, from object to the filters that traversed it
+	watchers map[types.ManagedObjectReference]map[*PropertyFilter]struct{}
+	// traversers are the filters that traverse a path of at least one object
+	traversers map[*PropertyFilter]struct{}
 }
 
 func NewPropertyCollector(ref types.ManagedObjectReference) object.Reference {
@@ -143,20 +149,48 @@ func wrapValue(rval reflect.Value, rtype reflect.Type) interface{} {
 			pval = &types.ArrayOfLong{
 				Long: v,
 			}
+		case []types.ManagedObjectReference:
+			pval = &types.ArrayOfManagedObjectReference{
+				ManagedObjectReference: v,
+			}
 		default:
-			kind := rtype.Elem().Name()
-			// Remove govmomi interface prefix name
-			kind = strings.TrimPrefix(kind, "Base")
-			akind, _ := defaultMapType("ArrayOf" + kind)
-			a := reflect.New(akind)
-			a.Elem().FieldByName(kind).Set(rval)
-			pval = a.Interface()
+			a := arrayOfType(rtype)
+			val := reflect.New(a.kind)
+			val.Elem().FieldByIndex(a.index).Set(rval)
+			pval = val.Interface()
 		}
 	}
 
 	return pval
 }
 
+// arrayOf is the types.ArrayOf* type and field index for a slice type, see arrayOfType
+type arrayOf struct {
+	kind  reflect.Type
+	index []int
+}
+
+// arrayOfTypes caches arrayOf by slice type
+var arrayOfTypes sync.Map
+
+// arrayOfType returns the types.ArrayOf* type of the given slice type,
+// caching the lookup to avoid the cost of defaultMapType and FieldByName per value.
+func arrayOfType(rtype reflect.Type) arrayOf {
+	if a, ok := arrayOfTypes.Load(rtype); ok {
+		return a.(arrayOf)
+	}
+
+	kind := rtype.Elem().Name()
+	// Remove govmomi interface prefix name
+	kind = strings.TrimPrefix(kind, "Base")
+	akind, _ := defaultMapType("ArrayOf" + kind)
+	f, _ := akind.FieldByName(kind)
+
+	a := arrayOf{kind: akind, index: f.Index}
+	arrayOfTypes.Store(rtype, a)
+	return a
+}
+
 func fieldValueInterface(f reflect.StructField, rval reflect.Value) interface{} {
 	if rval.Kind() == reflect.Ptr {
 		rval = rval.Elem()
@@ -272,6 +306,7 @@ type retrieveResult struct {
 	req       *types.RetrievePropertiesEx
 	collected map[types.ManagedObjectReference]bool
 	specs     map[string]*types.TraversalSpec
+	watch     map[types.ManagedObjectReference][]string // if not nil, the objects and paths visited by traverse
 }
 
 func (rr *retrieveResult) add(ctx *Context, name string, val types.AnyType, content *types.ObjectContent) {
@@ -398,7 +433,23 @@ func (rr *retrieveResult) collect(ctx *Context, ref types.ManagedObjectReference
 	rr.collected[ref] = true
 }
 
-func (rr *retrieveResult) selectSet(ctx *Context, obj reflect.Value, s []types.BaseSelectionSpec, refs *[]types.ManagedObjectReference) types.BaseMethodFault {
+// visit records the traversal of the given path on an object, if rr.watch is enabled.
+// An empty path records the object itself, such as an ObjectSpec that may not exist yet.
+func (rr *retrieveResult) visit(ref types.ManagedObjectReference, path string) {
+	if rr.watch == nil {
+		return
+	}
+
+	for _, p := range rr.watch[ref] {
+		if p == path {
+			return
+		}
+	}
+
+	rr.watch[ref] = append(rr.watch[ref], path)
+}
+
+func (rr *retrieveResult) selectSet(ctx *Context, self types.ManagedObjectReference, obj reflect.Value, s []types.BaseSelectionSpec, refs *[]types.ManagedObjectReference) types.BaseMethodFault {
 	for _, ss := range s {
 		ts, ok := ss.(*types.TraversalSpec)
 		if ok {
@@ -417,6 +468,7 @@ func (rr *retrieveResult) selectSet(ctx *Context, obj reflect.Value, s []types.B
 			}
 		}
 
+		rr.visit(self, ts.Path)
 		f, _ := fieldValue(obj, ts.Path)
 
 		for _, ref := range fieldRefs(f) {
@@ -426,7 +478,7 @@ func (rr *retrieveResult) selectSet(ctx *Context, obj reflect.Value, s []types.B
 
 			rval, ok := getObject(ctx, ref)
 			if ok {
-				if err := rr.selectSet(ctx, rval, ts.SelectSet, refs); err != nil {
+				if err := rr.selectSet(ctx, ref, rval, ts.SelectSet, refs); err != nil {
 					return err
 				}
 			}
@@ -436,21 +488,40 @@ func (rr *retrieveResult) selectSet(ctx *Context, obj reflect.Value, s []types.B
 	return nil
 }
 
-func (pc *PropertyCollector) collect(ctx *Context, r *types.RetrievePropertiesEx) (*types.RetrieveResult, types.BaseMethodFault) {
-	var refs []types.ManagedObjectReference
-
-	rr := &retrieveResult{
+func newRetrieveResult(r *types.RetrievePropertiesEx) *retrieveResult {
+	return &retrieveResult{
 		RetrieveResult: &types.RetrieveResult{},
 		req:            r,
 		collected:      make(map[types.ManagedObjectReference]bool),
 		specs:          make(map[string]*types.TraversalSpec),
 	}
+}
+
+func (pc *PropertyCollector) collect(ctx *Context, r *types.RetrievePropertiesEx) (*types.RetrieveResult, types.BaseMethodFault) {
+	rr := newRetrieveResult(r)
+
+	refs, fault := rr.traverse(ctx)
+	if fault != nil {
+		return nil, fault
+	}
+
+	for _, ref := range refs {
+		ctx.WithLock(ref, func() { rr.collect(ctx, ref) })
+	}
+
+	return rr.RetrieveResult, nil
+}
+
+// traverse returns the object references selected by the request SpecSet, without collecting any properties.
+func (rr *retrieveResult) traverse(ctx *Context) ([]types.ManagedObjectReference, types.BaseMethodFault) {
+	var refs []types.ManagedObjectReference
 
 	// Select object references
-	for _, spec := range r.SpecSet {
+	for _, spec := range rr.req.SpecSet {
 		for _, o := range spec.ObjectSet {
 			var rval reflect.Value
 			ok := false
+			rr.visit(o.Obj, "")
 			ctx.WithLock(o.Obj, func() { rval, ok = getObject(ctx, o.Obj) })
 			if !ok {
 				if isFalse(spec.ReportMissingObjectsInResults) {
@@ -463,17 +534,13 @@ func (pc *PropertyCollector) collect(ctx *Context, r *types.RetrievePropertiesEx
 				refs = append(refs, o.Obj)
 			}
 
-			if err := rr.selectSet(ctx, rval, o.SelectSet, &refs); err != nil {
+			if err := rr.selectSet(ctx, o.Obj, rval, o.SelectSet, &refs); err != nil {
 				return nil, err
 			}
 		}
 	}
 
-	for _, ref := range refs {
-		ctx.WithLock(ref, func() { rr.collect(ctx, ref) })
-	}
-
-	return rr.RetrieveResult, nil
+	return refs, nil
 }
 
 func (pc *PropertyCollector) CreateFilter(ctx *Context, c *types.CreateFilter) soap.HasFault {
@@ -485,6 +552,7 @@ func (pc *PropertyCollector) CreateFilter(ctx *Context, c *types.CreateFilter) s
 	}
 	filter.PartialUpdates = c.PartialUpdates
 	filter.Spec = c.Spec
+	filter.dirty = true
 
 	pc.Filter = append(pc.Filter, ctx.Session.Put(filter).Reference())
 
@@ -669,6 +737,12 @@ func (pc *PropertyCollector) CancelWaitForUpdates(r *types.CancelWaitForUpdates)
 func (pc *PropertyCollector) update(u types.ObjectUpdate) {
 	pc.mu.Lock()
 	pc.updates = append(pc.updates, u)
+	if pc.notify != nil {
+		select {
+		case pc.notify <- struct{}{}: // wake up WaitForUpdatesEx
+		default:
+		}
+	}
 	pc.mu.Unlock()
 }
 
@@ -696,26 +770,111 @@ func (pc *PropertyCollector) RemoveObject(_ *Context, ref types.ManagedObjectRef
 	})
 }
 
-func (pc *PropertyCollector) apply(ctx *Context, update *types.UpdateSet) types.BaseMethodFault {
+// index replaces the objects and paths watched by the given filter, as recorded by its latest traversal.
+func (pc *PropertyCollector) index(filter *PropertyFilter, watch map[types.ManagedObjectReference][]string) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	pc.unindex(filter)
+
+	if pc.watchers == nil {
+		pc.watchers = make(map[types.ManagedObjectReference]map[*PropertyFilter]struct{})
+		pc.traversers = make(map[*PropertyFilter]struct{})
+	}
+
+	for ref, paths := range watch {
+		filters, ok := pc.watchers[ref]
+		if !ok {
+			filters = make(map[*PropertyFilter]struct{})
+			pc.watchers[ref] = filters
+		}
+		filters[filter] = struct{}{}
+
+		for _, path := range paths {
+			if path != "" {
+				pc.traversers[filter] = struct{}{}
+			}
+		}
+	}
+
+	filter.watch = watch
+}
+
+// unindex removes the given filter from the watchers index. The caller must hold pc.mu.
+func (pc *PropertyCollector) unindex(filter *PropertyFilter) {
+	for ref := range filter.watch {
+		filters := pc.watchers[ref]
+		delete(filters, filter)
+		if len(filters) == 0 {
+			delete(pc.watchers, ref)
+		}
+	}
+
+	delete(pc.traversers, filter)
+	filter.watch = nil
+}
+
+// invalidate marks the filters that must be traversed again after the given updates, via the watchers index.
+// A change to a traversed path, such as Folder.childEntity or ContainerView.view, invalidates the filters
+// that traversed the path on that object. Several container properties, such as ResourcePool.vm and
+// HostSystem.vm, change without an update of their own, so a new object invalidates the filters that
+// traverse any path, along with those that selected the object itself.
+func (pc *PropertyCollector) invalidate(updates []types.ObjectUpdate) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	for _, u := range updates {
+		switch u.Kind {
+		case types.ObjectUpdateKindModify:
+			for filter := range pc.watchers[u.Obj] {
+				if filter.watches(u.Obj, u.ChangeSet) {
+					filter.dirty = true
+				}
+			}
+		case types.ObjectUpdateKindEnter:
+			for filter := range pc.watchers[u.Obj] {
+				filter.dirty = true
+			}
+			for filter := range pc.traversers {
+				filter.dirty = true
+			}
+		}
+	}
+}
+
+// apply collects the objects newly selected by the filters, for all filters or only those invalidated since the last apply.
+func (pc *PropertyCollector) apply(ctx *Context, update *types.UpdateSet, all bool) types.BaseMethodFault {
 	for _, ref := range pc.Filter {
 		filter := ctx.Session.Get(ref).(*PropertyFilter)
+		if !all && !filter.dirty {
+			continue
+		}
 
 		r := &types.RetrievePropertiesEx{}
 		r.SpecSet = append(r.SpecSet, filter.Spec)
 
-		res, fault := pc.collect(ctx, r)
+		rr := newRetrieveResult(r)
+		rr.watch = make(map[types.ManagedObjectReference][]string)
+		refs, fault := rr.traverse(ctx)
 		if fault != nil {
 			return fault
 		}
+		filter.dirty = false
+		pc.index(filter, rr.watch)
+
+		// filter.refs is the index of objects already sent to the client,
+		// properties are only collected for those newly selected by the traversal.
+		for _, obj := range refs {
+			if _, ok := filter.refs[obj]; !ok {
+				ctx.WithLock(obj, func() { rr.collect(ctx, obj) })
+			}
+		}
 
 		fu := types.PropertyFilterUpdate{
 			Filter: ref,
 		}
 
-		for _, o := range res.Objects {
-			if _, ok := filter.refs[o.Obj]; ok {
-				continue
-			}
+		for _, o := range rr.Objects {
 			filter.refs[o.Obj] = struct{}{}
 			ou := types.ObjectUpdate{
 				Obj:  o.Obj,
@@ -754,6 +913,10 @@ func (pc *PropertyCollector) WaitForUpdatesEx(ctx *Context, r *types.WaitForUpda
 	}
 	pc.mu.Lock()
 	pc.cancel = cancel
+	if pc.notify == nil {
+		pc.notify = make(chan struct{}, 1)
+	}
+	notify := pc.notify
 	pc.mu.Unlock()
 
 	body := &methods.WaitForUpdatesExBody{}
@@ -766,8 +929,8 @@ func (pc *PropertyCollector) WaitForUpdatesEx(ctx *Context, r *types.WaitForUpda
 		Returnval: set,
 	}
 
-	apply := func() bool {
-		if fault := pc.apply(ctx, set); fault != nil {
+	apply := func(all bool) bool {
+		if fault := pc.apply(ctx, set, all); fault != nil {
 			body.Fault_ = Fault("", fault)
 			body.Res = nil
 			return false
@@ -777,12 +940,12 @@ func (pc *PropertyCollector) WaitForUpdatesEx(ctx *Context, r *types.WaitForUpda
 
 	if r.Version == "" {
 		ctx.Map.AddHandler(pc) // Listen for create, update, delete of managed objects
-		apply()                // Collect current state
+		apply(true)            // Collect current state
 		set.Version = "-"      // Next request with Version set will wait via loop below
 		return body
 	}
 
-	ticker := time.NewTicker(20 * time.Millisecond) // allow for updates to accumulate
+	ticker := time.NewTicker(20 * time.Millisecond) // upper bound for updates to accumulate
 	defer ticker.Stop()
 	// Start the wait loop, returning on one of:
 	// - Client calls CancelWaitForUpdates
@@ -803,64 +966,66 @@ func (pc *PropertyCollector) WaitForUpdatesEx(ctx *Context, r *types.WaitForUpda
 
 			return body
 		case <-ticker.C:
-			pc.mu.Lock()
-			updates := pc.updates
-			pc.updates = nil // clear updates collected by the managed object CRUD listeners
-			pc.mu.Unlock()
-			if len(updates) == 0 {
-				if oneUpdate {
-					body.Res.Returnval = nil
-					return body
-				}
-				continue
+		case <-notify: // an update was queued, no need to wait for the next tick
+		}
+
+		pc.mu.Lock()
+		updates := pc.updates
+		pc.updates = nil // clear updates collected by the managed object CRUD listeners
+		pc.mu.Unlock()
+		if len(updates) == 0 {
+			if oneUpdate {
+				body.Res.Returnval = nil
+				return body
 			}
+			continue
+		}
 
-			tracef("%s: applying %d updates to %d filters", pc.Self, len(updates), len(pc.Filter))
+		tracef("%s: applying %d updates to %d filters", pc.Self, len(updates), len(pc.Filter))
 
-			for _, f := range pc.Filter {
-				filter := ctx.Session.Get(f).(*PropertyFilter)
-				fu := types.PropertyFilterUpdate{Filter: f}
+		// Creates and updates may change the objects selected by filter traversal specs,
+		// only the filters invalidated by the batch of updates are traversed again.
+		pc.invalidate(updates)
+		if !apply(false) {
+			return body
+		}
 
-				for _, update := range updates {
-					switch update.Kind {
-					case types.ObjectUpdateKindEnter: // Create
-						if !apply() {
-							return body
-						}
-					case types.ObjectUpdateKindModify: // Update
-						tracef("%s has %d changes", update.Obj, len(update.ChangeSet))
-						if !apply() { // An update may apply to collector traversal specs
-							return body
+		for _, f := range pc.Filter {
+			filter := ctx.Session.Get(f).(*PropertyFilter)
+			fu := types.PropertyFilterUpdate{Filter: f}
+
+			for _, update := range updates {
+				switch update.Kind {
+				case types.ObjectUpdateKindModify: // Update
+					tracef("%s has %d changes", update.Obj, len(update.ChangeSet))
+					if _, ok := filter.refs[update.Obj]; ok {
+						// This object has already been applied by the filter,
+						// now check if the property spec applies for this update.
+						update = filter.apply(ctx, update)
+						if len(update.ChangeSet) != 0 {
+							fu.ObjectSet = append(fu.ObjectSet, update)
 						}
-						if _, ok := filter.refs[update.Obj]; ok {
-							// This object has already been applied by the filter,
-							// now check if the property spec applies for this update.
-							update = filter.apply(ctx, update)
-							if len(update.ChangeSet) != 0 {
-								fu.ObjectSet = append(fu.ObjectSet, update)
-							}
-						}
-					case types.ObjectUpdateKindLeave: // Delete
-						if _, ok := filter.refs[update.Obj]; !ok {
-							continue
-						}
-						delete(filter.refs, update.Obj)
-						fu.ObjectSet = append(fu.ObjectSet, update)
 					}
+				case types.ObjectUpdateKindLeave: // Delete
+					if _, ok := filter.refs[update.Obj]; !ok {
+						continue
+					}
+					delete(filter.refs, update.Obj)
+					fu.ObjectSet = append(fu.ObjectSet, update)
 				}
-
-				if len(fu.ObjectSet) != 0 {
-					set.FilterSet = append(set.FilterSet, fu)
-				}
-			}
-			if len(set.FilterSet) != 0 {
-				return body
 			}
-			if oneUpdate {
-				body.Res.Returnval = nil
-				return body
+
+			if len(fu.ObjectSet) != 0 {
+				set.FilterSet = append(set.FilterSet, fu)
 			}
 		}
+		if len(set.FilterSet) != 0 {
+			return body
+		}
+		if oneUpdate {
+			body.Res.Returnval = nil
+			return body
+		}
 	}
 }
 
@@ -455,6 +455,93 @@ func TestIncrementalWaitForUpdates(t *testing.T) {
 	task.Wait(ctx)
 }
 
+func TestWaitForUpdatesTraversal(t *testing.T) {
+	Test(func(ctx context.Context, c *vim25.Client) {
+		pc, err := property.DefaultCollector(c).Create(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// select VMs via Folder and Datacenter traversal, rather than a ContainerView
+		spec := types.PropertyFilterSpec{
+			ObjectSet: []types.ObjectSpec{{
+				Obj: c.ServiceContent.RootFolder,
+				SelectSet: []types.BaseSelectionSpec{
+					&types.TraversalSpec{
+						SelectionSpec: types.SelectionSpec{Name: "folder"},
+						Type:          "Folder",
+						Path:          "childEntity",
+						SelectSet: []types.BaseSelectionSpec{
+							&types.SelectionSpec{Name: "folder"},
+							&types.SelectionSpec{Name: "datacenter"},
+						},
+					},
+					&types.TraversalSpec{
+						SelectionSpec: types.SelectionSpec{Name: "datacenter"},
+						Type:          "Datacenter",
+						Path:          "vmFolder",
+						SelectSet:     []types.BaseSelectionSpec{&types.SelectionSpec{Name: "folder"}},
+					},
+				},
+			}},
+			PropSet: []types.PropertySpec{{Type: "VirtualMachine", PathSet: []string{"name"}}},
+		}
+		if _, err = pc.CreateFilter(ctx, types.CreateFilter{Spec: spec}); err != nil {
+			t.Fatal(err)
+		}
+
+		wait := func(version string) (string, []types.ObjectUpdate) {
+			res, err := methods.WaitForUpdatesEx(ctx, c, &types.WaitForUpdatesEx{This: pc.Reference(), Version: version})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var updates []types.ObjectUpdate
+			for _, fs := range res.Returnval.FilterSet {
+				updates = append(updates, fs.ObjectSet...)
+			}
+			return res.Returnval.Version, updates
+		}
+
+		version, updates := wait("")
+		if n := len(Map.All("VirtualMachine")); len(updates) != n {
+			t.Fatalf("%d updates, expected %d", len(updates), n)
+		}
+
+		// a change to a property that is neither collected nor traversed is not reported
+		vm := Map.Any("VirtualMachine").(*VirtualMachine)
+		Map.Update(vm, []types.PropertyChange{{Name: "summary.quickStats.overallCpuUsage", Val: int32(42)}})
+
+		finder := find.NewFinder(c)
+		folder, err := finder.Folder(ctx, "vm")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// a new VM is selected via its parent folder's childEntity
+		task, err := object.NewVirtualMachine(c, vm.Self).Clone(ctx, folder, "traversal-clone", types.VirtualMachineCloneSpec{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := task.WaitForResult(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clone := info.Result.(types.ManagedObjectReference)
+
+		for {
+			version, updates = wait(version)
+			for _, u := range updates {
+				if u.Obj == vm.Self {
+					t.Errorf("unexpected update: %#v", u)
+				}
+				if u.Obj == clone && u.Kind == types.ObjectUpdateKindEnter {
+					return
+				}
+			}
+		}
+	})
+}
+
 func TestWaitForUpdatesOneUpdateCalculation(t *testing.T) {
 	/*
 	 * In this test, we use WaitForUpdatesEx in non-blocking way
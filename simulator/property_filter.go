@@ -29,8 +29,10 @@ import (
 type PropertyFilter struct {
 	mo.PropertyFilter
 
-	pc   *PropertyCollector
-	refs map[types.ManagedObjectReference]struct{}
+	pc    *PropertyCollector
+	refs  map[types.ManagedObjectReference]struct{}
+	watch map[types.ManagedObjectReference][]string // objects and paths visited by the latest traversal of Spec
+	dirty bool                                      // Spec must be traversed again, see PropertyCollector.invalidate
 }
 
 func (f *PropertyFilter) DestroyPropertyFilter(ctx *Context, c *types.DestroyPropertyFilter) soap.HasFault {
@@ -38,6 +40,10 @@ func (f *PropertyFilter) DestroyPropertyFilter(ctx *Context, c *types.DestroyPro
 
 	RemoveReference(&f.pc.Filter, c.This)
 
+	f.pc.mu.Lock()
+	f.pc.unindex(f)
+	f.pc.mu.Unlock()
+
 	ctx.Session.Remove(ctx, c.This)
 
 	body.Res = &types.DestroyPropertyFilterResponse{}
@@ -85,6 +91,24 @@ func (f *PropertyFilter) matches(ctx *Context, ref types.ManagedObjectReference,
 	return false
 }
 
+// watches returns true if one of the changes is to a path traversed on the given object by the filter Spec,
+// in which case the traversal may select a different set of objects.
+func (f *PropertyFilter) watches(ref types.ManagedObjectReference, changes []types.PropertyChange) bool {
+	for _, path := range f.watch[ref] {
+		if path == "" {
+			continue
+		}
+		for _, change := range changes {
+			name := change.Name
+			if name == path || strings.HasPrefix(path, name+".") || strings.HasPrefix(name, path+".") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
 // apply the PropertyFilter.Spec to the given ObjectUpdate
 func (f *PropertyFilter) apply(ctx *Context, change types.ObjectUpdate) types.ObjectUpdate {
 	parents := make(map[string]bool)
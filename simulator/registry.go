@@ -68,16 +68,28 @@ type RegisterObject interface {
 // Registry manages a map of mo.Reference objects
 type Registry struct {
 	counter  int64 // Keep first to ensure 64-bit alignment
-	m        sync.Mutex
+	m        sync.RWMutex
 	objects  map[types.ManagedObjectReference]mo.Reference
+	kinds    map[string]map[types.ManagedObjectReference]mo.Reference // objects indexed by type
 	handlers map[types.ManagedObjectReference]RegisterObject
-	locks    map[types.ManagedObjectReference]*internal.ObjectLock
+	locks    [lockShards]lockShard
 
 	Namespace string
 	Path      string
 	Handler   func(*Context, *Method) (mo.Reference, types.BaseMethodFault)
 
 	tagManager tagManager
+
+	// clock is the Model Clock, used by a Context not associated with a Service
+	clock *Clock
+
+	// reservationLock serializes reservation admission control and runtime accounting,
+	// which span the resource pool hierarchy rather than a single pool, see admitReservation
+	reservationLock sync.Mutex
+	// pendingPools are the ResourcePools pending a runtime update, see deferResourcePoolRuntime
+	pendingPools map[types.ManagedObjectReference]struct{}
+	// poweringOn are the VMs holding their reservations while powering on, see admitPowerOn
+	poweringOn map[types.ManagedObjectReference]struct{}
 }
 
 // tagManager is an interface to simplify internal interaction with the vapi tag manager simulator.
@@ -88,12 +100,22 @@ type tagManager interface {
 	DetachTag(types.ManagedObjectReference, types.VslmTagEntry) types.BaseMethodFault
 }
 
+// lockShards is the number of lockShard instances of a Registry.
+const lockShards = 64
+
+// lockShard holds the object locks for a subset of the Registry objects,
+// such that creating and looking up the lock for one object does not contend with all others.
+type lockShard struct {
+	mu    sync.Mutex
+	locks map[types.ManagedObjectReference]*internal.ObjectLock
+}
+
 // NewRegistry creates a new instances of Registry
 func NewRegistry() *Registry {
 	r := &Registry{
 		objects:  make(map[types.ManagedObjectReference]mo.Reference),
+		kinds:    make(map[string]map[types.ManagedObjectReference]mo.Reference),
 		handlers: make(map[types.ManagedObjectReference]RegisterObject),
-		locks:    make(map[types.ManagedObjectReference]*internal.ObjectLock),
 
 		Namespace: vim25.Namespace,
 		Path:      vim25.Path,
@@ -102,6 +124,60 @@ func NewRegistry() *Registry {
 	return r
 }
 
+// putObject adds the given object to r.objects and r.kinds, the caller must hold r.m.
+func (r *Registry) putObject(ref types.ManagedObjectReference, obj mo.Reference) {
+	r.objects[ref] = obj
+
+	kind, ok := r.kinds[ref.Type]
+	if !ok {
+		kind = make(map[types.ManagedObjectReference]mo.Reference)
+		r.kinds[ref.Type] = kind
+	}
+	kind[ref] = obj
+}
+
+// removeObject removes the given object from r.objects and r.kinds, the caller must hold r.m.
+func (r *Registry) removeObject(ref types.ManagedObjectReference) {
+	delete(r.objects, ref)
+
+	if kind, ok := r.kinds[ref.Type]; ok {
+		delete(kind, ref)
+		if len(kind) == 0 {
+			delete(r.kinds, ref.Type)
+		}
+	}
+}
+
+// objectsOfType returns the objects of the given type, or all objects if kind is empty.
+// The caller must hold r.m and must not modify the returned map.
+func (r *Registry) objectsOfType(kind string) map[types.ManagedObjectReference]mo.Reference {
+	if kind == "" {
+		return r.objects
+	}
+	return r.kinds[kind]
+}
+
+// lockShard returns the lockShard for the given reference.
+func (r *Registry) lockShard(ref types.ManagedObjectReference) *lockShard {
+	// FNV-1a
+	h := uint32(2166136261)
+	for _, s := range []string{ref.Type, ref.Value} {
+		for i := 0; i < len(s); i++ {
+			h ^= uint32(s[i])
+			h *= 16777619
+		}
+	}
+	return &r.locks[h%lockShards]
+}
+
+// removeLock removes the lock for the given reference, if any.
+func (r *Registry) removeLock(ref types.ManagedObjectReference) {
+	shard := r.lockShard(ref)
+	shard.mu.Lock()
+	delete(shard.locks, ref)
+	shard.mu.Unlock()
+}
+
 func (r *Registry) typeFunc(name string) (reflect.Type, bool) {
 	if r.Namespace != "" && r.Namespace != vim25.Namespace {
 		if kind, ok := defaultMapType(r.Namespace + ":" + name); ok {
@@ -147,9 +223,31 @@ func (r *Registry) newReference(item mo.Reference) types.ManagedObjectReference
 	return ref
 }
 
+// selfFields caches the index of the 'Self' field of each managed object type, see selfField
+var selfFields sync.Map
+
+// selfField returns the 'Self' field of the given managed object struct value,
+// caching the field index by type to avoid the cost of reflect.Value.FieldByName.
+func selfField(val reflect.Value) reflect.Value {
+	var index []int
+	if x, ok := selfFields.Load(val.Type()); ok {
+		index = x.([]int)
+	} else {
+		if f, ok := val.Type().FieldByName("Self"); ok {
+			index = f.Index
+		}
+		selfFields.Store(val.Type(), index)
+	}
+
+	if index == nil {
+		return reflect.Value{}
+	}
+	return val.FieldByIndex(index)
+}
+
 func (r *Registry) setReference(item mo.Reference, ref types.ManagedObjectReference) {
 	// mo.Reference() returns a value, not a pointer so use reflect to set the Self field
-	reflect.ValueOf(item).Elem().FieldByName("Self").Set(reflect.ValueOf(ref))
+	selfField(reflect.ValueOf(item).Elem()).Set(reflect.ValueOf(ref))
 }
 
 // AddHandler adds a RegisterObject handler to the Registry.
@@ -190,21 +288,19 @@ func (r *Registry) PutEntity(parent mo.Entity, item mo.Entity) mo.Entity {
 
 // Get returns the object for the given reference.
 func (r *Registry) Get(ref types.ManagedObjectReference) mo.Reference {
-	r.m.Lock()
-	defer r.m.Unlock()
+	r.m.RLock()
+	defer r.m.RUnlock()
 
 	return r.objects[ref]
 }
 
 // Any returns the first instance of entity type specified by kind.
 func (r *Registry) Any(kind string) mo.Entity {
-	r.m.Lock()
-	defer r.m.Unlock()
+	r.m.RLock()
+	defer r.m.RUnlock()
 
-	for ref, val := range r.objects {
-		if ref.Type == kind {
-			return val.(mo.Entity)
-		}
+	for _, val := range r.kinds[kind] {
+		return val.(mo.Entity)
 	}
 
 	return nil
@@ -213,15 +309,13 @@ func (r *Registry) Any(kind string) mo.Entity {
 // All returns all entities of type specified by kind.
 // If kind is empty - all entities will be returned.
 func (r *Registry) All(kind string) []mo.Entity {
-	r.m.Lock()
-	defer r.m.Unlock()
+	r.m.RLock()
+	defer r.m.RUnlock()
 
 	var entities []mo.Entity
-	for ref, val := range r.objects {
-		if kind == "" || ref.Type == kind {
-			if e, ok := val.(mo.Entity); ok {
-				entities = append(entities, e)
-			}
+	for _, val := range r.objectsOfType(kind) {
+		if e, ok := val.(mo.Entity); ok {
+			entities = append(entities, e)
 		}
 	}
 
@@ -231,14 +325,12 @@ func (r *Registry) All(kind string) []mo.Entity {
 // AllReference returns all mo.Reference objects of type specified by kind.
 // If kind is empty - all objects will be returned.
 func (r *Registry) AllReference(kind string) []mo.Reference {
-	r.m.Lock()
-	defer r.m.Unlock()
+	r.m.RLock()
+	defer r.m.RUnlock()
 
 	var objs []mo.Reference
-	for ref, val := range r.objects {
-		if kind == "" || ref.Type == kind {
-			objs = append(objs, val)
-		}
+	for _, val := range r.objectsOfType(kind) {
+		objs = append(objs, val)
 	}
 
 	return objs
@@ -246,12 +338,12 @@ func (r *Registry) AllReference(kind string) []mo.Reference {
 
 // applyHandlers calls the given func for each r.handlers
 func (r *Registry) applyHandlers(f func(o RegisterObject)) {
-	r.m.Lock()
+	r.m.RLock()
 	handlers := make([]RegisterObject, 0, len(r.handlers))
 	for _, handler := range r.handlers {
 		handlers = append(handlers, handler)
 	}
-	r.m.Unlock()
+	r.m.RUnlock()
 
 	for i := range handlers {
 		f(handlers[i])
@@ -277,7 +369,7 @@ func (r *Registry) Put(item mo.Reference) mo.Reference {
 		me.Entity().EffectiveRole = []int32{-1} // Admin
 	}
 
-	r.objects[r.reference(item)] = item
+	r.putObject(r.reference(item), item)
 
 	r.m.Unlock()
 
@@ -295,10 +387,11 @@ func (r *Registry) Remove(ctx *Context, item types.ManagedObjectReference) {
 	})
 
 	r.m.Lock()
-	delete(r.objects, item)
+	r.removeObject(item)
 	delete(r.handlers, item)
-	delete(r.locks, item)
 	r.m.Unlock()
+
+	r.removeLock(item)
 }
 
 // Update dispatches object property changes to RegisterObject handlers,
@@ -521,6 +614,36 @@ func (r *Registry) EventManager() *EventManager {
 	return r.Get(r.content().EventManager.Reference()).(*EventManager)
 }
 
+// AuthorizationManager returns the AuthorizationManager singleton
+func (r *Registry) AuthorizationManager() *AuthorizationManager {
+	if ref := r.content().AuthorizationManager; ref != nil {
+		if m, ok := r.Get(*ref).(*AuthorizationManager); ok {
+			return m
+		}
+	}
+	return nil
+}
+
+// AlarmManager returns the AlarmManager singleton, or nil if the model does not include one (ESX)
+func (r *Registry) AlarmManager() *AlarmManager {
+	ref := r.content().AlarmManager
+	if ref == nil {
+		return nil
+	}
+	m, _ := r.Get(*ref).(*AlarmManager)
+	return m
+}
+
+// ScheduledTaskManager returns the ScheduledTaskManager singleton, or nil if the model does not include one (ESX)
+func (r *Registry) ScheduledTaskManager() *ScheduledTaskManager {
+	ref := r.content().ScheduledTaskManager
+	if ref == nil {
+		return nil
+	}
+	m, _ := r.Get(*ref).(*ScheduledTaskManager)
+	return m
+}
+
 // FileManager returns the FileManager singleton
 func (r *Registry) FileManager() *FileManager {
 	return r.Get(r.content().FileManager.Reference()).(*FileManager)
@@ -535,6 +658,11 @@ type VirtualDiskManagerInterface interface {
 	CopyVirtualDiskTask(*Context, *types.CopyVirtualDisk_Task) soap.HasFault
 	QueryVirtualDiskUuid(*Context, *types.QueryVirtualDiskUuid) soap.HasFault
 	SetVirtualDiskUuid(*Context, *types.SetVirtualDiskUuid) soap.HasFault
+	ExtendVirtualDiskTask(*Context, *types.ExtendVirtualDisk_Task) soap.HasFault
+	InflateVirtualDiskTask(*Context, *types.InflateVirtualDisk_Task) soap.HasFault
+	ShrinkVirtualDiskTask(*Context, *types.ShrinkVirtualDisk_Task) soap.HasFault
+	EagerZeroVirtualDiskTask(*Context, *types.EagerZeroVirtualDisk_Task) soap.HasFault
+	ZeroFillVirtualDiskTask(*Context, *types.ZeroFillVirtualDisk_Task) soap.HasFault
 }
 
 // VirtualDiskManager returns the VirtualDiskManager singleton
@@ -578,15 +706,23 @@ func (r *Registry) ExtensionManager() *ExtensionManager {
 }
 
 func (r *Registry) MarshalJSON() ([]byte, error) {
-	r.m.Lock()
-	defer r.m.Unlock()
+	r.m.RLock()
+	objects := len(r.objects)
+	r.m.RUnlock()
+
+	locks := 0
+	for i := range r.locks {
+		r.locks[i].mu.Lock()
+		locks += len(r.locks[i].locks)
+		r.locks[i].mu.Unlock()
+	}
 
 	vars := struct {
 		Objects int `json:"objects"`
 		Locks   int `json:"locks"`
 	}{
-		len(r.objects),
-		len(r.locks),
+		objects,
+		locks,
 	}
 
 	return json.Marshal(vars)
@@ -604,7 +740,7 @@ func (r *Registry) locker(obj mo.Reference) *internal.ObjectLock {
 		obj = r.Get(ref) // to check for sync.Locker
 	default:
 		// Use of obj.Reference() may cause a read race, prefer the mo 'Self' field to avoid this
-		self := reflect.ValueOf(obj).Elem().FieldByName("Self")
+		self := selfField(reflect.ValueOf(obj).Elem())
 		if self.IsValid() {
 			ref = self.Interface().(types.ManagedObjectReference)
 		} else {
@@ -620,13 +756,17 @@ func (r *Registry) locker(obj mo.Reference) *internal.ObjectLock {
 		return internal.NewObjectLock(mu)
 	}
 
-	r.m.Lock()
-	mu, ok := r.locks[ref]
+	shard := r.lockShard(ref)
+	shard.mu.Lock()
+	if shard.locks == nil {
+		shard.locks = make(map[types.ManagedObjectReference]*internal.ObjectLock)
+	}
+	mu, ok := shard.locks[ref]
 	if !ok {
 		mu = internal.NewObjectLock(new(sync.Mutex))
-		r.locks[ref] = mu
+		shard.locks[ref] = mu
 	}
-	r.m.Unlock()
+	shard.mu.Unlock()
 
 	return mu
 }
@@ -38,12 +38,20 @@ func TestRegistry(t *testing.T) {
 		t.Fail()
 	}
 
+	if r.Any("Test") != e || len(r.All("Test")) != 1 || len(r.AllReference("")) != 1 {
+		t.Error("type index")
+	}
+
 	r.Remove(SpoofContext(), ref)
 
 	if r.Get(ref) != nil {
 		t.Fail()
 	}
 
+	if r.Any("Test") != nil || len(r.All("Test")) != 0 || len(r.AllReference("")) != 0 {
+		t.Error("type index")
+	}
+
 	r.Put(e)
 	e = r.Get(ref)
 
@@ -44,6 +44,9 @@ func NewResourcePool() *ResourcePool {
 		ResourcePool: esx.ResourcePool,
 	}
 
+	summary := *esx.ResourcePool.Summary.GetResourcePoolSummary()
+	pool.Summary = &summary
+
 	if Map.IsVPX() {
 		pool.DisabledMethod = nil // Enable VApp methods for VC
 	}
@@ -86,7 +89,7 @@ func allResourceFieldsValid(info *types.ResourceAllocationInfo) bool {
 	return true
 }
 
-func (p *ResourcePool) createChild(name string, spec types.ResourceConfigSpec) (*ResourcePool, *soap.Fault) {
+func (p *ResourcePool) createChild(ctx *Context, name string, spec types.ResourceConfigSpec) (*ResourcePool, *soap.Fault) {
 	if e := Map.FindByName(name, p.ResourcePool.ResourcePool); e != nil {
 		return nil, Fault("", &types.DuplicateName{
 			Name:   e.Entity().Name,
@@ -106,6 +109,15 @@ func (p *ResourcePool) createChild(name string, spec types.ResourceConfigSpec) (
 		})
 	}
 
+	ctx.Map.reservationLock.Lock()
+	fault := admitReservation(ctx, &p.ResourcePool, func(kind string) int64 {
+		return reservation(&spec, kind)
+	})
+	ctx.Map.reservationLock.Unlock()
+	if fault != nil {
+		return nil, Fault("", fault)
+	}
+
 	child := NewResourcePool()
 
 	child.Name = name
@@ -118,10 +130,10 @@ func (p *ResourcePool) createChild(name string, spec types.ResourceConfigSpec) (
 	return child, nil
 }
 
-func (p *ResourcePool) CreateResourcePool(c *types.CreateResourcePool) soap.HasFault {
+func (p *ResourcePool) CreateResourcePool(ctx *Context, c *types.CreateResourcePool) soap.HasFault {
 	body := &methods.CreateResourcePoolBody{}
 
-	child, err := p.createChild(c.Name, c.Spec)
+	child, err := p.createChild(ctx, c.Name, c.Spec)
 	if err != nil {
 		body.Fault_ = err
 		return body
@@ -130,6 +142,7 @@ func (p *ResourcePool) CreateResourcePool(c *types.CreateResourcePool) soap.HasF
 	Map.PutEntity(p, Map.NewEntity(child))
 
 	p.ResourcePool.ResourcePool = append(p.ResourcePool.ResourcePool, child.Reference())
+	updateResourcePoolRuntime(ctx, p.Self)
 
 	body.Res = &types.CreateResourcePoolResponse{
 		Returnval: child.Reference(),
@@ -153,6 +166,10 @@ func updateResourceAllocation(kind string, src, dst *types.ResourceAllocationInf
 		dst.Limit = src.Limit
 	}
 
+	if src.ExpandableReservation != nil {
+		dst.ExpandableReservation = src.ExpandableReservation
+	}
+
 	if src.Shares != nil {
 		dst.Shares = src.Shares
 	}
@@ -160,7 +177,7 @@ func updateResourceAllocation(kind string, src, dst *types.ResourceAllocationInf
 	return nil
 }
 
-func (p *ResourcePool) UpdateConfig(c *types.UpdateConfig) soap.HasFault {
+func (p *ResourcePool) UpdateConfig(ctx *Context, c *types.UpdateConfig) soap.HasFault {
 	body := &methods.UpdateConfigBody{}
 
 	if c.Name != "" {
@@ -178,15 +195,31 @@ func (p *ResourcePool) UpdateConfig(c *types.UpdateConfig) soap.HasFault {
 	spec := c.Config
 
 	if spec != nil {
-		if err := updateResourceAllocation("memory", &spec.MemoryAllocation, &p.Config.MemoryAllocation); err != nil {
+		config := p.Config
+
+		if err := updateResourceAllocation("memory", &spec.MemoryAllocation, &config.MemoryAllocation); err != nil {
 			body.Fault_ = Fault("", err)
 			return body
 		}
 
-		if err := updateResourceAllocation("cpu", &spec.CpuAllocation, &p.Config.CpuAllocation); err != nil {
+		if err := updateResourceAllocation("cpu", &spec.CpuAllocation, &config.CpuAllocation); err != nil {
 			body.Fault_ = Fault("", err)
 			return body
 		}
+
+		prev := p.Config
+		ctx.Map.reservationLock.Lock()
+		fault := p.admitConfig(ctx, &config)
+		if fault == nil {
+			p.Config.MemoryAllocation = config.MemoryAllocation
+			p.Config.CpuAllocation = config.CpuAllocation
+			updateResourcePoolUsage(ctx, &p.ResourcePool, &prev)
+		}
+		ctx.Map.reservationLock.Unlock()
+		if fault != nil {
+			body.Fault_ = Fault("", fault)
+			return body
+		}
 	}
 
 	body.Res = &types.UpdateConfigResponse{}
@@ -194,6 +227,360 @@ func (p *ResourcePool) UpdateConfig(c *types.UpdateConfig) soap.HasFault {
 	return body
 }
 
+// isPowerStateChange returns true if the given VirtualMachine changes include its power state.
+func isPowerStateChange(changes []types.PropertyChange) bool {
+	for _, change := range changes {
+		if change.Name == "runtime.powerState" {
+			return true
+		}
+	}
+	return false
+}
+
+// resourceKinds are the resource types subject to admission control, as named by updateResourceAllocation.
+var resourceKinds = []string{"cpu", "memory"}
+
+// resourceAllocation returns the allocation of the given kind.
+func resourceAllocation(spec *types.ResourceConfigSpec, kind string) *types.ResourceAllocationInfo {
+	if kind == "cpu" {
+		return &spec.CpuAllocation
+	}
+	return &spec.MemoryAllocation
+}
+
+// reservation returns the reservation of the given kind, in MHz for cpu and MB for memory.
+func reservation(spec *types.ResourceConfigSpec, kind string) int64 {
+	if r := resourceAllocation(spec, kind).Reservation; r != nil {
+		return *r
+	}
+	return 0
+}
+
+// vmReservation returns the reservation of the given kind held by a powered on VM.
+func vmReservation(vm *VirtualMachine, kind string) int64 {
+	info := vm.Config.CpuAllocation
+	if kind == "memory" {
+		info = vm.Config.MemoryAllocation
+	}
+	if info == nil || info.Reservation == nil {
+		return 0
+	}
+	return *info.Reservation
+}
+
+func getResourcePool(ctx *Context, ref types.ManagedObjectReference) *mo.ResourcePool {
+	obj := ctx.Map.Get(ref)
+	if obj == nil {
+		return nil
+	}
+	pool, _ := asResourcePoolMO(obj)
+	return pool
+}
+
+// parentResourcePool returns the parent of the given pool, or nil for a root pool.
+func parentResourcePool(ctx *Context, pool *mo.ResourcePool) *mo.ResourcePool {
+	if pool.Parent == nil || strings.HasSuffix(pool.Parent.Type, "ComputeResource") {
+		return nil
+	}
+	return getResourcePool(ctx, *pool.Parent)
+}
+
+// usageUnit returns the unit of runtime usage of the given kind, relative to MHz for cpu and MB for memory.
+func usageUnit(kind string) int64 {
+	if kind == "memory" {
+		return 1 << 20
+	}
+	return 1
+}
+
+// runtimeUsage returns the runtime usage of the given kind, as recorded by updateResourcePoolUsage.
+func runtimeUsage(pool *mo.ResourcePool, kind string) *types.ResourcePoolResourceUsage {
+	if kind == "cpu" {
+		return &pool.Runtime.Cpu
+	}
+	return &pool.Runtime.Memory
+}
+
+// reservationUsed returns the reservation used by the given pool in total, and by its powered on VMs,
+// as recorded in its runtime info by updateResourcePoolUsage.
+// The caller must hold ctx.Map.reservationLock.
+func reservationUsed(pool *mo.ResourcePool, kind string) (int64, int64) {
+	usage, unit := runtimeUsage(pool, kind), usageUnit(kind)
+	return usage.ReservationUsed / unit, usage.ReservationUsedForVm / unit
+}
+
+// reservationShare returns what a child pool with the given config and reservation used takes of its parent's reservation:
+// its own reservation, or more if it has borrowed from the parent via an expandable reservation.
+func reservationShare(config *types.ResourceConfigSpec, kind string, used int64) int64 {
+	if r := reservation(config, kind); r > used {
+		return r
+	}
+	return used
+}
+
+// poolUsage is the usage of a pool of one kind, in MHz for cpu and MB for memory.
+type poolUsage struct {
+	used, vms, unreserved, overall int64
+}
+
+// computePoolUsage computes the usage of the given pool from its own VMs, including those admitted by admitPowerOn
+// that have yet to power on, and the recorded usage of its child pools, rather than traversing its descendants.
+// The caller must hold ctx.Map.reservationLock.
+func computePoolUsage(ctx *Context, pool *mo.ResourcePool, kind string) poolUsage {
+	var usage poolUsage
+
+	for _, ref := range pool.Vm {
+		vm, ok := ctx.Map.Get(ref).(*VirtualMachine)
+		if !ok {
+			continue
+		}
+		_, poweringOn := ctx.Map.poweringOn[ref]
+		poweredOn := vm.Runtime.PowerState == types.VirtualMachinePowerStatePoweredOn
+		if poweringOn || poweredOn {
+			usage.vms += vmReservation(vm, kind)
+		}
+		if !poweredOn {
+			continue
+		}
+		if kind == "cpu" {
+			usage.overall += int64(vm.Summary.QuickStats.OverallCpuUsage)
+		} else {
+			usage.overall += int64(vm.Summary.QuickStats.HostMemoryUsage)
+		}
+	}
+
+	for _, ref := range pool.ResourcePool {
+		child := getResourcePool(ctx, ref)
+		if child == nil {
+			continue
+		}
+		used, _ := reservationUsed(child, kind)
+		usage.used += reservationShare(&child.Config, kind, used)
+		usage.overall += runtimeUsage(child, kind).OverallUsage / usageUnit(kind)
+	}
+
+	usage.used += usage.vms
+
+	return usage
+}
+
+// unreserved returns the reservation available to the given pool,
+// including what it can borrow from its ancestors when its reservation is expandable.
+func unreserved(ctx *Context, pool *mo.ResourcePool, kind string) int64 {
+	used, _ := reservationUsed(pool, kind)
+
+	available := reservation(&pool.Config, kind) - used
+	if available < 0 {
+		available = 0 // used beyond the reservation has been borrowed from the parent
+	}
+
+	if isTrue(resourceAllocation(&pool.Config, kind).ExpandableReservation) {
+		if parent := parentResourcePool(ctx, pool); parent != nil {
+			available += unreserved(ctx, parent, kind)
+		}
+	}
+
+	return available
+}
+
+func insufficientResources(kind string, unreserved, requested int64) types.BaseMethodFault {
+	if kind == "cpu" {
+		return &types.InsufficientCpuResourcesFault{Unreserved: unreserved, Requested: requested}
+	}
+	return &types.InsufficientMemoryResourcesFault{Unreserved: unreserved << 20, Requested: requested << 20}
+}
+
+// admitReservation returns an InsufficientResourcesFault if the given pool cannot admit
+// the additional reservation of each kind returned by requested.
+// The caller must hold ctx.Map.reservationLock.
+func admitReservation(ctx *Context, pool *mo.ResourcePool, requested func(kind string) int64) types.BaseMethodFault {
+	for _, kind := range resourceKinds {
+		need := requested(kind)
+		if need <= 0 {
+			continue
+		}
+		if available := unreserved(ctx, pool, kind); need > available {
+			return insufficientResources(kind, available, need)
+		}
+	}
+	return nil
+}
+
+// admitConfig validates a change of the pool's reservations to those of the given config.
+// The pool must be able to cover what its children and VMs use, unless its reservation is expandable,
+// and any increase in what the pool uses from its parent must be admitted by the parent.
+// The caller must hold ctx.Map.reservationLock.
+func (p *ResourcePool) admitConfig(ctx *Context, config *types.ResourceConfigSpec) types.BaseMethodFault {
+	parent := parentResourcePool(ctx, &p.ResourcePool)
+
+	for _, kind := range resourceKinds {
+		used, _ := reservationUsed(&p.ResourcePool, kind)
+		r := reservation(config, kind)
+
+		if r < used && (parent == nil || !isTrue(resourceAllocation(config, kind).ExpandableReservation)) {
+			return insufficientResources(kind, r, used)
+		}
+
+		if parent == nil {
+			continue
+		}
+
+		// what the pool uses from its parent before and after the change
+		before, after := reservation(&p.Config, kind), r
+		if used > before {
+			before = used
+		}
+		if used > after {
+			after = used
+		}
+
+		fault := admitReservation(ctx, parent, func(k string) int64 {
+			if k != kind {
+				return 0
+			}
+			return after - before
+		})
+		if fault != nil {
+			return fault
+		}
+	}
+
+	return nil
+}
+
+// resourcePoolUsage returns the runtime usage of the given kind, in MHz for cpu and bytes for memory.
+func resourcePoolUsage(ctx *Context, pool *mo.ResourcePool, kind string, usage poolUsage) types.ResourcePoolResourceUsage {
+	unit := usageUnit(kind)
+
+	return types.ResourcePoolResourceUsage{
+		ReservationUsed:      usage.used * unit,
+		ReservationUsedForVm: usage.vms * unit,
+		UnreservedForPool:    usage.unreserved * unit,
+		UnreservedForVm:      usage.unreserved * unit,
+		OverallUsage:         usage.overall * unit,
+		MaxUsage:             resourcePoolMaxUsage(ctx, pool, kind) * unit,
+	}
+}
+
+// resourcePoolMaxUsage returns the pool's limit, or that of its parent if unlimited.
+// The reservation of a root pool is the capacity of its compute resource.
+func resourcePoolMaxUsage(ctx *Context, pool *mo.ResourcePool, kind string) int64 {
+	limit := resourceAllocation(&pool.Config, kind).Limit
+	if limit != nil && *limit >= 0 {
+		return *limit
+	}
+
+	if parent := parentResourcePool(ctx, pool); parent != nil {
+		return resourcePoolMaxUsage(ctx, parent, kind)
+	}
+
+	return reservation(&pool.Config, kind)
+}
+
+// updateResourcePoolRuntime updates the runtime info of the given pool and its ancestors
+// after a change to the pool's VMs or child pools, see updateResourcePoolUsage.
+func updateResourcePoolRuntime(ctx *Context, ref types.ManagedObjectReference) {
+	ctx.Map.reservationLock.Lock()
+	defer ctx.Map.reservationLock.Unlock()
+
+	if pool := getResourcePool(ctx, ref); pool != nil {
+		updateResourcePoolUsage(ctx, pool, &pool.Config)
+	}
+}
+
+// updateResourcePoolUsage recomputes the runtime info of the given pool, whose config was prev before the change,
+// then walks up its ancestors, adjusting the recorded usage of each by the change in what its child takes of its
+// reservation, such that the cost of an update depends on the pool's own VMs and depth rather than the hierarchy.
+// Pools off the path to the root are not updated, so a pool that borrows from an ancestor via an expandable
+// reservation has its unreserved values refreshed by its own next update.
+// The caller must hold ctx.Map.reservationLock.
+func updateResourcePoolUsage(ctx *Context, pool *mo.ResourcePool, prev *types.ResourceConfigSpec) {
+	if pending := ctx.Map.pendingPools; pending != nil {
+		pending[pool.Self] = struct{}{}
+		return
+	}
+
+	path := []*mo.ResourcePool{pool}
+	for parent := parentResourcePool(ctx, pool); parent != nil; parent = parentResourcePool(ctx, parent) {
+		path = append(path, parent)
+	}
+
+	usage := make([]map[string]poolUsage, len(path))
+	for i := range usage {
+		usage[i] = make(map[string]poolUsage, len(resourceKinds))
+	}
+
+	for _, kind := range resourceKinds {
+		unit := usageUnit(kind)
+
+		next := computePoolUsage(ctx, pool, kind)
+		used, _ := reservationUsed(pool, kind)
+		share := reservationShare(&pool.Config, kind, next.used) - reservationShare(prev, kind, used)
+		overall := next.overall - runtimeUsage(pool, kind).OverallUsage/unit
+		usage[0][kind] = next
+
+		for i, p := range path[1:] {
+			used, vms := reservationUsed(p, kind)
+			next := poolUsage{
+				used:    used + share,
+				vms:     vms,
+				overall: runtimeUsage(p, kind).OverallUsage/unit + overall,
+			}
+			share = reservationShare(&p.Config, kind, next.used) - reservationShare(&p.Config, kind, used)
+			usage[i+1][kind] = next
+		}
+
+		// what is available to each pool depends on what is available to its parent
+		for i := len(path) - 1; i >= 0; i-- {
+			p, u := path[i], usage[i][kind]
+			u.unreserved = reservation(&p.Config, kind) - u.used
+			if u.unreserved < 0 {
+				u.unreserved = 0 // used beyond the reservation has been borrowed from the parent
+			}
+			if i+1 < len(path) && isTrue(resourceAllocation(&p.Config, kind).ExpandableReservation) {
+				u.unreserved += usage[i+1][kind].unreserved
+			}
+			usage[i][kind] = u
+		}
+	}
+
+	for i, p := range path {
+		runtime := types.ResourcePoolRuntimeInfo{
+			Cpu:           resourcePoolUsage(ctx, p, "cpu", usage[i]["cpu"]),
+			Memory:        resourcePoolUsage(ctx, p, "memory", usage[i]["memory"]),
+			OverallStatus: p.Runtime.OverallStatus,
+		}
+
+		summary := *p.Summary.GetResourcePoolSummary()
+		summary.Runtime = runtime
+
+		ctx.Map.Update(ctx.Map.Get(p.Self), []types.PropertyChange{
+			{Name: "runtime", Val: runtime},
+			{Name: "summary", Val: &summary},
+		})
+	}
+}
+
+// deferResourcePoolRuntime defers updateResourcePoolRuntime until the returned func is called,
+// which then updates each affected pool once, rather than once per change.
+// Used by the Model when creating a large number of VMs.
+func deferResourcePoolRuntime(ctx *Context) func() {
+	ctx.Map.reservationLock.Lock()
+	ctx.Map.pendingPools = make(map[types.ManagedObjectReference]struct{})
+	ctx.Map.reservationLock.Unlock()
+
+	return func() {
+		ctx.Map.reservationLock.Lock()
+		pending := ctx.Map.pendingPools
+		ctx.Map.pendingPools = nil
+		ctx.Map.reservationLock.Unlock()
+
+		for ref := range pending {
+			updateResourcePoolRuntime(ctx, ref)
+		}
+	}
+}
+
 func (a *VirtualApp) ImportVApp(ctx *Context, req *types.ImportVApp) soap.HasFault {
 	return (&ResourcePool{ResourcePool: a.ResourcePool}).ImportVApp(ctx, req)
 }
@@ -344,10 +731,10 @@ func NewVAppConfigSpec() types.VAppConfigSpec {
 	return spec
 }
 
-func (p *ResourcePool) CreateVApp(req *types.CreateVApp) soap.HasFault {
+func (p *ResourcePool) CreateVApp(ctx *Context, req *types.CreateVApp) soap.HasFault {
 	body := &methods.CreateVAppBody{}
 
-	pool, err := p.createChild(req.Name, req.ResSpec)
+	pool, err := p.createChild(ctx, req.Name, req.ResSpec)
 	if err != nil {
 		body.Fault_ = err
 		return body
@@ -375,6 +762,7 @@ func (p *ResourcePool) CreateVApp(req *types.CreateVApp) soap.HasFault {
 	Map.PutEntity(p, Map.NewEntity(child))
 
 	p.ResourcePool.ResourcePool = append(p.ResourcePool.ResourcePool, child.Reference())
+	updateResourcePoolRuntime(ctx, p.Self)
 
 	body.Res = &types.CreateVAppResponse{
 		Returnval: child.Reference(),
@@ -415,7 +803,7 @@ func (a *VirtualApp) CloneVAppTask(ctx *Context, req *types.CloneVApp_Task) soap
 			rspec = &s
 		}
 
-		res := a.CreateVApp(&types.CreateVApp{
+		res := a.CreateVApp(ctx, &types.CreateVApp{
 			This:       a.Self,
 			Name:       req.Name,
 			ResSpec:    *rspec,
@@ -461,8 +849,44 @@ func (a *VirtualApp) CloneVAppTask(ctx *Context, req *types.CloneVApp_Task) soap
 	}
 }
 
-func (a *VirtualApp) CreateVApp(req *types.CreateVApp) soap.HasFault {
-	return (&ResourcePool{ResourcePool: a.ResourcePool}).CreateVApp(req)
+func (a *VirtualApp) ExportVApp(ctx *Context, req *types.ExportVApp) soap.HasFault {
+	body := new(methods.ExportVAppBody)
+
+	for _, ref := range a.Vm {
+		vm := ctx.Map.Get(ref).(*VirtualMachine)
+		if vm.Runtime.PowerState == types.VirtualMachinePowerStatePoweredOn {
+			body.Fault_ = Fault("", &types.InvalidPowerState{
+				RequestedState: types.VirtualMachinePowerStatePoweredOff,
+				ExistingState:  vm.Runtime.PowerState,
+			})
+			return body
+		}
+	}
+
+	lease := newHttpNfcLease(ctx)
+	ref := lease.Reference()
+
+	CreateTask(a, "ExportVAppLRO", func(*Task) (types.AnyType, types.BaseMethodFault) {
+		var urls []types.HttpNfcLeaseDeviceUrl
+
+		for _, ref := range a.Vm {
+			vm := ctx.Map.Get(ref).(*VirtualMachine)
+			urls = append(urls, lease.export(vm, vm.Config.Hardware.Device, vm.Name+"-")...)
+		}
+
+		lease.ready(ctx, a.Self, urls)
+		return nil, nil
+	}).Run(ctx)
+
+	body.Res = &types.ExportVAppResponse{
+		Returnval: ref,
+	}
+
+	return body
+}
+
+func (a *VirtualApp) CreateVApp(ctx *Context, req *types.CreateVApp) soap.HasFault {
+	return (&ResourcePool{ResourcePool: a.ResourcePool}).CreateVApp(ctx, req)
 }
 
 func (a *VirtualApp) DestroyTask(ctx *Context, req *types.Destroy_Task) soap.HasFault {
@@ -502,6 +926,7 @@ func (p *ResourcePool) DestroyTask(ctx *Context, req *types.Destroy_Task) soap.H
 		})
 
 		ctx.Map.Remove(ctx, req.This)
+		updateResourcePoolRuntime(ctx, parent.Self)
 
 		return nil, nil
 	})
@@ -27,6 +27,8 @@ import (
 	"github.com/zhengkes/govmomi/object"
 	"github.com/zhengkes/govmomi/property"
 	"github.com/zhengkes/govmomi/simulator/esx"
+	"github.com/zhengkes/govmomi/task"
+	"github.com/zhengkes/govmomi/vim25"
 	"github.com/zhengkes/govmomi/vim25/mo"
 	"github.com/zhengkes/govmomi/vim25/soap"
 	"github.com/zhengkes/govmomi/vim25/types"
@@ -359,3 +361,218 @@ func TestResourcePoolValidation(t *testing.T) {
 		}
 	}
 }
+
+func TestResourcePoolAdmission(t *testing.T) {
+	err := ESX().Run(func(ctx context.Context, c *vim25.Client) error {
+		finder := find.NewFinder(c)
+		root, err := finder.DefaultResourcePool(ctx)
+		if err != nil {
+			return err
+		}
+		vm, err := finder.VirtualMachine(ctx, "ha-host_VM0")
+		if err != nil {
+			return err
+		}
+
+		spec := func(mem int64, expandable bool) types.ResourceConfigSpec {
+			spec := types.DefaultResourceConfigSpec()
+			spec.MemoryAllocation.Reservation = types.NewInt64(mem)
+			spec.MemoryAllocation.ExpandableReservation = types.NewBool(expandable)
+			return spec
+		}
+
+		isInsufficient := func(err error) {
+			t.Helper()
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			var fault interface{}
+			if terr, ok := err.(task.Error); ok {
+				fault = terr.Fault()
+			} else {
+				fault = soap.ToSoapFault(err).VimFault()
+			}
+			switch fault.(type) {
+			case types.InsufficientMemoryResourcesFault, *types.InsufficientMemoryResourcesFault:
+			default:
+				t.Fatalf("unexpected error: %s", err)
+			}
+		}
+
+		runtime := func(pool *object.ResourcePool) types.ResourcePoolResourceUsage {
+			t.Helper()
+			var p mo.ResourcePool
+			if err := pool.Properties(ctx, pool.Reference(), []string{"runtime"}, &p); err != nil {
+				t.Fatal(err)
+			}
+			return p.Runtime.Memory
+		}
+
+		wait := func(task *object.Task, err error) error {
+			if err != nil {
+				return err
+			}
+			return task.Wait(ctx)
+		}
+
+		// the root pool has a memory reservation of 961MB
+		a, err := root.Create(ctx, "a", spec(512, false))
+		if err != nil {
+			return err
+		}
+
+		_, err = root.Create(ctx, "b", spec(512, false))
+		isInsufficient(err)
+
+		b, err := root.Create(ctx, "b", spec(0, true))
+		if err != nil {
+			return err
+		}
+
+		s := spec(400, true)
+		if err = b.UpdateConfig(ctx, "", &s); err != nil {
+			return err
+		}
+		s = spec(500, true)
+		isInsufficient(b.UpdateConfig(ctx, "", &s))
+
+		if used := runtime(root).ReservationUsed; used != (512+400)<<20 {
+			t.Errorf("root reservationUsed=%d", used)
+		}
+
+		// vm reservations are admitted on power on
+		if err = wait(vm.PowerOff(ctx)); err != nil {
+			return err
+		}
+		ref := a.Reference()
+		if err = wait(vm.Relocate(ctx, types.VirtualMachineRelocateSpec{Pool: &ref}, "")); err != nil {
+			return err
+		}
+
+		reserve := func(mem int64) error {
+			return wait(vm.Reconfigure(ctx, types.VirtualMachineConfigSpec{
+				MemoryAllocation: &types.ResourceAllocationInfo{Reservation: types.NewInt64(mem)},
+			}))
+		}
+
+		if err = reserve(600); err != nil {
+			return err
+		}
+		isInsufficient(wait(vm.PowerOn(ctx)))
+
+		if err = reserve(256); err != nil {
+			return err
+		}
+		if err = wait(vm.PowerOn(ctx)); err != nil {
+			return err
+		}
+
+		usage := runtime(a)
+		if usage.ReservationUsedForVm != 256<<20 || usage.UnreservedForVm != (512-256)<<20 {
+			t.Errorf("usage=%#v", usage)
+		}
+
+		// the pool reservation cannot shrink below what its vms use
+		s = spec(128, false)
+		isInsufficient(a.UpdateConfig(ctx, "", &s))
+
+		if err = wait(vm.PowerOff(ctx)); err != nil {
+			return err
+		}
+		if used := runtime(a).ReservationUsedForVm; used != 0 {
+			t.Errorf("reservationUsedForVm=%d", used)
+		}
+		if err = a.UpdateConfig(ctx, "", &s); err != nil {
+			return err
+		}
+
+		// concurrent power on requests cannot over-commit the pool
+		vm1, err := finder.VirtualMachine(ctx, "ha-host_VM1")
+		if err != nil {
+			return err
+		}
+		if err = wait(vm1.PowerOff(ctx)); err != nil {
+			return err
+		}
+		if err = wait(vm1.Relocate(ctx, types.VirtualMachineRelocateSpec{Pool: &ref}, "")); err != nil {
+			return err
+		}
+		if err = wait(vm1.Reconfigure(ctx, types.VirtualMachineConfigSpec{
+			MemoryAllocation: &types.ResourceAllocationInfo{Reservation: types.NewInt64(100)},
+		})); err != nil {
+			return err
+		}
+		if err = reserve(100); err != nil {
+			return err
+		}
+
+		var tasks []*object.Task
+		for _, v := range []*object.VirtualMachine{vm, vm1} {
+			task, err := v.PowerOn(ctx)
+			if err != nil {
+				return err
+			}
+			tasks = append(tasks, task)
+		}
+
+		failed := 0
+		for _, task := range tasks {
+			if err = task.Wait(ctx); err != nil {
+				isInsufficient(err)
+				failed++
+			}
+		}
+		if failed != 1 {
+			t.Errorf("failed=%d", failed)
+		}
+
+		// the usage of a nested pool is recorded by its ancestors
+		for _, v := range []*object.VirtualMachine{vm, vm1} {
+			state, err := v.PowerState(ctx)
+			if err != nil {
+				return err
+			}
+			if state == types.VirtualMachinePowerStatePoweredOn {
+				if err = wait(v.PowerOff(ctx)); err != nil {
+					return err
+				}
+			}
+		}
+		c1, err := b.Create(ctx, "c", spec(0, true))
+		if err != nil {
+			return err
+		}
+		ref = c1.Reference()
+		if err = wait(vm.Relocate(ctx, types.VirtualMachineRelocateSpec{Pool: &ref}, "")); err != nil {
+			return err
+		}
+		if err = reserve(450); err != nil {
+			return err
+		}
+		if err = wait(vm.PowerOn(ctx)); err != nil {
+			return err
+		}
+
+		expect := map[*object.ResourcePool]int64{c1: 450, b: 450, root: 128 + 450}
+		for pool, used := range expect {
+			if usage := runtime(pool).ReservationUsed; usage != used<<20 {
+				t.Errorf("%s reservationUsed=%d", pool.Reference(), usage)
+			}
+		}
+
+		if err = wait(vm.PowerOff(ctx)); err != nil {
+			return err
+		}
+		expect = map[*object.ResourcePool]int64{c1: 0, b: 0, root: 128 + 400}
+		for pool, used := range expect {
+			if usage := runtime(pool).ReservationUsed; usage != used<<20 {
+				t.Errorf("%s reservationUsed=%d", pool.Reference(), usage)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,527 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/zhengkes/govmomi/vim25/methods"
+	"github.com/zhengkes/govmomi/vim25/mo"
+	"github.com/zhengkes/govmomi/vim25/types"
+)
+
+// Scenario is a timeline of steps played back by the Service, such as to reproduce an incident.
+// Scenarios are encoded as YAML or JSON, for example:
+//
+//	name: host failure
+//	steps:
+//	  - at: 30s
+//	    target: DC0_C0_H1
+//	    action: DisconnectHost_Task
+//	  - at: 45s
+//	    target: /DC0/vm/DC0_C0_RP0_VM0
+//	    action: PowerOffVM_Task
+//	  - at: 1m
+//	    target: LocalDS_0
+//	    action: datastore.fill
+//	    args: {percent: 95}
+//	  - at: 1m
+//	    target: DC0_C0_H1
+//	    action: event.post
+//	    args: {message: datastore is almost full}
+type Scenario struct {
+	Name  string         `yaml:"name,omitempty"`
+	Steps []ScenarioStep `yaml:"steps"`
+}
+
+// ScenarioStep applies an action to an inventory object at a time relative to the start of the Scenario.
+type ScenarioStep struct {
+	// At is the time of the step relative to the start of playback, such as "30s".
+	At time.Duration `yaml:"at"`
+	// Target is the object's inventory path, name or reference, such as "/DC0/host/DC0_C0/DC0_C0_H1",
+	// "DC0_C0_H1" or "HostSystem:host-21".
+	Target string `yaml:"target"`
+	// Action is either the name of a ScenarioActions entry or the name of a method to invoke on the target,
+	// such as "PowerOffVM_Task". When the method returns a Task, the step completes when the Task is complete.
+	Action string `yaml:"action"`
+	// Args are the parameters of a ScenarioActions entry,
+	// or the method request fields in the vim25 JSON encoding, such as {"host": {"_typeName": "ManagedObjectReference", ...}}.
+	Args map[string]interface{} `yaml:"args,omitempty"`
+}
+
+// ScenarioAction applies a step to the given object.
+type ScenarioAction func(ctx *Context, obj mo.Reference, args map[string]interface{}) error
+
+// ScenarioActions are simulator operations that can be used as a ScenarioStep action, in addition to vSphere API methods.
+// Custom actions can be added before a Scenario is loaded.
+var ScenarioActions = map[string]ScenarioAction{
+	// host.fail fails a HostSystem, see HostSystem.Fail
+	"host.fail": func(ctx *Context, obj mo.Reference, _ map[string]interface{}) error {
+		host, ok := obj.(*HostSystem)
+		if !ok {
+			return fmt.Errorf("%s is not a HostSystem", obj.Reference())
+		}
+		ctx.WithLock(host, func() {
+			host.Fail(ctx)
+		})
+		return nil
+	},
+	// host.connection changes a HostSystem's connection state to the given state: connected, disconnected or notResponding
+	"host.connection": func(ctx *Context, obj mo.Reference, args map[string]interface{}) error {
+		host, ok := obj.(*HostSystem)
+		if !ok {
+			return fmt.Errorf("%s is not a HostSystem", obj.Reference())
+		}
+		state, _ := args["state"].(string)
+		switch types.HostSystemConnectionState(state) {
+		case types.HostSystemConnectionStateConnected:
+			return invokeTask(ctx, host.Self, new(types.ReconnectHost_Task))
+		case types.HostSystemConnectionStateDisconnected:
+			return invokeTask(ctx, host.Self, new(types.DisconnectHost_Task))
+		case types.HostSystemConnectionStateNotResponding:
+			ctx.WithLock(host, func() {
+				host.Fail(ctx)
+			})
+			return nil
+		default:
+			return fmt.Errorf("host.connection: invalid state %q", state)
+		}
+	},
+	// host.add adds a HostSystem with the given name to a ClusterComputeResource or host Folder
+	"host.add": func(ctx *Context, obj mo.Reference, args map[string]interface{}) error {
+		name, _ := args["name"].(string)
+		if name == "" {
+			return errors.New("host.add: name is required")
+		}
+		spec := types.HostConnectSpec{HostName: name}
+		switch obj := obj.(type) {
+		case *ClusterComputeResource:
+			return invokeTask(ctx, obj.Self, &types.AddHost_Task{Spec: spec, AsConnected: true})
+		case *Folder:
+			return invokeTask(ctx, obj.Self, &types.AddStandaloneHost_Task{Spec: spec, AddConnected: true})
+		default:
+			return fmt.Errorf("%s is not a ClusterComputeResource or Folder", obj.Reference())
+		}
+	},
+	// vm.create creates a VirtualMachine with the given name on a HostSystem or ClusterComputeResource,
+	// using the first datastore of the host or cluster
+	"vm.create": func(ctx *Context, obj mo.Reference, args map[string]interface{}) error {
+		name, _ := args["name"].(string)
+		if name == "" {
+			return errors.New("vm.create: name is required")
+		}
+		req := &types.CreateVM_Task{
+			Config: types.VirtualMachineConfigSpec{
+				Name:    name,
+				GuestId: string(types.VirtualMachineGuestOsIdentifierOtherGuest),
+			},
+		}
+		var ds []types.ManagedObjectReference
+		switch obj := obj.(type) {
+		case *HostSystem:
+			req.Pool = *hostParent(&obj.HostSystem).ResourcePool
+			req.Host = &obj.Self
+			ds = obj.Datastore
+		case *ClusterComputeResource:
+			req.Pool = *obj.ResourcePool
+			ds = obj.Datastore
+		default:
+			return fmt.Errorf("%s is not a HostSystem or ClusterComputeResource", obj.Reference())
+		}
+		if len(ds) == 0 {
+			return fmt.Errorf("vm.create: %s has no datastore", obj.Reference())
+		}
+		req.Config.Files = &types.VirtualMachineFileInfo{
+			VmPathName: fmt.Sprintf("[%s]", ctx.Map.Get(ds[0]).(*Datastore).Name),
+		}
+		dc := ctx.Map.getEntityDatacenter(obj.(mo.Entity))
+		return invokeTask(ctx, dc.VmFolder, req)
+	},
+	// vm.power changes a VirtualMachine's power state to the given state: poweredOn, poweredOff or suspended
+	"vm.power": func(ctx *Context, obj mo.Reference, args map[string]interface{}) error {
+		vm, ok := obj.(*VirtualMachine)
+		if !ok {
+			return fmt.Errorf("%s is not a VirtualMachine", obj.Reference())
+		}
+		state, _ := args["state"].(string)
+		switch types.VirtualMachinePowerState(state) {
+		case types.VirtualMachinePowerStatePoweredOn:
+			return invokeTask(ctx, vm.Self, new(types.PowerOnVM_Task))
+		case types.VirtualMachinePowerStatePoweredOff:
+			return invokeTask(ctx, vm.Self, new(types.PowerOffVM_Task))
+		case types.VirtualMachinePowerStateSuspended:
+			return invokeTask(ctx, vm.Self, new(types.SuspendVM_Task))
+		default:
+			return fmt.Errorf("vm.power: invalid state %q", state)
+		}
+	},
+	// datastore.fill changes a Datastore's free space, such that the given percent of its capacity is used
+	"datastore.fill": func(ctx *Context, obj mo.Reference, args map[string]interface{}) error {
+		ds, ok := obj.(*Datastore)
+		if !ok {
+			return fmt.Errorf("%s is not a Datastore", obj.Reference())
+		}
+		var percent float64
+		switch val := args["percent"].(type) {
+		case int:
+			percent = float64(val)
+		case float64:
+			percent = val
+		default:
+			percent = -1
+		}
+		if percent < 0 || percent > 100 {
+			return fmt.Errorf("datastore.fill: invalid percent %v", args["percent"])
+		}
+		ctx.WithLock(ds, func() {
+			free := int64(float64(ds.Summary.Capacity) * (100 - percent) / 100)
+			ds.Info.GetDatastoreInfo().FreeSpace = free
+			ctx.Map.Update(ds, []types.PropertyChange{{Name: "summary.freeSpace", Val: free}})
+		})
+		return nil
+	},
+	// event.post posts an event of the given type, GeneralUserEvent by default, with the given message and the object as its entity
+	"event.post": func(ctx *Context, obj mo.Reference, args map[string]interface{}) error {
+		name, _ := args["type"].(string)
+		if name == "" {
+			name = "GeneralUserEvent"
+		}
+		kind, ok := types.TypeFunc()(name)
+		if !ok {
+			return fmt.Errorf("event.post: unknown type %q", name)
+		}
+		event, ok := reflect.New(kind).Interface().(types.BaseEvent)
+		if !ok {
+			return fmt.Errorf("event.post: %s is not an Event", name)
+		}
+
+		message, _ := args["message"].(string)
+		switch e := event.(type) {
+		case types.BaseGeneralEvent:
+			e.GetGeneralEvent().Message = message
+		case *types.EventEx:
+			e.Message = message
+			e.EventTypeId, _ = args["eventTypeId"].(string)
+			e.Severity, _ = args["severity"].(string)
+		}
+
+		e := event.GetEvent()
+		e.FullFormattedMessage = message
+		switch obj := obj.(type) {
+		case *VirtualMachine:
+			ve := obj.event()
+			e.Vm, e.Host, e.ComputeResource, e.Datacenter = ve.Vm, ve.Host, ve.ComputeResource, ve.Datacenter
+		case *HostSystem:
+			he := obj.event()
+			e.Host, e.ComputeResource, e.Datacenter = he.Host, he.ComputeResource, he.Datacenter
+		case *Datastore:
+			e.Ds = obj.eventArgument()
+		}
+
+		ctx.postEvent(event)
+		return nil
+	},
+}
+
+// LoadScenario decodes a Scenario encoded as YAML or JSON from the given reader.
+// Steps are sorted by their time, steps with the same time are applied in the given order.
+func LoadScenario(r io.Reader) (*Scenario, error) {
+	s := new(Scenario)
+
+	if err := yaml.NewDecoder(r).Decode(s); err != nil {
+		return nil, fmt.Errorf("decoding scenario: %s", err)
+	}
+
+	for i, step := range s.Steps {
+		if step.Target == "" {
+			return nil, fmt.Errorf("scenario step %d: target is required", i)
+		}
+		if ScenarioActions[step.Action] == nil {
+			if _, ok := types.TypeFunc()(step.Action); !ok {
+				return nil, fmt.Errorf("scenario step %d: unknown action %q", i, step.Action)
+			}
+		}
+	}
+
+	sort.SliceStable(s.Steps, func(i, j int) bool {
+		return s.Steps[i].At < s.Steps[j].At
+	})
+
+	return s, nil
+}
+
+// LoadScenarioFile decodes a Scenario from the given file, see LoadScenario.
+func LoadScenarioFile(name string) (*Scenario, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return LoadScenario(f)
+}
+
+// target returns the object referred to by the given inventory path, name or reference.
+func (s *ScenarioStep) target(ctx *Context) (mo.Reference, error) {
+	if strings.HasPrefix(s.Target, "/") {
+		req := &types.FindByInventoryPath{InventoryPath: s.Target}
+		res := ctx.Map.SearchIndex().FindByInventoryPath(req).(*methods.FindByInventoryPathBody).Res
+		if res.Returnval == nil {
+			return nil, fmt.Errorf("%s not found", s.Target)
+		}
+		return ctx.Map.Get(*res.Returnval), nil
+	}
+
+	var ref types.ManagedObjectReference
+	if ref.FromString(s.Target) {
+		if obj := ctx.Map.Get(ref); obj != nil {
+			return obj, nil
+		}
+	}
+
+	var match []mo.Entity
+	for _, e := range ctx.Map.All("") {
+		if entityName(e) == s.Target {
+			match = append(match, e)
+		}
+	}
+
+	switch len(match) {
+	case 0:
+		return nil, fmt.Errorf("%s not found", s.Target)
+	case 1:
+		return match[0], nil
+	default:
+		return nil, fmt.Errorf("%s matches %d objects", s.Target, len(match))
+	}
+}
+
+// apply runs the step's action on its target.
+func (s *ScenarioStep) apply(ctx *Context) error {
+	obj, err := s.target(ctx)
+	if err != nil {
+		return err
+	}
+
+	if action := ScenarioActions[s.Action]; action != nil {
+		return action(ctx, obj, s.Args)
+	}
+
+	rtype, _ := types.TypeFunc()(s.Action)
+	req := reflect.New(rtype)
+	if len(s.Args) != 0 {
+		b, err := json.Marshal(s.Args)
+		if err != nil {
+			return err
+		}
+		if err = types.NewJSONDecoder(bytes.NewReader(b)).Decode(req.Interface()); err != nil {
+			return fmt.Errorf("%s args: %s", s.Action, err)
+		}
+	}
+
+	return invokeTask(ctx, obj.Reference(), req.Interface())
+}
+
+// invokeTask invokes the method of the given request type on the given object,
+// waiting for completion if the method returns a Task.
+func invokeTask(ctx *Context, this types.ManagedObjectReference, req interface{}) error {
+	name := reflect.TypeOf(req).Elem().Name()
+
+	res, fault := invokeMethod(ctx, this, name, reflect.ValueOf(req))
+	if fault != nil {
+		return fmt.Errorf("%s: %T", name, fault)
+	}
+
+	if ref, ok := res.(types.ManagedObjectReference); ok {
+		if task, ok := ctx.Map.Get(ref).(*Task); ok {
+			task.Wait()
+			var err *types.LocalizedMethodFault
+			ctx.WithLock(task, func() { err = task.Info.Error })
+			if err != nil {
+				return fmt.Errorf("%s: %T", name, err.Fault)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ScenarioPlayback applies the steps of a Scenario when they are due according to the Service Clock.
+// When the Clock is frozen, due steps are only applied when the Clock is changed.
+type ScenarioPlayback struct {
+	scenario *Scenario
+	ctx      *Context
+	start    time.Time
+
+	mu      sync.Mutex
+	next    int
+	timer   *time.Timer
+	cancel  func() // removes the OnChange registration of the Clock
+	stopped bool
+	errs    []error
+	done    chan struct{}
+}
+
+// PlayScenario starts playback of the given Scenario, relative to the current time of the Service Clock.
+// Steps that fail are logged, and playback continues with the next step.
+// Playback is stopped when the given context is done.
+func (s *Service) PlayScenario(ctx context.Context, scenario *Scenario) *ScenarioPlayback {
+	sctx := SpoofContext()
+	sctx.svc = s
+
+	p := &ScenarioPlayback{
+		scenario: scenario,
+		ctx:      sctx,
+		start:    sctx.Now(),
+		done:     make(chan struct{}),
+	}
+
+	p.mu.Lock()
+	p.cancel = sctx.Clock().OnChange(func(time.Time) { p.run() })
+	p.mu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			p.Stop()
+		case <-p.done:
+		}
+	}()
+	go p.run()
+
+	return p
+}
+
+// run applies the steps that are due and arms a timer for the next step, if any.
+func (p *ScenarioPlayback) run() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopped {
+		return
+	}
+
+	clock := p.ctx.Clock()
+	steps := p.scenario.Steps
+
+	for p.next < len(steps) {
+		step := &steps[p.next]
+		due := p.start.Add(step.At)
+		now := clock.Now()
+
+		if due.After(now) {
+			if p.timer != nil {
+				p.timer.Stop()
+				p.timer = nil
+			}
+			if !clock.Frozen() {
+				p.timer = time.AfterFunc(due.Sub(now), p.run)
+			}
+			return
+		}
+
+		p.next++
+		if err := step.apply(p.ctx); err != nil {
+			err = fmt.Errorf("scenario %q step %d (%s %s): %s", p.scenario.Name, p.next-1, step.Action, step.Target, err)
+			log.Print(err)
+			p.errs = append(p.errs, err)
+		}
+	}
+
+	p.stop()
+}
+
+func (p *ScenarioPlayback) stop() {
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	if p.cancel != nil {
+		p.cancel()
+		p.cancel = nil
+	}
+	if !p.stopped {
+		p.stopped = true
+		close(p.done)
+	}
+}
+
+// Stop ends playback, any remaining steps are not applied.
+func (p *ScenarioPlayback) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.stop()
+}
+
+// Wait blocks until all steps have been applied or playback is stopped,
+// returning an error if any of the applied steps failed.
+func (p *ScenarioPlayback) Wait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-p.done:
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.errs) == 0 {
+		return nil
+	}
+
+	msgs := make([]string, len(p.errs))
+	for i, err := range p.errs {
+		msgs[i] = err.Error()
+	}
+	return fmt.Errorf("%s", strings.Join(msgs, "; "))
+}
+
+// ServeScenario is the vcsim admin endpoint for Scenario playback, see ServeAdmin.
+// POST starts playback of the Scenario encoded as YAML or JSON in the request body.
+func (s *Service) ServeScenario(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	scenario, err := LoadScenario(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// playback continues after the request is done
+	_ = s.PlayScenario(context.Background(), scenario)
+
+	w.WriteHeader(http.StatusAccepted)
+}
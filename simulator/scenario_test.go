@@ -0,0 +1,176 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zhengkes/govmomi/event"
+	"github.com/zhengkes/govmomi/vim25"
+	"github.com/zhengkes/govmomi/vim25/mo"
+	"github.com/zhengkes/govmomi/vim25/types"
+)
+
+const testScenario = `
+name: incident
+steps:
+  - at: 1m
+    target: LocalDS_0
+    action: datastore.fill
+    args: {percent: 95}
+  - at: 1m
+    target: LocalDS_0
+    action: event.post
+    args: {message: datastore is almost full}
+  - at: 30s
+    target: DC0_C0_H0
+    action: DisconnectHost_Task
+  - at: 30s
+    target: /DC0/vm/DC0_C0_RP0_VM0
+    action: PowerOffVM_Task
+  - at: 2m
+    target: no-such-vm
+    action: PowerOnVM_Task
+`
+
+func TestLoadScenario(t *testing.T) {
+	s, err := LoadScenario(strings.NewReader(testScenario))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var at []time.Duration
+	for _, step := range s.Steps {
+		at = append(at, step.At)
+	}
+	want := []time.Duration{30 * time.Second, 30 * time.Second, time.Minute, time.Minute, 2 * time.Minute}
+	for i := range want {
+		if at[i] != want[i] {
+			t.Fatalf("at=%v", at)
+		}
+	}
+	if s.Steps[0].Action != "DisconnectHost_Task" {
+		t.Errorf("steps[0]=%s", s.Steps[0].Action)
+	}
+
+	// JSON is also valid YAML
+	_, err = LoadScenario(strings.NewReader(`{"steps": [{"at": "5s", "target": "DC0_H0", "action": "host.fail"}]}`))
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = LoadScenario(strings.NewReader(`{"steps": [{"target": "DC0_H0", "action": "NoSuchMethod"}]}`))
+	if err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestPlayScenario(t *testing.T) {
+	m := VPX()
+	m.Clock = new(Clock)
+	m.Clock.Freeze()
+
+	err := m.Run(func(ctx context.Context, c *vim25.Client) error {
+		s, err := LoadScenario(strings.NewReader(testScenario))
+		if err != nil {
+			return err
+		}
+
+		find := func(target string) mo.Reference {
+			obj, err := (&ScenarioStep{Target: target}).target(SpoofContext())
+			if err != nil {
+				t.Fatal(err)
+			}
+			return obj
+		}
+		host := find("DC0_C0_H0").(*HostSystem)
+		vm := find("/DC0/vm/DC0_C0_RP0_VM0").(*VirtualMachine)
+		ds := find("LocalDS_0").(*Datastore)
+
+		handlers := func() int {
+			m.Clock.mu.Lock()
+			defer m.Clock.mu.Unlock()
+			return len(m.Clock.handlers)
+		}
+		nhandlers := handlers()
+
+		p := m.Service.PlayScenario(ctx, s)
+
+		m.Clock.Advance(10 * time.Second)
+		if host.Runtime.ConnectionState != types.HostSystemConnectionStateConnected {
+			t.Errorf("host state=%s", host.Runtime.ConnectionState)
+		}
+
+		m.Clock.Advance(20 * time.Second)
+		if host.Runtime.ConnectionState != types.HostSystemConnectionStateDisconnected {
+			t.Errorf("host state=%s", host.Runtime.ConnectionState)
+		}
+		if vm.Runtime.PowerState != types.VirtualMachinePowerStatePoweredOff {
+			t.Errorf("vm state=%s", vm.Runtime.PowerState)
+		}
+		if ds.Summary.FreeSpace == ds.Summary.Capacity/20 {
+			t.Error("datastore filled early")
+		}
+
+		m.Clock.Advance(30 * time.Second)
+		if ds.Summary.FreeSpace != ds.Summary.Capacity/20 {
+			t.Errorf("free=%d capacity=%d", ds.Summary.FreeSpace, ds.Summary.Capacity)
+		}
+
+		events, err := event.NewManager(c).QueryEvents(ctx, types.EventFilterSpec{
+			EventTypeId: []string{"GeneralUserEvent"},
+			Entity: &types.EventFilterSpecByEntity{
+				Entity:    ds.Reference(),
+				Recursion: types.EventFilterSpecRecursionOptionSelf,
+			},
+		})
+		if err != nil {
+			return err
+		}
+		if len(events) != 1 || events[0].(*types.GeneralUserEvent).Message != "datastore is almost full" {
+			t.Errorf("events=%#v", events)
+		}
+
+		m.Clock.Advance(time.Minute)
+		err = p.Wait(ctx)
+		if err == nil || !strings.Contains(err.Error(), "no-such-vm not found") {
+			t.Errorf("err=%v", err)
+		}
+		if n := handlers(); n != nhandlers {
+			t.Errorf("handlers=%d, expected %d", n, nhandlers)
+		}
+
+		// playback stops when its context is done
+		pctx, cancel := context.WithCancel(ctx)
+		p = m.Service.PlayScenario(pctx, s)
+		cancel()
+		if err = p.Wait(ctx); err != nil {
+			t.Errorf("err=%v", err)
+		}
+		if n := handlers(); n != nhandlers {
+			t.Errorf("handlers=%d, expected %d", n, nhandlers)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,572 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/zhengkes/govmomi/vim25/methods"
+	"github.com/zhengkes/govmomi/vim25/mo"
+	"github.com/zhengkes/govmomi/vim25/soap"
+	"github.com/zhengkes/govmomi/vim25/types"
+)
+
+// ScheduledTaskManager runs ScheduledTask MethodActions when their TaskScheduler is due.
+// Supported schedulers are OnceTaskScheduler, AfterStartupTaskScheduler, HourlyTaskScheduler
+// and DailyTaskScheduler, with time provided by the manager's Clock.
+type ScheduledTaskManager struct {
+	mo.ScheduledTaskManager
+
+	// Clock determines when tasks are due. Tests can Freeze and Advance the Clock
+	// to run scheduled tasks deterministically.
+	Clock *Clock
+
+	mu      sync.Mutex
+	started time.Time
+	tasks   map[types.ManagedObjectReference]*ScheduledTask
+	cancel  func() // removes the OnChange registration of Clock
+}
+
+type ScheduledTask struct {
+	mo.ScheduledTask
+
+	m       *ScheduledTaskManager
+	next    *time.Time
+	running bool
+	timer   *time.Timer
+}
+
+func (m *ScheduledTaskManager) init(r *Registry) {
+	m.tasks = make(map[types.ManagedObjectReference]*ScheduledTask)
+	m.useClock(new(Clock))
+}
+
+// useClock changes the manager's Clock, such as to the Service Clock.
+func (m *ScheduledTaskManager) useClock(c *Clock) {
+	if m.Clock == c {
+		return
+	}
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.Clock = c
+	m.cancel = m.Clock.OnChange(func(time.Time) { m.runDue() })
+	m.started = m.Clock.Now()
+}
+
+// restore schedules the tasks loaded from a checkpoint
+func (m *ScheduledTaskManager) restore(ctx *Context) {
+	var tasks []*ScheduledTask
+
+	m.useClock(ctx.Clock())
+
+	m.mu.Lock()
+	for _, obj := range ctx.Map.AllReference("ScheduledTask") {
+		t := obj.(*ScheduledTask)
+		t.m = m
+		m.tasks[t.Self] = t
+		tasks = append(tasks, t)
+	}
+	m.mu.Unlock()
+
+	for _, t := range tasks {
+		m.schedule(ctx, t)
+	}
+}
+
+// discard stops the task timers and Clock handler when replaced by a checkpoint restore
+func (m *ScheduledTaskManager) discard(*Context) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for ref, t := range m.tasks {
+		if t.timer != nil {
+			t.timer.Stop()
+			t.timer = nil
+		}
+		delete(m.tasks, ref)
+	}
+
+	if m.cancel != nil {
+		m.cancel()
+		m.cancel = nil
+	}
+}
+
+// nextRunTime returns the time a task with the given scheduler is next due, or nil if it will not run again.
+func nextRunTime(s types.BaseTaskScheduler, started, now time.Time, prev *time.Time) *time.Time {
+	ts := s.GetTaskScheduler()
+
+	if ts.ActiveTime != nil && now.Before(*ts.ActiveTime) {
+		now = *ts.ActiveTime
+	}
+
+	now = now.UTC()
+
+	// base returns the time after which a recurrent task is next due
+	base := func(interval int32, period time.Duration) time.Time {
+		if prev == nil || interval <= 1 {
+			return now
+		}
+		after := prev.Add(time.Duration(interval-1) * period)
+		if after.After(now) {
+			return after.UTC()
+		}
+		return now
+	}
+
+	var next time.Time
+
+	switch x := s.(type) {
+	case *types.OnceTaskScheduler:
+		if prev != nil {
+			return nil
+		}
+		next = now
+		if x.RunAt != nil {
+			next = *x.RunAt
+		}
+	case *types.AfterStartupTaskScheduler:
+		if prev != nil {
+			return nil
+		}
+		next = started.Add(time.Duration(x.Minute) * time.Minute)
+		if next.Before(now) {
+			return nil
+		}
+	case *types.HourlyTaskScheduler:
+		after := base(x.Interval, time.Hour)
+		next = after.Truncate(time.Hour).Add(time.Duration(x.Minute) * time.Minute)
+		if !next.After(after) {
+			next = next.Add(time.Hour)
+		}
+	case *types.DailyTaskScheduler:
+		after := base(x.Interval, 24*time.Hour)
+		next = time.Date(after.Year(), after.Month(), after.Day(), int(x.Hour), int(x.Minute), 0, 0, time.UTC)
+		if !next.After(after) {
+			next = next.AddDate(0, 0, 1)
+		}
+	default:
+		return nil
+	}
+
+	if ts.ExpireTime != nil && next.After(*ts.ExpireTime) {
+		return nil
+	}
+
+	return &next
+}
+
+// validateSpec returns a fault if the given spec is not supported by the simulator.
+// The self param is the task being reconfigured, if any, excluded from the unique name check.
+func (m *ScheduledTaskManager) validateSpec(ctx *Context, self, entity types.ManagedObjectReference, spec *types.ScheduledTaskSpec) *soap.Fault {
+	switch spec.Scheduler.(type) {
+	case *types.OnceTaskScheduler, *types.AfterStartupTaskScheduler,
+		*types.HourlyTaskScheduler, *types.DailyTaskScheduler:
+	case nil:
+		return Fault("", &types.InvalidArgument{InvalidProperty: "spec.scheduler"})
+	default:
+		return Fault(fmt.Sprintf("%T is not supported", spec.Scheduler), &types.NotSupported{})
+	}
+
+	action, ok := spec.Action.(*types.MethodAction)
+	if !ok {
+		return Fault("", &types.InvalidArgument{InvalidProperty: "spec.action"})
+	}
+
+	if _, ok := types.TypeFunc()(action.Name); !ok {
+		return Fault("", &types.InvalidArgument{InvalidProperty: "spec.action.name"})
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, t := range m.tasks {
+		if t.Self != self && t.Info.Entity == entity && t.Info.Name == spec.Name {
+			return Fault("", &types.DuplicateName{Name: spec.Name, Object: t.Self})
+		}
+	}
+
+	return nil
+}
+
+func (m *ScheduledTaskManager) event(ctx *Context, t *ScheduledTask) types.ScheduledTaskEvent {
+	return types.ScheduledTaskEvent{
+		Event: entityEvent(ctx, t.Info.Entity),
+		ScheduledTask: types.ScheduledTaskEventArgument{
+			EntityEventArgument: types.EntityEventArgument{Name: t.Info.Name},
+			ScheduledTask:       t.Self,
+		},
+		Entity: entityEventArgument(ctx, t.Info.Entity),
+	}
+}
+
+// schedule computes the task's next run time and arms a timer to run the task when it is due.
+// When the Clock is frozen, due tasks are only run when the Clock is changed.
+func (m *ScheduledTaskManager) schedule(ctx *Context, t *ScheduledTask) {
+	now := m.Clock.Now()
+
+	m.mu.Lock()
+
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+
+	t.next = nil
+	if t.Info.Enabled {
+		t.next = nextRunTime(t.Info.Scheduler, m.started, now, t.Info.PrevRunTime)
+	}
+	next := t.next
+
+	if next != nil && (!m.Clock.Frozen() || !next.After(now)) {
+		t.timer = time.AfterFunc(next.Sub(now), m.runDue)
+	}
+
+	m.mu.Unlock()
+
+	var val interface{}
+	if next != nil {
+		val = *next
+	}
+
+	ctx.WithLock(t, func() {
+		ctx.Map.Update(t, []types.PropertyChange{{Name: "info.nextRunTime", Val: val}})
+	})
+}
+
+// runDue runs any tasks that are due and reschedules the others, called when the Clock changes or a timer fires.
+func (m *ScheduledTaskManager) runDue() {
+	now := m.Clock.Now()
+	ctx := SpoofContext()
+
+	var due, other []*ScheduledTask
+
+	m.mu.Lock()
+	for _, t := range m.tasks {
+		if t.running {
+			continue
+		}
+		if t.next != nil && !t.next.After(now) {
+			t.running = true
+			due = append(due, t)
+		} else {
+			other = append(other, t)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, t := range due {
+		m.run(ctx, t)
+	}
+
+	for _, t := range other {
+		m.schedule(ctx, t)
+	}
+}
+
+// invoke calls the task's MethodAction on the task's entity, returning the method result if any.
+func (t *ScheduledTask) invoke(ctx *Context) (types.AnyType, types.BaseMethodFault) {
+	action := t.Info.Action.(*types.MethodAction)
+
+	rtype, ok := types.TypeFunc()(action.Name)
+	if !ok {
+		return nil, &types.MethodNotFound{Receiver: t.Info.Entity, Method: action.Name}
+	}
+	req := reflect.New(rtype)
+
+	args := action.Argument
+	for i := 0; i < rtype.NumField() && len(args) != 0; i++ {
+		if rtype.Field(i).Name == "This" {
+			continue
+		}
+
+		val := args[0].Value
+		args = args[1:]
+		if val == nil {
+			continue
+		}
+
+		field := req.Elem().Field(i)
+		arg := reflect.ValueOf(val)
+
+		switch {
+		case arg.Type().AssignableTo(field.Type()):
+			field.Set(arg)
+		case field.Kind() == reflect.Ptr && arg.Type().AssignableTo(field.Type().Elem()):
+			ptr := reflect.New(arg.Type())
+			ptr.Elem().Set(arg)
+			field.Set(ptr)
+		case arg.Type().ConvertibleTo(field.Type()):
+			field.Set(arg.Convert(field.Type()))
+		default:
+			return nil, &types.InvalidArgument{InvalidProperty: "spec.action.argument"}
+		}
+	}
+
+	return invokeMethod(ctx, t.Info.Entity, action.Name, req)
+}
+
+// invokeMethod calls the handler of the named method on the given object, where req is a pointer to the method's
+// request type, such as *types.PowerOnVM_Task. The request This field is set to the object.
+// The method result is returned, if any.
+func invokeMethod(ctx *Context, this types.ManagedObjectReference, name string, req reflect.Value) (types.AnyType, types.BaseMethodFault) {
+	handler := ctx.Map.Get(this)
+	if handler == nil {
+		return nil, &types.ManagedObjectNotFound{Obj: this}
+	}
+
+	method := reflect.ValueOf(handler).MethodByName(handlerMethodName(name))
+	if !method.IsValid() {
+		return nil, &types.MethodNotFound{Receiver: this, Method: name}
+	}
+
+	req.Elem().FieldByName("This").Set(reflect.ValueOf(this))
+
+	var in []reflect.Value
+	if method.Type().NumIn() == 2 {
+		in = append(in, reflect.ValueOf(ctx))
+	}
+	in = append(in, req)
+
+	var out []reflect.Value
+	ctx.WithLock(handler, func() {
+		out = method.Call(in)
+	})
+
+	body := out[0].Interface().(soap.HasFault)
+	if fault := body.Fault(); fault != nil {
+		return nil, fault.VimFault().(types.BaseMethodFault)
+	}
+
+	res := reflect.ValueOf(body).Elem().FieldByName("Res")
+	if res.IsNil() {
+		return nil, nil
+	}
+	if val := res.Elem().FieldByName("Returnval"); val.IsValid() {
+		return val.Interface(), nil
+	}
+
+	return nil, nil
+}
+
+// run invokes the task's action and records the outcome in the task's info.
+// If the action returns a Task, the outcome is recorded when that Task is complete.
+func (m *ScheduledTaskManager) run(ctx *Context, t *ScheduledTask) {
+	now := m.Clock.Now()
+
+	ctx.WithLock(t, func() {
+		ctx.Map.Update(t, []types.PropertyChange{
+			{Name: "info.state", Val: types.TaskInfoStateRunning},
+			{Name: "info.prevRunTime", Val: now},
+			{Name: "info.progress", Val: int32(0)},
+			{Name: "info.result", Val: nil},
+			{Name: "info.error", Val: nil},
+		})
+	})
+
+	ctx.postEvent(&types.ScheduledTaskStartedEvent{ScheduledTaskEvent: m.event(ctx, t)})
+
+	res, fault := t.invoke(ctx)
+
+	if ref, ok := res.(types.ManagedObjectReference); ok && fault == nil {
+		if task, ok := ctx.Map.Get(ref).(*Task); ok {
+			ctx.WithLock(t, func() {
+				ctx.Map.Update(t, []types.PropertyChange{{Name: "info.activeTask", Val: ref}})
+			})
+
+			go func() {
+				task.Wait()
+				m.complete(SpoofContext(), t, task.Info.Result, task.Info.Error)
+			}()
+
+			return
+		}
+	}
+
+	var err *types.LocalizedMethodFault
+	if fault != nil {
+		err = &types.LocalizedMethodFault{
+			Fault:            fault,
+			LocalizedMessage: fmt.Sprintf("%T", fault),
+		}
+	}
+
+	m.complete(ctx, t, res, err)
+}
+
+// complete records the outcome of a task run and schedules the next run, if any.
+func (m *ScheduledTaskManager) complete(ctx *Context, t *ScheduledTask, res types.AnyType, err *types.LocalizedMethodFault) {
+	state := types.TaskInfoStateSuccess
+	var fault interface{}
+	if err != nil {
+		state = types.TaskInfoStateError
+		fault = *err
+	}
+
+	ctx.WithLock(t, func() {
+		ctx.Map.Update(t, []types.PropertyChange{
+			{Name: "info.state", Val: state},
+			{Name: "info.progress", Val: int32(100)},
+			{Name: "info.result", Val: res},
+			{Name: "info.error", Val: fault},
+			{Name: "info.activeTask", Val: nil},
+		})
+	})
+
+	if err == nil {
+		ctx.postEvent(&types.ScheduledTaskCompletedEvent{ScheduledTaskEvent: m.event(ctx, t)})
+	} else {
+		ctx.postEvent(&types.ScheduledTaskFailedEvent{ScheduledTaskEvent: m.event(ctx, t), Reason: *err})
+	}
+
+	m.mu.Lock()
+	t.running = false
+	_, ok := m.tasks[t.Self]
+	m.mu.Unlock()
+
+	if ok {
+		m.schedule(ctx, t)
+	}
+}
+
+func (m *ScheduledTaskManager) CreateScheduledTask(ctx *Context, req *types.CreateScheduledTask) soap.HasFault {
+	body := new(methods.CreateScheduledTaskBody)
+
+	if _, ok := ctx.Map.Get(req.Entity).(mo.Entity); !ok {
+		body.Fault_ = Fault("", &types.ManagedObjectNotFound{Obj: req.Entity})
+		return body
+	}
+
+	spec := req.Spec.GetScheduledTaskSpec()
+	if fault := m.validateSpec(ctx, types.ManagedObjectReference{}, req.Entity, spec); fault != nil {
+		body.Fault_ = fault
+		return body
+	}
+
+	t := &ScheduledTask{m: m}
+	t.Info.ScheduledTaskSpec = *spec
+	t.Info.Entity = req.Entity
+	t.Info.LastModifiedTime = m.Clock.Now()
+	t.Info.LastModifiedUser = ctx.Session.UserName
+	t.Info.State = types.TaskInfoStateQueued
+
+	ref := ctx.Map.Put(t).Reference()
+	t.Info.ScheduledTask = ref
+
+	m.mu.Lock()
+	m.tasks[ref] = t
+	m.mu.Unlock()
+
+	ctx.Map.AppendReference(ctx, m, &m.ScheduledTask, ref)
+	ctx.postEvent(&types.ScheduledTaskCreatedEvent{ScheduledTaskEvent: m.event(ctx, t)})
+
+	m.schedule(ctx, t)
+
+	body.Res = &types.CreateScheduledTaskResponse{Returnval: ref}
+
+	return body
+}
+
+func (m *ScheduledTaskManager) RetrieveEntityScheduledTask(ctx *Context, req *types.RetrieveEntityScheduledTask) soap.HasFault {
+	body := &methods.RetrieveEntityScheduledTaskBody{
+		Res: new(types.RetrieveEntityScheduledTaskResponse),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, ref := range m.ScheduledTask {
+		t := m.tasks[ref]
+		if req.Entity == nil || *req.Entity == t.Info.Entity {
+			body.Res.Returnval = append(body.Res.Returnval, ref)
+		}
+	}
+
+	return body
+}
+
+func (t *ScheduledTask) ReconfigureScheduledTask(ctx *Context, req *types.ReconfigureScheduledTask) soap.HasFault {
+	body := new(methods.ReconfigureScheduledTaskBody)
+
+	spec := req.Spec.GetScheduledTaskSpec()
+	if fault := t.m.validateSpec(ctx, t.Self, t.Info.Entity, spec); fault != nil {
+		body.Fault_ = fault
+		return body
+	}
+
+	t.m.mu.Lock()
+	t.Info.ScheduledTaskSpec = *spec
+	t.Info.LastModifiedTime = t.m.Clock.Now()
+	t.Info.LastModifiedUser = ctx.Session.UserName
+	t.m.mu.Unlock()
+
+	ctx.postEvent(&types.ScheduledTaskReconfiguredEvent{ScheduledTaskEvent: t.m.event(ctx, t)})
+
+	t.m.schedule(ctx, t)
+
+	body.Res = new(types.ReconfigureScheduledTaskResponse)
+
+	return body
+}
+
+func (t *ScheduledTask) RunScheduledTask(ctx *Context, req *types.RunScheduledTask) soap.HasFault {
+	body := new(methods.RunScheduledTaskBody)
+
+	t.m.mu.Lock()
+	running := t.running
+	t.running = true
+	t.m.mu.Unlock()
+
+	if running {
+		body.Fault_ = Fault("", &types.InvalidState{})
+		return body
+	}
+
+	t.m.run(ctx, t)
+
+	body.Res = new(types.RunScheduledTaskResponse)
+
+	return body
+}
+
+func (t *ScheduledTask) RemoveScheduledTask(ctx *Context, req *types.RemoveScheduledTask) soap.HasFault {
+	m := t.m
+
+	m.mu.Lock()
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+	delete(m.tasks, t.Self)
+	m.mu.Unlock()
+
+	ctx.postEvent(&types.ScheduledTaskRemovedEvent{ScheduledTaskEvent: m.event(ctx, t)})
+
+	ctx.WithLock(m, func() {
+		RemoveReference(&m.ScheduledTask, t.Self)
+	})
+
+	ctx.Map.Remove(ctx, t.Self)
+
+	return &methods.RemoveScheduledTaskBody{
+		Res: new(types.RemoveScheduledTaskResponse),
+	}
+}
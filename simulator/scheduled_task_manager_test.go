@@ -0,0 +1,278 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/zhengkes/govmomi/property"
+	"github.com/zhengkes/govmomi/vim25"
+	"github.com/zhengkes/govmomi/vim25/methods"
+	"github.com/zhengkes/govmomi/vim25/mo"
+	"github.com/zhengkes/govmomi/vim25/types"
+)
+
+func createScheduledTask(ctx context.Context, t *testing.T, c *vim25.Client, entity types.ManagedObjectReference, spec types.ScheduledTaskSpec) types.ManagedObjectReference {
+	t.Helper()
+
+	res, err := methods.CreateScheduledTask(ctx, c, &types.CreateScheduledTask{
+		This:   *c.ServiceContent.ScheduledTaskManager,
+		Entity: entity,
+		Spec:   &spec,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return res.Returnval
+}
+
+func scheduledTaskInfo(ctx context.Context, t *testing.T, c *vim25.Client, ref types.ManagedObjectReference) types.ScheduledTaskInfo {
+	t.Helper()
+
+	var task mo.ScheduledTask
+	err := property.DefaultCollector(c).RetrieveOne(ctx, ref, []string{"info"}, &task)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return task.Info
+}
+
+// waitScheduledTask waits for the given ScheduledTask run to complete
+func waitScheduledTask(ctx context.Context, t *testing.T, c *vim25.Client, ref types.ManagedObjectReference) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	pc := property.DefaultCollector(c)
+	err := property.Wait(ctx, pc, ref, []string{"info.state"}, func(changes []types.PropertyChange) bool {
+		for _, change := range changes {
+			switch change.Val {
+			case types.TaskInfoStateSuccess, types.TaskInfoStateError:
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScheduledTaskManagerOnce(t *testing.T) {
+	Test(func(ctx context.Context, c *vim25.Client) {
+		clock := Map.ScheduledTaskManager().Clock
+		clock.Freeze()
+
+		vm := Map.Any("VirtualMachine").(*VirtualMachine)
+		runAt := clock.Now().Add(time.Hour)
+
+		ref := createScheduledTask(ctx, t, c, vm.Self, types.ScheduledTaskSpec{
+			Name:      "power off",
+			Enabled:   true,
+			Scheduler: &types.OnceTaskScheduler{RunAt: &runAt},
+			Action:    &types.MethodAction{Name: "PowerOffVM_Task"},
+		})
+
+		info := scheduledTaskInfo(ctx, t, c, ref)
+		if info.NextRunTime == nil || !info.NextRunTime.Equal(runAt) {
+			t.Errorf("nextRunTime=%v", info.NextRunTime)
+		}
+		if info.State != types.TaskInfoStateQueued {
+			t.Errorf("state=%s", info.State)
+		}
+
+		clock.Advance(30 * time.Minute)
+
+		if info = scheduledTaskInfo(ctx, t, c, ref); info.PrevRunTime != nil {
+			t.Errorf("prevRunTime=%v", info.PrevRunTime)
+		}
+
+		clock.Advance(30 * time.Minute)
+		waitScheduledTask(ctx, t, c, ref)
+
+		info = scheduledTaskInfo(ctx, t, c, ref)
+		if info.State != types.TaskInfoStateSuccess || info.PrevRunTime == nil || info.NextRunTime != nil {
+			t.Errorf("info=%#v", info)
+		}
+		if vm.Runtime.PowerState != types.VirtualMachinePowerStatePoweredOff {
+			t.Errorf("powerState=%s", vm.Runtime.PowerState)
+		}
+
+		// failure is recorded in info.error
+		_, err := methods.RunScheduledTask(ctx, c, &types.RunScheduledTask{This: ref})
+		if err != nil {
+			t.Fatal(err)
+		}
+		waitScheduledTask(ctx, t, c, ref)
+
+		info = scheduledTaskInfo(ctx, t, c, ref)
+		if info.State != types.TaskInfoStateError || info.Error == nil {
+			t.Errorf("info=%#v", info)
+		}
+		if _, ok := info.Error.Fault.(*types.InvalidPowerState); !ok {
+			t.Errorf("fault=%T", info.Error.Fault)
+		}
+	})
+}
+
+func TestScheduledTaskManagerRecurrent(t *testing.T) {
+	Test(func(ctx context.Context, c *vim25.Client) {
+		clock := Map.ScheduledTaskManager().Clock
+		clock.Freeze()
+		clock.Set(time.Date(2024, time.January, 1, 12, 30, 0, 0, time.UTC))
+
+		vm := Map.Any("VirtualMachine").(*VirtualMachine)
+
+		hourly := createScheduledTask(ctx, t, c, vm.Self, types.ScheduledTaskSpec{
+			Name:      "hourly snapshot",
+			Enabled:   true,
+			Scheduler: &types.HourlyTaskScheduler{Minute: 45},
+			Action: &types.MethodAction{
+				Name: "CreateSnapshot_Task",
+				Argument: []types.MethodActionArgument{
+					{Value: "hourly"},
+					{Value: "scheduled"},
+					{Value: false},
+					{Value: false},
+				},
+			},
+		})
+
+		info := scheduledTaskInfo(ctx, t, c, hourly)
+		expect := time.Date(2024, time.January, 1, 12, 45, 0, 0, time.UTC)
+		if info.NextRunTime == nil || !info.NextRunTime.Equal(expect) {
+			t.Errorf("nextRunTime=%v", info.NextRunTime)
+		}
+
+		for i := 1; i <= 2; i++ {
+			clock.Advance(time.Hour)
+			waitScheduledTask(ctx, t, c, hourly)
+
+			if n := len(Map.AllReference("VirtualMachineSnapshot")); n != i {
+				t.Fatalf("%d: snapshots=%d", i, n)
+			}
+		}
+
+		info = scheduledTaskInfo(ctx, t, c, hourly)
+		expect = time.Date(2024, time.January, 1, 14, 45, 0, 0, time.UTC)
+		if info.NextRunTime == nil || !info.NextRunTime.Equal(expect) {
+			t.Errorf("nextRunTime=%v", info.NextRunTime)
+		}
+		if ref, ok := info.Result.(types.ManagedObjectReference); !ok || ref.Type != "VirtualMachineSnapshot" {
+			t.Errorf("result=%#v", info.Result)
+		}
+
+		// daily at 06:00, every other day
+		spec := types.ScheduledTaskSpec{
+			Name:      "daily snapshot",
+			Enabled:   true,
+			Scheduler: &types.DailyTaskScheduler{HourlyTaskScheduler: types.HourlyTaskScheduler{RecurrentTaskScheduler: types.RecurrentTaskScheduler{Interval: 2}}, Hour: 6},
+			Action:    &types.MethodAction{Name: "RemoveAllSnapshots_Task"},
+		}
+		_, err := methods.ReconfigureScheduledTask(ctx, c, &types.ReconfigureScheduledTask{This: hourly, Spec: &spec})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		info = scheduledTaskInfo(ctx, t, c, hourly)
+		expect = time.Date(2024, time.January, 3, 6, 0, 0, 0, time.UTC) // 2 days since prevRunTime
+		if info.Name != spec.Name || info.NextRunTime == nil || !info.NextRunTime.Equal(expect) {
+			t.Errorf("name=%s nextRunTime=%v", info.Name, info.NextRunTime)
+		}
+
+		clock.Set(expect)
+		waitScheduledTask(ctx, t, c, hourly)
+
+		if vm.Snapshot != nil {
+			t.Errorf("snapshot=%#v", vm.Snapshot)
+		}
+
+		info = scheduledTaskInfo(ctx, t, c, hourly)
+		expect = time.Date(2024, time.January, 5, 6, 0, 0, 0, time.UTC)
+		if info.NextRunTime == nil || !info.NextRunTime.Equal(expect) {
+			t.Errorf("nextRunTime=%v", info.NextRunTime)
+		}
+	})
+}
+
+func TestScheduledTaskManagerAfterStartup(t *testing.T) {
+	Test(func(ctx context.Context, c *vim25.Client) {
+		m := Map.ScheduledTaskManager()
+		m.Clock.Freeze()
+
+		vm := Map.Any("VirtualMachine").(*VirtualMachine)
+		vm.Runtime.PowerState = types.VirtualMachinePowerStatePoweredOff
+
+		ref := createScheduledTask(ctx, t, c, vm.Self, types.ScheduledTaskSpec{
+			Name:      "power on",
+			Enabled:   true,
+			Scheduler: &types.AfterStartupTaskScheduler{Minute: 10},
+			Action:    &types.MethodAction{Name: "PowerOnVM_Task"},
+		})
+
+		tasks, err := methods.RetrieveEntityScheduledTask(ctx, c, &types.RetrieveEntityScheduledTask{
+			This:   *c.ServiceContent.ScheduledTaskManager,
+			Entity: &vm.Self,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tasks.Returnval) != 1 || tasks.Returnval[0] != ref {
+			t.Errorf("tasks=%v", tasks.Returnval)
+		}
+
+		_, err = methods.CreateScheduledTask(ctx, c, &types.CreateScheduledTask{
+			This:   *c.ServiceContent.ScheduledTaskManager,
+			Entity: vm.Self,
+			Spec: &types.ScheduledTaskSpec{
+				Name:      "power on",
+				Scheduler: &types.AfterStartupTaskScheduler{},
+				Action:    &types.MethodAction{Name: "PowerOnVM_Task"},
+			},
+		})
+		if err == nil {
+			t.Error("expected DuplicateName fault")
+		}
+
+		m.Clock.Set(m.started.Add(10 * time.Minute))
+		waitScheduledTask(ctx, t, c, ref)
+
+		if vm.Runtime.PowerState != types.VirtualMachinePowerStatePoweredOn {
+			t.Errorf("powerState=%s", vm.Runtime.PowerState)
+		}
+
+		_, err = methods.RemoveScheduledTask(ctx, c, &types.RemoveScheduledTask{This: ref})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tasks, err = methods.RetrieveEntityScheduledTask(ctx, c, &types.RetrieveEntityScheduledTask{
+			This: *c.ServiceContent.ScheduledTaskManager,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tasks.Returnval) != 0 {
+			t.Errorf("tasks=%v", tasks.Returnval)
+		}
+	})
+}
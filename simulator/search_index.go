@@ -32,8 +32,8 @@ type SearchIndex struct {
 func (s *SearchIndex) FindByDatastorePath(r *types.FindByDatastorePath) soap.HasFault {
 	res := &methods.FindByDatastorePathBody{Res: new(types.FindByDatastorePathResponse)}
 
-	Map.m.Lock()
-	defer Map.m.Unlock()
+	Map.m.RLock()
+	defer Map.m.RUnlock()
 
 	for ref, obj := range Map.objects {
 		vm, ok := asVirtualMachineMO(obj)
@@ -129,8 +129,8 @@ func (s *SearchIndex) FindChild(req *types.FindChild) soap.HasFault {
 func (s *SearchIndex) FindByUuid(req *types.FindByUuid) soap.HasFault {
 	body := &methods.FindByUuidBody{Res: new(types.FindByUuidResponse)}
 
-	Map.m.Lock()
-	defer Map.m.Unlock()
+	Map.m.RLock()
+	defer Map.m.RUnlock()
 
 	if req.VmSearch {
 		// Find Virtual Machine using UUID
@@ -188,8 +188,8 @@ func (s *SearchIndex) FindByDnsName(req *types.FindByDnsName) soap.HasFault {
 func (s *SearchIndex) FindAllByDnsName(req *types.FindAllByDnsName) soap.HasFault {
 	body := &methods.FindAllByDnsNameBody{Res: new(types.FindAllByDnsNameResponse)}
 
-	Map.m.Lock()
-	defer Map.m.Unlock()
+	Map.m.RLock()
+	defer Map.m.RUnlock()
 
 	if req.VmSearch {
 		// Find Virtual Machine using DNS name
@@ -240,8 +240,8 @@ func (s *SearchIndex) FindByIp(req *types.FindByIp) soap.HasFault {
 func (s *SearchIndex) FindAllByIp(req *types.FindAllByIp) soap.HasFault {
 	body := &methods.FindAllByIpBody{Res: new(types.FindAllByIpResponse)}
 
-	Map.m.Lock()
-	defer Map.m.Unlock()
+	Map.m.RLock()
+	defer Map.m.RUnlock()
 
 	if req.VmSearch {
 		// Find Virtual Machine using IP
@@ -17,8 +17,6 @@ limitations under the License.
 package simulator
 
 import (
-	"time"
-
 	"github.com/google/uuid"
 
 	"github.com/zhengkes/govmomi/simulator/internal"
@@ -62,7 +60,7 @@ func NewServiceInstance(ctx *Context, content types.ServiceContent, folder mo.Fo
 			continue
 		}
 		content := types.ObjectContent{Obj: refs[i]}
-		o, err := loadObject(content)
+		o, err := loadObject(Map, content)
 		if err != nil {
 			panic(err)
 		}
@@ -80,10 +78,10 @@ func (s *ServiceInstance) RetrieveServiceContent(*types.RetrieveServiceContent)
 	}
 }
 
-func (*ServiceInstance) CurrentTime(*types.CurrentTime) soap.HasFault {
+func (*ServiceInstance) CurrentTime(ctx *Context, _ *types.CurrentTime) soap.HasFault {
 	return &methods.CurrentTimeBody{
 		Res: &types.CurrentTimeResponse{
-			Returnval: time.Now(),
+			Returnval: ctx.Now(),
 		},
 	}
 }
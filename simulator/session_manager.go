@@ -55,6 +55,9 @@ var (
 	// SessionIdleTimeout duration used to expire idle sessions
 	SessionIdleTimeout time.Duration
 
+	// SessionMaxAge duration used to expire sessions after login, regardless of activity
+	SessionMaxAge time.Duration
+
 	sessionMutex sync.Mutex
 
 	// secureCookies enables Set-Cookie.Secure=true
@@ -64,7 +67,7 @@ var (
 )
 
 func createSession(ctx *Context, name string, locale string) types.UserSession {
-	now := time.Now().UTC()
+	now := ctx.Now().UTC()
 
 	if locale == "" {
 		locale = session.Locale
@@ -89,10 +92,24 @@ func createSession(ctx *Context, name string, locale string) types.UserSession {
 	return ctx.Session.UserSession
 }
 
-func (m *SessionManager) getSession(id string) (Session, bool) {
+// expired returns true if the session has been idle longer than SessionIdleTimeout
+// or was created longer than SessionMaxAge ago.
+func (s *Session) expired(now time.Time) bool {
+	if SessionIdleTimeout != 0 && now.Sub(s.LastActiveTime) > SessionIdleTimeout {
+		return true
+	}
+	return SessionMaxAge != 0 && now.Sub(s.LoginTime) > SessionMaxAge
+}
+
+// getSession returns the session with the given id, removing the session if it has expired as of now.
+func (m *SessionManager) getSession(id string, now time.Time) (Session, bool) {
 	sessionMutex.Lock()
 	defer sessionMutex.Unlock()
 	s, ok := m.sessions[id]
+	if ok && s.expired(now) {
+		delete(m.sessions, id)
+		return s, false
+	}
 	return s, ok
 }
 
@@ -213,7 +230,7 @@ func (s *SessionManager) TerminateSession(ctx *Context, req *types.TerminateSess
 			body.Fault_ = Fault("", new(types.InvalidArgument))
 			return body
 		}
-		if _, ok := s.getSession(id); !ok {
+		if _, ok := s.getSession(id, ctx.Now()); !ok {
 			body.Fault_ = Fault("", new(types.NotFound))
 			return body
 		}
@@ -234,7 +251,7 @@ func (s *SessionManager) SessionIsActive(ctx *Context, req *types.SessionIsActiv
 
 	body.Res = new(types.SessionIsActiveResponse)
 
-	if session, exists := s.getSession(req.SessionID); exists {
+	if session, exists := s.getSession(req.SessionID, ctx.Now()); exists {
 		body.Res.Returnval = session.UserName == req.UserName
 	}
 
@@ -256,7 +273,7 @@ func (s *SessionManager) AcquireCloneTicket(ctx *Context, _ *types.AcquireCloneT
 func (s *SessionManager) CloneSession(ctx *Context, ticket *types.CloneSession) soap.HasFault {
 	body := new(methods.CloneSessionBody)
 
-	session, exists := s.getSession(ticket.CloneTicket)
+	session, exists := s.getSession(ticket.CloneTicket, ctx.Now())
 
 	if exists {
 		s.delSession(ticket.CloneTicket) // A clone ticket can only be used once
@@ -292,6 +309,9 @@ type Context struct {
 	res http.ResponseWriter
 	svc *Service
 
+	// taskFault is set by a FaultRule with FaultActionTask, failing the next Task run with this Context
+	taskFault types.BaseMethodFault
+
 	context.Context
 	Session *Session
 	Header  soap.Header
@@ -301,8 +321,15 @@ type Context struct {
 
 // mapSession maps an HTTP cookie to a Session.
 func (c *Context) mapSession() {
+	if id := c.req.Header.Get(jsonSessionHeader); id != "" {
+		if val, ok := c.svc.sm.getSession(id, c.Now()); ok {
+			c.SetSession(val, false)
+			return
+		}
+	}
+
 	if cookie, err := c.req.Cookie(soap.SessionCookieName); err == nil {
-		if val, ok := c.svc.sm.getSession(cookie.Value); ok {
+		if val, ok := c.svc.sm.getSession(cookie.Value, c.Now()); ok {
 			c.SetSession(val, false)
 		}
 	}
@@ -311,26 +338,40 @@ func (c *Context) mapSession() {
 func (m *SessionManager) expiredSession(id string, now time.Time) bool {
 	expired := true
 
-	s, ok := m.getSession(id)
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+
+	s, ok := m.sessions[id]
 	if ok {
-		expired = now.Sub(s.LastActiveTime) > SessionIdleTimeout
+		expired = s.expired(now)
 		if expired {
-			m.delSession(id)
+			delete(m.sessions, id)
 		}
 	}
 
 	return expired
 }
 
-// SessionIdleWatch starts a goroutine that calls func expired() at SessionIdleTimeout intervals.
+// sessionWatchInterval returns the interval used by SessionIdleWatch,
+// the smaller of SessionIdleTimeout and SessionMaxAge that is non-zero.
+func sessionWatchInterval() time.Duration {
+	if SessionIdleTimeout == 0 || (SessionMaxAge != 0 && SessionMaxAge < SessionIdleTimeout) {
+		return SessionMaxAge
+	}
+	return SessionIdleTimeout
+}
+
+// SessionIdleWatch starts a goroutine that calls func expired() at SessionIdleTimeout intervals,
+// or SessionMaxAge intervals if smaller.
 // The goroutine exits if the func returns true.
 func SessionIdleWatch(ctx context.Context, id string, expired func(string, time.Time) bool) {
-	if SessionIdleTimeout == 0 {
+	interval := sessionWatchInterval()
+	if interval == 0 {
 		return
 	}
 
 	go func() {
-		for t := time.NewTimer(SessionIdleTimeout); ; {
+		for t := time.NewTimer(interval); ; {
 			select {
 			case <-ctx.Done():
 				return
@@ -338,7 +379,7 @@ func SessionIdleWatch(ctx context.Context, id string, expired func(string, time.
 				if expired(id, now) {
 					return
 				}
-				t.Reset(SessionIdleTimeout)
+				t.Reset(interval)
 			}
 		}
 	}()
@@ -348,7 +389,7 @@ func SessionIdleWatch(ctx context.Context, id string, expired func(string, time.
 func (c *Context) SetSession(session Session, login bool) {
 	session.UserAgent = c.req.UserAgent()
 	session.IpAddress = strings.Split(c.req.RemoteAddr, ":")[0]
-	session.LastActiveTime = time.Now()
+	session.LastActiveTime = c.Now().UTC()
 	session.CallCount++
 
 	c.svc.sm.putSession(session)
@@ -361,6 +402,7 @@ func (c *Context) SetSession(session Session, login bool) {
 			Secure:   secureCookies,
 			HttpOnly: true,
 		})
+		c.res.Header().Set(jsonSessionHeader, session.Key)
 
 		c.postEvent(&types.UserLoginSessionEvent{
 			SessionId: session.Key,
@@ -369,7 +411,10 @@ func (c *Context) SetSession(session Session, login bool) {
 			Locale:    session.Locale,
 		})
 
-		SessionIdleWatch(c.Context, session.Key, c.svc.sm.expiredSession)
+		clock := c.Clock()
+		SessionIdleWatch(c.Context, session.Key, func(id string, _ time.Time) bool {
+			return c.svc.sm.expiredSession(id, clock.Now())
+		})
 	}
 }
 
@@ -433,8 +478,12 @@ func (s *Session) Get(ref types.ManagedObjectReference) mo.Reference {
 		m.CurrentSession = &s.UserSession
 
 		// TODO: we could maintain SessionList as part of the SessionManager singleton
+		now := s.LastActiveTime // this session was active as of the current request
 		sessionMutex.Lock()
 		for _, session := range m.sessions {
+			if session.expired(now) {
+				continue
+			}
 			m.SessionList = append(m.SessionList, session.UserSession)
 		}
 		sessionMutex.Unlock()
@@ -22,12 +22,14 @@ import (
 	"log"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/zhengkes/govmomi"
 	"github.com/zhengkes/govmomi/object"
 	"github.com/zhengkes/govmomi/property"
 	"github.com/zhengkes/govmomi/session"
 	"github.com/zhengkes/govmomi/simulator/vpx"
+	"github.com/zhengkes/govmomi/vim25"
 	"github.com/zhengkes/govmomi/vim25/methods"
 	"github.com/zhengkes/govmomi/vim25/mo"
 	"github.com/zhengkes/govmomi/vim25/soap"
@@ -368,3 +370,71 @@ func TestSessionManagerPropertyCollector(t *testing.T) {
 		t.Errorf("kind=%s", set.Kind)
 	}
 }
+
+func TestSessionManagerExpiry(t *testing.T) {
+	defer func(idle, age time.Duration) {
+		SessionIdleTimeout, SessionMaxAge = idle, age
+	}(SessionIdleTimeout, SessionMaxAge)
+
+	SessionIdleTimeout = time.Minute
+	SessionMaxAge = time.Second / 2
+
+	Test(func(ctx context.Context, c *vim25.Client) {
+		m := session.NewManager(c)
+
+		time.Sleep(time.Second / 10)
+
+		s, err := m.UserSession(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s == nil {
+			t.Fatal("expected session")
+		}
+		if !s.LastActiveTime.After(s.LoginTime) {
+			t.Errorf("LastActiveTime=%s, LoginTime=%s", s.LastActiveTime, s.LoginTime)
+		}
+
+		var sm mo.SessionManager
+		err = property.DefaultCollector(c).RetrieveOne(ctx, m.Reference(), []string{"sessionList"}, &sm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for _, session := range sm.SessionList {
+			if session.Key == s.Key {
+				found = true
+				if session.LastActiveTime.Before(s.LastActiveTime) {
+					t.Errorf("sessionList LastActiveTime=%s", session.LastActiveTime)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("session %s not in sessionList", s.Key)
+		}
+
+		// the session expires after SessionMaxAge, although it has not been idle for SessionIdleTimeout
+		time.Sleep(SessionMaxAge)
+
+		_, err = object.NewFolder(c, c.ServiceContent.RootFolder).CreateFolder(ctx, "expired")
+		if !isNotAuthenticated(err) {
+			t.Errorf("err=%v", err)
+		}
+
+		s, err = m.UserSession(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s != nil {
+			t.Error("expected expired session")
+		}
+
+		// a new session can be created after expiry
+		if err = m.Login(ctx, DefaultLogin); err != nil {
+			t.Fatal(err)
+		}
+		if ok, _ := m.SessionIsActive(ctx); !ok {
+			t.Error("expected active session")
+		}
+	})
+}
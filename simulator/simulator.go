@@ -36,6 +36,8 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 
@@ -75,14 +77,31 @@ type Service struct {
 	sdk    map[string]*Registry
 	funcs  []handleFunc
 	delay  *DelayConfig
+	faults *FaultConfig
+
+	privileges    *bool
+	workload      *workloadSimulation
+	customization *CustomizationTiming
 
 	readAll func(io.Reader) ([]byte, error)
 
 	Listen   *url.URL
 	TLS      *tls.Config
 	ServeMux *http.ServeMux
+	// Clock is the time source of the Service, New creates a Clock that follows the system time
+	Clock *Clock
 	// RegisterEndpoints will initialize any endpoints added via RegisterEndpoint
 	RegisterEndpoints bool
+	// Metrics enables recording of method call metrics and the Server's Prometheus /metrics endpoint
+	Metrics bool
+	// CheckpointDir is the directory in which ServeCheckpoint saves and restores checkpoints.
+	// When empty, the first checkpoint saved creates a temporary directory, which is removed by Server.Close.
+	CheckpointDir string
+
+	metrics *serviceMetrics
+
+	checkpointMu   sync.Mutex
+	checkpointTemp string // temporary CheckpointDir, if created
 }
 
 // Server provides a simulator Service over HTTP
@@ -91,7 +110,8 @@ type Server struct {
 	URL    *url.URL
 	Tunnel int
 
-	caFile string
+	caFile  string
+	service *Service
 }
 
 // New returns an initialized simulator Service instance
@@ -100,6 +120,7 @@ func New(instance *ServiceInstance) *Service {
 		readAll: io.ReadAll,
 		sm:      Map.SessionManager(),
 		sdk:     make(map[string]*Registry),
+		Clock:   new(Clock),
 	}
 
 	s.client, _ = vim25.NewClient(context.Background(), s)
@@ -107,6 +128,14 @@ func New(instance *ServiceInstance) *Service {
 	return s
 }
 
+// registry returns the Registry of the vim25 SDK endpoint.
+func (s *Service) registry() *Registry {
+	if r, ok := s.sdk[vim25.Path]; ok {
+		return r
+	}
+	return Map
+}
+
 type serverFaultBody struct {
 	Reason *soap.Fault `xml:"http://schemas.xmlsoap.org/soap/envelope/ Fault,omitempty"`
 }
@@ -135,7 +164,27 @@ func tracef(format string, v ...interface{}) {
 	}
 }
 
-func (s *Service) call(ctx *Context, method *Method) soap.HasFault {
+// handlerMethodName maps the given vmodl method name to the name of the simulator handler method.
+func handlerMethodName(method string) string {
+	// Lowercase methods can't be accessed outside their package
+	name := strings.Title(method)
+
+	if strings.HasSuffix(name, vTaskSuffix) {
+		// Make golint happy renaming "Foo_Task" -> "FooTask"
+		name = name[:len(name)-len(vTaskSuffix)] + sTaskSuffix
+	}
+
+	return name
+}
+
+func (s *Service) call(ctx *Context, method *Method) (body soap.HasFault) {
+	if s.metrics != nil {
+		s.metrics.start(method.Name)
+		defer func(start time.Time) {
+			s.metrics.done(method.Name, time.Since(start), body)
+		}(time.Now())
+	}
+
 	handler := ctx.Map.Get(method.This)
 	session := ctx.Session
 	ctx.Caller = &method.This
@@ -177,13 +226,7 @@ func (s *Service) call(ctx *Context, method *Method) soap.HasFault {
 		return &serverFaultBody{Reason: Fault(msg, fault)}
 	}
 
-	// Lowercase methods can't be accessed outside their package
-	name := strings.Title(method.Name)
-
-	if strings.HasSuffix(name, vTaskSuffix) {
-		// Make golint happy renaming "Foo_Task" -> "FooTask"
-		name = name[:len(name)-len(vTaskSuffix)] + sTaskSuffix
-	}
+	name := handlerMethodName(method.Name)
 
 	m := reflect.ValueOf(handler).MethodByName(name)
 	if !m.IsValid() {
@@ -203,11 +246,25 @@ func (s *Service) call(ctx *Context, method *Method) soap.HasFault {
 		}
 	}
 
+	if res := s.checkPrivilege(ctx, handler, method); res != nil {
+		return res
+	}
+
 	// We have a valid call. Introduce a delay if requested
 	if s.delay != nil {
 		s.delay.delay(method.Name)
 	}
 
+	if s.faults != nil {
+		if res := s.faults.inject(ctx, method); res != nil {
+			return res
+		}
+	}
+
+	if s.workload != nil {
+		s.workload.update(ctx)
+	}
+
 	var args, res []reflect.Value
 	if m.Type().NumIn() == 2 {
 		args = append(args, reflect.ValueOf(ctx))
@@ -254,6 +311,7 @@ func (s *Service) RoundTrip(ctx context.Context, request, response soap.HasFault
 		Map:     Map,
 		Context: ctx,
 		Session: internalSession,
+		svc:     s,
 	}, method)
 
 	if err := res.Fault(); err != nil {
@@ -427,9 +485,11 @@ func (s *Service) HandleFunc(pattern string, handler func(http.ResponseWriter, *
 // multiple paths.
 func (s *Service) RegisterSDK(r *Registry, alias ...string) {
 	if existing, ok := s.sdk[r.Path]; ok {
+		existing.m.Lock()
 		for id, obj := range r.objects {
-			existing.objects[id] = obj
+			existing.putObject(id, obj)
 		}
+		existing.m.Unlock()
 		return
 	}
 
@@ -439,10 +499,16 @@ func (s *Service) RegisterSDK(r *Registry, alias ...string) {
 
 	s.sdk[r.Path] = r
 	s.ServeMux.HandleFunc(r.Path, s.ServeSDK)
+	if r.Namespace == vim25.Namespace {
+		s.ServeMux.HandleFunc(jsonPrefix(r.Path, r.Namespace), s.ServeJSON)
+	}
 
 	for _, p := range alias {
 		s.sdk[p] = r
 		s.ServeMux.HandleFunc(p, s.ServeSDK)
+		if r.Namespace == vim25.Namespace {
+			s.ServeMux.HandleFunc(jsonPrefix(p, r.Namespace), s.ServeJSON)
+		}
 	}
 }
 
@@ -501,9 +567,18 @@ func (s *Service) ServeSDK(w http.ResponseWriter, r *http.Request) {
 		res = s.call(ctx, method)
 	}
 
+	if _, ok := res.(*dropConnectionBody); ok && dropConnection(w) {
+		return
+	}
+
 	if f := res.Fault(); f != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 
+		var detail *faultDetail
+		if f.Detail.Fault != nil {
+			detail = &faultDetail{f.Detail.Fault}
+		}
+
 		// the generated method/*Body structs use the '*soap.Fault' type,
 		// so we need our own Body type to use the modified '*soapFault' type.
 		soapBody = struct {
@@ -514,7 +589,7 @@ func (s *Service) ServeSDK(w http.ResponseWriter, r *http.Request) {
 				String: f.String,
 				Detail: struct {
 					Fault *faultDetail
-				}{&faultDetail{f.Detail.Fault}},
+				}{detail},
 			},
 		}
 	} else {
@@ -680,6 +755,10 @@ func defaultIP(addr *net.TCPAddr) string {
 // NewServer returns an http Server instance for the given service
 func (s *Service) NewServer() *Server {
 	s.RegisterSDK(Map, Map.Path+"/vimService")
+	if Map.clock == nil {
+		// objects not created via a Model share the Service Clock
+		Map.clock = s.Clock
+	}
 
 	mux := s.ServeMux
 	mux.HandleFunc(Map.Path+"/vimServiceVersions.xml", s.ServiceVersions)
@@ -688,6 +767,11 @@ func (s *Service) NewServer() *Server {
 	mux.HandleFunc(guestPrefix, ServeGuest)
 	mux.HandleFunc(nfcPrefix, ServeNFC)
 	mux.HandleFunc("/about", s.About)
+	mux.HandleFunc(adminPrefix, s.ServeAdmin)
+	if s.Metrics {
+		s.metrics = newServiceMetrics()
+		mux.HandleFunc("/metrics", s.ServeMetrics)
+	}
 
 	if s.Listen == nil {
 		s.Listen = new(url.URL)
@@ -764,8 +848,9 @@ func (s *Service) NewServer() *Server {
 	}
 
 	return &Server{
-		Server: ts,
-		URL:    u,
+		Server:  ts,
+		URL:     u,
+		service: s,
 	}
 }
 
@@ -863,6 +948,7 @@ func (s *Server) Close() {
 	if s.caFile != "" {
 		_ = os.Remove(s.caFile)
 	}
+	s.service.removeCheckpointTemp()
 }
 
 var (
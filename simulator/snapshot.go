@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"strings"
 
 	"github.com/zhengkes/govmomi/object"
 	"github.com/zhengkes/govmomi/vim25/methods"
@@ -33,6 +34,348 @@ type VirtualMachineSnapshot struct {
 	DataSets map[string]*DataSet
 }
 
+// copyDisks returns a copy of the given device list, where disks and their backing are copied
+// such that changes to the VM's disks, such as the backing's change ID, are not reflected in a snapshot.
+func copyDisks(devices []types.BaseVirtualDevice) []types.BaseVirtualDevice {
+	list := make([]types.BaseVirtualDevice, len(devices))
+
+	for i, device := range devices {
+		if disk, ok := device.(*types.VirtualDisk); ok {
+			c := *disk
+			if b, ok := disk.Backing.(*types.VirtualDiskFlatVer2BackingInfo); ok {
+				backing := *b
+				c.Backing = &backing
+			}
+			device = &c
+		}
+		list[i] = device
+	}
+
+	return list
+}
+
+// diskBackings returns the flat disk backings of the given devices, by device key.
+func diskBackings(devices []types.BaseVirtualDevice) map[int32]*types.VirtualDiskFlatVer2BackingInfo {
+	backings := make(map[int32]*types.VirtualDiskFlatVer2BackingInfo)
+
+	for _, device := range devices {
+		if disk, ok := device.(*types.VirtualDisk); ok {
+			if backing, ok := disk.Backing.(*types.VirtualDiskFlatVer2BackingInfo); ok {
+				backings[disk.Key] = backing
+			}
+		}
+	}
+
+	return backings
+}
+
+// setDiskBackings sets the backing of the given disk devices, by device key.
+func setDiskBackings(devices []types.BaseVirtualDevice, backings map[int32]*types.VirtualDiskFlatVer2BackingInfo) {
+	for _, device := range devices {
+		if disk, ok := device.(*types.VirtualDisk); ok {
+			if backing, ok := backings[disk.Key]; ok {
+				disk.Backing = backing
+			}
+		}
+	}
+}
+
+// diskDataBacking returns the backing of the base disk in the chain of the given backing.
+// The simulator keeps the data of a disk in the extent of its base disk, delta disk extents are left empty.
+func diskDataBacking(backing types.BaseVirtualDeviceBackingInfo) types.BaseVirtualDeviceFileBackingInfo {
+	for {
+		flat, ok := backing.(*types.VirtualDiskFlatVer2BackingInfo)
+		if !ok || flat.Parent == nil {
+			break
+		}
+		backing = flat.Parent
+	}
+
+	info, _ := backing.(types.BaseVirtualDeviceFileBackingInfo)
+	return info
+}
+
+// diskChain returns the file names of the given backing and its parents.
+func diskChain(backing *types.VirtualDiskFlatVer2BackingInfo) []string {
+	var files []string
+	for ; backing != nil; backing = backing.Parent {
+		files = append(files, backing.FileName)
+	}
+	return files
+}
+
+// mergeDiskBacking returns a copy of the given backing chain, where the given child is merged into its parent.
+// The merged disk uses the file of the parent, parents are shared with the given chain.
+func mergeDiskBacking(backing *types.VirtualDiskFlatVer2BackingInfo, child string) *types.VirtualDiskFlatVer2BackingInfo {
+	if backing == nil {
+		return nil
+	}
+
+	c := *backing
+	if backing.FileName == child && backing.Parent != nil {
+		c.FileName = backing.Parent.FileName
+		c.Datastore = backing.Parent.Datastore
+		c.DeltaDiskFormat = backing.Parent.DeltaDiskFormat
+		c.Parent = backing.Parent.Parent
+		return &c
+	}
+
+	c.Parent = mergeDiskBacking(backing.Parent, child)
+	return &c
+}
+
+// snapshots returns the VM's snapshots.
+func (vm *VirtualMachine) snapshots(ctx *Context) []*VirtualMachineSnapshot {
+	if vm.Snapshot == nil {
+		return nil
+	}
+
+	var snapshots []*VirtualMachineSnapshot
+
+	for _, ref := range allSnapshotsInTree(vm.Snapshot.RootSnapshotList) {
+		if snapshot, ok := ctx.Map.Get(ref).(*VirtualMachineSnapshot); ok {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+
+	return snapshots
+}
+
+// createDeltaDisk creates a delta disk in the VM's directory, with the given backing as its parent,
+// returning the backing of the delta disk.
+func (vm *VirtualMachine) createDeltaDisk(ctx *Context, parent *types.VirtualDiskFlatVer2BackingInfo) (*types.VirtualDiskFlatVer2BackingInfo, types.BaseMethodFault) {
+	dc := ctx.Map.getEntityDatacenter(vm)
+
+	p, fault := parseDatastorePath(parent.FileName)
+	if fault != nil {
+		return nil, fault
+	}
+
+	// snapshot of a delta disk "disk-000001.vmdk" is named "disk-000002.vmdk"
+	name := strings.TrimSuffix(path.Base(vdmDeltaName.ReplaceAllString(p.Path, ".vmdk")), ".vmdk")
+
+	dir := vm.vmx(nil)
+	dir.Path = path.Dir(dir.Path)
+	ds := vm.useDatastore(dir.Datastore)
+
+	for index := 1; ; index++ {
+		delta := object.DatastorePath{
+			Datastore: dir.Datastore,
+			Path:      path.Join(dir.Path, fmt.Sprintf("%s-%06d.vmdk", name, index)),
+		}
+
+		fault = vdmCreateChildDisk(&dc.Self, delta.String(), parent.FileName)
+		if fault != nil {
+			if _, ok := fault.(*types.FileAlreadyExists); ok {
+				continue
+			}
+			return nil, fault
+		}
+
+		backing := *parent
+		backing.FileName = delta.String()
+		backing.Datastore = &ds.Self
+		backing.DeltaDiskFormat = string(types.VirtualDiskDeltaDiskFormatRedoLogFormat)
+		backing.Parent = parent
+
+		return &backing, nil
+	}
+}
+
+// deleteDisk removes the files of the given disk from the datastore.
+func (vm *VirtualMachine) deleteDisk(ctx *Context, name string) {
+	dc := ctx.Map.getEntityDatacenter(vm)
+
+	file, fault := ctx.Map.FileManager().resolve(&dc.Self, name)
+	if fault != nil {
+		return
+	}
+
+	for _, name := range vdmNames(file) {
+		_ = os.Remove(name)
+	}
+}
+
+// diskReferences returns the disk files used by the VM and its snapshots, and the disk files shared with other VMs,
+// such as the parent disks of linked clones.
+func (vm *VirtualMachine) diskReferences(ctx *Context) (map[string]bool, map[string]bool) {
+	used := make(map[string]bool)
+	shared := make(map[string]bool)
+
+	devices := [][]types.BaseVirtualDevice{vm.Config.Hardware.Device}
+	for _, snapshot := range vm.snapshots(ctx) {
+		devices = append(devices, snapshot.Config.Hardware.Device)
+	}
+
+	for _, list := range devices {
+		for _, backing := range diskBackings(list) {
+			for _, file := range diskChain(backing) {
+				used[file] = true
+			}
+		}
+	}
+
+	for _, obj := range ctx.Map.All("VirtualMachine") {
+		other := obj.(*VirtualMachine)
+		if other == vm || other.Config == nil {
+			continue
+		}
+
+		for _, backing := range diskBackings(other.Config.Hardware.Device) {
+			for _, file := range diskChain(backing) {
+				shared[file] = true
+			}
+		}
+	}
+
+	return used, shared
+}
+
+// deleteUnusedDisks removes the given disk files that are no longer used by the VM, its snapshots or other VMs.
+func (vm *VirtualMachine) deleteUnusedDisks(ctx *Context, files []string) {
+	used, shared := vm.diskReferences(ctx)
+
+	for _, file := range files {
+		if !used[file] && !shared[file] {
+			vm.deleteDisk(ctx, file)
+		}
+	}
+}
+
+// findDiskMerge returns the backing of a delta disk that can be merged into its parent, if any.
+// A delta disk can be merged if its parent is not the current state of a snapshot or shared with another VM,
+// and the parent has no other child disk.
+func (vm *VirtualMachine) findDiskMerge(ctx *Context) *types.VirtualDiskFlatVer2BackingInfo {
+	_, shared := vm.diskReferences(ctx)
+	frozen := make(map[string]bool)
+	children := make(map[string]map[string]bool)
+
+	var backings []*types.VirtualDiskFlatVer2BackingInfo
+	for _, backing := range diskBackings(vm.Config.Hardware.Device) {
+		backings = append(backings, backing)
+	}
+	for _, snapshot := range vm.snapshots(ctx) {
+		for _, backing := range diskBackings(snapshot.Config.Hardware.Device) {
+			frozen[backing.FileName] = true
+			backings = append(backings, backing)
+		}
+	}
+
+	for _, backing := range backings {
+		for b := backing; b.Parent != nil; b = b.Parent {
+			if children[b.Parent.FileName] == nil {
+				children[b.Parent.FileName] = make(map[string]bool)
+			}
+			children[b.Parent.FileName][b.FileName] = true
+		}
+	}
+
+	for _, backing := range backings {
+		for b := backing; b.Parent != nil; b = b.Parent {
+			parent := b.Parent.FileName
+			if frozen[parent] || shared[parent] || shared[b.FileName] || len(children[parent]) != 1 {
+				continue
+			}
+			return b
+		}
+	}
+
+	return nil
+}
+
+// mergeDisk merges the given delta disk into its parent, updating the disk chain of the VM and its snapshots.
+func (vm *VirtualMachine) mergeDisk(ctx *Context, child *types.VirtualDiskFlatVer2BackingInfo) {
+	dc := ctx.Map.getEntityDatacenter(vm)
+	fm := ctx.Map.FileManager()
+
+	// the merged disk has the capacity of the child, which may have been extended
+	cfile, _ := fm.resolve(&dc.Self, child.FileName)
+	pfile, _ := fm.resolve(&dc.Self, child.Parent.FileName)
+	if cdesc, err := vdmReadDescriptor(cfile); err == nil {
+		if pdesc, err := vdmReadDescriptor(pfile); err == nil && pdesc.CapacityKB < cdesc.CapacityKB {
+			pdesc.CapacityKB = cdesc.CapacityKB
+			_ = pdesc.write(pfile)
+		}
+	}
+
+	devices := [][]types.BaseVirtualDevice{vm.Config.Hardware.Device}
+	for _, snapshot := range vm.snapshots(ctx) {
+		devices = append(devices, snapshot.Config.Hardware.Device)
+	}
+
+	for _, list := range devices {
+		backings := diskBackings(list)
+		for key, backing := range backings {
+			backings[key] = mergeDiskBacking(backing, child.FileName)
+		}
+		setDiskBackings(list, backings)
+	}
+
+	vm.deleteDisk(ctx, child.FileName)
+}
+
+// updateDiskChains updates the VM's disk layouts and runtime.consolidationNeeded after a change to the VM's disk chains.
+// If consolidate is true, delta disks that are no longer needed are merged into their parent.
+func (vm *VirtualMachine) updateDiskChains(ctx *Context, consolidate bool) {
+	if consolidate {
+		for child := vm.findDiskMerge(ctx); child != nil; child = vm.findDiskMerge(ctx) {
+			vm.mergeDisk(ctx, child)
+		}
+	}
+
+	vm.updateDiskLayouts()
+
+	ctx.Map.Update(vm, []types.PropertyChange{
+		{Name: "config.hardware.device", Val: vm.Config.Hardware.Device},
+		{Name: "runtime.consolidationNeeded", Val: vm.findDiskMerge(ctx) != nil},
+	})
+}
+
+// createDeltaDisks creates a delta disk for each of the VM's disks, with the current disk as parent.
+func (vm *VirtualMachine) createDeltaDisks(ctx *Context) types.BaseMethodFault {
+	backings := diskBackings(vm.Config.Hardware.Device)
+
+	for key, backing := range backings {
+		delta, fault := vm.createDeltaDisk(ctx, backing)
+		if fault != nil {
+			return fault
+		}
+		backings[key] = delta
+	}
+
+	setDiskBackings(vm.Config.Hardware.Device, backings)
+	vm.updateDiskChains(ctx, false)
+
+	return nil
+}
+
+// revertDisks reverts the VM's disks to the state of the given snapshot, using a new delta disk
+// for each disk with the snapshot's disk as parent. The VM's current delta disks are discarded.
+func (vm *VirtualMachine) revertDisks(ctx *Context, snapshot *VirtualMachineSnapshot) types.BaseMethodFault {
+	current := diskBackings(vm.Config.Hardware.Device)
+	backings := make(map[int32]*types.VirtualDiskFlatVer2BackingInfo)
+	var files []string
+
+	for key, backing := range diskBackings(snapshot.Config.Hardware.Device) {
+		if _, ok := current[key]; !ok {
+			continue // disk was added after the snapshot
+		}
+
+		delta, fault := vm.createDeltaDisk(ctx, backing)
+		if fault != nil {
+			return fault
+		}
+		backings[key] = delta
+		files = append(files, diskChain(current[key])...)
+	}
+
+	setDiskBackings(vm.Config.Hardware.Device, backings)
+	vm.deleteUnusedDisks(ctx, files)
+	vm.updateDiskChains(ctx, false)
+
+	return nil
+}
+
 func (v *VirtualMachineSnapshot) createSnapshotFiles() types.BaseMethodFault {
 	vm := Map.Get(v.Vm).(*VirtualMachine)
 
@@ -73,8 +416,6 @@ func (v *VirtualMachineSnapshot) createSnapshotFiles() types.BaseMethodFault {
 }
 
 func (v *VirtualMachineSnapshot) removeSnapshotFiles(ctx *Context) types.BaseMethodFault {
-	// TODO: also remove delta disks that were created when snapshot was taken
-
 	vm := ctx.Map.Get(v.Vm).(*VirtualMachine)
 
 	for idx, sLayout := range vm.Layout.Snapshot {
@@ -121,6 +462,22 @@ func (v *VirtualMachineSnapshot) RemoveSnapshotTask(ctx *Context, req *types.Rem
 				changes = append(changes, types.PropertyChange{Name: "snapshot.currentSnapshot", Val: parent})
 			}
 
+			removed := []types.ManagedObjectReference{req.This}
+			if req.RemoveChildren {
+				if ss := findSnapshotInTree(vm.Snapshot.RootSnapshotList, req.This); ss != nil {
+					removed = append(removed, allSnapshotsInTree(ss.ChildSnapshotList)...)
+				}
+			}
+			var files []string
+			for _, ref := range removed {
+				if snapshot, ok := ctx.Map.Get(ref).(*VirtualMachineSnapshot); ok {
+					for _, backing := range diskBackings(snapshot.Config.Hardware.Device) {
+						files = append(files, diskChain(backing)...)
+					}
+					vm.pruneChangeTracking(snapshot)
+				}
+			}
+
 			rootSnapshots := removeSnapshotInTree(vm.Snapshot.RootSnapshotList, req.This, req.RemoveChildren)
 			changes = append(changes, types.PropertyChange{Name: "snapshot.rootSnapshotList", Val: rootSnapshots})
 
@@ -140,6 +497,9 @@ func (v *VirtualMachineSnapshot) RemoveSnapshotTask(ctx *Context, req *types.Rem
 			ctx.Map.Get(req.This).(*VirtualMachineSnapshot).removeSnapshotFiles(ctx)
 
 			ctx.Map.Update(vm, changes)
+
+			vm.deleteUnusedDisks(ctx, files)
+			vm.updateDiskChains(ctx, req.Consolidate == nil || *req.Consolidate)
 		})
 
 		ctx.Map.Remove(ctx, req.This)
@@ -154,18 +514,40 @@ func (v *VirtualMachineSnapshot) RemoveSnapshotTask(ctx *Context, req *types.Rem
 	}
 }
 
+func (v *VirtualMachineSnapshot) ExportSnapshot(ctx *Context, req *types.ExportSnapshot) soap.HasFault {
+	vm := ctx.Map.Get(v.Vm).(*VirtualMachine)
+	lease := newHttpNfcLease(ctx)
+	ref := lease.Reference()
+
+	CreateTask(v, "ExportSnapshotLRO", func(*Task) (types.AnyType, types.BaseMethodFault) {
+		lease.ready(ctx, vm.Self, lease.export(vm, v.Config.Hardware.Device, ""))
+		return nil, nil
+	}).Run(ctx)
+
+	return &methods.ExportSnapshotBody{
+		Res: &types.ExportSnapshotResponse{
+			Returnval: ref,
+		},
+	}
+}
+
 func (v *VirtualMachineSnapshot) RevertToSnapshotTask(ctx *Context, req *types.RevertToSnapshot_Task) soap.HasFault {
 	task := CreateTask(v.Vm, "revertToSnapshot", func(t *Task) (types.AnyType, types.BaseMethodFault) {
 		vm := ctx.Map.Get(v.Vm).(*VirtualMachine)
 
+		var fault types.BaseMethodFault
+
 		ctx.WithLock(vm, func() {
+			if fault = vm.revertDisks(ctx, v); fault != nil {
+				return
+			}
 			vm.DataSets = copyDataSetsForVmClone(v.DataSets)
 			ctx.Map.Update(vm, []types.PropertyChange{
 				{Name: "snapshot.currentSnapshot", Val: v.Self},
 			})
 		})
 
-		return nil, nil
+		return nil, fault
 	})
 
 	return &methods.RevertToSnapshot_TaskBody{
@@ -0,0 +1,194 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zhengkes/govmomi/find"
+	"github.com/zhengkes/govmomi/object"
+	"github.com/zhengkes/govmomi/vim25"
+	"github.com/zhengkes/govmomi/vim25/methods"
+	"github.com/zhengkes/govmomi/vim25/mo"
+	"github.com/zhengkes/govmomi/vim25/types"
+)
+
+func TestSnapshotDiskChain(t *testing.T) {
+	m := ESX()
+
+	err := m.Run(func(ctx context.Context, c *vim25.Client) error {
+		vm, err := find.NewFinder(c).VirtualMachine(ctx, "ha-host_VM0")
+		if err != nil {
+			return err
+		}
+		dm := object.NewVirtualDiskManager(c)
+
+		wait := func(task *object.Task, err error) {
+			t.Helper()
+			if err == nil {
+				err = task.Wait(ctx)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var mvm mo.VirtualMachine
+		chain := func() []string {
+			t.Helper()
+			if err := vm.Properties(ctx, vm.Reference(), []string{"config.hardware", "layoutEx", "runtime"}, &mvm); err != nil {
+				t.Fatal(err)
+			}
+			disk := object.VirtualDeviceList(mvm.Config.Hardware.Device).SelectByType((*types.VirtualDisk)(nil))[0].(*types.VirtualDisk)
+			files := diskChain(disk.Backing.(*types.VirtualDiskFlatVer2BackingInfo))
+			if n := len(mvm.LayoutEx.Disk[0].Chain); n != len(files) {
+				t.Errorf("layoutEx chain=%d, files=%v", n, files)
+			}
+			return files
+		}
+
+		exists := func(name string) bool {
+			file, _ := Map.FileManager().resolve(nil, name)
+			_, err := os.Stat(file)
+			return err == nil
+		}
+
+		base := chain()
+		if len(base) != 1 {
+			t.Fatalf("chain=%v", base)
+		}
+
+		// each snapshot adds a delta disk to the chain
+		wait(vm.CreateSnapshot(ctx, "s1", "", false, false))
+		wait(vm.CreateSnapshot(ctx, "s2", "", false, false))
+
+		files := chain()
+		if len(files) != 3 || files[2] != base[0] || !strings.HasSuffix(files[0], "-000002.vmdk") {
+			t.Fatalf("chain=%v", files)
+		}
+		for _, name := range files {
+			if !exists(name) || !exists(vdmNames(name)[0]) {
+				t.Errorf("%s does not exist", name)
+			}
+		}
+		if len(mvm.LayoutEx.Snapshot) != 2 || len(mvm.LayoutEx.Snapshot[1].Disk[0].Chain) != 2 {
+			t.Errorf("snapshot layout=%#v", mvm.LayoutEx.Snapshot)
+		}
+
+		info, err := dm.QueryVirtualDiskInfo(ctx, files[0], nil, true)
+		if err != nil {
+			return err
+		}
+		if len(info) != 3 || info[0].Parent != files[1] || info[1].Parent != files[2] {
+			t.Errorf("info=%#v", info)
+		}
+
+		// removing s1 without consolidation leaves a redundant delta disk
+		wait(vm.RemoveSnapshot(ctx, "s1", false, types.NewBool(false)))
+		if !reflect.DeepEqual(chain(), files) || !isTrue(mvm.Runtime.ConsolidationNeeded) {
+			t.Errorf("chain=%v, consolidationNeeded=%v", chain(), mvm.Runtime.ConsolidationNeeded)
+		}
+
+		res, err := methods.ConsolidateVMDisks_Task(ctx, c, &types.ConsolidateVMDisks_Task{This: vm.Reference()})
+		if err != nil {
+			return err
+		}
+		wait(object.NewTask(c, res.Returnval), nil)
+
+		consolidated := chain()
+		if len(consolidated) != 2 || consolidated[0] != files[0] || consolidated[1] != base[0] || isTrue(mvm.Runtime.ConsolidationNeeded) {
+			t.Errorf("chain=%v, consolidationNeeded=%v", consolidated, mvm.Runtime.ConsolidationNeeded)
+		}
+		if exists(files[1]) {
+			t.Errorf("%s was not removed", files[1])
+		}
+
+		// revert discards the current delta disk
+		wait(vm.RevertToSnapshot(ctx, "s2", true))
+		reverted := chain()
+		if len(reverted) != 2 || reverted[1] != base[0] || exists(files[0]) {
+			t.Errorf("chain=%v", reverted)
+		}
+
+		// linked clone of the snapshot
+		ref, err := vm.FindSnapshot(ctx, "s2")
+		if err != nil {
+			return err
+		}
+		folder, err := find.NewFinder(c).DefaultFolder(ctx)
+		if err != nil {
+			return err
+		}
+		pool, err := vm.ResourcePool(ctx)
+		if err != nil {
+			return err
+		}
+		poolRef := pool.Reference()
+		task, err := vm.Clone(ctx, folder, "linked", types.VirtualMachineCloneSpec{
+			Location: types.VirtualMachineRelocateSpec{
+				Pool:         &poolRef,
+				DiskMoveType: string(types.VirtualMachineRelocateDiskMoveOptionsCreateNewChildDiskBacking),
+			},
+			Snapshot: ref,
+		})
+		if err != nil {
+			return err
+		}
+		cinfo, err := task.WaitForResult(ctx, nil)
+		if err != nil {
+			return err
+		}
+		clone := object.NewVirtualMachine(c, cinfo.Result.(types.ManagedObjectReference))
+		devices, err := clone.Device(ctx)
+		if err != nil {
+			return err
+		}
+		backing := devices.SelectByType((*types.VirtualDisk)(nil))[0].(*types.VirtualDisk).Backing.(*types.VirtualDiskFlatVer2BackingInfo)
+		if backing.Parent == nil || backing.Parent.FileName != base[0] {
+			t.Errorf("clone chain=%v", diskChain(backing))
+		}
+
+		// the base disk is shared with the linked clone, so the delta disk is not merged into it
+		wait(vm.RemoveAllSnapshot(ctx, nil))
+		if files = chain(); !reflect.DeepEqual(files, reverted) {
+			t.Errorf("chain=%v", files)
+		}
+
+		wait(clone.Destroy(ctx))
+		res, err = methods.ConsolidateVMDisks_Task(ctx, c, &types.ConsolidateVMDisks_Task{This: vm.Reference()})
+		if err != nil {
+			return err
+		}
+		wait(object.NewTask(c, res.Returnval), nil)
+
+		if files = chain(); len(files) != 1 || files[0] != base[0] {
+			t.Errorf("chain=%v", files)
+		}
+		if exists(reverted[0]) {
+			t.Errorf("%s was not removed", reverted[0])
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
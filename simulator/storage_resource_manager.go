@@ -18,7 +18,6 @@ package simulator
 
 import (
 	"strconv"
-	"time"
 
 	"github.com/zhengkes/govmomi/object"
 	"github.com/zhengkes/govmomi/vim25/methods"
@@ -74,7 +73,7 @@ func (m *StorageResourceManager) pod(ref *types.ManagedObjectReference) *Storage
 	return cluster
 }
 
-func (m *StorageResourceManager) RecommendDatastores(req *types.RecommendDatastores) soap.HasFault {
+func (m *StorageResourceManager) RecommendDatastores(ctx *Context, req *types.RecommendDatastores) soap.HasFault {
 	spec := req.StorageSpec.PodSelectionSpec
 	body := new(methods.RecommendDatastoresBody)
 	res := new(types.RecommendDatastoresResponse)
@@ -90,7 +89,7 @@ func (m *StorageResourceManager) RecommendDatastores(req *types.RecommendDatasto
 		res.Returnval.Recommendations = append(res.Returnval.Recommendations, types.ClusterRecommendation{
 			Key:            strconv.Itoa(key),
 			Type:           "V1",
-			Time:           time.Now(),
+			Time:           ctx.Now(),
 			Rating:         1,
 			Reason:         "storagePlacement",
 			ReasonText:     "Satisfy storage initial placement requests",
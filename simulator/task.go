@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"reflect"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/zhengkes/govmomi/vim25/methods"
@@ -42,6 +43,45 @@ type Task struct {
 
 	ctx     *Context
 	Execute func(*Task) (types.AnyType, types.BaseMethodFault)
+
+	// done is closed when the task completes, see Task.Wait
+	done   chan struct{}
+	doneMu sync.Mutex
+}
+
+// newTask returns a Task with its completion channel initialized.
+func newTask() *Task {
+	return &Task{done: make(chan struct{})}
+}
+
+// complete signals Task.Wait callers that the task has reached a terminal state.
+func (t *Task) complete() {
+	t.doneMu.Lock()
+	defer t.doneMu.Unlock()
+
+	if t.done == nil {
+		return
+	}
+	select {
+	case <-t.done:
+	default:
+		close(t.done)
+	}
+}
+
+// pending returns the completion channel of the task, reset if a completed task is run again.
+func (t *Task) pending(reset bool) chan struct{} {
+	t.doneMu.Lock()
+	defer t.doneMu.Unlock()
+
+	if reset && t.done != nil {
+		select {
+		case <-t.done:
+			t.done = make(chan struct{})
+		default:
+		}
+	}
+	return t.done
 }
 
 func NewTask(runner TaskRunner) *Task {
@@ -60,9 +100,8 @@ func CreateTask(e mo.Reference, name string, run func(*Task) (types.AnyType, typ
 		name = id + vTaskSuffix
 	}
 
-	task := &Task{
-		Execute: run,
-	}
+	task := newTask()
+	task.Execute = run
 
 	task.Self = Map.newReference(task)
 	task.Info.Key = task.Self.Value
@@ -72,7 +111,7 @@ func CreateTask(e mo.Reference, name string, run func(*Task) (types.AnyType, typ
 	task.Info.Entity = &ref
 	task.Info.EntityName = ref.Value
 	task.Info.Reason = &types.TaskReasonUser{UserName: "vcsim"} // TODO: Context.Session.User
-	task.Info.QueueTime = time.Now()
+	task.Info.QueueTime = Map.Clock().Now()
 	task.Info.State = types.TaskInfoStateQueued
 
 	Map.Put(task)
@@ -97,12 +136,20 @@ func (tr *taskReference) Reference() types.ManagedObjectReference {
 
 func (t *Task) Run(ctx *Context) types.ManagedObjectReference {
 	t.ctx = ctx
+	if fault := ctx.taskFault; fault != nil {
+		ctx.taskFault = nil
+		t.Execute = func(*Task) (types.AnyType, types.BaseMethodFault) {
+			return nil, fault
+		}
+	}
 	// alias the global Map to reduce data races in tests that reset the
 	// global Map variable.
 	vimMap := Map
 
+	t.pending(true)
+
 	vimMap.AtomicUpdate(t.ctx, t, []types.PropertyChange{
-		{Name: "info.startTime", Val: time.Now()},
+		{Name: "info.startTime", Val: ctx.Now()},
 		{Name: "info.state", Val: types.TaskInfoStateRunning},
 	})
 
@@ -141,11 +188,12 @@ func (t *Task) Run(ctx *Context) types.ManagedObjectReference {
 		}
 
 		vimMap.AtomicUpdate(t.ctx, t, []types.PropertyChange{
-			{Name: "info.completeTime", Val: time.Now()},
+			{Name: "info.completeTime", Val: t.ctx.Now()},
 			{Name: "info.state", Val: state},
 			{Name: "info.result", Val: res},
 			{Name: "info.error", Val: fault},
 		})
+		t.complete()
 	}()
 
 	return t.Self
@@ -153,7 +201,7 @@ func (t *Task) Run(ctx *Context) types.ManagedObjectReference {
 
 // RunBlocking() should only be used when an async simulator task needs to wait
 // on another async simulator task.
-// It polls for task completion to avoid the need to set up a PropertyCollector.
+// It waits for task completion without the need to set up a PropertyCollector.
 func (t *Task) RunBlocking(ctx *Context) {
 	_ = t.Run(ctx)
 	t.Wait()
@@ -161,6 +209,11 @@ func (t *Task) RunBlocking(ctx *Context) {
 
 // Wait blocks until the task is complete.
 func (t *Task) Wait() {
+	if done := t.pending(false); done != nil {
+		<-done
+		return
+	}
+
 	// we do NOT want to share our lock with the tasks's context, because
 	// the goroutine that executes the task will use ctx to update the
 	// state (among other things).
@@ -202,9 +255,9 @@ func (t *Task) SetTaskState(ctx *Context, req *types.SetTaskState) soap.HasFault
 
 	switch req.State {
 	case types.TaskInfoStateRunning:
-		changes = append(changes, types.PropertyChange{Name: "info.startTime", Val: time.Now()})
+		changes = append(changes, types.PropertyChange{Name: "info.startTime", Val: ctx.Now()})
 	case types.TaskInfoStateError, types.TaskInfoStateSuccess:
-		changes = append(changes, types.PropertyChange{Name: "info.completeTime", Val: time.Now()})
+		changes = append(changes, types.PropertyChange{Name: "info.completeTime", Val: ctx.Now()})
 
 		if req.Fault != nil {
 			changes = append(changes, types.PropertyChange{Name: "info.error", Val: req.Fault})
@@ -215,6 +268,9 @@ func (t *Task) SetTaskState(ctx *Context, req *types.SetTaskState) soap.HasFault
 	}
 
 	ctx.Map.Update(t, changes)
+	if t.isDone() {
+		t.complete()
+	}
 
 	body.Res = new(types.SetTaskStateResponse)
 	return body
@@ -258,7 +314,7 @@ func (t *Task) CancelTask(ctx *Context, req *types.CancelTask) soap.HasFault {
 
 	changes := []types.PropertyChange{
 		{Name: "info.canceled", Val: true},
-		{Name: "info.completeTime", Val: time.Now()},
+		{Name: "info.completeTime", Val: ctx.Now()},
 		{Name: "info.state", Val: types.TaskInfoStateError},
 		{Name: "info.error", Val: &types.LocalizedMethodFault{
 			Fault:            &types.RequestCanceled{},
@@ -267,6 +323,7 @@ func (t *Task) CancelTask(ctx *Context, req *types.CancelTask) soap.HasFault {
 	}
 
 	ctx.Map.Update(t, changes)
+	t.complete()
 
 	body.Res = new(types.CancelTaskResponse)
 	return body
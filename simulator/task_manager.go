@@ -18,8 +18,8 @@ package simulator
 
 import (
 	"container/list"
+	"sort"
 	"sync"
-	"time"
 
 	"github.com/zhengkes/govmomi/simulator/esx"
 	"github.com/zhengkes/govmomi/simulator/vpx"
@@ -57,6 +57,27 @@ func (m *TaskManager) init(r *Registry) {
 	r.AddHandler(m)
 }
 
+// restore rebuilds the task history from the tasks loaded from a checkpoint
+func (m *TaskManager) restore(ctx *Context) {
+	var tasks []*Task
+	for _, obj := range ctx.Map.AllReference("Task") {
+		if task, ok := obj.(*Task); ok {
+			tasks = append(tasks, task)
+		}
+	}
+
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].Info.QueueTime.Before(tasks[j].Info.QueueTime)
+	})
+
+	m.Lock()
+	m.history.page.Init()
+	for _, task := range tasks {
+		pushHistory(m.history.page, task)
+	}
+	m.Unlock()
+}
+
 func recentTask(recent []types.ManagedObjectReference, ref types.ManagedObjectReference) []types.PropertyChange {
 	// TODO: tasks completed > 10m ago should be removed
 	recent = append(recent, ref)
@@ -161,7 +182,7 @@ func (m *TaskManager) CreateTask(ctx *Context, req *types.CreateTask) soap.HasFa
 		return body
 	}
 
-	task := &Task{}
+	task := newTask()
 
 	task.Self = ctx.Map.newReference(task)
 	task.Info.Key = task.Self.Value
@@ -171,7 +192,7 @@ func (m *TaskManager) CreateTask(ctx *Context, req *types.CreateTask) soap.HasFa
 	task.Info.Entity = &req.Obj
 	task.Info.EntityName = req.Obj.Value
 	task.Info.Reason = &types.TaskReasonUser{UserName: ctx.Session.UserName}
-	task.Info.QueueTime = time.Now()
+	task.Info.QueueTime = ctx.Now()
 	task.Info.State = types.TaskInfoStateQueued
 
 	body.Res = &types.CreateTaskResponse{Returnval: task.Info}
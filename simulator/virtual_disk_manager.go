@@ -17,12 +17,18 @@ limitations under the License.
 package simulator
 
 import (
+	"bytes"
 	"fmt"
 	"os"
+	"path"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/google/uuid"
 
+	"github.com/zhengkes/govmomi/object"
+	"github.com/zhengkes/govmomi/simulator/internal"
 	"github.com/zhengkes/govmomi/vim25/methods"
 	"github.com/zhengkes/govmomi/vim25/mo"
 	"github.com/zhengkes/govmomi/vim25/soap"
@@ -37,9 +43,17 @@ func (m *VirtualDiskManager) MO() mo.VirtualDiskManager {
 	return m.VirtualDiskManager
 }
 
+// vdmDeltaName matches the name of a delta disk, as created for a snapshot, such as "disk-000001.vmdk"
+var vdmDeltaName = regexp.MustCompile(`-\d{6}\.vmdk$`)
+
 func vdmNames(name string) []string {
+	extent := "-flat.vmdk"
+	if vdmDeltaName.MatchString(name) {
+		extent = "-delta.vmdk"
+	}
+
 	return []string{
-		strings.Replace(name, ".vmdk", "-flat.vmdk", 1),
+		strings.Replace(name, ".vmdk", extent, 1),
 		name,
 	}
 }
@@ -64,7 +78,7 @@ func vdmCreateVirtualDisk(op types.VirtualDeviceConfigSpecFileOperation, req *ty
 				if err = os.Truncate(file, 0); err != nil {
 					return fm.fault(name, err, new(types.CannotCreateFile))
 				}
-				return nil
+				break
 			}
 			return fm.fault(name, nil, new(types.FileAlreadyExists))
 		} else if shouldExist {
@@ -79,6 +93,237 @@ func vdmCreateVirtualDisk(op types.VirtualDeviceConfigSpecFileOperation, req *ty
 		_ = f.Close()
 	}
 
+	desc := newVdmDescriptor()
+	if spec, ok := req.Spec.(*types.FileBackedVirtualDiskSpec); ok {
+		desc.CapacityKB = spec.CapacityKb
+		if spec.DiskType != "" {
+			desc.DiskType = spec.DiskType
+		}
+		if spec.AdapterType != "" {
+			desc.AdapterType = spec.AdapterType
+		}
+	}
+
+	if err := desc.write(file); err != nil {
+		return fm.fault(file, err, new(types.CannotCreateFile))
+	}
+
+	return nil
+}
+
+// vdmCreateChildDisk creates a delta disk with the given name, with the given disk as its parent.
+func vdmCreateChildDisk(dc *types.ManagedObjectReference, name string, parent string) types.BaseMethodFault {
+	fm := Map.FileManager()
+
+	pfile, fault := fm.resolve(dc, parent)
+	if fault != nil {
+		return fault
+	}
+
+	pdesc, err := vdmReadDescriptor(pfile)
+	if err != nil {
+		return fm.fault(parent, err, new(types.CannotAccessFile))
+	}
+
+	fault = vdmCreateVirtualDisk(types.VirtualDeviceConfigSpecFileOperationCreate, &types.CreateVirtualDisk_Task{
+		Datacenter: dc,
+		Name:       name,
+		Spec: &types.FileBackedVirtualDiskSpec{
+			VirtualDiskSpec: types.VirtualDiskSpec{
+				DiskType:    string(types.VirtualDiskTypeDelta),
+				AdapterType: pdesc.AdapterType,
+			},
+			CapacityKb: pdesc.CapacityKB,
+		},
+	})
+	if fault != nil {
+		return fault
+	}
+
+	file, _ := fm.resolve(dc, name)
+
+	desc, err := vdmReadDescriptor(file)
+	if err != nil {
+		return fm.fault(name, err, new(types.CannotCreateFile))
+	}
+
+	// the parent hint is relative to the child's directory, when both are in the same directory
+	desc.Parent = parent
+	if path.Dir(pfile) == path.Dir(file) {
+		desc.Parent = path.Base(pfile)
+	}
+
+	if err = desc.write(file); err != nil {
+		return fm.fault(name, err, new(types.CannotCreateFile))
+	}
+
+	return nil
+}
+
+// vdmDescriptor is the virtual disk metadata stored in the simulated .vmdk descriptor file,
+// using a subset of the VMDK text descriptor format. The extent (-flat.vmdk) file is left empty.
+type vdmDescriptor struct {
+	CapacityKB  int64
+	DiskType    string
+	AdapterType string
+	UUID        string
+	Parent      string
+}
+
+func newVdmDescriptor() *vdmDescriptor {
+	return &vdmDescriptor{
+		DiskType:    string(types.VirtualDiskTypeThin),
+		AdapterType: string(types.VirtualDiskAdapterTypeLsiLogic),
+	}
+}
+
+// vdmReadDescriptor parses the given descriptor file.
+// Files that are not text descriptors, such as those uploaded by clients, are treated as thin disks of unknown capacity.
+func vdmReadDescriptor(file string) (*vdmDescriptor, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	d := newVdmDescriptor()
+
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+
+		if fields := strings.Fields(line); len(fields) == 4 && fields[0] == "RW" {
+			if sectors, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+				d.CapacityKB = sectors / 2
+			}
+			continue
+		}
+
+		key, val, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		val = strings.Trim(strings.TrimSpace(val), `"`)
+
+		switch strings.TrimSpace(key) {
+		case "ddb.adapterType":
+			d.AdapterType = val
+		case "ddb.uuid":
+			d.UUID = val
+		case "ddb.sim.diskType":
+			d.DiskType = val
+		case "parentFileNameHint":
+			d.Parent = val
+		}
+	}
+
+	return d, nil
+}
+
+func (d *vdmDescriptor) write(file string) error {
+	var buf bytes.Buffer
+
+	parentCID := "ffffffff"
+	createType, extentType := "vmfs", "VMFS"
+	if d.Parent != "" {
+		parentCID = "fffffffe"
+		createType, extentType = "vmfsSparse", "VMFSSPARSE"
+	}
+
+	fmt.Fprintf(&buf, "# Disk DescriptorFile\nversion=1\nCID=fffffffe\nparentCID=%s\ncreateType=%q\n", parentCID, createType)
+	if d.Parent != "" {
+		fmt.Fprintf(&buf, "parentFileNameHint=%q\n", d.Parent)
+	}
+	fmt.Fprintf(&buf, "\n# Extent description\nRW %d %s %q\n", d.CapacityKB*2, extentType, path.Base(vdmNames(file)[0]))
+	fmt.Fprintf(&buf, "\n# The Disk Data Base\n#DDB\n\n")
+	fmt.Fprintf(&buf, "ddb.adapterType = %q\n", d.AdapterType)
+	if d.DiskType == string(types.VirtualDiskTypeThin) {
+		fmt.Fprintf(&buf, "ddb.thinProvisioned = \"1\"\n")
+	}
+	fmt.Fprintf(&buf, "ddb.sim.diskType = %q\n", d.DiskType)
+	if d.UUID != "" {
+		fmt.Fprintf(&buf, "ddb.uuid = %q\n", d.UUID)
+	}
+
+	return os.WriteFile(file, buf.Bytes(), 0600)
+}
+
+// vdmDisk is a VirtualDisk device backed by a virtual disk file.
+type vdmDisk struct {
+	vm   *VirtualMachine
+	disk *types.VirtualDisk
+}
+
+// vdmAttached returns the VM disks backed by the given file, either directly or as a parent in the backing chain.
+func vdmAttached(ctx *Context, file string) []vdmDisk {
+	var disks []vdmDisk
+
+	for _, obj := range ctx.Map.All("VirtualMachine") {
+		vm := obj.(*VirtualMachine)
+
+		ctx.WithLock(vm, func() {
+			if vm.Config == nil {
+				return
+			}
+
+			for _, device := range object.VirtualDeviceList(vm.Config.Hardware.Device).SelectByType((*types.VirtualDisk)(nil)) {
+				disk := device.(*types.VirtualDisk)
+
+				for backing := disk.Backing; backing != nil; {
+					info, ok := backing.(types.BaseVirtualDeviceFileBackingInfo)
+					if !ok {
+						break
+					}
+
+					if p, fault := parseDatastorePath(info.GetVirtualDeviceFileBackingInfo().FileName); fault == nil {
+						if ds, ok := ctx.Map.FindByName(p.Datastore, vm.Datastore).(*Datastore); ok {
+							if path.Join(ds.Info.GetDatastoreInfo().Url, p.Path) == file {
+								disks = append(disks, vdmDisk{vm, disk})
+								break
+							}
+						}
+					}
+
+					flat, ok := backing.(*types.VirtualDiskFlatVer2BackingInfo)
+					if !ok || flat.Parent == nil {
+						break
+					}
+					backing = flat.Parent
+				}
+			}
+		})
+	}
+
+	return disks
+}
+
+// vdmUpdate applies the given func to the descriptor of the given disk.
+// A FileLocked fault is returned if the disk is in use by a VM that is not powered off.
+func vdmUpdate(ctx *Context, dc *types.ManagedObjectReference, name string, update func(*vdmDescriptor) types.BaseMethodFault) types.BaseMethodFault {
+	fm := ctx.Map.FileManager()
+
+	file, fault := fm.resolve(dc, name)
+	if fault != nil {
+		return fault
+	}
+
+	desc, err := vdmReadDescriptor(file)
+	if err != nil {
+		return fm.fault(name, err, new(types.CannotAccessFile))
+	}
+
+	for _, d := range vdmAttached(ctx, file) {
+		if d.vm.Runtime.PowerState != types.VirtualMachinePowerStatePoweredOff {
+			return &types.FileLocked{FileFault: types.FileFault{File: name}}
+		}
+	}
+
+	if fault = update(desc); fault != nil {
+		return fault
+	}
+
+	if err = desc.write(file); err != nil {
+		return fm.fault(name, err, new(types.CannotAccessFile))
+	}
+
 	return nil
 }
 
@@ -206,23 +451,214 @@ func (m *VirtualDiskManager) QueryVirtualDiskUuid(ctx *Context, req *types.Query
 		return body
 	}
 
-	_, err := os.Stat(file)
+	desc, err := vdmReadDescriptor(file)
 	if err != nil {
 		fault = fm.fault(req.Name, err, new(types.CannotAccessFile))
 		body.Fault_ = Fault(fmt.Sprintf("File %s was not found", req.Name), fault)
 		return body
 	}
 
+	id := desc.UUID
+	if id == "" {
+		id = virtualDiskUUID(req.Datacenter, file)
+	}
+
 	body.Res = &types.QueryVirtualDiskUuidResponse{
-		Returnval: virtualDiskUUID(req.Datacenter, file),
+		Returnval: id,
 	}
 
 	return body
 }
 
-func (m *VirtualDiskManager) SetVirtualDiskUuid(_ *Context, req *types.SetVirtualDiskUuid) soap.HasFault {
+// parseVirtualDiskUUID parses the given uuid in either the standard or the VMDK descriptor format,
+// where the latter separates each of the 16 bytes with a space.
+func parseVirtualDiskUUID(id string) (string, bool) {
+	u, err := uuid.Parse(strings.NewReplacer(" ", "", "-", "").Replace(id))
+	if err != nil {
+		return "", false
+	}
+	return u.String(), true
+}
+
+func (m *VirtualDiskManager) SetVirtualDiskUuid(ctx *Context, req *types.SetVirtualDiskUuid) soap.HasFault {
 	body := new(methods.SetVirtualDiskUuidBody)
-	// TODO: validate uuid format and persist
+
+	id, ok := parseVirtualDiskUUID(req.Uuid)
+	if !ok {
+		body.Fault_ = Fault("", &types.InvalidArgument{InvalidProperty: "uuid"})
+		return body
+	}
+
+	fault := vdmUpdate(ctx, req.Datacenter, req.Name, func(desc *vdmDescriptor) types.BaseMethodFault {
+		desc.UUID = id
+		return nil
+	})
+	if fault != nil {
+		body.Fault_ = Fault("", fault)
+		return body
+	}
+
 	body.Res = new(types.SetVirtualDiskUuidResponse)
 	return body
 }
+
+func (m *VirtualDiskManager) ExtendVirtualDiskTask(ctx *Context, req *types.ExtendVirtualDisk_Task) soap.HasFault {
+	task := CreateTask(m, "extendVirtualDisk", func(*Task) (types.AnyType, types.BaseMethodFault) {
+		fault := vdmUpdate(ctx, req.Datacenter, req.Name, func(desc *vdmDescriptor) types.BaseMethodFault {
+			if req.NewCapacityKb < desc.CapacityKB {
+				return &types.InvalidArgument{InvalidProperty: "newCapacityKb"}
+			}
+			desc.CapacityKB = req.NewCapacityKb
+			if isTrue(req.EagerZero) && desc.DiskType == string(types.VirtualDiskTypePreallocated) {
+				desc.DiskType = string(types.VirtualDiskTypeEagerZeroedThick)
+			}
+			return nil
+		})
+		if fault != nil {
+			return nil, fault
+		}
+
+		file, _ := ctx.Map.FileManager().resolve(req.Datacenter, req.Name)
+
+		for _, d := range vdmAttached(ctx, file) {
+			ctx.WithLock(d.vm, func() {
+				d.disk.CapacityInKB = req.NewCapacityKb
+				d.disk.CapacityInBytes = req.NewCapacityKb * 1024
+				ctx.Map.Update(d.vm, []types.PropertyChange{
+					{Name: "config.hardware.device", Val: d.vm.Config.Hardware.Device},
+				})
+			})
+		}
+
+		return nil, nil
+	})
+
+	return &methods.ExtendVirtualDisk_TaskBody{
+		Res: &types.ExtendVirtualDisk_TaskResponse{
+			Returnval: task.Run(ctx),
+		},
+	}
+}
+
+func (m *VirtualDiskManager) InflateVirtualDiskTask(ctx *Context, req *types.InflateVirtualDisk_Task) soap.HasFault {
+	task := CreateTask(m, "inflateVirtualDisk", func(*Task) (types.AnyType, types.BaseMethodFault) {
+		return nil, vdmUpdate(ctx, req.Datacenter, req.Name, func(desc *vdmDescriptor) types.BaseMethodFault {
+			if desc.DiskType != string(types.VirtualDiskTypeThin) {
+				return new(types.InvalidDiskFormat)
+			}
+			desc.DiskType = string(types.VirtualDiskTypeEagerZeroedThick)
+			return nil
+		})
+	})
+
+	return &methods.InflateVirtualDisk_TaskBody{
+		Res: &types.InflateVirtualDisk_TaskResponse{
+			Returnval: task.Run(ctx),
+		},
+	}
+}
+
+func (m *VirtualDiskManager) ShrinkVirtualDiskTask(ctx *Context, req *types.ShrinkVirtualDisk_Task) soap.HasFault {
+	task := CreateTask(m, "shrinkVirtualDisk", func(*Task) (types.AnyType, types.BaseMethodFault) {
+		return nil, vdmUpdate(ctx, req.Datacenter, req.Name, func(desc *vdmDescriptor) types.BaseMethodFault {
+			// the simulated extent file has no blocks to reclaim
+			return vdmRequireFlat(desc)
+		})
+	})
+
+	return &methods.ShrinkVirtualDisk_TaskBody{
+		Res: &types.ShrinkVirtualDisk_TaskResponse{
+			Returnval: task.Run(ctx),
+		},
+	}
+}
+
+func (m *VirtualDiskManager) EagerZeroVirtualDiskTask(ctx *Context, req *types.EagerZeroVirtualDisk_Task) soap.HasFault {
+	task := CreateTask(m, "eagerZeroVirtualDisk", func(*Task) (types.AnyType, types.BaseMethodFault) {
+		return nil, vdmUpdate(ctx, req.Datacenter, req.Name, func(desc *vdmDescriptor) types.BaseMethodFault {
+			switch types.VirtualDiskType(desc.DiskType) {
+			case types.VirtualDiskTypePreallocated, types.VirtualDiskTypeThick:
+				desc.DiskType = string(types.VirtualDiskTypeEagerZeroedThick)
+				return nil
+			case types.VirtualDiskTypeEagerZeroedThick:
+				return nil
+			default:
+				return new(types.InvalidDiskFormat)
+			}
+		})
+	})
+
+	return &methods.EagerZeroVirtualDisk_TaskBody{
+		Res: &types.EagerZeroVirtualDisk_TaskResponse{
+			Returnval: task.Run(ctx),
+		},
+	}
+}
+
+func (m *VirtualDiskManager) ZeroFillVirtualDiskTask(ctx *Context, req *types.ZeroFillVirtualDisk_Task) soap.HasFault {
+	task := CreateTask(m, "zeroFillVirtualDisk", func(*Task) (types.AnyType, types.BaseMethodFault) {
+		return nil, vdmUpdate(ctx, req.Datacenter, req.Name, vdmRequireFlat)
+	})
+
+	return &methods.ZeroFillVirtualDisk_TaskBody{
+		Res: &types.ZeroFillVirtualDisk_TaskResponse{
+			Returnval: task.Run(ctx),
+		},
+	}
+}
+
+// vdmRequireFlat returns InvalidDiskFormat for raw device mappings, which have no extent file to operate on.
+func vdmRequireFlat(desc *vdmDescriptor) types.BaseMethodFault {
+	switch types.VirtualDiskType(desc.DiskType) {
+	case types.VirtualDiskTypeRdm, types.VirtualDiskTypeRdmp:
+		return new(types.InvalidDiskFormat)
+	}
+	return nil
+}
+
+func (m *VirtualDiskManager) QueryVirtualDiskInfoTask(ctx *Context, req *internal.QueryVirtualDiskInfo_Task) soap.HasFault {
+	task := CreateTask(m, "queryVirtualDiskInfo", func(*Task) (types.AnyType, types.BaseMethodFault) {
+		fm := ctx.Map.FileManager()
+
+		var res internal.ArrayOfVirtualDiskInfo
+
+		for name := req.Name; name != ""; {
+			file, fault := fm.resolve(req.Datacenter, name)
+			if fault != nil {
+				return nil, fault
+			}
+
+			desc, err := vdmReadDescriptor(file)
+			if err != nil {
+				return nil, fm.fault(name, err, new(types.CannotAccessFile))
+			}
+
+			parent := desc.Parent
+			if parent != "" && !strings.HasPrefix(parent, "[") {
+				// relative to the child disk's directory
+				p, _ := parseDatastorePath(name)
+				p.Path = path.Join(path.Dir(p.Path), parent)
+				parent = p.String()
+			}
+
+			res.VirtualDiskInfo = append(res.VirtualDiskInfo, internal.VirtualDiskInfo{
+				Name:     name,
+				DiskType: desc.DiskType,
+				Parent:   parent,
+			})
+
+			if !req.IncludeParents {
+				break
+			}
+			name = parent
+		}
+
+		return res, nil
+	})
+
+	return &internal.QueryVirtualDiskInfo_TaskBody{
+		Res: &internal.QueryVirtualDiskInfo_TaskResponse{
+			Returnval: task.Run(ctx),
+		},
+	}
+}
@@ -25,7 +25,10 @@ import (
 	"github.com/google/uuid"
 
 	"github.com/zhengkes/govmomi"
+	"github.com/zhengkes/govmomi/find"
 	"github.com/zhengkes/govmomi/object"
+	"github.com/zhengkes/govmomi/task"
+	"github.com/zhengkes/govmomi/vim25"
 	"github.com/zhengkes/govmomi/vim25/types"
 )
 
@@ -162,3 +165,122 @@ func TestVirtualDiskManager(t *testing.T) {
 		}
 	}
 }
+
+func TestVirtualDiskManagerOperations(t *testing.T) {
+	m := ESX()
+
+	err := m.Run(func(ctx context.Context, c *vim25.Client) error {
+		dm := object.NewVirtualDiskManager(c)
+
+		name := "[LocalDS_0] ops.vmdk"
+		spec := &types.FileBackedVirtualDiskSpec{
+			VirtualDiskSpec: types.VirtualDiskSpec{
+				AdapterType: string(types.VirtualDiskAdapterTypeLsiLogic),
+				DiskType:    string(types.VirtualDiskTypeThin),
+			},
+			CapacityKb: 1024,
+		}
+
+		wait := func(task *object.Task, err error) error {
+			if err != nil {
+				return err
+			}
+			return task.Wait(ctx)
+		}
+
+		diskType := func() string {
+			info, err := dm.QueryVirtualDiskInfo(ctx, name, nil, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return info[0].DiskType
+		}
+
+		if err := wait(dm.CreateVirtualDisk(ctx, name, nil, spec)); err != nil {
+			return err
+		}
+		if kind := diskType(); kind != spec.DiskType {
+			t.Errorf("type=%s", kind)
+		}
+
+		if err := wait(dm.ExtendVirtualDisk(ctx, name, nil, 2048, nil)); err != nil {
+			return err
+		}
+		if err := wait(dm.ExtendVirtualDisk(ctx, name, nil, 1024, nil)); err == nil {
+			t.Error("expected error") // cannot shrink capacity
+		}
+
+		if err := wait(dm.EagerZeroVirtualDisk(ctx, name, nil)); err == nil {
+			t.Error("expected error") // thin disk
+		}
+		if err := wait(dm.ShrinkVirtualDisk(ctx, name, nil, nil)); err != nil {
+			return err
+		}
+		if err := wait(dm.InflateVirtualDisk(ctx, name, nil)); err != nil {
+			return err
+		}
+		if kind := diskType(); kind != string(types.VirtualDiskTypeEagerZeroedThick) {
+			t.Errorf("type=%s", kind)
+		}
+		if err := wait(dm.InflateVirtualDisk(ctx, name, nil)); err == nil {
+			t.Error("expected error") // already inflated
+		}
+		if err := wait(dm.ZeroFillVirtualDisk(ctx, name, nil)); err != nil {
+			return err
+		}
+
+		id := uuid.New().String()
+		if err := dm.SetVirtualDiskUuid(ctx, name, nil, id); err != nil {
+			return err
+		}
+		if err := dm.SetVirtualDiskUuid(ctx, name, nil, "enoent"); err == nil {
+			t.Error("expected error")
+		}
+		qid, err := dm.QueryVirtualDiskUuid(ctx, name, nil)
+		if err != nil {
+			return err
+		}
+		if qid != id {
+			t.Errorf("uuid=%s", qid)
+		}
+
+		// disks of powered on vms are locked
+		vm, err := find.NewFinder(c).VirtualMachine(ctx, "ha-host_VM0")
+		if err != nil {
+			return err
+		}
+		devices, err := vm.Device(ctx)
+		if err != nil {
+			return err
+		}
+		disk := devices.SelectByType((*types.VirtualDisk)(nil))[0].(*types.VirtualDisk)
+		file := disk.Backing.(*types.VirtualDiskFlatVer2BackingInfo).FileName
+		capacity := disk.CapacityInKB + 1024
+
+		err = wait(dm.ExtendVirtualDisk(ctx, file, nil, capacity, nil))
+		if _, ok := err.(task.Error).Fault().(*types.FileLocked); !ok {
+			t.Errorf("err=%v", err)
+		}
+
+		if err = wait(vm.PowerOff(ctx)); err != nil {
+			return err
+		}
+		if err = wait(dm.ExtendVirtualDisk(ctx, file, nil, capacity, nil)); err != nil {
+			return err
+		}
+
+		devices, err = vm.Device(ctx)
+		if err != nil {
+			return err
+		}
+		disk = devices.SelectByType((*types.VirtualDisk)(nil))[0].(*types.VirtualDisk)
+		if disk.CapacityInKB != capacity || disk.CapacityInBytes != capacity*1024 {
+			t.Errorf("capacity=%d", disk.CapacityInKB)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
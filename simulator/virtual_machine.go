@@ -49,6 +49,7 @@ type VirtualMachine struct {
 	svm *simVM
 	uid uuid.UUID
 	imc *types.CustomizationSpec
+	cbt map[int32]*changeTracker
 }
 
 func asVirtualMachineMO(obj mo.Reference) (*mo.VirtualMachine, bool) {
@@ -56,6 +57,11 @@ func asVirtualMachineMO(obj mo.Reference) (*mo.VirtualMachine, bool) {
 	return vm, ok
 }
 
+// discard removes the container backing, if any, when replaced by a checkpoint restore
+func (vm *VirtualMachine) discard(ctx *Context) {
+	_ = vm.svm.remove(ctx)
+}
+
 func NewVirtualMachine(ctx *Context, parent types.ManagedObjectReference, spec *types.VirtualMachineConfigSpec) (*VirtualMachine, types.BaseMethodFault) {
 	vm := &VirtualMachine{}
 	vm.Parent = &parent
@@ -73,6 +79,7 @@ func NewVirtualMachine(ctx *Context, parent types.ManagedObjectReference, spec *
 
 	rspec := types.DefaultResourceConfigSpec()
 	vm.Guest = &types.GuestInfo{}
+	now := ctx.Now()
 	vm.Config = &types.VirtualMachineConfigInfo{
 		ExtraConfig:        []types.BaseOptionValue{&types.OptionValue{Key: "govcsim", Value: "TRUE"}},
 		Tools:              &types.ToolsConfigInfo{},
@@ -80,20 +87,20 @@ func NewVirtualMachine(ctx *Context, parent types.ManagedObjectReference, spec *
 		CpuAllocation:      &rspec.CpuAllocation,
 		LatencySensitivity: &types.LatencySensitivity{Level: types.LatencySensitivitySensitivityLevelNormal},
 		BootOptions:        &types.VirtualMachineBootOptions{},
-		CreateDate:         types.NewTime(time.Now()),
+		CreateDate:         types.NewTime(now),
 	}
 	vm.Layout = &types.VirtualMachineFileLayout{}
 	vm.LayoutEx = &types.VirtualMachineFileLayoutEx{
-		Timestamp: time.Now(),
+		Timestamp: now,
 	}
 	vm.Snapshot = nil // intentionally set to nil until a snapshot is created
 	vm.Storage = &types.VirtualMachineStorageInfo{
-		Timestamp: time.Now(),
+		Timestamp: now,
 	}
 	vm.Summary.Guest = &types.VirtualMachineGuestSummary{}
 	vm.Summary.Vm = &vm.Self
 	vm.Summary.Storage = &types.VirtualMachineStorageSummary{
-		Timestamp: time.Now(),
+		Timestamp: now,
 	}
 
 	vmx := vm.vmx(spec)
@@ -553,6 +560,12 @@ func (vm *VirtualMachine) configure(ctx *Context, spec *types.VirtualMachineConf
 		}
 	}()
 
+	if vm.Runtime.PowerState == types.VirtualMachinePowerStatePoweredOn {
+		if err := vm.validateHotPlug(spec); err != nil {
+			return err
+		}
+	}
+
 	vm.apply(spec)
 
 	if spec.MemoryAllocation != nil {
@@ -585,7 +598,30 @@ func (vm *VirtualMachine) configure(ctx *Context, spec *types.VirtualMachineConf
 		}
 	}
 
-	return vm.configureDevices(ctx, spec)
+	if err := vm.configureDevices(ctx, spec); err != nil {
+		return err
+	}
+
+	return vm.configureChangeTracking()
+}
+
+// validateHotPlug checks that CPU and memory changes to a powered on VM are enabled by its hot plug settings.
+func (vm *VirtualMachine) validateHotPlug(spec *types.VirtualMachineConfigSpec) types.BaseMethodFault {
+	hw := vm.Config.Hardware
+
+	if n := spec.NumCPUs; n != 0 && n != hw.NumCPU {
+		if (n > hw.NumCPU && !isTrue(vm.Config.CpuHotAddEnabled)) || (n < hw.NumCPU && !isTrue(vm.Config.CpuHotRemoveEnabled)) {
+			return new(types.CpuHotPlugNotSupported)
+		}
+	}
+
+	if n := spec.MemoryMB; n != 0 && n != int64(hw.MemoryMB) {
+		if n < int64(hw.MemoryMB) || !isTrue(vm.Config.MemoryHotAddEnabled) {
+			return new(types.MemoryHotPlugNotSupported)
+		}
+	}
+
+	return nil
 }
 
 func getVMFileType(fileName string) types.VirtualMachineFileLayoutExFileType {
@@ -769,12 +805,63 @@ func (vm *VirtualMachine) addSnapshotLayoutEx(snapshot types.ManagedObjectRefere
 	vm.updateStorage()
 }
 
-// Updates both vm.Layout.Disk and vm.LayoutEx.Disk
+// virtualDiskSpec returns the spec used to create the backing file of the given disk.
+func virtualDiskSpec(disk *types.VirtualDisk) *types.FileBackedVirtualDiskSpec {
+	spec := &types.FileBackedVirtualDiskSpec{
+		VirtualDiskSpec: types.VirtualDiskSpec{
+			DiskType:    string(types.VirtualDiskTypePreallocated),
+			AdapterType: string(types.VirtualDiskAdapterTypeLsiLogic),
+		},
+		CapacityKb: disk.CapacityInKB,
+	}
+
+	if b, ok := disk.Backing.(*types.VirtualDiskFlatVer2BackingInfo); ok {
+		switch {
+		case isTrue(b.ThinProvisioned):
+			spec.DiskType = string(types.VirtualDiskTypeThin)
+		case isTrue(b.EagerlyScrub):
+			spec.DiskType = string(types.VirtualDiskTypeEagerZeroedThick)
+		}
+	}
+
+	return spec
+}
+
+// Updates both vm.Layout.Disk and vm.LayoutEx.Disk, along with the disk layout of each snapshot
 func (vm *VirtualMachine) updateDiskLayouts() types.BaseMethodFault {
+	disksLayout, disksLayoutEx, fault := vm.diskLayouts(vm.Config.Hardware.Device)
+	if fault != nil {
+		return fault
+	}
+
+	vm.Layout.Disk = disksLayout
+
+	vm.LayoutEx.Disk = disksLayoutEx
+
+	for i, snapshotLayoutEx := range vm.LayoutEx.Snapshot {
+		if snapshot, ok := Map.Get(snapshotLayoutEx.Key).(*VirtualMachineSnapshot); ok {
+			_, disks, fault := vm.diskLayouts(snapshot.Config.Hardware.Device)
+			if fault != nil {
+				return fault
+			}
+			vm.LayoutEx.Snapshot[i].Disk = disks
+		}
+	}
+
+	vm.removeUnusedDiskFiles()
+	vm.LayoutEx.Timestamp = time.Now()
+
+	vm.updateStorage()
+
+	return nil
+}
+
+// diskLayouts returns the layout of the given disk devices, adding the files of each disk's chain to vm.LayoutEx.File.
+func (vm *VirtualMachine) diskLayouts(devices []types.BaseVirtualDevice) ([]types.VirtualMachineFileLayoutDiskLayout, []types.VirtualMachineFileLayoutExDiskLayout, types.BaseMethodFault) {
 	var disksLayout []types.VirtualMachineFileLayoutDiskLayout
 	var disksLayoutEx []types.VirtualMachineFileLayoutExDiskLayout
 
-	disks := object.VirtualDeviceList(vm.Config.Hardware.Device).SelectByType((*types.VirtualDisk)(nil))
+	disks := object.VirtualDeviceList(devices).SelectByType((*types.VirtualDisk)(nil))
 	for _, disk := range disks {
 		disk := disk.(*types.VirtualDisk)
 		diskBacking := disk.Backing.(*types.VirtualDiskFlatVer2BackingInfo)
@@ -793,7 +880,7 @@ func (vm *VirtualMachine) updateDiskLayouts() types.BaseMethodFault {
 				// get full path including datastore location
 				p, fault := parseDatastorePath(diskName)
 				if fault != nil {
-					return fault
+					return nil, nil, fault
 				}
 
 				datastore := vm.useDatastore(p.Datastore)
@@ -825,14 +912,35 @@ func (vm *VirtualMachine) updateDiskLayouts() types.BaseMethodFault {
 		disksLayoutEx = append(disksLayoutEx, *diskLayoutEx)
 	}
 
-	vm.Layout.Disk = disksLayout
-
-	vm.LayoutEx.Disk = disksLayoutEx
-	vm.LayoutEx.Timestamp = time.Now()
+	return disksLayout, disksLayoutEx, nil
+}
 
-	vm.updateStorage()
+// removeUnusedDiskFiles removes disk files from vm.LayoutEx.File that are no longer used by a disk or snapshot.
+func (vm *VirtualMachine) removeUnusedDiskFiles() {
+	used := make(map[int32]bool)
+	layouts := append([]types.VirtualMachineFileLayoutExDiskLayout(nil), vm.LayoutEx.Disk...)
+	for _, snapshot := range vm.LayoutEx.Snapshot {
+		layouts = append(layouts, snapshot.Disk...)
+	}
+	for _, layout := range layouts {
+		for _, unit := range layout.Chain {
+			for _, key := range unit.FileKey {
+				used[key] = true
+			}
+		}
+	}
 
-	return nil
+	files := vm.LayoutEx.File[:0]
+	for _, file := range vm.LayoutEx.File {
+		switch types.VirtualMachineFileLayoutExFileType(file.Type) {
+		case types.VirtualMachineFileLayoutExFileTypeDiskDescriptor, types.VirtualMachineFileLayoutExFileTypeDiskExtent:
+			if !used[file.Key] {
+				continue
+			}
+		}
+		files = append(files, file)
+	}
+	vm.LayoutEx.File = files
 }
 
 func (vm *VirtualMachine) updateStorage() types.BaseMethodFault {
@@ -862,10 +970,11 @@ func (vm *VirtualMachine) updateStorage() types.BaseMethodFault {
 			}
 		}
 
-		dsUsage.Committed = file.Size
+		dsUsage.Committed += file.Size
 
 		if path.Ext(file.Name) == ".vmdk" {
-			dsUsage.Unshared = file.Size
+			dsUsage.Unshared += file.Size
+			dsUsage.Uncommitted -= file.Size
 		}
 
 		for _, disk := range disks {
@@ -873,7 +982,7 @@ func (vm *VirtualMachine) updateStorage() types.BaseMethodFault {
 			backing := disk.Backing.(types.BaseVirtualDeviceFileBackingInfo).GetVirtualDeviceFileBackingInfo()
 
 			if backing.FileName == file.Name {
-				dsUsage.Uncommitted = disk.CapacityInBytes
+				dsUsage.Uncommitted += disk.CapacityInBytes
 			}
 		}
 
@@ -976,7 +1085,7 @@ func (vm *VirtualMachine) RefreshStorageInfo(ctx *Context, req *types.RefreshSto
 		return body
 	}
 
-	vm.LayoutEx.Timestamp = time.Now()
+	vm.LayoutEx.Timestamp = ctx.Now()
 
 	body.Res = new(types.RefreshStorageInfoResponse)
 
@@ -1231,11 +1340,13 @@ func (vm *VirtualMachine) validateSwitchMembers(id string) types.BaseMethodFault
 
 	h := Map.Get(*vm.Runtime.Host).(*HostSystem)
 	c := hostParent(&h.HostSystem)
+	members := make(map[types.ManagedObjectReference]bool, len(dswitch.Summary.HostMember))
+	for _, mem := range dswitch.Summary.HostMember {
+		members[mem] = true
+	}
 	isMember := func(val types.ManagedObjectReference) bool {
-		for _, mem := range dswitch.Summary.HostMember {
-			if mem == val {
-				return true
-			}
+		if members[val] {
+			return true
 		}
 		log.Printf("%s is not a member of VDS %s", h.Name, dswitch.Name)
 		return false
@@ -1364,10 +1475,16 @@ func (vm *VirtualMachine) configureDevice(
 				info.FileName = filename
 			}
 
-			err := vdmCreateVirtualDisk(spec.FileOperation, &types.CreateVirtualDisk_Task{
-				Datacenter: &dc.Self,
-				Name:       info.FileName,
-			})
+			var err types.BaseMethodFault
+			if flat, ok := b.(*types.VirtualDiskFlatVer2BackingInfo); ok && flat.Parent != nil && spec.FileOperation == types.VirtualDeviceConfigSpecFileOperationCreate {
+				err = vdmCreateChildDisk(&dc.Self, info.FileName, flat.Parent.FileName)
+			} else {
+				err = vdmCreateVirtualDisk(spec.FileOperation, &types.CreateVirtualDisk_Task{
+					Datacenter: &dc.Self,
+					Name:       info.FileName,
+					Spec:       virtualDiskSpec(x),
+				})
+			}
 			if err != nil {
 				return err
 			}
@@ -1585,8 +1702,169 @@ func (vm *VirtualMachine) genVmdkPath(p object.DatastorePath) (string, types.Bas
 	}
 }
 
+// configOption returns the VirtualMachineConfigOption for the VM's hardware version,
+// via the EnvironmentBrowser of the VM's host ComputeResource.
+func (vm *VirtualMachine) configOption(ctx *Context) *types.VirtualMachineConfigOption {
+	b := new(EnvironmentBrowser)
+	if vm.Runtime.Host != nil {
+		if host, ok := ctx.Map.Get(*vm.Runtime.Host).(*HostSystem); ok {
+			if cr := hostParent(&host.HostSystem); cr != nil && cr.EnvironmentBrowser != nil {
+				if eb, ok := ctx.Map.Get(*cr.EnvironmentBrowser).(*EnvironmentBrowser); ok {
+					b = eb
+				}
+			}
+		}
+	}
+	return b.configOption(vm.Config.Version)
+}
+
+// deviceType returns the type name of the given device, as used by VirtualDeviceOption.Type
+func deviceType(device types.BaseVirtualDevice) string {
+	return reflect.TypeOf(device).Elem().Name()
+}
+
+// findController returns the controller with the given key,
+// from the VM's devices or the devices being added by the given spec.
+func findController(devices object.VirtualDeviceList, spec *types.VirtualMachineConfigSpec, key int32) types.BaseVirtualDevice {
+	if c := devices.FindByKey(key); c != nil {
+		if _, ok := c.(types.BaseVirtualController); ok {
+			return c
+		}
+		return nil
+	}
+	for _, change := range spec.DeviceChange {
+		dspec := change.GetVirtualDeviceConfigSpec()
+		if dspec.Operation != types.VirtualDeviceConfigSpecOperationAdd {
+			continue
+		}
+		if _, ok := dspec.Device.(types.BaseVirtualController); ok && dspec.Device.GetVirtualDevice().Key == key {
+			return dspec.Device
+		}
+	}
+	return nil
+}
+
+// validateDevice checks the device of an add or edit spec against the hardware options of the VM's version,
+// as the host does when applying a device change.
+func (vm *VirtualMachine) validateDevice(
+	devices object.VirtualDeviceList,
+	hw *types.VirtualHardwareOption,
+	spec *types.VirtualMachineConfigSpec,
+	index int) types.BaseMethodFault {
+
+	dspec := spec.DeviceChange[index].GetVirtualDeviceConfigSpec()
+	device := dspec.Device.GetVirtualDevice()
+	kind := deviceType(dspec.Device)
+	invalid := &types.InvalidDeviceSpec{DeviceIndex: int32(index)}
+
+	options := make(map[string]types.BaseVirtualDeviceOption)
+	for _, opt := range hw.VirtualDeviceOption {
+		options[opt.GetVirtualDeviceOption().Type] = opt
+	}
+
+	if len(options) != 0 && options[kind] == nil {
+		if min, ok := esx.VirtualDeviceMinHardwareVersion[kind]; ok {
+			return &types.DeviceUnsupportedForVmVersion{
+				InvalidDeviceSpec: *invalid,
+				CurrentVersion:    vm.Config.Version,
+				ExpectedVersion:   min.String(),
+			}
+		}
+	}
+
+	// the PCI controller limits the number of storage controllers of each kind
+	if pci, ok := options["VirtualPCIController"].(*types.VirtualPCIControllerOption); ok && dspec.Operation == types.VirtualDeviceConfigSpecOperationAdd {
+		var limit *types.IntOption
+		switch dspec.Device.(type) {
+		case types.BaseVirtualSCSIController:
+			limit = &pci.NumSCSIControllers
+		case types.BaseVirtualSATAController:
+			limit = pci.NumSATAControllers
+		case *types.VirtualNVMEController:
+			limit = pci.NumNVMEControllers
+		}
+		if limit != nil && len(devices.SelectByType(dspec.Device)) >= int(limit.Max) {
+			return invalid
+		}
+	}
+
+	if device.ControllerKey == 0 {
+		if device.UnitNumber != nil && devices.SelectByType(dspec.Device).Select(func(d types.BaseVirtualDevice) bool {
+			base := d.GetVirtualDevice()
+			return base.ControllerKey == 0 && base.UnitNumber != nil && *base.UnitNumber == *device.UnitNumber
+		}) != nil {
+			return invalid
+		}
+		return nil
+	}
+
+	controller := findController(devices, spec, device.ControllerKey)
+	if controller == nil {
+		return &types.InvalidController{InvalidDeviceSpec: *invalid, ControllerKey: device.ControllerKey}
+	}
+
+	var copt *types.VirtualControllerOption
+	if opt, ok := options[deviceType(controller)].(types.BaseVirtualControllerOption); ok {
+		copt = opt.GetVirtualControllerOption()
+	}
+
+	storage := false
+	if copt != nil && len(copt.SupportedDevice) != 0 {
+		supported := false
+		for _, name := range copt.SupportedDevice {
+			if name == kind {
+				supported = true
+			}
+			if name == "VirtualDisk" {
+				storage = true
+			}
+		}
+		if !supported {
+			return &types.InvalidController{InvalidDeviceSpec: *invalid, ControllerKey: device.ControllerKey}
+		}
+	}
+
+	// unit numbers are unique per storage controller, other controllers number each device type separately
+	children := devices.Select(func(d types.BaseVirtualDevice) bool {
+		base := d.GetVirtualDevice()
+		if base.ControllerKey != device.ControllerKey {
+			return false
+		}
+		return storage || deviceType(d) == kind
+	})
+
+	if device.UnitNumber != nil {
+		unit := *device.UnitNumber
+		for _, d := range children {
+			if n := d.GetVirtualDevice().UnitNumber; n != nil && *n == unit {
+				return invalid
+			}
+		}
+
+		if storage {
+			max := copt.Devices.Max
+			if scsi, ok := options[deviceType(controller)].(types.BaseVirtualSCSIControllerOption); ok {
+				if unit == scsi.GetVirtualSCSIControllerOption().ScsiCtlrUnitNumber {
+					return invalid
+				}
+				max++ // the controller's own unit number
+			}
+			if unit < 0 || unit >= max {
+				return invalid
+			}
+		}
+	}
+
+	if storage && len(children) >= int(copt.Devices.Max) {
+		return invalid
+	}
+
+	return nil
+}
+
 func (vm *VirtualMachine) configureDevices(ctx *Context, spec *types.VirtualMachineConfigSpec) types.BaseMethodFault {
 	devices := object.VirtualDeviceList(vm.Config.Hardware.Device)
+	hw := &vm.configOption(ctx).HardwareOptions
 
 	var err types.BaseMethodFault
 	for i, change := range spec.DeviceChange {
@@ -1610,18 +1888,9 @@ func (vm *VirtualMachine) configureDevices(ctx *Context, spec *types.VirtualMach
 				// Note: real ESX does not allow adding base controllers (ControllerKey = 0)
 				// after VM is created (returns success but device is not added).
 				continue
-			} else if device.UnitNumber != nil && devices.SelectByType(dspec.Device).Select(func(d types.BaseVirtualDevice) bool {
-				base := d.GetVirtualDevice()
-				if base.UnitNumber != nil {
-					if base.ControllerKey != device.ControllerKey {
-						return false
-					}
-					return *base.UnitNumber == *device.UnitNumber
-				}
-				return false
-			}) != nil {
-				// UnitNumber for this device type is taken
-				return invalid
+			}
+			if err = vm.validateDevice(devices, hw, spec, i); err != nil {
+				return err
 			}
 
 			key := device.Key
@@ -1632,9 +1901,13 @@ func (vm *VirtualMachine) configureDevices(ctx *Context, spec *types.VirtualMach
 
 			devices = append(devices, dspec.Device)
 			if key != device.Key {
-				// Update ControllerKey refs
-				for i := range spec.DeviceChange {
-					ckey := &spec.DeviceChange[i].GetVirtualDeviceConfigSpec().Device.GetVirtualDevice().ControllerKey
+				// Update ControllerKey refs, up to the next controller added with the same temporary key
+				for j := range spec.DeviceChange {
+					dev := spec.DeviceChange[j].GetVirtualDeviceConfigSpec().Device
+					if _, ok := dev.(types.BaseVirtualController); ok && j > i && dev.GetVirtualDevice().Key == key {
+						break
+					}
+					ckey := &dev.GetVirtualDevice().ControllerKey
 					if *ckey == key {
 						*ckey = device.Key
 					}
@@ -1647,6 +1920,13 @@ func (vm *VirtualMachine) configureDevices(ctx *Context, spec *types.VirtualMach
 				return invalid
 			}
 			rspec.Device = oldDevice
+			// the edited device is validated in place of the existing device with the same key
+			others := devices.Select(func(d types.BaseVirtualDevice) bool {
+				return d.GetVirtualDevice().Key != device.Key
+			})
+			if err = vm.validateDevice(others, hw, spec, i); err != nil {
+				return err
+			}
 			devices = vm.removeDevice(ctx, devices, &rspec)
 			if device.DeviceInfo != nil {
 				device.DeviceInfo.GetDescription().Summary = "" // regenerate summary
@@ -1701,7 +1981,7 @@ func (c *powerVMTask) Run(task *Task) (types.AnyType, types.BaseMethodFault) {
 
 	var boot types.AnyType
 	if c.state == types.VirtualMachinePowerStatePoweredOn {
-		boot = time.Now()
+		boot = c.ctx.Now()
 	}
 
 	event := c.event()
@@ -1711,6 +1991,13 @@ func (c *powerVMTask) Run(task *Task) (types.AnyType, types.BaseMethodFault) {
 			return nil, new(types.InvalidState)
 		}
 
+		release, fault := c.VirtualMachine.admitPowerOn(c.ctx)
+		if fault != nil {
+			return nil, fault
+		}
+		// the reservation is held until the power state is updated below, or released if the power on fails
+		defer release()
+
 		err := c.svm.start(c.ctx)
 		if err != nil {
 			return nil, &types.MissingPowerOnConfiguration{
@@ -1763,7 +2050,7 @@ func (c *powerVMTask) Run(task *Task) (types.AnyType, types.BaseMethodFault) {
 		}
 	}
 
-	c.ctx.Map.Update(c.VirtualMachine, []types.PropertyChange{
+	c.VirtualMachine.updatePowerState(c.ctx, []types.PropertyChange{
 		{Name: "runtime.powerState", Val: c.state},
 		{Name: "summary.runtime.powerState", Val: c.state},
 		{Name: "summary.runtime.bootTime", Val: boot},
@@ -1773,6 +2060,60 @@ func (c *powerVMTask) Run(task *Task) (types.AnyType, types.BaseMethodFault) {
 	return nil, nil
 }
 
+// updatePowerState applies the given changes, which include the VM's power state, while holding the Registry
+// reservationLock, as reservation accounting reads the power state of VMs without locking them.
+// The runtime info of the VM's resource pool is then updated.
+func (vm *VirtualMachine) updatePowerState(ctx *Context, changes []types.PropertyChange) {
+	ctx.Map.reservationLock.Lock()
+	ctx.Map.Update(vm, changes)
+	ctx.Map.reservationLock.Unlock()
+
+	if vm.ResourcePool != nil {
+		updateResourcePoolRuntime(ctx, *vm.ResourcePool)
+	}
+}
+
+// admitPowerOn returns an InsufficientResourcesFault if the VM's resource pool cannot admit its reservations.
+// Otherwise the VM holds its reservations until the returned func is called, which must be after the VM's
+// power state has been updated, such that concurrent power on requests cannot over-commit the pool.
+func (vm *VirtualMachine) admitPowerOn(ctx *Context) (func(), types.BaseMethodFault) {
+	release := func() {}
+
+	if vm.ResourcePool == nil {
+		return release, nil
+	}
+
+	pool := getResourcePool(ctx, *vm.ResourcePool)
+	if pool == nil {
+		return release, nil
+	}
+
+	ctx.Map.reservationLock.Lock()
+	defer ctx.Map.reservationLock.Unlock()
+
+	fault := admitReservation(ctx, pool, func(kind string) int64 {
+		return vmReservation(vm, kind)
+	})
+	if fault != nil {
+		return release, fault
+	}
+
+	if ctx.Map.poweringOn == nil {
+		ctx.Map.poweringOn = make(map[types.ManagedObjectReference]struct{})
+	}
+	ctx.Map.poweringOn[vm.Self] = struct{}{}
+	updateResourcePoolUsage(ctx, pool, &pool.Config)
+
+	return func() {
+		ctx.Map.reservationLock.Lock()
+		delete(ctx.Map.poweringOn, vm.Self)
+		if vm.Runtime.PowerState != types.VirtualMachinePowerStatePoweredOn {
+			updateResourcePoolUsage(ctx, pool, &pool.Config) // power on failed, return the reservation
+		}
+		ctx.Map.reservationLock.Unlock()
+	}, nil
+}
+
 func (vm *VirtualMachine) PowerOnVMTask(ctx *Context, c *types.PowerOnVM_Task) soap.HasFault {
 	if vm.Config.Template {
 		return &methods.PowerOnVM_TaskBody{
@@ -1856,6 +2197,32 @@ func (vm *VirtualMachine) RebootGuest(ctx *Context, req *types.RebootGuest) soap
 	return body
 }
 
+func (vm *VirtualMachine) ExportVm(ctx *Context, req *types.ExportVm) soap.HasFault {
+	body := new(methods.ExportVmBody)
+
+	if vm.Runtime.PowerState == types.VirtualMachinePowerStatePoweredOn {
+		body.Fault_ = Fault("", &types.InvalidPowerState{
+			RequestedState: types.VirtualMachinePowerStatePoweredOff,
+			ExistingState:  vm.Runtime.PowerState,
+		})
+		return body
+	}
+
+	lease := newHttpNfcLease(ctx)
+	ref := lease.Reference()
+
+	CreateTask(vm, "ExportVmLRO", func(*Task) (types.AnyType, types.BaseMethodFault) {
+		lease.ready(ctx, vm.Self, lease.export(vm, vm.Config.Hardware.Device, ""))
+		return nil, nil
+	}).Run(ctx)
+
+	body.Res = &types.ExportVmResponse{
+		Returnval: ref,
+	}
+
+	return body
+}
+
 func (vm *VirtualMachine) ReconfigVMTask(ctx *Context, req *types.ReconfigVM_Task) soap.HasFault {
 	task := CreateTask(vm, "reconfigVm", func(t *Task) (types.AnyType, types.BaseMethodFault) {
 		ctx.postEvent(&types.VmReconfiguredEvent{
@@ -1875,6 +2242,10 @@ func (vm *VirtualMachine) ReconfigVMTask(ctx *Context, req *types.ReconfigVM_Tas
 		}
 
 		err := vm.configure(ctx, &req.Spec)
+		if err == nil && vm.ResourcePool != nil && (req.Spec.CpuAllocation != nil || req.Spec.MemoryAllocation != nil) {
+			// the reservations of a powered on VM are recorded by its pool
+			updateResourcePoolRuntime(ctx, *vm.ResourcePool)
+		}
 
 		return nil, err
 	})
@@ -2201,6 +2572,15 @@ func (vm *VirtualMachine) CloneVMTask(ctx *Context, req *types.CloneVM_Task) soa
 		defaultDevices := object.VirtualDeviceList(esx.VirtualDevice)
 		devices := vm.cloneDevice()
 
+		// disks of a linked clone are delta disks, with the source VM's disk chain as parent
+		linked := req.Spec.Location.DiskMoveType == string(types.VirtualMachineRelocateDiskMoveOptionsCreateNewChildDiskBacking)
+		parents := diskBackings(vm.Config.Hardware.Device)
+		if req.Spec.Snapshot != nil {
+			if snapshot, ok := ctx.Map.Get(*req.Spec.Snapshot).(*VirtualMachineSnapshot); ok {
+				parents = diskBackings(snapshot.Config.Hardware.Device)
+			}
+		}
+
 		for _, device := range devices {
 			var fop types.VirtualDeviceConfigSpecFileOperation
 
@@ -2211,12 +2591,18 @@ func (vm *VirtualMachine) CloneVMTask(ctx *Context, req *types.CloneVM_Task) soa
 
 			switch disk := device.(type) {
 			case *types.VirtualDisk:
-				// TODO: consider VirtualMachineCloneSpec.DiskMoveType
 				fop = types.VirtualDeviceConfigSpecFileOperationCreate
 
 				// Leave FileName empty so CreateVM will just create a new one under VmPathName
-				disk.Backing.(*types.VirtualDiskFlatVer2BackingInfo).FileName = ""
-				disk.Backing.(*types.VirtualDiskFlatVer2BackingInfo).Parent = nil
+				backing := disk.Backing.(*types.VirtualDiskFlatVer2BackingInfo)
+				backing.FileName = ""
+				backing.Parent = nil
+
+				if parent, ok := parents[disk.Key]; ok && linked {
+					p := *parent
+					backing.Parent = &p
+					backing.DeltaDiskFormat = string(types.VirtualDiskDeltaDiskFormatRedoLogFormat)
+				}
 			}
 
 			config.DeviceChange = append(config.DeviceChange, &types.VirtualDeviceConfigSpec{
@@ -2255,6 +2641,17 @@ func (vm *VirtualMachine) CloneVMTask(ctx *Context, req *types.CloneVM_Task) soa
 		}
 		clone.DataSets = copyDataSetsForVmClone(vm.DataSets)
 
+		if spec := req.Spec.Customization; spec != nil {
+			if len(clone.Guest.Net) != len(spec.NicSettingMap) {
+				return nil, &types.NicSettingMismatch{
+					NumberOfNicsInSpec: int32(len(spec.NicSettingMap)),
+					NumberOfNicsInVM:   int32(len(clone.Guest.Net)),
+				}
+			}
+			clone.imc = spec
+			clone.Config.Tools.PendingCustomization = uuid.New().String()
+		}
+
 		if req.Spec.Template {
 			_ = clone.MarkAsTemplate(&types.MarkAsTemplate{This: clone.Self})
 		}
@@ -2264,6 +2661,16 @@ func (vm *VirtualMachine) CloneVMTask(ctx *Context, req *types.CloneVM_Task) soa
 			SourceVm:     *event.Vm,
 		})
 
+		if req.Spec.PowerOn && !req.Spec.Template {
+			var fault types.BaseMethodFault
+			ctx.WithLock(clone, func() {
+				_, fault = (&powerVMTask{clone, types.VirtualMachinePowerStatePoweredOn, ctx}).Run(t)
+			})
+			if fault != nil {
+				return nil, fault
+			}
+		}
+
 		return ref, nil
 	})
 
@@ -2285,6 +2692,209 @@ func copyNonEmptyValue[T comparable](dst, src *T) {
 	*dst = *src
 }
 
+// validInstantCloneDeviceChange returns true if the given device change is allowed by InstantClone,
+// which only supports edits of ethernet cards and file backed serial and parallel ports.
+func validInstantCloneDeviceChange(change types.BaseVirtualDeviceConfigSpec) bool {
+	spec := change.GetVirtualDeviceConfigSpec()
+	if spec.Operation != types.VirtualDeviceConfigSpecOperationEdit {
+		return false
+	}
+
+	switch device := spec.Device.(type) {
+	case types.BaseVirtualEthernetCard:
+		return true
+	case *types.VirtualSerialPort:
+		_, ok := device.Backing.(*types.VirtualSerialPortFileBackingInfo)
+		return ok
+	case *types.VirtualParallelPort:
+		_, ok := device.Backing.(*types.VirtualParallelPortFileBackingInfo)
+		return ok
+	}
+
+	return false
+}
+
+// copyGuestIdentity carries over the guest identity of src, as an instant clone
+// starts from the running state of its source VM.
+func (vm *VirtualMachine) copyGuestIdentity(src *VirtualMachine) {
+	guest := *src.Guest
+	guest.Net = nil
+
+	// NICs of the clone have their own MAC address, IP addresses are that of the source's NIC with the same device key
+	for _, nic := range vm.Guest.Net {
+		for _, snic := range src.Guest.Net {
+			if snic.DeviceConfigId == nic.DeviceConfigId {
+				nic.IpAddress = snic.IpAddress
+				nic.IpConfig = snic.IpConfig
+				nic.DnsConfig = snic.DnsConfig
+			}
+		}
+		guest.Net = append(guest.Net, nic)
+	}
+
+	vm.Guest = &guest
+
+	if src.Summary.Guest != nil {
+		summary := *src.Summary.Guest
+		vm.Summary.Guest = &summary
+	}
+}
+
+func (vm *VirtualMachine) InstantCloneTask(ctx *Context, req *types.InstantClone_Task) soap.HasFault {
+	spec := req.Spec
+	pool := spec.Location.Pool
+	if pool == nil {
+		pool = vm.ResourcePool
+	}
+
+	destHost := vm.Runtime.Host
+	if spec.Location.Host != nil {
+		destHost = spec.Location.Host
+	}
+
+	folderRef := spec.Location.Folder
+	if folderRef == nil {
+		dc := ctx.Map.getEntityDatacenter(vm)
+		folderRef = &dc.VmFolder
+	}
+
+	folder, _ := asFolderMO(ctx.Map.Get(*folderRef))
+	host := ctx.Map.Get(*destHost).(*HostSystem)
+	event := vm.event()
+
+	vmx := vm.vmx(nil)
+	vmx.Path = spec.Name
+	if ref := spec.Location.Datastore; ref != nil {
+		vmx.Datastore = ctx.Map.Get(*ref).(*Datastore).Name
+	}
+
+	task := CreateTask(vm, "instantClone", func(t *Task) (types.AnyType, types.BaseMethodFault) {
+		if vm.Runtime.PowerState != types.VirtualMachinePowerStatePoweredOn {
+			return nil, &types.InvalidPowerState{
+				RequestedState: types.VirtualMachinePowerStatePoweredOn,
+				ExistingState:  vm.Runtime.PowerState,
+			}
+		}
+		if pool == nil || folder == nil {
+			return nil, &types.InvalidArgument{InvalidProperty: "spec.location"}
+		}
+		for _, change := range spec.Location.DeviceChange {
+			if !validInstantCloneDeviceChange(change) {
+				return nil, &types.InvalidArgument{InvalidProperty: "spec.location.deviceChange"}
+			}
+		}
+		if obj := ctx.Map.FindByName(spec.Name, folder.ChildEntity); obj != nil {
+			return nil, &types.DuplicateName{
+				Name:   spec.Name,
+				Object: obj.Reference(),
+			}
+		}
+
+		ctx.postEvent(&types.VmBeingClonedEvent{
+			VmCloneEvent: types.VmCloneEvent{
+				VmEvent: event,
+			},
+			DestFolder: folderEventArgument(folder),
+			DestName:   spec.Name,
+			DestHost:   *host.eventArgument(),
+		})
+
+		config := types.VirtualMachineConfigSpec{
+			Name:              spec.Name,
+			Version:           vm.Config.Version,
+			GuestId:           vm.Config.GuestId,
+			Uuid:              spec.BiosUuid,
+			NumCPUs:           vm.Config.Hardware.NumCPU,
+			MemoryMB:          int64(vm.Config.Hardware.MemoryMB),
+			NumCoresPerSocket: vm.Config.Hardware.NumCoresPerSocket,
+			ExtraConfig:       spec.Config,
+			Files: &types.VirtualMachineFileInfo{
+				VmPathName: vmx.String(),
+			},
+		}
+
+		defaultDevices := object.VirtualDeviceList(esx.VirtualDevice)
+		devices := vm.cloneDevice()
+
+		for _, device := range devices {
+			var fop types.VirtualDeviceConfigSpecFileOperation
+
+			if defaultDevices.Find(object.VirtualDeviceList(devices).Name(device)) != nil {
+				// Default devices are added during CreateVMTask
+				continue
+			}
+
+			switch disk := device.(type) {
+			case *types.VirtualDisk:
+				// The clone's disks are delta disks, with the source VM's disk chain as parent
+				fop = types.VirtualDeviceConfigSpecFileOperationCreate
+
+				backing := disk.Backing.(*types.VirtualDiskFlatVer2BackingInfo)
+				parent := *backing
+				backing.FileName = ""
+				backing.Parent = &parent
+				backing.DeltaDiskFormat = string(types.VirtualDiskDeltaDiskFormatRedoLogFormat)
+				backing.ChangeId = ""
+			case types.BaseVirtualEthernetCard:
+				// The clone's NICs are assigned a new MAC address
+				card := disk.GetVirtualEthernetCard()
+				card.MacAddress = ""
+				card.AddressType = string(types.VirtualEthernetCardMacTypeGenerated)
+			}
+
+			config.DeviceChange = append(config.DeviceChange, &types.VirtualDeviceConfigSpec{
+				Operation:     types.VirtualDeviceConfigSpecOperationAdd,
+				Device:        device,
+				FileOperation: fop,
+			})
+		}
+
+		res := ctx.Map.Get(folder.Self).(vmFolder).CreateVMTask(ctx, &types.CreateVM_Task{
+			This:   folder.Self,
+			Config: config,
+			Pool:   *pool,
+			Host:   destHost,
+		})
+
+		ctask := ctx.Map.Get(res.(*methods.CreateVM_TaskBody).Res.Returnval).(*Task)
+		ctask.Wait()
+		if ctask.Info.Error != nil {
+			return nil, ctask.Info.Error.Fault
+		}
+
+		ref := ctask.Info.Result.(types.ManagedObjectReference)
+		clone := ctx.Map.Get(ref).(*VirtualMachine)
+		if err := clone.configureDevices(ctx, &types.VirtualMachineConfigSpec{DeviceChange: spec.Location.DeviceChange}); err != nil {
+			return nil, err
+		}
+		clone.DataSets = copyDataSetsForVmClone(vm.DataSets)
+
+		runner := &powerVMTask{clone, types.VirtualMachinePowerStatePoweredOn, ctx}
+		if _, err := runner.Run(t); err != nil {
+			return nil, err
+		}
+
+		clone.copyGuestIdentity(vm)
+		ctx.Map.Update(clone, []types.PropertyChange{
+			{Name: "guest", Val: clone.Guest},
+			{Name: "summary.guest", Val: clone.Summary.Guest},
+		})
+
+		ctx.postEvent(&types.VmClonedEvent{
+			VmCloneEvent: types.VmCloneEvent{VmEvent: clone.event()},
+			SourceVm:     *event.Vm,
+		})
+
+		return ref, nil
+	})
+
+	return &methods.InstantClone_TaskBody{
+		Res: &types.InstantClone_TaskResponse{
+			Returnval: task.Run(ctx),
+		},
+	}
+}
+
 func (vm *VirtualMachine) RelocateVMTask(ctx *Context, req *types.RelocateVM_Task) soap.HasFault {
 	task := CreateTask(vm, "relocateVm", func(t *Task) (types.AnyType, types.BaseMethodFault) {
 		var changes []types.PropertyChange
@@ -2298,9 +2908,15 @@ func (vm *VirtualMachine) RelocateVMTask(ctx *Context, req *types.RelocateVM_Tas
 			changes = append(changes, types.PropertyChange{Name: "datastore", Val: []types.ManagedObjectReference{*ref}})
 		}
 
-		if ref := req.Spec.Pool; ref != nil {
-			pool := ctx.Map.Get(*ref).(*ResourcePool)
-			ctx.Map.RemoveReference(ctx, pool, &pool.Vm, *ref)
+		if ref := req.Spec.Pool; ref != nil && vm.ResourcePool != nil && *ref != *vm.ResourcePool {
+			src := ctx.Map.Get(*vm.ResourcePool)
+			if pool, ok := asResourcePoolMO(src); ok {
+				ctx.Map.RemoveReference(ctx, src, &pool.Vm, vm.Self)
+			}
+			dst := ctx.Map.Get(*ref)
+			if pool, ok := asResourcePoolMO(dst); ok {
+				ctx.Map.AddReference(ctx, dst, &pool.Vm, vm.Self)
+			}
 
 			changes = append(changes, types.PropertyChange{Name: "resourcePool", Val: ref})
 		}
@@ -2329,8 +2945,14 @@ func (vm *VirtualMachine) RelocateVMTask(ctx *Context, req *types.RelocateVM_Tas
 			SourceDatastore:  ctx.Map.Get(vm.Datastore[0]).(*Datastore).eventArgument(),
 		})
 
+		src := vm.ResourcePool
 		ctx.Map.Update(vm, changes)
 
+		if ref := req.Spec.Pool; ref != nil && src != nil && *src != *ref {
+			updateResourcePoolRuntime(ctx, *src)
+			updateResourcePoolRuntime(ctx, *ref)
+		}
+
 		return nil, nil
 	})
 
@@ -2341,87 +2963,38 @@ func (vm *VirtualMachine) RelocateVMTask(ctx *Context, req *types.RelocateVM_Tas
 	}
 }
 
-func (vm *VirtualMachine) customize(ctx *Context) {
-	if vm.imc == nil {
-		return
-	}
+// setHost moves the VM to the given host, along with any additional property changes.
+func (vm *VirtualMachine) setHost(ctx *Context, host *HostSystem, changes ...types.PropertyChange) {
+	src := ctx.Map.Get(*vm.Runtime.Host).(*HostSystem)
+	ref := host.Reference()
 
-	event := types.CustomizationEvent{VmEvent: vm.event()}
-	ctx.postEvent(&types.CustomizationStartedEvent{CustomizationEvent: event})
+	ctx.Map.RemoveReference(ctx, src, &src.Vm, vm.Self)
+	ctx.Map.AddReference(ctx, host, &host.Vm, vm.Self)
 
-	changes := []types.PropertyChange{
-		{Name: "config.tools.pendingCustomization", Val: ""},
-	}
+	changes = append(changes,
+		types.PropertyChange{Name: "runtime.host", Val: &ref},
+		types.PropertyChange{Name: "summary.runtime.host", Val: &ref},
+	)
 
-	hostname := ""
-	address := ""
-
-	switch c := vm.imc.Identity.(type) {
-	case *types.CustomizationLinuxPrep:
-		hostname = customizeName(vm, c.HostName)
-	case *types.CustomizationSysprep:
-		hostname = customizeName(vm, c.UserData.ComputerName)
-	}
-
-	cards := object.VirtualDeviceList(vm.Config.Hardware.Device).SelectByType((*types.VirtualEthernetCard)(nil))
-
-	for i, s := range vm.imc.NicSettingMap {
-		nic := &vm.Guest.Net[i]
-		if s.MacAddress != "" {
-			nic.MacAddress = strings.ToLower(s.MacAddress) // MacAddress in guest will always be lowercase
-			card := cards[i].(types.BaseVirtualEthernetCard).GetVirtualEthernetCard()
-			card.MacAddress = s.MacAddress // MacAddress in Virtual NIC can be any case
-			card.AddressType = string(types.VirtualEthernetCardMacTypeManual)
-		}
-		if nic.DnsConfig == nil {
-			nic.DnsConfig = new(types.NetDnsConfigInfo)
-		}
-		if s.Adapter.DnsDomain != "" {
-			nic.DnsConfig.DomainName = s.Adapter.DnsDomain
-		}
-		if len(s.Adapter.DnsServerList) != 0 {
-			nic.DnsConfig.IpAddress = s.Adapter.DnsServerList
-		}
-		if hostname != "" {
-			nic.DnsConfig.HostName = hostname
-		}
-		if len(vm.imc.GlobalIPSettings.DnsSuffixList) != 0 {
-			nic.DnsConfig.SearchDomain = vm.imc.GlobalIPSettings.DnsSuffixList
-		}
-		if nic.IpConfig == nil {
-			nic.IpConfig = new(types.NetIpConfigInfo)
-		}
+	ctx.WithLock(vm, func() {
+		ctx.Map.Update(vm, changes)
+	})
+}
 
-		switch ip := s.Adapter.Ip.(type) {
-		case *types.CustomizationCustomIpGenerator:
-		case *types.CustomizationDhcpIpGenerator:
-		case *types.CustomizationFixedIp:
-			if address == "" {
-				address = ip.IpAddress
-			}
-			nic.IpAddress = []string{ip.IpAddress}
-			nic.IpConfig.IpAddress = []types.NetIpConfigInfoIpAddress{{
-				IpAddress: ip.IpAddress,
-			}}
-		case *types.CustomizationUnknownIpGenerator:
-		}
-	}
+// drsMigrate moves the VM to the given host within its cluster, as a DRS initiated vMotion would.
+func (vm *VirtualMachine) drsMigrate(ctx *Context, host *HostSystem) {
+	src := ctx.Map.Get(*vm.Runtime.Host).(*HostSystem)
 
-	if len(vm.imc.NicSettingMap) != 0 {
-		changes = append(changes, types.PropertyChange{Name: "guest.net", Val: vm.Guest.Net})
-	}
-	if hostname != "" {
-		changes = append(changes, types.PropertyChange{Name: "guest.hostName", Val: hostname})
-		changes = append(changes, types.PropertyChange{Name: "summary.guest.hostName", Val: hostname})
-	}
-	if address != "" {
-		changes = append(changes, types.PropertyChange{Name: "guest.ipAddress", Val: address})
-		changes = append(changes, types.PropertyChange{Name: "summary.guest.ipAddress", Val: address})
-	}
+	vm.setHost(ctx, host)
 
-	vm.imc = nil
-	ctx.Map.Update(vm, changes)
-	ctx.postEvent(&types.CustomizationSucceeded{CustomizationEvent: event})
+	ctx.postEvent(&types.DrsVmMigratedEvent{
+		VmMigratedEvent: types.VmMigratedEvent{
+			VmEvent:          vm.event(),
+			SourceHost:       *src.eventArgument(),
+			SourceDatacenter: datacenterEventArgument(vm),
+			SourceDatastore:  ctx.Map.Get(vm.Datastore[0]).(*Datastore).eventArgument(),
+		},
+	})
 }
 
 func (vm *VirtualMachine) CustomizeVMTask(ctx *Context, req *types.CustomizeVM_Task) soap.HasFault {
@@ -2467,9 +3040,14 @@ func (vm *VirtualMachine) CreateSnapshotTask(ctx *Context, req *types.CreateSnap
 			vm.Snapshot = &types.VirtualMachineSnapshotInfo{}
 		}
 
+		if err := vm.checkpointChangeTracking(); err != nil {
+			return nil, err
+		}
+
 		snapshot := &VirtualMachineSnapshot{}
 		snapshot.Vm = vm.Reference()
 		snapshot.Config = *vm.Config
+		snapshot.Config.Hardware.Device = copyDisks(vm.Config.Hardware.Device)
 		snapshot.DataSets = copyDataSetsForVmClone(vm.DataSets)
 
 		ctx.Map.Put(snapshot)
@@ -2480,7 +3058,7 @@ func (vm *VirtualMachine) CreateSnapshotTask(ctx *Context, req *types.CreateSnap
 			Name:            req.Name,
 			Description:     req.Description,
 			Id:              atomic.AddInt32(&vm.sid, 1),
-			CreateTime:      time.Now(),
+			CreateTime:      ctx.Now(),
 			State:           vm.Runtime.PowerState,
 			Quiesced:        req.Quiesce,
 			BackupManifest:  "",
@@ -2507,6 +3085,10 @@ func (vm *VirtualMachine) CreateSnapshotTask(ctx *Context, req *types.CreateSnap
 
 		snapshot.createSnapshotFiles()
 
+		if err := vm.createDeltaDisks(ctx); err != nil {
+			return nil, err
+		}
+
 		changes = append(changes, types.PropertyChange{Name: "snapshot.currentSnapshot", Val: snapshot.Self})
 		ctx.Map.Update(vm, changes)
 
@@ -2531,6 +3113,9 @@ func (vm *VirtualMachine) RevertToCurrentSnapshotTask(ctx *Context, req *types.R
 	snapshot := ctx.Map.Get(*vm.Snapshot.CurrentSnapshot).(*VirtualMachineSnapshot)
 
 	task := CreateTask(vm, "revertSnapshot", func(t *Task) (types.AnyType, types.BaseMethodFault) {
+		if err := vm.revertDisks(ctx, snapshot); err != nil {
+			return nil, err
+		}
 		vm.DataSets = copyDataSetsForVmClone(snapshot.DataSets)
 		return nil, nil
 	})
@@ -2550,6 +3135,14 @@ func (vm *VirtualMachine) RemoveAllSnapshotsTask(ctx *Context, req *types.Remove
 
 		refs := allSnapshotsInTree(vm.Snapshot.RootSnapshotList)
 
+		var files []string
+		for _, snapshot := range vm.snapshots(ctx) {
+			for _, backing := range diskBackings(snapshot.Config.Hardware.Device) {
+				files = append(files, diskChain(backing)...)
+			}
+			vm.pruneChangeTracking(snapshot)
+		}
+
 		ctx.Map.Update(vm, []types.PropertyChange{
 			{Name: "snapshot", Val: nil},
 			{Name: "rootSnapshot", Val: nil},
@@ -2560,6 +3153,9 @@ func (vm *VirtualMachine) RemoveAllSnapshotsTask(ctx *Context, req *types.Remove
 			ctx.Map.Remove(ctx, ref)
 		}
 
+		vm.deleteUnusedDisks(ctx, files)
+		vm.updateDiskChains(ctx, req.Consolidate == nil || *req.Consolidate)
+
 		return nil, nil
 	})
 
@@ -2570,6 +3166,19 @@ func (vm *VirtualMachine) RemoveAllSnapshotsTask(ctx *Context, req *types.Remove
 	}
 }
 
+func (vm *VirtualMachine) ConsolidateVMDisksTask(ctx *Context, req *types.ConsolidateVMDisks_Task) soap.HasFault {
+	task := CreateTask(vm, "consolidateDisks", func(t *Task) (types.AnyType, types.BaseMethodFault) {
+		vm.updateDiskChains(ctx, true)
+		return nil, nil
+	})
+
+	return &methods.ConsolidateVMDisks_TaskBody{
+		Res: &types.ConsolidateVMDisks_TaskResponse{
+			Returnval: task.Run(ctx),
+		},
+	}
+}
+
 func (vm *VirtualMachine) ShutdownGuest(ctx *Context, c *types.ShutdownGuest) soap.HasFault {
 	r := &methods.ShutdownGuestBody{}
 
@@ -2588,7 +3197,7 @@ func (vm *VirtualMachine) ShutdownGuest(ctx *Context, c *types.ShutdownGuest) so
 	_ = CreateTask(vm, "shutdownGuest", func(*Task) (types.AnyType, types.BaseMethodFault) {
 		vm.svm.stop(ctx)
 
-		ctx.Map.Update(vm, []types.PropertyChange{
+		vm.updatePowerState(ctx, []types.PropertyChange{
 			{Name: "runtime.powerState", Val: types.VirtualMachinePowerStatePoweredOff},
 			{Name: "summary.runtime.powerState", Val: types.VirtualMachinePowerStatePoweredOff},
 		})
@@ -2621,7 +3230,7 @@ func (vm *VirtualMachine) StandbyGuest(ctx *Context, c *types.StandbyGuest) soap
 	_ = CreateTask(vm, "standbyGuest", func(*Task) (types.AnyType, types.BaseMethodFault) {
 		vm.svm.pause(ctx)
 
-		ctx.Map.Update(vm, []types.PropertyChange{
+		vm.updatePowerState(ctx, []types.PropertyChange{
 			{Name: "runtime.powerState", Val: types.VirtualMachinePowerStateSuspended},
 			{Name: "summary.runtime.powerState", Val: types.VirtualMachinePowerStateSuspended},
 		})
@@ -2795,7 +3404,7 @@ func changeTrackingSupported(spec *types.VirtualMachineConfigSpec) bool {
 }
 
 func (vm *VirtualMachine) updateLastModifiedAndChangeVersion(ctx *Context) {
-	modified := time.Now()
+	modified := ctx.Now()
 	ctx.Map.Update(vm, []types.PropertyChange{
 		{
 			Name: "config.changeVersion",
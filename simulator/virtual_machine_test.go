@@ -406,6 +406,122 @@ func TestCloneVm(t *testing.T) {
 	}
 }
 
+func TestInstantClone(t *testing.T) {
+	Test(func(ctx context.Context, c *vim25.Client) {
+		src := Map.Any("VirtualMachine").(*VirtualMachine)
+		src.Guest.HostName = "source-host"
+		src.Guest.IpAddress = "10.0.0.42"
+		src.Guest.GuestState = string(types.VirtualMachineGuestStateRunning)
+		vm := object.NewVirtualMachine(c, src.Self)
+
+		spec := types.VirtualMachineInstantCloneSpec{
+			Name:     "instant-clone",
+			BiosUuid: "12345678-abcd-1234-cdef-123456789abc",
+			Config: []types.BaseOptionValue{
+				&types.OptionValue{Key: "guestinfo.hostname", Value: "clone-host"},
+			},
+		}
+
+		ctask, err := vm.PowerOff(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = ctask.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		ctask, err = vm.InstantClone(ctx, spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ctask.Wait(ctx)
+		if _, ok := err.(task.Error).Fault().(*types.InvalidPowerState); !ok {
+			t.Fatalf("err=%v", err)
+		}
+
+		ctask, err = vm.PowerOn(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = ctask.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		ctask, err = vm.InstantClone(ctx, spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := ctask.WaitForResult(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		clone := Map.Get(info.Result.(types.ManagedObjectReference)).(*VirtualMachine)
+
+		if clone.Runtime.PowerState != types.VirtualMachinePowerStatePoweredOn {
+			t.Errorf("powerState=%s", clone.Runtime.PowerState)
+		}
+		if clone.Config.Uuid != spec.BiosUuid {
+			t.Errorf("uuid=%s", clone.Config.Uuid)
+		}
+		if *clone.Parent != Map.getEntityDatacenter(src).VmFolder {
+			t.Errorf("parent=%s", clone.Parent)
+		}
+
+		var extra string
+		for _, opt := range clone.Config.ExtraConfig {
+			if o := opt.GetOptionValue(); o.Key == "guestinfo.hostname" {
+				extra = o.Value.(string)
+			}
+		}
+		if extra != "clone-host" {
+			t.Errorf("extraConfig=%#v", clone.Config.ExtraConfig)
+		}
+
+		if clone.Guest.HostName != src.Guest.HostName || clone.Guest.IpAddress != src.Guest.IpAddress {
+			t.Errorf("guest=%#v", clone.Guest)
+		}
+
+		srcDisks := object.VirtualDeviceList(src.Config.Hardware.Device).SelectByType((*types.VirtualDisk)(nil))
+		disks := object.VirtualDeviceList(clone.Config.Hardware.Device).SelectByType((*types.VirtualDisk)(nil))
+		if len(disks) != len(srcDisks) {
+			t.Fatalf("disks=%d", len(disks))
+		}
+		for i, disk := range disks {
+			backing := disk.(*types.VirtualDisk).Backing.(*types.VirtualDiskFlatVer2BackingInfo)
+			parent := srcDisks[i].(*types.VirtualDisk).Backing.(*types.VirtualDiskFlatVer2BackingInfo)
+			if backing.Parent == nil || backing.Parent.FileName != parent.FileName || backing.FileName == parent.FileName {
+				t.Errorf("backing=%#v", backing)
+			}
+		}
+		if n := len(clone.Layout.Disk[0].DiskFile); n != 2 {
+			t.Errorf("disk chain=%d", n)
+		}
+
+		srcNIC := object.VirtualDeviceList(src.Config.Hardware.Device).SelectByType((*types.VirtualEthernetCard)(nil))[0]
+		nic := object.VirtualDeviceList(clone.Config.Hardware.Device).SelectByType((*types.VirtualEthernetCard)(nil))[0]
+		if nic.(types.BaseVirtualEthernetCard).GetVirtualEthernetCard().MacAddress == srcNIC.(types.BaseVirtualEthernetCard).GetVirtualEthernetCard().MacAddress {
+			t.Error("expected new MAC address")
+		}
+
+		// only edits of ethernet cards and file backed serial/parallel ports are supported
+		spec.Name = "instant-clone-disk"
+		spec.Location.DeviceChange = []types.BaseVirtualDeviceConfigSpec{
+			&types.VirtualDeviceConfigSpec{
+				Operation: types.VirtualDeviceConfigSpecOperationRemove,
+				Device:    srcDisks[0],
+			},
+		}
+		ctask, err = vm.InstantClone(ctx, spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = ctask.Wait(ctx); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
+
 func TestReconfigVmDevice(t *testing.T) {
 	ctx := context.Background()
 
@@ -533,6 +649,187 @@ func TestReconfigVmDevice(t *testing.T) {
 	}
 }
 
+func TestReconfigVmDeviceValidation(t *testing.T) {
+	Test(func(ctx context.Context, c *vim25.Client) {
+		vm := object.NewVirtualMachine(c, Map.Any("VirtualMachine").Reference())
+
+		fault := func(err error) types.BaseMethodFault {
+			if err, ok := err.(task.Error); ok {
+				return err.Fault()
+			}
+			t.Fatalf("unexpected error: %v", err)
+			return nil
+		}
+
+		devices, err := vm.Device(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scsi := devices.PickController((*types.ParaVirtualSCSIController)(nil))
+		disk := devices.SelectByType((*types.VirtualDisk)(nil))[0].(*types.VirtualDisk)
+		ds := *disk.Backing.(*types.VirtualDiskFlatVer2BackingInfo).Datastore
+		newDisk := func() *types.VirtualDisk {
+			d := devices.CreateDisk(scsi, ds, "")
+			d.CapacityInKB = 1024
+			return d
+		}
+
+		// unit numbers are unique across device types on a storage controller
+		cdrom := &types.VirtualCdrom{
+			VirtualDevice: types.VirtualDevice{
+				ControllerKey: disk.ControllerKey,
+				UnitNumber:    disk.UnitNumber,
+				Backing:       &types.VirtualCdromRemotePassthroughBackingInfo{},
+			},
+		}
+		if _, ok := fault(vm.AddDevice(ctx, cdrom)).(*types.InvalidDeviceSpec); !ok {
+			t.Error("expected InvalidDeviceSpec")
+		}
+
+		// unit number 7 is reserved for the scsi controller
+		unit := int32(7)
+		reserved := newDisk()
+		reserved.UnitNumber = &unit
+		if _, ok := fault(vm.AddDevice(ctx, reserved)).(*types.InvalidDeviceSpec); !ok {
+			t.Error("expected InvalidDeviceSpec")
+		}
+
+		// controller must exist
+		missing := newDisk()
+		missing.ControllerKey = 9999
+		if _, ok := fault(vm.AddDevice(ctx, missing)).(*types.InvalidController); !ok {
+			t.Error("expected InvalidController")
+		}
+
+		// controller must support the device type
+		ide, err := devices.FindIDEController("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		nic := &types.VirtualE1000{VirtualEthernetCard: types.VirtualEthernetCard{
+			VirtualDevice: types.VirtualDevice{ControllerKey: ide.Key},
+		}}
+		if _, ok := fault(vm.AddDevice(ctx, nic)).(*types.InvalidController); !ok {
+			t.Error("expected InvalidController")
+		}
+
+		// scsi controllers support 15 devices
+		for i := len(devices.SelectByType((*types.VirtualDisk)(nil))); i < 15; i++ {
+			if devices, err = vm.Device(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if err = vm.AddDevice(ctx, newDisk()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if devices, err = vm.Device(ctx); err != nil {
+			t.Fatal(err)
+		}
+		full := newDisk()
+		unit = 15
+		full.UnitNumber = &unit
+		if _, ok := fault(vm.AddDevice(ctx, full)).(*types.InvalidDeviceSpec); !ok {
+			t.Error("expected InvalidDeviceSpec")
+		}
+
+		// editing a disk on the full controller replaces the disk
+		edit := devices.SelectByType((*types.VirtualDisk)(nil))[0].(*types.VirtualDisk)
+		edit.CapacityInKB *= 2
+		if err = vm.EditDevice(ctx, edit); err != nil {
+			t.Fatal(err)
+		}
+
+		// a disk cannot be moved onto the full controller
+		pvscsi, err := devices.CreateSCSIController("pvscsi")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = vm.AddDevice(ctx, pvscsi); err != nil {
+			t.Fatal(err)
+		}
+		if devices, err = vm.Device(ctx); err != nil {
+			t.Fatal(err)
+		}
+		var other types.BaseVirtualController
+		for _, c := range devices.SelectByType((*types.ParaVirtualSCSIController)(nil)) {
+			if c.GetVirtualDevice().Key != scsi.GetVirtualController().Key {
+				other = c.(types.BaseVirtualController)
+			}
+		}
+		add := devices.CreateDisk(other, ds, "")
+		add.CapacityInKB = 1024
+		if err = vm.AddDevice(ctx, add); err != nil {
+			t.Fatal(err)
+		}
+		if devices, err = vm.Device(ctx); err != nil {
+			t.Fatal(err)
+		}
+		move := devices.SelectByType((*types.VirtualDisk)(nil)).Select(func(d types.BaseVirtualDevice) bool {
+			return d.GetVirtualDevice().ControllerKey == other.GetVirtualController().Key
+		})[0].(*types.VirtualDisk)
+		move.ControllerKey = scsi.GetVirtualController().Key
+		move.UnitNumber = nil
+		if _, ok := fault(vm.EditDevice(ctx, move)).(*types.InvalidDeviceSpec); !ok {
+			t.Error("expected InvalidDeviceSpec")
+		}
+
+		// hot plug requires cpuHotAddEnabled and memoryHotAddEnabled
+		tsk, err := vm.Reconfigure(ctx, types.VirtualMachineConfigSpec{NumCPUs: 4})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := fault(tsk.Wait(ctx)).(*types.CpuHotPlugNotSupported); !ok {
+			t.Error("expected CpuHotPlugNotSupported")
+		}
+
+		tsk, err = vm.Reconfigure(ctx, types.VirtualMachineConfigSpec{MemoryMB: 4096})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := fault(tsk.Wait(ctx)).(*types.MemoryHotPlugNotSupported); !ok {
+			t.Error("expected MemoryHotPlugNotSupported")
+		}
+
+		tsk, err = vm.Reconfigure(ctx, types.VirtualMachineConfigSpec{NumCPUs: 4, CpuHotAddEnabled: types.NewBool(true)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := fault(tsk.Wait(ctx)).(*types.CpuHotPlugNotSupported); !ok {
+			t.Error("expected CpuHotPlugNotSupported") // hot add setting cannot be changed while powered on
+		}
+
+		// device types are limited by hardware version
+		clock := &types.VirtualPrecisionClock{
+			VirtualDevice: types.VirtualDevice{
+				Backing: &types.VirtualPrecisionClockSystemClockBackingInfo{},
+			},
+		}
+		if _, ok := fault(vm.AddDevice(ctx, clock)).(*types.DeviceUnsupportedForVmVersion); !ok {
+			t.Error("expected DeviceUnsupportedForVmVersion")
+		}
+
+		browser, err := vm.EnvironmentBrowser(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for version, supported := range map[string]bool{"vmx-13": false, "vmx-17": true} {
+			opt, err := browser.QueryConfigOption(ctx, &types.EnvironmentBrowserConfigOptionQuerySpec{Key: version})
+			if err != nil {
+				t.Fatal(err)
+			}
+			found := false
+			for _, d := range opt.HardwareOptions.VirtualDeviceOption {
+				if d.GetVirtualDeviceOption().Type == "VirtualPrecisionClock" {
+					found = true
+				}
+			}
+			if found != supported {
+				t.Errorf("%s: VirtualPrecisionClock=%t", version, found)
+			}
+		}
+	}, ESX())
+}
+
 func TestConnectVmDevice(t *testing.T) {
 	ctx := context.Background()
 
@@ -1270,7 +1567,9 @@ func TestCreateVmWithDevices(t *testing.T) {
 			},
 		},
 	}
-	devices.AssignController(disk, scsi.(*types.VirtualLsiLogicController))
+	// the controller key and disk unit numbers were assigned by CreateVM
+	devices = object.VirtualDeviceList(vm.Config.Hardware.Device)
+	devices.AssignController(disk, devices.PickController((*types.VirtualLsiLogicController)(nil)))
 	devices = nil
 	devices = append(devices, disk)
 	create, _ = devices.ConfigSpec(types.VirtualDeviceConfigSpecOperationAdd)
@@ -240,9 +240,24 @@ func (m *VcenterVStorageObjectManager) createObject(req *types.CreateDisk_Task,
 	}
 
 	if !register {
+		diskType := types.VirtualDiskTypeThin
+		switch types.BaseConfigInfoDiskFileBackingInfoProvisioningType(backing.ProvisioningType) {
+		case types.BaseConfigInfoDiskFileBackingInfoProvisioningTypeEagerZeroedThick:
+			diskType = types.VirtualDiskTypeEagerZeroedThick
+		case types.BaseConfigInfoDiskFileBackingInfoProvisioningTypeLazyZeroedThick:
+			diskType = types.VirtualDiskTypePreallocated
+		}
+
 		err := vdmCreateVirtualDisk(types.VirtualDeviceConfigSpecFileOperationCreate, &types.CreateVirtualDisk_Task{
 			Datacenter: &dc.Self,
 			Name:       path.String(),
+			Spec: &types.FileBackedVirtualDiskSpec{
+				VirtualDiskSpec: types.VirtualDiskSpec{
+					DiskType:    string(diskType),
+					AdapterType: string(types.VirtualDiskAdapterTypeLsiLogic),
+				},
+				CapacityKb: req.Spec.CapacityInMB * 1024,
+			},
 		})
 		if err != nil {
 			return nil, err
@@ -335,7 +350,7 @@ func (m *VcenterVStorageObjectManager) VStorageObjectCreateSnapshotTask(ctx *Con
 				Id: uuid.New().String(),
 			},
 			BackingObjectId: uuid.New().String(),
-			CreateTime:      time.Now(),
+			CreateTime:      ctx.Now(),
 			Description:     req.Description,
 		}
 		obj.Snapshots = append(obj.Snapshots, snapshot)
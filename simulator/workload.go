@@ -0,0 +1,277 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/zhengkes/govmomi/vim25/types"
+)
+
+// WorkloadOptionKey is the VM ExtraConfig key used to select the VM's Workload profile by name,
+// overriding the Model's default Workload.
+const WorkloadOptionKey = "SIM.workload"
+
+// workloadInterval is the sample interval of simulated usage, matching the PerformanceManager realtime refresh rate.
+const workloadInterval = 20 * time.Second
+
+// Workload simulates the resource demand of a powered on VM over time.
+type Workload interface {
+	// Demand returns the fraction of the VM's configured CPU and memory in use at the given time, from 0 to 1.
+	// The same inputs must produce the same outputs, so that quickStats and performance counters are consistent.
+	Demand(vm types.ManagedObjectReference, now time.Time) (cpu float64, mem float64)
+}
+
+// WorkloadFunc adapts a function to the Workload interface.
+type WorkloadFunc func(vm types.ManagedObjectReference, now time.Time) (float64, float64)
+
+// Demand calls f(vm, now)
+func (f WorkloadFunc) Demand(vm types.ManagedObjectReference, now time.Time) (float64, float64) {
+	return f(vm, now)
+}
+
+// Workloads are the available Workload profiles by name.
+// Custom profiles can be added before the Model is created.
+var Workloads = map[string]Workload{
+	"idle": WorkloadFunc(func(vm types.ManagedObjectReference, now time.Time) (float64, float64) {
+		n := workloadNoise(vm, now)
+		return 0.01 + 0.02*n, 0.1 + 0.02*n
+	}),
+	"steady": WorkloadFunc(func(vm types.ManagedObjectReference, now time.Time) (float64, float64) {
+		n := workloadNoise(vm, now)
+		return 0.45 + 0.1*n, 0.6 + 0.05*n
+	}),
+	"spiky": WorkloadFunc(func(vm types.ManagedObjectReference, now time.Time) (float64, float64) {
+		n := workloadNoise(vm, now)
+		if n < 0.15 {
+			return 0.85 + n, 0.7 + n
+		}
+		return 0.05 + 0.1*n, 0.3 + 0.05*n
+	}),
+}
+
+// workloadNoise returns a pseudo random number between 0 and 1, fixed for the given VM and sample interval.
+func workloadNoise(vm types.ManagedObjectReference, now time.Time) float64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(vm.Value))
+	_, _ = h.Write([]byte(strconv.FormatInt(now.Unix()/int64(workloadInterval.Seconds()), 10)))
+	return float64(h.Sum64()%1000) / 1000
+}
+
+// workloadSimulation updates VM and host quickStats from the Workload of each powered on VM,
+// at most once per workloadInterval.
+type workloadSimulation struct {
+	sync.Mutex
+
+	name string
+	last time.Time
+}
+
+func newWorkloadSimulation(name string) (*workloadSimulation, error) {
+	if name != "" && Workloads[name] == nil {
+		return nil, fmt.Errorf("unknown workload profile %q", name)
+	}
+	return &workloadSimulation{name: name}, nil
+}
+
+// workload returns the Workload of the given VM, if any.
+func (w *workloadSimulation) workload(vm *VirtualMachine) Workload {
+	name := w.name
+	for _, opt := range vm.Config.ExtraConfig {
+		if val := opt.GetOptionValue(); val.Key == WorkloadOptionKey {
+			name, _ = val.Value.(string)
+		}
+	}
+	return Workloads[name]
+}
+
+// vmUsage returns the CPU usage in MHz and memory usage in MB of the given VM at the given time.
+func (w *workloadSimulation) vmUsage(ctx *Context, vm *VirtualMachine, now time.Time) (int64, int64, bool) {
+	workload := w.workload(vm)
+	if workload == nil {
+		return 0, 0, false
+	}
+	if vm.Runtime.PowerState != types.VirtualMachinePowerStatePoweredOn {
+		return 0, 0, true
+	}
+
+	mhz := int64(2000)
+	if vm.Runtime.Host != nil {
+		if host, ok := ctx.Map.Get(*vm.Runtime.Host).(*HostSystem); ok && host.Summary.Hardware != nil {
+			mhz = int64(host.Summary.Hardware.CpuMhz)
+		}
+	}
+
+	cpu, mem := workload.Demand(vm.Self, now)
+	cpu = clamp(cpu)
+	mem = clamp(mem)
+
+	return int64(cpu * float64(int64(vm.Config.Hardware.NumCPU)*mhz)), int64(mem * float64(vm.Config.Hardware.MemoryMB)), true
+}
+
+// hostUsage returns the CPU usage in MHz and memory usage in MB of the given host at the given time,
+// as the sum of its VMs usage.
+func (w *workloadSimulation) hostUsage(ctx *Context, host *HostSystem, now time.Time) (int64, int64, bool) {
+	var cpu, mem int64
+	simulated := false
+
+	for _, ref := range host.Vm {
+		vm, ok := ctx.Map.Get(ref).(*VirtualMachine)
+		if !ok {
+			continue
+		}
+		c, m, ok := w.vmUsage(ctx, vm, now)
+		if ok {
+			simulated = true
+			cpu += c
+			mem += m
+		}
+	}
+
+	return cpu, mem, simulated
+}
+
+func clamp(f float64) float64 {
+	if f < 0 {
+		return 0
+	}
+	if f > 1 {
+		return 1
+	}
+	return f
+}
+
+// update applies the simulated usage to quickStats, if a new sample interval has started since the last update.
+func (w *workloadSimulation) update(ctx *Context) {
+	now := ctx.Now().Truncate(workloadInterval)
+
+	w.Lock()
+	defer w.Unlock()
+
+	if !now.After(w.last) {
+		return
+	}
+	w.last = now
+
+	pools := make(map[types.ManagedObjectReference]bool)
+
+	for _, obj := range ctx.Map.All("VirtualMachine") {
+		vm := obj.(*VirtualMachine)
+
+		ctx.WithLock(vm, func() {
+			cpu, mem, ok := w.vmUsage(ctx, vm, now)
+			if !ok {
+				return
+			}
+
+			stats := vm.Summary.QuickStats
+			stats.OverallCpuUsage = int32(cpu)
+			stats.OverallCpuDemand = int32(cpu)
+			stats.GuestMemoryUsage = int32(mem)
+			stats.HostMemoryUsage = int32(mem)
+			stats.UptimeSeconds = 0
+			if boot := vm.Runtime.BootTime; boot != nil && vm.Runtime.PowerState == types.VirtualMachinePowerStatePoweredOn {
+				stats.UptimeSeconds = int32(now.Sub(*boot).Seconds())
+			}
+
+			ctx.Map.Update(vm, []types.PropertyChange{{Name: "summary.quickStats", Val: stats}})
+
+			if vm.ResourcePool != nil {
+				pools[*vm.ResourcePool] = true
+			}
+		})
+	}
+
+	for _, obj := range ctx.Map.All("HostSystem") {
+		host := obj.(*HostSystem)
+
+		ctx.WithLock(host, func() {
+			cpu, mem, ok := w.hostUsage(ctx, host, now)
+			if !ok {
+				return
+			}
+
+			stats := host.Summary.QuickStats
+			stats.OverallCpuUsage = int32(cpu)
+			stats.OverallMemoryUsage = int32(mem)
+			if boot := host.Runtime.BootTime; boot != nil {
+				stats.Uptime = int32(now.Sub(*boot).Seconds())
+			}
+
+			ctx.Map.Update(host, []types.PropertyChange{{Name: "summary.quickStats", Val: stats}})
+		})
+	}
+
+	for pool := range pools {
+		updateResourcePoolRuntime(ctx, pool)
+	}
+}
+
+// counter returns the simulated value of the given performance counter for entity at the given time,
+// using the units of the counter: MHz, KB or hundredths of a percent.
+func (w *workloadSimulation) counter(ctx *Context, entity types.ManagedObjectReference, name string, now time.Time) (int64, bool) {
+	var cpu, mem, cpuCapacity, memCapacity int64
+	var ok bool
+
+	switch obj := ctx.Map.Get(entity).(type) {
+	case *VirtualMachine:
+		cpu, mem, ok = w.vmUsage(ctx, obj, now)
+		if obj.Config != nil {
+			cpuCapacity = int64(obj.Config.Hardware.NumCPU) * 2000
+			if obj.Runtime.Host != nil {
+				if host, ok := ctx.Map.Get(*obj.Runtime.Host).(*HostSystem); ok && host.Summary.Hardware != nil {
+					cpuCapacity = int64(obj.Config.Hardware.NumCPU) * int64(host.Summary.Hardware.CpuMhz)
+				}
+			}
+			memCapacity = int64(obj.Config.Hardware.MemoryMB)
+		}
+	case *HostSystem:
+		cpu, mem, ok = w.hostUsage(ctx, obj, now)
+		if hw := obj.Summary.Hardware; hw != nil {
+			cpuCapacity = int64(hw.CpuMhz) * int64(hw.NumCpuCores)
+			memCapacity = hw.MemorySize >> 20
+		}
+	}
+
+	if !ok {
+		return 0, false
+	}
+
+	percent := func(val, capacity int64) int64 {
+		if capacity == 0 {
+			return 0
+		}
+		return val * 10000 / capacity
+	}
+
+	switch name {
+	case "cpu.usagemhz":
+		return cpu, true
+	case "cpu.usage":
+		return percent(cpu, cpuCapacity), true
+	case "mem.usage":
+		return percent(mem, memCapacity), true
+	case "mem.active", "mem.consumed":
+		return mem << 10, true
+	}
+
+	return 0, false
+}
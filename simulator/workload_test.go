@@ -0,0 +1,143 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/zhengkes/govmomi/find"
+	"github.com/zhengkes/govmomi/performance"
+	"github.com/zhengkes/govmomi/vim25"
+	"github.com/zhengkes/govmomi/vim25/mo"
+	"github.com/zhengkes/govmomi/vim25/types"
+)
+
+func TestWorkloadProfiles(t *testing.T) {
+	vm := types.ManagedObjectReference{Type: "VirtualMachine", Value: "vm-42"}
+	now := time.Now()
+
+	for name, w := range Workloads {
+		cpu, mem := w.Demand(vm, now)
+		if cpu < 0 || cpu > 1 || mem < 0 || mem > 1 {
+			t.Errorf("%s: cpu=%f mem=%f", name, cpu, mem)
+		}
+
+		c, m := w.Demand(vm, now)
+		if c != cpu || m != mem {
+			t.Errorf("%s: demand is not deterministic", name)
+		}
+	}
+
+	if _, err := newWorkloadSimulation("enoent"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestWorkloadSimulation(t *testing.T) {
+	Workloads["half"] = WorkloadFunc(func(types.ManagedObjectReference, time.Time) (float64, float64) {
+		return 0.5, 0.25
+	})
+	defer delete(Workloads, "half")
+
+	m := VPX()
+	m.Workload = "half"
+
+	err := m.Run(func(ctx context.Context, c *vim25.Client) error {
+		finder := find.NewFinder(c)
+
+		vm, err := finder.VirtualMachine(ctx, "DC0_H0_VM0")
+		if err != nil {
+			return err
+		}
+		host, err := vm.HostSystem(ctx)
+		if err != nil {
+			return err
+		}
+
+		var mvm mo.VirtualMachine
+		if err = vm.Properties(ctx, vm.Reference(), []string{"summary", "config.hardware"}, &mvm); err != nil {
+			return err
+		}
+		var mhost mo.HostSystem
+		if err = host.Properties(ctx, host.Reference(), []string{"summary", "vm"}, &mhost); err != nil {
+			return err
+		}
+
+		stats := mvm.Summary.QuickStats
+		cpu := int32(0.5 * float64(mvm.Config.Hardware.NumCPU*mhost.Summary.Hardware.CpuMhz))
+		mem := int32(0.25 * float64(mvm.Config.Hardware.MemoryMB))
+		if stats.OverallCpuUsage != cpu || stats.HostMemoryUsage != mem || stats.GuestMemoryUsage != mem {
+			t.Errorf("vm quickStats=%#v", stats)
+		}
+
+		// all vms on the host are powered on with the same size
+		hstats := mhost.Summary.QuickStats
+		if hstats.OverallCpuUsage != cpu*int32(len(mhost.Vm)) || hstats.OverallMemoryUsage != mem*int32(len(mhost.Vm)) {
+			t.Errorf("host quickStats=%#v", hstats)
+		}
+
+		// performance counters are consistent with quickStats
+		spec := types.PerfQuerySpec{
+			MetricId:   []types.PerfMetricId{{Instance: ""}},
+			IntervalId: 20,
+		}
+		pm := performance.NewManager(c)
+		sample, err := pm.SampleByName(ctx, spec, []string{"cpu.usagemhz.average", "mem.consumed.average"}, []types.ManagedObjectReference{vm.Reference()})
+		if err != nil {
+			return err
+		}
+		series, err := pm.ToMetricSeries(ctx, sample)
+		if err != nil {
+			return err
+		}
+
+		values := make(map[string]int64)
+		for _, s := range series[0].Value {
+			values[s.Name] = s.Value[0]
+		}
+		if values["cpu.usagemhz.average"] != int64(cpu) || values["mem.consumed.average"] != int64(mem)<<10 {
+			t.Errorf("metrics=%v", values)
+		}
+
+		// powered off vms have no usage
+		task, err := vm.PowerOff(ctx)
+		if err != nil {
+			return err
+		}
+		if err = task.Wait(ctx); err != nil {
+			return err
+		}
+
+		simulation := m.Service.workload
+		simulation.last = time.Time{} // force an update
+		simulation.update(SpoofContext())
+
+		if err = vm.Properties(ctx, vm.Reference(), []string{"summary"}, &mvm); err != nil {
+			return err
+		}
+		if stats = mvm.Summary.QuickStats; stats.OverallCpuUsage != 0 || stats.HostMemoryUsage != 0 {
+			t.Errorf("vm quickStats=%#v", stats)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
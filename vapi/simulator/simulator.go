@@ -467,7 +467,9 @@ func (s *handler) expiredSession(id string, now time.Time) bool {
 	s.Lock()
 	session, ok := s.Session[id]
 	if ok {
-		expired = now.Sub(session.LastAccessed) > simulator.SessionIdleTimeout
+		idle := simulator.SessionIdleTimeout != 0 && now.Sub(session.LastAccessed) > simulator.SessionIdleTimeout
+		aged := simulator.SessionMaxAge != 0 && now.Sub(session.Created) > simulator.SessionMaxAge
+		expired = idle || aged
 		if expired {
 			delete(s.Session, id)
 		}
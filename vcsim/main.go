@@ -17,6 +17,7 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"expvar"
 	"flag"
@@ -96,11 +97,30 @@ func main() {
 	trace := flag.String("trace-file", "", "Trace output file (defaults to stderr)")
 	stdinExit := flag.Bool("stdinexit", false, "Press any key to exit")
 	dir := flag.String("load", "", "Load model from directory")
+	flag.DurationVar(&simulator.SessionIdleTimeout, "session-idle-timeout", simulator.SessionIdleTimeout, "Expire sessions that are idle longer than the given duration (no expiry by default)")
+	flag.DurationVar(&simulator.SessionMaxAge, "session-max-age", simulator.SessionMaxAge, "Expire sessions the given duration after login, regardless of activity (no expiry by default)")
 
 	flag.IntVar(&model.DelayConfig.Delay, "delay", model.DelayConfig.Delay, "Method response delay across all methods")
 	methodDelayP := flag.String("method-delay", "", "Delay per method on the form 'method1:delay1,method2:delay2...'")
 	flag.Float64Var(&model.DelayConfig.DelayJitter, "delay-jitter", model.DelayConfig.DelayJitter, "Delay jitter coefficient of variation (tip: 0.5 is a good starting value)")
 
+	flag.Func("fault", "Fault injection rule on the form 'method=name,type=type,fault=name,action=fault|task|drop|server|hostFailure,...' (can be repeated)", func(s string) error {
+		rule, err := simulator.ParseFaultRule(s)
+		if err == nil {
+			model.FaultConfig.Add(rule)
+		}
+		return err
+	})
+	faultFile := flag.String("fault-file", "", "Load fault injection rules from JSON file")
+	metrics := flag.Bool("metrics", false, "Enable the Prometheus /metrics endpoint")
+	checkpointDir := flag.String("checkpoint-dir", "", "Directory for checkpoints saved via the admin API (defaults to a temporary directory)")
+	scenarioFile := flag.String("scenario", "", "Play back the scenario steps from YAML or JSON file once the server has started")
+
+	flag.BoolVar(&model.EnforcePrivileges, "enforce-privileges", model.EnforcePrivileges, "Require privileges granted via AuthorizationManager permissions to invoke methods")
+	flag.StringVar(&model.Workload, "workload", model.Workload, "Simulate VM and host usage with the given workload profile: idle|steady|spiky (static usage by default)")
+	flag.DurationVar(&model.Customization.Start, "customization-start", model.Customization.Start, "Delay from VM power on until guest customization starts")
+	flag.DurationVar(&model.Customization.Duration, "customization-duration", model.Customization.Duration, "Duration of guest customization")
+
 	flag.Parse()
 
 	if *trace != "" {
@@ -149,6 +169,21 @@ func main() {
 		simulator.TaskDelay.MethodDelay = m
 	}
 
+	if *faultFile != "" {
+		if err := model.FaultConfig.LoadFile(*faultFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var scenario *simulator.Scenario
+	if *scenarioFile != "" {
+		var err error
+		scenario, err = simulator.LoadScenarioFile(*scenarioFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	var err error
 
 	if err = updateHostTemplate(u.Host); err != nil {
@@ -165,6 +200,10 @@ func main() {
 		model.DelayConfig.Delay = opts.DelayConfig.Delay
 		model.DelayConfig.MethodDelay = opts.DelayConfig.MethodDelay
 		model.DelayConfig.DelayJitter = opts.DelayConfig.DelayJitter
+		model.FaultConfig = opts.FaultConfig
+		model.EnforcePrivileges = opts.EnforcePrivileges
+		model.Workload = opts.Workload
+		model.Customization = opts.Customization
 	}
 
 	tag := " (govmomi simulator)"
@@ -183,6 +222,8 @@ func main() {
 	}
 
 	model.Service.RegisterEndpoints = true
+	model.Service.Metrics = *metrics
+	model.Service.CheckpointDir = *checkpointDir
 	model.Service.Listen = u
 	if *isTLS {
 		model.Service.TLS = new(tls.Config)
@@ -239,6 +280,10 @@ func main() {
 		}
 	}
 
+	if scenario != nil {
+		_ = model.Service.PlayScenario(context.Background(), scenario)
+	}
+
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
 	if *stdinExit {
@@ -251,6 +296,8 @@ func main() {
 
 	<-sig
 
+	s.CloseClientConnections()
+	s.Close() // removes the temporary checkpoint directory, if any
 	model.Remove()
 
 	if *trace != "" {
@@ -45,6 +45,7 @@ func NewTeeReader(rc io.ReadCloser, w io.Writer) io.ReadCloser {
 
 var currentProvider Provider = nil
 var scrubPassword = regexp.MustCompile(`<password>(.*)</password>`)
+var scrubJSONPassword = regexp.MustCompile(`"password"\s*:\s*"(\\.|[^"\\])*"`)
 
 func SetProvider(p Provider) {
 	if currentProvider != nil {
@@ -68,6 +69,8 @@ func Flush() {
 	currentProvider.Flush()
 }
 
+// Scrub replaces passwords in the given XML or JSON request body.
 func Scrub(in []byte) []byte {
-	return scrubPassword.ReplaceAll(in, []byte(`<password>********</password>`))
+	out := scrubPassword.ReplaceAll(in, []byte(`<password>********</password>`))
+	return scrubJSONPassword.ReplaceAll(out, []byte(`"password":"********"`))
 }
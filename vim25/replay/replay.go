@@ -0,0 +1,393 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+/*
+Package replay records request/response pairs to fixture files and serves them back,
+such that tests can run against the behavior of a real endpoint without access to it.
+
+A Recorder wraps the soap.RoundTripper used by a vim25.Client:
+
+	rec := replay.NewRecorder(dir, soapClient)
+	c, err := vim25.NewClient(ctx, rec)
+	c.Client = soapClient // for use by rest.NewClient
+
+HTTP traffic, such as that of a vapi/rest.Client, is recorded by wrapping the http.RoundTripper:
+
+	rc := rest.NewClient(c)
+	rc.Transport = rec.Transport(rc.Transport)
+
+A Replayer serves the recorded responses, matching requests by method and normalized body:
+
+	r, err := replay.NewReplayer(dir)
+	c, err := vim25.NewClient(ctx, r)
+	c.Client = soap.NewClient(u, true) // the URL is not used
+	rc := rest.NewClient(c)
+	rc.Transport = r.Transport()
+
+Passwords are scrubbed from recorded request and response bodies with debug.Scrub,
+and session cookies are scrubbed from recorded response headers.
+*/
+package replay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/zhengkes/govmomi/vim25/debug"
+	"github.com/zhengkes/govmomi/vim25/soap"
+	"github.com/zhengkes/govmomi/vim25/types"
+	"github.com/zhengkes/govmomi/vim25/xml"
+)
+
+// Entry is a request/response pair, stored as a JSON encoded fixture file.
+type Entry struct {
+	// Method is the SOAP method name, or the HTTP method and request URI, for example:
+	// "RetrieveServiceContent" or "GET /rest/com/vmware/cis/tagging/tag"
+	Method string `json:"method"`
+	// Request is the normalized request body
+	Request string `json:"request,omitempty"`
+
+	// Status is the HTTP response status code
+	Status int `json:"status,omitempty"`
+	// Header contains the HTTP response headers
+	Header http.Header `json:"header,omitempty"`
+	// Response is the response body
+	Response string `json:"response,omitempty"`
+	// Fault is the XML encoded SOAP fault
+	Fault string `json:"fault,omitempty"`
+	// Error is set if the request failed without a response
+	Error string `json:"error,omitempty"`
+}
+
+// scrubHeaders are the response headers containing session keys
+var scrubHeaders = []string{"Set-Cookie", "Vmware-Api-Session-Id"}
+
+const scrubbed = "********"
+
+// Recorder is a soap.RoundTripper that records request/response pairs to fixture files in a directory.
+type Recorder struct {
+	mu  sync.Mutex
+	dir string
+	seq int
+	rt  soap.RoundTripper
+}
+
+// NewRecorder returns a Recorder that writes to the given directory, which is created if needed.
+// Requests are sent using the given RoundTripper.
+func NewRecorder(dir string, rt soap.RoundTripper) *Recorder {
+	return &Recorder{dir: dir, rt: rt}
+}
+
+// RoundTrip implements soap.RoundTripper, recording the request and response.
+func (r *Recorder) RoundTrip(ctx context.Context, req, res soap.HasFault) error {
+	method, body, err := marshalRequest(req)
+	if err != nil {
+		return err
+	}
+
+	rerr := r.rt.RoundTrip(ctx, req, res)
+
+	e := &Entry{Method: method, Request: body}
+
+	switch {
+	case rerr == nil:
+		b, err := xml.Marshal(field(res, "Res").Interface())
+		if err != nil {
+			return err
+		}
+		e.Response = string(debug.Scrub(b))
+	case soap.IsSoapFault(rerr):
+		b, err := xml.Marshal(soap.ToSoapFault(rerr))
+		if err != nil {
+			return err
+		}
+		e.Fault = string(b)
+	default:
+		e.Error = rerr.Error()
+	}
+
+	if err = r.save(e); err != nil {
+		return err
+	}
+
+	return rerr
+}
+
+// Transport returns an http.RoundTripper that records request/response pairs sent using the given http.RoundTripper.
+func (r *Recorder) Transport(rt http.RoundTripper) http.RoundTripper {
+	return &recordTransport{r: r, rt: rt}
+}
+
+func (r *Recorder) save(e *Entry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := os.MkdirAll(r.dir, 0750); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	r.seq++
+	name := strings.NewReplacer("/", "_", " ", "_", "?", "_", "~", "_").Replace(e.Method)
+	if len(name) > 64 {
+		name = name[:64]
+	}
+
+	return os.WriteFile(filepath.Join(r.dir, fmt.Sprintf("%04d-%s.json", r.seq, name)), b, 0600)
+}
+
+type recordTransport struct {
+	r  *Recorder
+	rt http.RoundTripper
+}
+
+func (t *recordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(&req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Entry{
+		Method:  req.Method + " " + req.URL.RequestURI(),
+		Request: normalize(body),
+	}
+
+	res, rerr := t.rt.RoundTrip(req)
+	if rerr != nil {
+		e.Error = rerr.Error()
+	} else {
+		b, err := readBody(&res.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		e.Status = res.StatusCode
+		e.Header = res.Header.Clone()
+		for _, h := range scrubHeaders {
+			if _, ok := e.Header[h]; ok {
+				e.Header[h] = []string{scrubbed}
+			}
+		}
+		e.Response = string(debug.Scrub(b))
+	}
+
+	if err = t.r.save(e); err != nil {
+		return nil, err
+	}
+
+	return res, rerr
+}
+
+// Replayer is a soap.RoundTripper that serves the responses recorded by a Recorder.
+// Requests are matched by method and normalized body, in the order they were recorded.
+// When all recorded responses for a request have been served, the last one is repeated.
+type Replayer struct {
+	mu      sync.Mutex
+	entries []*Entry
+	served  map[*Entry]bool
+}
+
+// NewReplayer loads the fixture files written by a Recorder in the given directory.
+func NewReplayer(dir string) (*Replayer, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	r := &Replayer{served: make(map[*Entry]bool)}
+
+	for _, name := range files {
+		b, err := os.ReadFile(filepath.Clean(name))
+		if err != nil {
+			return nil, err
+		}
+
+		e := new(Entry)
+		if err = json.Unmarshal(b, e); err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+
+		r.entries = append(r.entries, e)
+	}
+
+	return r, nil
+}
+
+func (r *Replayer) find(method, body string) (*Entry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var last *Entry
+
+	for _, e := range r.entries {
+		if e.Method != method || e.Request != body {
+			continue
+		}
+		if !r.served[e] {
+			r.served[e] = true
+			return e, nil
+		}
+		last = e
+	}
+
+	if last == nil {
+		return nil, fmt.Errorf("replay: no recorded response for %s", method)
+	}
+
+	return last, nil
+}
+
+// RoundTrip implements soap.RoundTripper, serving the recorded response for the given request.
+func (r *Replayer) RoundTrip(ctx context.Context, req, res soap.HasFault) error {
+	method, body, err := marshalRequest(req)
+	if err != nil {
+		return err
+	}
+
+	e, err := r.find(method, body)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case e.Fault != "":
+		f := new(soap.Fault)
+		if err = unmarshal(e.Fault, f); err != nil {
+			return err
+		}
+		return soap.WrapSoapFault(f)
+	case e.Error != "":
+		return errors.New(e.Error)
+	}
+
+	val := field(res, "Res")
+	v := reflect.New(val.Type().Elem())
+	if err = unmarshal(e.Response, v.Interface()); err != nil {
+		return err
+	}
+	val.Set(v)
+
+	return nil
+}
+
+// Transport returns an http.RoundTripper that serves the recorded HTTP responses.
+func (r *Replayer) Transport() http.RoundTripper {
+	return replayTransport{r}
+}
+
+type replayTransport struct {
+	r *Replayer
+}
+
+func (t replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(&req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := t.r.find(req.Method+" "+req.URL.RequestURI(), normalize(body))
+	if err != nil {
+		return nil, err
+	}
+
+	if e.Error != "" {
+		return nil, errors.New(e.Error)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status)),
+		StatusCode:    e.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        e.Header.Clone(),
+		Body:          io.NopCloser(strings.NewReader(e.Response)),
+		ContentLength: int64(len(e.Response)),
+		Request:       req,
+	}, nil
+}
+
+// field returns the given field of a method request or response body
+func field(body soap.HasFault, name string) reflect.Value {
+	return reflect.ValueOf(body).Elem().FieldByName(name)
+}
+
+// marshalRequest returns the method name and scrubbed XML encoding of the given request body
+func marshalRequest(req soap.HasFault) (string, string, error) {
+	val := field(req, "Req")
+	if !val.IsValid() || val.IsNil() {
+		return "", "", fmt.Errorf("replay: invalid request type %T", req)
+	}
+
+	b, err := xml.Marshal(val.Interface())
+	if err != nil {
+		return "", "", err
+	}
+
+	return val.Elem().Type().Name(), string(debug.Scrub(b)), nil
+}
+
+func unmarshal(s string, v interface{}) error {
+	dec := xml.NewDecoder(strings.NewReader(s))
+	dec.TypeFunc = types.TypeFunc()
+	return dec.Decode(v)
+}
+
+// readBody reads and replaces the given body, such that it can be read again
+func readBody(body *io.ReadCloser) ([]byte, error) {
+	if *body == nil || *body == http.NoBody {
+		return nil, nil
+	}
+
+	b, err := io.ReadAll(*body)
+	_ = (*body).Close()
+	if err != nil {
+		return nil, err
+	}
+
+	*body = io.NopCloser(bytes.NewReader(b))
+
+	return b, nil
+}
+
+// normalize returns the scrubbed request body, with insignificant whitespace removed from JSON
+func normalize(body []byte) string {
+	var buf bytes.Buffer
+
+	if json.Valid(body) {
+		if err := json.Compact(&buf, body); err == nil {
+			body = buf.Bytes()
+		}
+	}
+
+	return string(debug.Scrub(bytes.TrimSpace(body)))
+}
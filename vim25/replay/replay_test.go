@@ -0,0 +1,156 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package replay_test
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zhengkes/govmomi/find"
+	"github.com/zhengkes/govmomi/object"
+	"github.com/zhengkes/govmomi/session"
+	"github.com/zhengkes/govmomi/simulator"
+	"github.com/zhengkes/govmomi/vapi/rest"
+	"github.com/zhengkes/govmomi/vapi/tags"
+	"github.com/zhengkes/govmomi/vim25"
+	"github.com/zhengkes/govmomi/vim25/replay"
+	"github.com/zhengkes/govmomi/vim25/soap"
+	"github.com/zhengkes/govmomi/vim25/types"
+
+	_ "github.com/zhengkes/govmomi/vapi/simulator"
+)
+
+type result struct {
+	VMs        []string
+	Fault      string
+	Categories []string
+}
+
+// run makes the same calls when recording and replaying
+func run(ctx context.Context, c *vim25.Client, rc *rest.Client) (*result, error) {
+	var res result
+
+	// invalid password, which must be scrubbed from the fixture files
+	err := session.NewManager(c).Login(ctx, url.UserPassword("user", "secret"))
+	if !soap.IsSoapFault(err) {
+		return nil, err
+	}
+	res.Fault = reflect.TypeOf(soap.ToSoapFault(err).VimFault()).Name()
+
+	vms, err := find.NewFinder(c).VirtualMachineList(ctx, "*")
+	if err != nil {
+		return nil, err
+	}
+	for _, vm := range vms {
+		res.VMs = append(res.VMs, vm.Name())
+	}
+
+	if err = rc.Login(ctx, simulator.DefaultLogin); err != nil {
+		return nil, err
+	}
+
+	m := tags.NewManager(rc)
+	if _, err = m.CreateCategory(ctx, &tags.Category{Name: "replay"}); err != nil {
+		return nil, err
+	}
+	categories, err := m.GetCategories(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, category := range categories {
+		res.Categories = append(res.Categories, category.Name)
+	}
+
+	return &res, nil
+}
+
+func TestRecordReplay(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	var recorded *result
+
+	simulator.Test(func(ctx context.Context, sc *vim25.Client) {
+		rec := replay.NewRecorder(dir, sc.Client)
+
+		c, err := vim25.NewClient(ctx, rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Client = sc.Client
+
+		rc := rest.NewClient(c)
+		rc.Transport = rec.Transport(rc.Transport)
+
+		recorded, err = run(ctx, c, rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	if len(recorded.VMs) == 0 || recorded.Fault != "InvalidLogin" || len(recorded.Categories) != 1 {
+		t.Fatalf("recorded=%#v", recorded)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range files {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(b), "secret") {
+			t.Errorf("%s: password was not scrubbed", name)
+		}
+	}
+
+	r, err := replay.NewReplayer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := vim25.NewClient(ctx, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := soap.ParseURL("127.0.0.1")
+	c.Client = soap.NewClient(u, true)
+
+	rc := rest.NewClient(c)
+	rc.Transport = r.Transport()
+
+	replayed, err := run(ctx, c, rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(recorded, replayed) {
+		t.Errorf("recorded=%#v, replayed=%#v", recorded, replayed)
+	}
+
+	// requests that were not recorded fail
+	_, err = object.NewVirtualMachine(c, types.ManagedObjectReference{Type: "VirtualMachine", Value: "vm-missing"}).PowerOn(ctx)
+	if err == nil || soap.IsSoapFault(err) {
+		t.Errorf("err=%#v", err)
+	}
+}
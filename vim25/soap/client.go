@@ -914,7 +914,7 @@ func (c *Client) Download(ctx context.Context, u *url.URL, param *Download) (io.
 	}
 
 	switch res.StatusCode {
-	case http.StatusOK:
+	case http.StatusOK, http.StatusPartialContent:
 	default:
 		err = fmt.Errorf("download(%s): %s", u, res.Status)
 	}
@@ -0,0 +1,272 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vmdk
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zhengkes/govmomi/object"
+	"github.com/zhengkes/govmomi/vim25/methods"
+	"github.com/zhengkes/govmomi/vim25/mo"
+	"github.com/zhengkes/govmomi/vim25/soap"
+	"github.com/zhengkes/govmomi/vim25/types"
+)
+
+// Backup copies the disk with the given device key of vm to the local raw disk image file specified by name.
+// A snapshot of the VM is created for the duration of the backup and Changed Block Tracking (CBT) is used to
+// download only the allocated areas of the disk, which requires config.changeTrackingEnabled to be set on the VM.
+// If changeID is empty, a full backup is done. Otherwise only the areas changed since changeID are downloaded,
+// in which case the file must contain the backup made at changeID.
+// The disk's change ID at the time of the backup is returned, for use by the next incremental backup.
+func Backup(ctx context.Context, vm *object.VirtualMachine, key int32, name string, changeID string) (string, error) {
+	task, err := vm.CreateSnapshot(ctx, "backup", "vmdk.Backup", false, false)
+	if err != nil {
+		return "", err
+	}
+
+	res, err := task.WaitForResult(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	snapshot := res.Result.(types.ManagedObjectReference)
+
+	id, err := backup(ctx, vm, snapshot, key, name, changeID)
+
+	consolidate := true
+	task, rerr := vm.RemoveSnapshot(ctx, snapshot.Value, false, &consolidate)
+	if rerr == nil {
+		rerr = task.Wait(ctx)
+	}
+	if err == nil {
+		err = rerr
+	}
+
+	return id, err
+}
+
+func backup(ctx context.Context, vm *object.VirtualMachine, snapshot types.ManagedObjectReference, key int32, name string, changeID string) (string, error) {
+	var s mo.VirtualMachineSnapshot
+	err := vm.Properties(ctx, snapshot, []string{"config.hardware"}, &s)
+	if err != nil {
+		return "", err
+	}
+
+	disk, ok := object.VirtualDeviceList(s.Config.Hardware.Device).FindByKey(key).(*types.VirtualDisk)
+	if !ok {
+		return "", fmt.Errorf("disk %d not found", key)
+	}
+
+	backing, ok := disk.Backing.(*types.VirtualDiskFlatVer2BackingInfo)
+	if !ok || backing.Datastore == nil {
+		return "", fmt.Errorf("disk %d: unsupported backing %T", key, disk.Backing)
+	}
+	if backing.ChangeId == "" {
+		return "", fmt.Errorf("CBT is not enabled on disk %d", key)
+	}
+
+	var p object.DatastorePath
+	p.FromString(backing.FileName)
+	extent := strings.TrimSuffix(p.Path, ".vmdk") + "-flat.vmdk"
+
+	ds := object.NewDatastore(vm.Client(), *backing.Datastore)
+	if err = ds.FindInventoryPath(ctx); err != nil {
+		return "", err
+	}
+
+	// Disk areas beyond the size of the extent file are read as zeros
+	fi, err := ds.Stat(ctx, extent)
+	if err != nil {
+		return "", err
+	}
+	size := fi.GetFileInfo().FileSize
+
+	capacity := disk.CapacityInBytes
+	if capacity == 0 {
+		capacity = disk.CapacityInKB * 1024
+	}
+
+	flag := os.O_RDWR
+	if changeID == "" {
+		changeID = "*"
+		flag |= os.O_CREATE | os.O_TRUNC
+	}
+
+	f, err := os.OpenFile(filepath.Clean(name), flag, 0600)
+	if err != nil {
+		return "", err
+	}
+
+	if err = f.Truncate(capacity); err != nil {
+		_ = f.Close()
+		return "", err
+	}
+
+	for offset := int64(0); offset < capacity; {
+		req := types.QueryChangedDiskAreas{
+			This:        vm.Reference(),
+			Snapshot:    &snapshot,
+			DeviceKey:   key,
+			StartOffset: offset,
+			ChangeId:    changeID,
+		}
+
+		res, err := methods.QueryChangedDiskAreas(ctx, vm.Client(), &req)
+		if err != nil {
+			_ = f.Close()
+			return "", err
+		}
+
+		for _, area := range res.Returnval.ChangedArea {
+			if err = copyArea(ctx, ds, extent, size, f, area); err != nil {
+				_ = f.Close()
+				return "", err
+			}
+		}
+
+		if res.Returnval.Length == 0 {
+			break
+		}
+		offset = res.Returnval.StartOffset + res.Returnval.Length
+	}
+
+	if err = f.Close(); err != nil {
+		return "", err
+	}
+
+	return backing.ChangeId, nil
+}
+
+// copyArea downloads the given area of a disk extent of the given size, writing it to f at the same offset.
+func copyArea(ctx context.Context, ds *object.Datastore, extent string, size int64, f *os.File, area types.DiskChangeExtent) error {
+	n := area.Length
+	if area.Start+n > size {
+		n = size - area.Start
+	}
+	if n < 0 {
+		n = 0
+	}
+
+	if n > 0 {
+		r, err := downloadArea(ctx, ds, extent, area.Start, n)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.CopyN(&offsetWriter{f, area.Start}, r, n)
+		_ = r.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return writeZeros(f, area.Start+n, area.Length-n)
+}
+
+// downloadArea requests n bytes of extent starting at offset, returning a reader positioned at offset.
+// A 206 response must have a Content-Range starting at offset, a 200 response (Range ignored by the server)
+// is read up to offset.
+func downloadArea(ctx context.Context, ds *object.Datastore, extent string, offset int64, n int64) (io.ReadCloser, error) {
+	u, ticket, err := ds.ServiceTicket(ctx, extent, http.MethodGet)
+	if err != nil {
+		return nil, err
+	}
+
+	param := soap.DefaultDownload
+	param.Headers = map[string]string{
+		"Range": fmt.Sprintf("bytes=%d-%d", offset, offset+n-1),
+	}
+	if ticket != nil {
+		param.Ticket = ticket
+		param.Close = true // disable Keep-Alive connection to ESX
+	}
+
+	res, err := ds.Client().DownloadRequest(ctx, u, &param)
+	if err != nil {
+		return nil, err
+	}
+
+	switch res.StatusCode {
+	case http.StatusPartialContent:
+		var start, end int64
+		cr := res.Header.Get("Content-Range")
+		if _, err = fmt.Sscanf(cr, "bytes %d-%d/", &start, &end); err != nil || start != offset || end < offset+n-1 {
+			err = fmt.Errorf("download(%s): unexpected Content-Range %q for offset %d length %d", u, cr, offset, n)
+		}
+	case http.StatusOK:
+		_, err = io.CopyN(io.Discard, res.Body, offset)
+	default:
+		err = fmt.Errorf("download(%s): %s", u, res.Status)
+	}
+
+	if err != nil {
+		_ = res.Body.Close()
+		return nil, err
+	}
+
+	return res.Body, nil
+}
+
+// zeroChunkSize is the maximum size of the buffer used by writeZeros
+const zeroChunkSize = 1024 * 1024
+
+// writeZeros writes n zero bytes to f at the given offset, in chunks of at most zeroChunkSize.
+// The zeros are written rather than left as a hole, as an incremental backup may overwrite existing data.
+func writeZeros(f io.WriterAt, offset int64, n int64) error {
+	if n <= 0 {
+		return nil
+	}
+
+	size := int64(zeroChunkSize)
+	if n < size {
+		size = n
+	}
+	buf := make([]byte, size)
+
+	for n > 0 {
+		chunk := buf
+		if n < int64(len(chunk)) {
+			chunk = chunk[:n]
+		}
+
+		if _, err := f.WriteAt(chunk, offset); err != nil {
+			return err
+		}
+
+		offset += int64(len(chunk))
+		n -= int64(len(chunk))
+	}
+
+	return nil
+}
+
+// offsetWriter writes to an io.WriterAt, starting at the given offset
+type offsetWriter struct {
+	w      io.WriterAt
+	offset int64
+}
+
+func (o *offsetWriter) Write(p []byte) (int, error) {
+	n, err := o.w.WriteAt(p, o.offset)
+	o.offset += int64(n)
+	return n, err
+}
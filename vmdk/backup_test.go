@@ -0,0 +1,243 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vmdk_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zhengkes/govmomi/find"
+	"github.com/zhengkes/govmomi/object"
+	"github.com/zhengkes/govmomi/simulator"
+	"github.com/zhengkes/govmomi/vim25"
+	"github.com/zhengkes/govmomi/vim25/mo"
+	"github.com/zhengkes/govmomi/vim25/soap"
+	"github.com/zhengkes/govmomi/vim25/types"
+	"github.com/zhengkes/govmomi/vmdk"
+)
+
+func TestBackup(t *testing.T) {
+	simulator.Test(func(ctx context.Context, c *vim25.Client) {
+		vm, err := find.NewFinder(c).VirtualMachine(ctx, "DC0_H0_VM0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		devices, err := vm.Device(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		disk := devices.SelectByType((*types.VirtualDisk)(nil))[0].(*types.VirtualDisk)
+		backing := disk.Backing.(*types.VirtualDiskFlatVer2BackingInfo)
+
+		name := filepath.Join(t.TempDir(), "disk.raw")
+
+		if _, err = vmdk.Backup(ctx, vm, disk.Key, name, ""); err == nil {
+			t.Fatal("expected error, CBT is not enabled")
+		}
+
+		task, err := vm.Reconfigure(ctx, types.VirtualMachineConfigSpec{ChangeTrackingEnabled: types.NewBool(true)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = task.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		// write to the disk via datastore upload
+		ds := object.NewDatastore(c, *backing.Datastore)
+		if err = ds.FindInventoryPath(ctx); err != nil {
+			t.Fatal(err)
+		}
+		var p object.DatastorePath
+		p.FromString(backing.FileName)
+		extent := strings.TrimSuffix(p.Path, ".vmdk") + "-flat.vmdk"
+
+		data := make([]byte, 1<<20)
+		copy(data[0:], "first block")
+		copy(data[300<<10:], "second block")
+
+		upload := func() {
+			if err := ds.Upload(ctx, bytes.NewReader(data), extent, &soap.DefaultUpload); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		verify := func() {
+			f, err := os.Open(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			info, err := f.Stat()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() != disk.CapacityInBytes {
+				t.Errorf("size=%d", info.Size())
+			}
+
+			buf := make([]byte, len(data))
+			if _, err = f.ReadAt(buf, 0); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf, data) {
+				t.Error("backup does not match disk data")
+			}
+		}
+
+		upload()
+
+		full, err := vmdk.Backup(ctx, vm, disk.Key, name, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		verify()
+
+		// change one block, zero another
+		copy(data[700<<10:], "third block")
+		copy(data[300<<10:], make([]byte, 64))
+		upload()
+
+		// areas not changed since the full backup are not written by the incremental backup
+		marker := []byte("not changed")
+		f, err := os.OpenFile(name, os.O_RDWR, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = f.WriteAt(marker, 2<<20); err != nil {
+			t.Fatal(err)
+		}
+		_ = f.Close()
+
+		incr, err := vmdk.Backup(ctx, vm, disk.Key, name, full)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if incr == full {
+			t.Errorf("change ID not updated: %s", incr)
+		}
+		verify()
+
+		f, err = os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		buf := make([]byte, len(marker))
+		if _, err = f.ReadAt(buf, 2<<20); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf, marker) {
+			t.Error("unchanged area was written")
+		}
+
+		// backup snapshots are removed
+		var o mo.VirtualMachine
+		if err = vm.Properties(ctx, vm.Reference(), []string{"snapshot"}, &o); err != nil {
+			t.Fatal(err)
+		}
+		if o.Snapshot != nil && len(o.Snapshot.RootSnapshotList) != 0 {
+			t.Errorf("snapshots=%d", len(o.Snapshot.RootSnapshotList))
+		}
+	})
+}
+
+// ignoreRange simulates a server that does not support Range requests
+type ignoreRange struct {
+	http.RoundTripper
+	n int
+}
+
+func (t *ignoreRange) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Range") != "" {
+		t.n++
+		req = req.Clone(req.Context())
+		req.Header.Del("Range")
+	}
+	return t.RoundTripper.RoundTrip(req)
+}
+
+func TestBackupRangeIgnored(t *testing.T) {
+	simulator.Test(func(ctx context.Context, c *vim25.Client) {
+		vm, err := find.NewFinder(c).VirtualMachine(ctx, "DC0_H0_VM0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		devices, err := vm.Device(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		disk := devices.SelectByType((*types.VirtualDisk)(nil))[0].(*types.VirtualDisk)
+		backing := disk.Backing.(*types.VirtualDiskFlatVer2BackingInfo)
+
+		task, err := vm.Reconfigure(ctx, types.VirtualMachineConfigSpec{ChangeTrackingEnabled: types.NewBool(true)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = task.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		ds := object.NewDatastore(c, *backing.Datastore)
+		if err = ds.FindInventoryPath(ctx); err != nil {
+			t.Fatal(err)
+		}
+		var p object.DatastorePath
+		p.FromString(backing.FileName)
+		extent := strings.TrimSuffix(p.Path, ".vmdk") + "-flat.vmdk"
+
+		data := make([]byte, 1<<20)
+		copy(data[0:], "first block")
+		copy(data[300<<10:], "second block")
+		copy(data[700<<10:], "third block")
+		if err = ds.Upload(ctx, bytes.NewReader(data), extent, &soap.DefaultUpload); err != nil {
+			t.Fatal(err)
+		}
+
+		transport := &ignoreRange{RoundTripper: c.Client.Transport}
+		c.Client.Transport = transport
+
+		name := filepath.Join(t.TempDir(), "disk.raw")
+		if _, err = vmdk.Backup(ctx, vm, disk.Key, name, ""); err != nil {
+			t.Fatal(err)
+		}
+		if transport.n < 3 {
+			t.Errorf("range requests=%d", transport.n)
+		}
+
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		buf := make([]byte, len(data))
+		if _, err = f.ReadAt(buf, 0); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf, data) {
+			t.Error("backup does not match disk data")
+		}
+	})
+}
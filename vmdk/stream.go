@@ -0,0 +1,358 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vmdk
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"text/template"
+)
+
+const (
+	sectorSize   = 512
+	grainSectors = 128 // 64KiB grains
+	grainSize    = grainSectors * sectorSize
+	numGTEsPerGT = 512
+
+	sparseMagicNumber = 0x564d444b // "KDMV"
+	gdAtEnd           = 0xffffffffffffffff
+
+	flagValidNewLineDetection = 1 << 0
+	flagCompressed            = 1 << 16
+	flagMarkers               = 1 << 17
+
+	compressionDeflate = 1
+
+	markerEOS    = 0
+	markerGT     = 1
+	markerGD     = 2
+	markerFooter = 3
+)
+
+// sparseHeader is the SparseExtentHeader of a hosted sparse extent,
+// see the "Virtual Disk Format 5.0" specification.
+type sparseHeader struct {
+	MagicNumber        uint32
+	Version            uint32
+	Flags              uint32
+	Capacity           uint64
+	GrainSize          uint64
+	DescriptorOffset   uint64
+	DescriptorSize     uint64
+	NumGTEsPerGT       uint32
+	RgdOffset          uint64
+	GdOffset           uint64
+	OverHead           uint64
+	UncleanShutdown    uint8
+	SingleEndLineChar  uint8
+	NonEndLineChar     uint8
+	DoubleEndLineChar1 uint8
+	DoubleEndLineChar2 uint8
+	CompressAlgorithm  uint16
+	Pad                [433]uint8
+}
+
+// marker is a streamOptimized metadata marker
+type marker struct {
+	Val  uint64
+	Size uint32
+	Type uint32
+	Pad  [496]uint8
+}
+
+var streamDescriptor = template.Must(template.New("descriptor").Parse(`# Disk DescriptorFile
+version=1
+encoding="UTF-8"
+CID=fffffffe
+parentCID=ffffffff
+createType="streamOptimized"
+
+# Extent description
+RW {{ .Sectors }} SPARSE "{{ .Name }}"
+
+# The Disk Data Base
+#DDB
+
+ddb.adapterType = "lsilogic"
+ddb.geometry.cylinders = "{{ .Cylinders }}"
+ddb.geometry.heads = "255"
+ddb.geometry.sectors = "63"
+ddb.virtualHWVersion = "4"
+`))
+
+// streamWriter tracks the sector offset of data written to a streamOptimized VMDK
+type streamWriter struct {
+	w   io.Writer
+	pos uint64 // in sectors
+}
+
+func (s *streamWriter) write(data []byte) error {
+	if pad := len(data) % sectorSize; pad != 0 {
+		data = append(data, make([]byte, sectorSize-pad)...)
+	}
+
+	_, err := s.w.Write(data)
+	s.pos += uint64(len(data) / sectorSize)
+	return err
+}
+
+func (s *streamWriter) writeValue(v interface{}) error {
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, v); err != nil {
+		return err
+	}
+	return s.write(buf.Bytes())
+}
+
+func (s *streamWriter) writeMarker(kind uint32, val uint64) error {
+	return s.writeValue(&marker{Val: val, Type: kind})
+}
+
+// writeGrain writes the given grain data compressed, returning the grain's sector offset
+func (s *streamWriter) writeGrain(lba uint64, data []byte) (uint32, error) {
+	var buf bytes.Buffer
+	buf.Write(make([]byte, 12)) // lba (8) + size (4)
+
+	z := zlib.NewWriter(&buf)
+	if _, err := z.Write(data); err != nil {
+		return 0, err
+	}
+	if err := z.Close(); err != nil {
+		return 0, err
+	}
+
+	b := buf.Bytes()
+	binary.LittleEndian.PutUint64(b[0:], lba)
+	binary.LittleEndian.PutUint32(b[8:], uint32(len(b)-12))
+
+	offset := s.pos
+	return uint32(offset), s.write(b)
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteStreamOptimized writes a streamOptimized VMDK with the given capacity in bytes and extent name to w.
+// Disk data is read from r, which may be shorter than capacity, in which case the remaining sectors are zero.
+// Grains containing only zeros are not written, as the format allows for sparse extents.
+func WriteStreamOptimized(w io.Writer, name string, capacity int64, r io.Reader) error {
+	sectors := (uint64(capacity) + sectorSize - 1) / sectorSize
+	grains := (sectors + grainSectors - 1) / grainSectors
+	numGTs := (grains + numGTEsPerGT - 1) / numGTEsPerGT
+
+	var desc bytes.Buffer
+	err := streamDescriptor.Execute(&desc, map[string]interface{}{
+		"Name":      name,
+		"Sectors":   sectors,
+		"Cylinders": (sectors + 255*63 - 1) / (255 * 63),
+	})
+	if err != nil {
+		return err
+	}
+	descSectors := uint64(desc.Len()+sectorSize-1) / sectorSize
+
+	header := sparseHeader{
+		MagicNumber:        sparseMagicNumber,
+		Version:            3,
+		Flags:              flagValidNewLineDetection | flagCompressed | flagMarkers,
+		Capacity:           sectors,
+		GrainSize:          grainSectors,
+		DescriptorOffset:   1,
+		DescriptorSize:     descSectors,
+		NumGTEsPerGT:       numGTEsPerGT,
+		GdOffset:           gdAtEnd,
+		OverHead:           1 + descSectors,
+		SingleEndLineChar:  '\n',
+		NonEndLineChar:     ' ',
+		DoubleEndLineChar1: '\r',
+		DoubleEndLineChar2: '\n',
+		CompressAlgorithm:  compressionDeflate,
+	}
+
+	s := &streamWriter{w: w}
+
+	if err = s.writeValue(&header); err != nil {
+		return err
+	}
+	if err = s.write(desc.Bytes()); err != nil {
+		return err
+	}
+
+	gt := make([]uint32, numGTs*numGTEsPerGT)
+	buf := make([]byte, grainSize)
+	eof := false
+
+	for i := uint64(0); i < grains && !eof; i++ {
+		n, err := io.ReadFull(r, buf)
+		switch err {
+		case nil:
+		case io.EOF, io.ErrUnexpectedEOF:
+			eof = true
+		default:
+			return err
+		}
+
+		if n == 0 || isZero(buf[:n]) {
+			continue
+		}
+
+		for j := n; j < len(buf); j++ {
+			buf[j] = 0
+		}
+
+		if gt[i], err = s.writeGrain(i*grainSectors, buf); err != nil {
+			return err
+		}
+	}
+
+	gtSectors := uint64(numGTEsPerGT*4) / sectorSize
+	gd := make([]uint32, numGTs)
+
+	for i := uint64(0); i < numGTs; i++ {
+		table := gt[i*numGTEsPerGT : (i+1)*numGTEsPerGT]
+		if isZeroTable(table) {
+			continue
+		}
+
+		if err = s.writeMarker(markerGT, gtSectors); err != nil {
+			return err
+		}
+		gd[i] = uint32(s.pos)
+		if err = s.writeValue(table); err != nil {
+			return err
+		}
+	}
+
+	if err = s.writeMarker(markerGD, (numGTs*4+sectorSize-1)/sectorSize); err != nil {
+		return err
+	}
+	header.GdOffset = s.pos
+	if err = s.writeValue(gd); err != nil {
+		return err
+	}
+
+	if err = s.writeMarker(markerFooter, 1); err != nil {
+		return err
+	}
+	if err = s.writeValue(&header); err != nil {
+		return err
+	}
+
+	return s.writeMarker(markerEOS, 0)
+}
+
+func isZeroTable(table []uint32) bool {
+	for _, e := range table {
+		if e != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// IsStreamOptimized returns true if the given header bytes are that of a streamOptimized (compressed) sparse extent.
+func IsStreamOptimized(header []byte) bool {
+	if len(header) < 12 {
+		return false
+	}
+
+	magic := binary.LittleEndian.Uint32(header[0:])
+	flags := binary.LittleEndian.Uint32(header[8:])
+
+	return magic == sparseMagicNumber && flags&flagCompressed != 0
+}
+
+// ReadStreamOptimized reads a streamOptimized VMDK from r, writing the disk data to w.
+// Only grains present in the stream are written, sparse regions of w are left untouched.
+// The disk capacity in bytes is returned.
+func ReadStreamOptimized(r io.Reader, w io.WriterAt) (int64, error) {
+	var header sparseHeader
+
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return 0, err
+	}
+
+	if header.MagicNumber != sparseMagicNumber || header.Flags&flagCompressed == 0 || header.Flags&flagMarkers == 0 {
+		return 0, ErrInvalidFormat
+	}
+
+	if header.OverHead > 1 {
+		if _, err := io.CopyN(io.Discard, r, int64(header.OverHead-1)*sectorSize); err != nil {
+			return 0, err
+		}
+	}
+
+	sector := make([]byte, sectorSize)
+
+	for {
+		if _, err := io.ReadFull(r, sector); err != nil {
+			return 0, err
+		}
+
+		val := binary.LittleEndian.Uint64(sector[0:])
+		size := binary.LittleEndian.Uint32(sector[8:])
+
+		if size == 0 {
+			switch binary.LittleEndian.Uint32(sector[12:]) {
+			case markerEOS:
+				return int64(header.Capacity) * sectorSize, nil
+			case markerGT, markerGD, markerFooter:
+				if _, err := io.CopyN(io.Discard, r, int64(val)*sectorSize); err != nil {
+					return 0, err
+				}
+				continue
+			default:
+				return 0, fmt.Errorf("vmdk: unknown marker type at lba %d", val)
+			}
+		}
+
+		// grain marker: data is stored in this sector (after lba and size) and any following sectors
+		total := 12 + int(size)
+		if pad := total % sectorSize; pad != 0 {
+			total += sectorSize - pad
+		}
+
+		buf := make([]byte, total)
+		copy(buf, sector)
+		if _, err := io.ReadFull(r, buf[sectorSize:]); err != nil {
+			return 0, err
+		}
+
+		z, err := zlib.NewReader(bytes.NewReader(buf[12 : 12+size]))
+		if err != nil {
+			return 0, err
+		}
+
+		data, err := io.ReadAll(z)
+		if err != nil {
+			return 0, err
+		}
+
+		if _, err = w.WriteAt(data, int64(val)*sectorSize); err != nil {
+			return 0, err
+		}
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Copyright (c) 2024-2024 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vmdk
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStreamOptimized(t *testing.T) {
+	const capacity = 40 << 20 // spans 2 grain tables
+
+	data := make([]byte, capacity-grainSize/2) // last grain is partial
+	copy(data[0:], "first grain")
+	copy(data[grainSize*3+17:], "fourth grain")
+	copy(data[len(data)-5:], "last")
+
+	name := filepath.Join(t.TempDir(), "disk.vmdk")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = WriteStreamOptimized(f, "disk.vmdk", capacity, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	di, err := stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if di.Capacity != capacity {
+		t.Errorf("capacity=%d", di.Capacity)
+	}
+
+	if di.Size > 64*1024 {
+		t.Errorf("size=%d", di.Size) // only 3 grains are non-zero
+	}
+
+	f, err = os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	dst, err := os.Create(filepath.Join(t.TempDir(), "disk.raw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	size, err := ReadStreamOptimized(f, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if size != capacity {
+		t.Errorf("size=%d", size)
+	}
+
+	raw, err := os.ReadFile(dst.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(raw[:len(data)], data) {
+		t.Error("data mismatch")
+	}
+
+	header := make([]byte, 512)
+	if _, err = f.ReadAt(header, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsStreamOptimized(header) || IsStreamOptimized(raw) {
+		t.Error("unexpected format")
+	}
+}